@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// createAlertRule handles the POST /alert-rules request
+// It creates a threshold rule that is evaluated against ingested metrics
+func (app *application) createAlertRule(c echo.Context) error {
+	var ruleRequest service.AlertRuleRequest
+	err := c.Bind(&ruleRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind alert rule request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(ruleRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate alert rule request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	rule, err := app.service.CreateAlertRule(ruleRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to create alert rule: %v", err)
+		return app.alertErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, rule)
+}
+
+// getAlertRules handles the GET /alert-rules request
+// It retrieves all alert rules
+func (app *application) getAlertRules(c echo.Context) error {
+	rules, err := app.service.GetAlertRules()
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve alert rules: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, rules)
+}
+
+// getAlertRule handles the GET /alert-rules/:id request
+// It retrieves a single alert rule
+func (app *application) getAlertRule(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid alert rule ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid alert rule ID")))
+	}
+
+	rule, err := app.service.GetAlertRule(id)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve alert rule with ID %d: %v", id, err)
+		return app.alertErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, rule)
+}
+
+// updateAlertRule handles the PUT /alert-rules/:id request
+// It replaces the settings of an alert rule
+func (app *application) updateAlertRule(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid alert rule ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid alert rule ID")))
+	}
+
+	var ruleRequest service.AlertRuleRequest
+	err = c.Bind(&ruleRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind alert rule request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(ruleRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate alert rule request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	rule, err := app.service.UpdateAlertRule(id, ruleRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to update alert rule with ID %d: %v", id, err)
+		return app.alertErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, rule)
+}
+
+// deleteAlertRule handles the DELETE /alert-rules/:id request
+// It removes an alert rule together with its alerts
+func (app *application) deleteAlertRule(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid alert rule ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid alert rule ID")))
+	}
+
+	err = app.service.DeleteAlertRule(id)
+	if err != nil {
+		app.logger.Errorf("Failed to delete alert rule with ID %d: %v", id, err)
+		return app.alertErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getAlerts handles the GET /alerts request
+// It lists alerts, optionally filtered by state, rule or agent
+func (app *application) getAlerts(c echo.Context) error {
+	var filter service.AlertFilter
+	err := c.Bind(&filter)
+	if err != nil {
+		app.logger.Errorf("Failed to bind alert filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to validate alert filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	alerts, err := app.service.GetAlerts(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve alerts: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, alerts)
+}
+
+// getAlert handles the GET /alerts/:id request
+// It retrieves a single alert
+func (app *application) getAlert(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid alert ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid alert ID")))
+	}
+
+	alert, err := app.service.GetAlert(id)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve alert with ID %d: %v", id, err)
+		return app.alertErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, alert)
+}
+
+// getAlertHistory handles the GET /alerts/:id/history request
+// It retrieves the state changes of an alert in the order they happened
+func (app *application) getAlertHistory(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid alert ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid alert ID")))
+	}
+
+	history, err := app.service.GetAlertHistory(id)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve history of alert with ID %d: %v", id, err)
+		return app.alertErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
+// alertErrorResponse maps alerting errors to HTTP responses
+func (app *application) alertErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrAlertRuleNotFound), errors.Is(err, service.ErrAlertNotFound):
+		return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+	case errors.Is(err, service.ErrInvalidAlertRule):
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+	return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+}
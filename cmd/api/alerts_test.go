@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateAlertRuleHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{rule: service.AlertRule{ID: 1, Name: "high latency"}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/alert-rules", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.createAlertRule(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Successfully Create Rule", func(t *testing.T) {
+		rec := create(`{"name": "high latency", "metric": "latency_ms", "scope": "all", "comparator": "gt", "threshold": 100, "duration_seconds": 60}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("Zero Threshold Is Allowed", func(t *testing.T) {
+		rec := create(`{"name": "loss", "metric": "packet_loss", "scope": "all", "comparator": "gt", "threshold": 0}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("Agent Offline Rule Needs No Metric", func(t *testing.T) {
+		rec := create(`{"name": "offline", "type": "agent_offline", "scope": "group", "scope_id": 1, "window_seconds": 120}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("Scope ID Required", func(t *testing.T) {
+		rec := create(`{"name": "high latency", "metric": "latency_ms", "scope": "agent", "comparator": "gt", "threshold": 100}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Invalid Comparator", func(t *testing.T) {
+		rec := create(`{"name": "high latency", "metric": "latency_ms", "scope": "all", "comparator": "eq", "threshold": 100}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Missing Scope Target", func(t *testing.T) {
+		mockService.err = service.ErrInvalidAlertRule
+		rec := create(`{"name": "high latency", "metric": "latency_ms", "scope": "agent", "scope_id": 9, "comparator": "gt", "threshold": 100}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestGetAlertsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{alerts: []service.Alert{{ID: 1, RuleID: 2, AgentID: 3, State: service.AlertFiring}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	t.Run("Filters By State", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/alerts?state=firing&rule_id=2", nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.getAlerts(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, service.AlertFilter{State: "firing", RuleID: 2}, mockService.lastFilter)
+	})
+
+	t.Run("Invalid State", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/alerts?state=unknown", nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.getAlerts(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("History Of Missing Alert", func(t *testing.T) {
+		mockService.err = service.ErrAlertNotFound
+		req := httptest.NewRequest(http.MethodGet, "/alerts/5/history", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("5")
+		assert.NoError(t, app.getAlertHistory(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestRecordHeartbeatHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{}
+	app := &application{logger: e.Logger, service: mockService}
+
+	heartbeat := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/agents/1/heartbeat", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, app.recordHeartbeat(c))
+		return rec
+	}
+
+	t.Run("Records Heartbeat", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, heartbeat().Code)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+		assert.Equal(t, http.StatusNotFound, heartbeat().Code)
+	})
+}
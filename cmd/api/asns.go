@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// getASNs handles the GET /asns request
+// It lists the autonomous systems of the registered agents with their registration details
+func (app *application) getASNs(c echo.Context) error {
+	asns, err := app.service.GetASNs()
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve ASNs: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, asns)
+}
+
+// getASNAgents handles the GET /asns/:asn/agents request
+// It lists the agents announced by an AS, given as 15169 or AS15169
+func (app *application) getASNAgents(c echo.Context) error {
+	asn, err := service.ParseASN(c.Param("asn"))
+	if err != nil {
+		app.logger.Errorf("Invalid ASN: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	agents, err := app.service.GetASNAgents(asn)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve agents of AS%d: %v", asn, err)
+		if errors.Is(err, service.ErrASNNotFound) {
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, agents)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetASNsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{asns: []service.ASN{{ASN: 15169, Name: "Google LLC", Country: "US", Registry: "ARIN", AgentCount: 2}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	req := httptest.NewRequest(http.MethodGet, "/asns", nil)
+	rec := httptest.NewRecorder()
+
+	assert.NoError(t, app.getASNs(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"asn":15169,"name":"Google LLC","country":"US","registry":"ARIN","agent_count":2`)
+}
+
+func TestGetASNAgentsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{agents: []service.Agent{{ID: 1, IPAddress: "8.8.8.8"}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	list := func(asn string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/asns/"+asn+"/agents", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("asn")
+		c.SetParamValues(asn)
+		assert.NoError(t, app.getASNAgents(c))
+		return rec
+	}
+
+	t.Run("Successfully Retrieve Agents", func(t *testing.T) {
+		for _, asn := range []string{"15169", "AS15169"} {
+			rec := list(asn)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, uint32(15169), mockService.asn)
+		}
+	})
+
+	t.Run("Invalid ASN", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, list("Google").Code)
+	})
+
+	t.Run("ASN Not Found", func(t *testing.T) {
+		mockService.err = service.ErrASNNotFound
+		assert.Equal(t, http.StatusNotFound, list("64500").Code)
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+// getAgentConfig handles the GET /agents/:id/config request
+// Agents poll it with If-None-Match and get 304 Not Modified while their configuration is unchanged
+func (app *application) getAgentConfig(c echo.Context) error {
+	agentID, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	config, err := app.service.GetAgentConfig(agentID)
+	if err != nil {
+		app.logger.Errorf("Failed to build configuration of agent %d: %v", agentID, err)
+		if errors.Is(err, service.ErrAgentNotFound) {
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	c.Response().Header().Set("ETag", config.ETag)
+	if etagMatches(c.Request().Header.Values("If-None-Match"), config.ETag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(http.StatusOK, config)
+}
+
+// etagMatches reports whether If-None-Match header values match an entity tag. As in
+// RFC 9110 they hold either * or a comma-separated list of tags compared weakly, so
+// W/ prefixes are ignored.
+func etagMatches(values []string, etag string) bool {
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || opaqueTag(tag) == opaqueTag(etag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// opaqueTag strips the weakness indicator and quotes of an entity tag
+func opaqueTag(tag string) string {
+	return strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+}
+
+// getConfigOverride handles the GET requests for configuration overrides of a scope:
+// /config/defaults, /groups/:id/config and /agents/:id/config/overrides
+func (app *application) getConfigOverride(scope string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		scopeID, err := readScopeID(c, scope)
+		if err != nil {
+			app.logger.Errorf("Invalid %s ID: %v", scope, err)
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid "+scope+" ID")))
+		}
+
+		override, err := app.service.GetConfigOverride(scope, scopeID)
+		if err != nil {
+			app.logger.Errorf("Failed to retrieve %s configuration override: %v", scope, err)
+			return app.configErrorResponse(c, err)
+		}
+
+		return c.JSON(http.StatusOK, override)
+	}
+}
+
+// setConfigOverride handles the PUT requests for configuration overrides of a scope
+// The body is a partial settings document; keys set to null remove inherited values
+func (app *application) setConfigOverride(scope string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		scopeID, err := readScopeID(c, scope)
+		if err != nil {
+			app.logger.Errorf("Invalid %s ID: %v", scope, err)
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid "+scope+" ID")))
+		}
+
+		var override map[string]any
+		err = c.Bind(&override)
+		if err != nil {
+			app.logger.Errorf("Failed to bind configuration override: %v", err)
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		}
+
+		err = app.service.SetConfigOverride(scope, scopeID, override)
+		if err != nil {
+			app.logger.Errorf("Failed to store %s configuration override: %v", scope, err)
+			return app.configErrorResponse(c, err)
+		}
+
+		return c.JSON(http.StatusOK, override)
+	}
+}
+
+// deleteConfigOverride handles the DELETE requests for configuration overrides of a scope
+func (app *application) deleteConfigOverride(scope string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		scopeID, err := readScopeID(c, scope)
+		if err != nil {
+			app.logger.Errorf("Invalid %s ID: %v", scope, err)
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid "+scope+" ID")))
+		}
+
+		err = app.service.DeleteConfigOverride(scope, scopeID)
+		if err != nil {
+			app.logger.Errorf("Failed to delete %s configuration override: %v", scope, err)
+			return app.configErrorResponse(c, err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// readScopeID returns the agent or group ID of a configuration scope, or 0 for the global scope
+func readScopeID(c echo.Context, scope string) (int, error) {
+	if scope == service.ConfigScopeGlobal {
+		return 0, nil
+	}
+	return readIDParam(c, "id")
+}
+
+// configErrorResponse maps configuration errors to HTTP responses
+func (app *application) configErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrConfigOverrideNotFound),
+		errors.Is(err, service.ErrAgentNotFound),
+		errors.Is(err, service.ErrGroupNotFound):
+		return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+	case errors.Is(err, service.ErrInvalidConfig):
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+	return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+}
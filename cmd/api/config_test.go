@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetAgentConfigHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{agentConfig: service.AgentConfig{AgentID: 1, Version: 3, ETag: `"3-abc"`}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/agents/1/config", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, app.getAgentConfig(c))
+		return rec
+	}
+
+	t.Run("Returns Configuration With ETag", func(t *testing.T) {
+		rec := get("")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"3-abc"`, rec.Header().Get("ETag"))
+		assert.Contains(t, rec.Body.String(), `"version":3`)
+	})
+
+	t.Run("Not Modified", func(t *testing.T) {
+		rec := get(`"3-abc"`)
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.String())
+	})
+
+	t.Run("Not Modified For Weak Tags, Lists And Wildcards", func(t *testing.T) {
+		for _, ifNoneMatch := range []string{`W/"3-abc"`, `"2-def", "3-abc"`, `*`} {
+			rec := get(ifNoneMatch)
+			assert.Equal(t, http.StatusNotModified, rec.Code, ifNoneMatch)
+		}
+	})
+
+	t.Run("Returns Configuration For Other Tags", func(t *testing.T) {
+		rec := get(`"2-def", W/"3-ab"`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+		rec := get("")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestSetConfigOverrideHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{}
+	app := &application{logger: e.Logger, service: mockService}
+
+	set := func(scope, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, app.setConfigOverride(scope)(c))
+		return rec
+	}
+
+	t.Run("Stores Global Defaults", func(t *testing.T) {
+		rec := set(service.ConfigScopeGlobal, "", `{"intervals": {"heartbeat": 10}}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, mockService.override, "intervals")
+	})
+
+	t.Run("Invalid Group ID", func(t *testing.T) {
+		rec := set(service.ConfigScopeGroup, "abc", `{}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Invalid Override", func(t *testing.T) {
+		mockService.err = service.ErrInvalidConfig
+		rec := set(service.ConfigScopeAgent, "1", `{"unknown": true}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+		rec := set(service.ConfigScopeAgent, "1", `{}`)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
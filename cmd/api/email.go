@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// createEmailRoute handles the POST /email-routes request
+// It sends alert events to a recipient, right away or batched into digests
+func (app *application) createEmailRoute(c echo.Context) error {
+	var routeRequest service.EmailRouteRequest
+	err := c.Bind(&routeRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind email route request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(routeRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate email route request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	route, err := app.service.CreateEmailRoute(routeRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to create email route: %v", err)
+		return app.emailErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, route)
+}
+
+// getEmailRoutes handles the GET /email-routes request
+// It retrieves all email routes
+func (app *application) getEmailRoutes(c echo.Context) error {
+	routes, err := app.service.GetEmailRoutes()
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve email routes: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, routes)
+}
+
+// getEmailRoute handles the GET /email-routes/:id request
+// It retrieves a single email route
+func (app *application) getEmailRoute(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid email route ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid email route ID")))
+	}
+
+	route, err := app.service.GetEmailRoute(id)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve email route with ID %d: %v", id, err)
+		return app.emailErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, route)
+}
+
+// updateEmailRoute handles the PUT /email-routes/:id request
+// It replaces the recipient, filters and digest interval of a route
+func (app *application) updateEmailRoute(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid email route ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid email route ID")))
+	}
+
+	var routeRequest service.EmailRouteRequest
+	err = c.Bind(&routeRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind email route request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(routeRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate email route request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	route, err := app.service.UpdateEmailRoute(id, routeRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to update email route with ID %d: %v", id, err)
+		return app.emailErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, route)
+}
+
+// deleteEmailRoute handles the DELETE /email-routes/:id request
+// It removes an email route together with its notifications
+func (app *application) deleteEmailRoute(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid email route ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid email route ID")))
+	}
+
+	err = app.service.DeleteEmailRoute(id)
+	if err != nil {
+		app.logger.Errorf("Failed to delete email route with ID %d: %v", id, err)
+		return app.emailErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getEmailNotifications handles the GET /email-notifications request
+// It lists queued and sent notifications, filtered by status or route
+func (app *application) getEmailNotifications(c echo.Context) error {
+	var filter service.EmailNotificationFilter
+	err := c.Bind(&filter)
+	if err != nil {
+		app.logger.Errorf("Failed to bind email notification filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to validate email notification filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	notifications, err := app.service.GetEmailNotifications(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve email notifications: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, notifications)
+}
+
+// emailErrorResponse maps email route errors to HTTP responses
+func (app *application) emailErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrEmailRouteNotFound):
+		return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+	case errors.Is(err, service.ErrInvalidEmailRoute):
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+	return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateEmailRouteHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{emailRoute: service.EmailRoute{ID: 1, Name: "on call"}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/email-routes", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.createEmailRoute(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Successfully Create Email Route", func(t *testing.T) {
+		rec := create(`{"name": "on call", "recipient": "oncall@example.com", "event_types": ["alert.firing"], "digest_seconds": 300}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"name":"on call"`)
+	})
+
+	t.Run("Invalid Recipient", func(t *testing.T) {
+		rec := create(`{"name": "on call", "recipient": "not an address", "event_types": ["alert.firing"]}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Non Alert Event Type", func(t *testing.T) {
+		rec := create(`{"name": "on call", "recipient": "oncall@example.com", "event_types": ["agent.created"]}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Unknown Rule", func(t *testing.T) {
+		mockService.err = service.ErrInvalidEmailRoute
+		rec := create(`{"name": "on call", "recipient": "oncall@example.com", "event_types": ["alert.firing"], "rule_id": 99}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestGetEmailNotificationsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{emails: []service.EmailNotification{{ID: 1, Status: service.EmailPending}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	list := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/email-notifications?"+query, nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.getEmailNotifications(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Filter By Status", func(t *testing.T) {
+		rec := list("status=pending&route_id=2")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"status":"pending"`)
+	})
+
+	t.Run("Unknown Status", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, list("status=bounced").Code)
+	})
+}
+
+func TestDeleteEmailRouteHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{err: service.ErrEmailRouteNotFound}
+	app := &application{logger: e.Logger, service: mockService}
+
+	req := httptest.NewRequest(http.MethodDelete, "/email-routes/7", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+	assert.NoError(t, app.deleteEmailRoute(c))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
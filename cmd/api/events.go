@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// getEvents handles the GET /events request
+// It lists recorded events, latest first, optionally of one type or agent
+func (app *application) getEvents(c echo.Context) error {
+	var filter service.EventFilter
+	err := c.Bind(&filter)
+	if err != nil {
+		app.logger.Errorf("Failed to bind event filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to validate event filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	events, err := app.service.GetEvents(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve events: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetEventsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{events: []service.Event{{ID: 1, Type: service.EventAgentISPChanged}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	list := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/events?"+query, nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.getEvents(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Filter By Type And Agent", func(t *testing.T) {
+		rec := list("type=agent.isp_changed&agent_id=3&limit=10")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"type":"agent.isp_changed"`)
+		assert.Equal(t, service.EventFilter{Type: "agent.isp_changed", AgentID: 3, Limit: 10}, mockService.eventFilter)
+	})
+
+	t.Run("Unknown Type", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, list("type=agent.moved").Code)
+	})
+
+	t.Run("Limit Too Large", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, list("limit=5000").Code)
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// createGroup handles the POST /groups request
+// It creates an agent group and provisions the sessions its topology calls for
+func (app *application) createGroup(c echo.Context) error {
+	var groupRequest service.AgentGroupRequest
+	err := c.Bind(&groupRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind group request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(groupRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate group request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	group, err := app.service.CreateGroup(groupRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to create group: %v", err)
+		return app.groupErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, group)
+}
+
+// getGroups handles the GET /groups request
+// It retrieves all agent groups with their members
+func (app *application) getGroups(c echo.Context) error {
+	groups, err := app.service.GetGroups()
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve groups: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, groups)
+}
+
+// getGroup handles the GET /groups/:id request
+// It retrieves a single agent group with its members and links
+func (app *application) getGroup(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid group ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid group ID")))
+	}
+
+	group, err := app.service.GetGroup(id)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve group with ID %d: %v", id, err)
+		return app.groupErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, group)
+}
+
+// updateGroup handles the PUT /groups/:id request
+// It replaces the settings of an agent group and reconciles its sessions
+func (app *application) updateGroup(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid group ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid group ID")))
+	}
+
+	var groupRequest service.AgentGroupRequest
+	err = c.Bind(&groupRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind group request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(groupRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate group request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	group, err := app.service.UpdateGroup(id, groupRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to update group with ID %d: %v", id, err)
+		return app.groupErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, group)
+}
+
+// deleteGroup handles the DELETE /groups/:id request
+// It removes an agent group together with the sessions it provisioned
+func (app *application) deleteGroup(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid group ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid group ID")))
+	}
+
+	err = app.service.DeleteGroup(id)
+	if err != nil {
+		app.logger.Errorf("Failed to delete group with ID %d: %v", id, err)
+		return app.groupErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// addGroupMember handles the PUT /groups/:id/members/:agent_id request
+// It adds an agent to a group and returns the session changes
+func (app *application) addGroupMember(c echo.Context) error {
+	return app.changeGroupMember(c, app.service.AddGroupMember)
+}
+
+// removeGroupMember handles the DELETE /groups/:id/members/:agent_id request
+// It removes an agent from a group and returns the session changes
+func (app *application) removeGroupMember(c echo.Context) error {
+	return app.changeGroupMember(c, app.service.RemoveGroupMember)
+}
+
+// reconcileGroup handles the POST /groups/:id/reconcile request
+// It repairs a group's sessions, for instance after sessions were edited by hand
+func (app *application) reconcileGroup(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid group ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid group ID")))
+	}
+
+	result, err := app.service.ReconcileGroup(id)
+	if err != nil {
+		app.logger.Errorf("Failed to reconcile group with ID %d: %v", id, err)
+		return app.groupErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// changeGroupMember reads the group and agent IDs of a membership request and applies change
+func (app *application) changeGroupMember(c echo.Context, change func(groupID, agentID int) (service.ReconcileResult, error)) error {
+	groupID, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid group ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid group ID")))
+	}
+	agentID, err := readIDParam(c, "agent_id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	result, err := change(groupID, agentID)
+	if err != nil {
+		app.logger.Errorf("Failed to change membership of agent %d in group %d: %v", agentID, groupID, err)
+		return app.groupErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// groupErrorResponse maps agent group errors to HTTP responses
+func (app *application) groupErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrGroupNotFound), errors.Is(err, service.ErrAgentNotFound):
+		return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+	case errors.Is(err, service.ErrInvalidGroup):
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+	return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+}
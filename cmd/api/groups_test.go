@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateGroupHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{group: service.AgentGroup{ID: 1, Name: "branches", Topology: "full_mesh", MemberIDs: []int{}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/groups", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.createGroup(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Successfully Create Group", func(t *testing.T) {
+		mockService.err = nil
+		rec := create(`{"name": "branches", "topology": "full_mesh", "protocol": "udp", "interval_seconds": 10, "packet_size": 64}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("Hub Required For Hub And Spoke", func(t *testing.T) {
+		rec := create(`{"name": "branches", "topology": "hub_and_spoke", "protocol": "udp", "interval_seconds": 10, "packet_size": 64}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Duplicate Name", func(t *testing.T) {
+		mockService.err = service.ErrInvalidGroup
+		rec := create(`{"name": "branches", "topology": "full_mesh", "protocol": "udp", "interval_seconds": 10, "packet_size": 64}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestGroupMemberHandlers(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{}
+	app := &application{logger: e.Logger, service: mockService}
+
+	member := func(method, agentID string, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/groups/1/members/"+agentID, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id", "agent_id")
+		c.SetParamValues("1", agentID)
+		assert.NoError(t, handler(c))
+		return rec
+	}
+
+	t.Run("Successfully Add Member", func(t *testing.T) {
+		mockService.err = nil
+		mockService.reconcile = service.ReconcileResult{Created: 4}
+
+		rec := member(http.MethodPut, "3", app.addGroupMember)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"created": 4, "updated": 0, "deleted": 0}`, rec.Body.String())
+	})
+
+	t.Run("Successfully Remove Member", func(t *testing.T) {
+		mockService.err = nil
+		mockService.reconcile = service.ReconcileResult{Deleted: 4}
+
+		rec := member(http.MethodDelete, "3", app.removeGroupMember)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Invalid Agent ID", func(t *testing.T) {
+		rec := member(http.MethodPut, "abc", app.addGroupMember)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Group Not Found", func(t *testing.T) {
+		mockService.err = service.ErrGroupNotFound
+
+		rec := member(http.MethodPut, "3", app.addGroupMember)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
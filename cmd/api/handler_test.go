@@ -10,27 +10,418 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 // Mock Service
 type MockService struct {
-	agents    []service.Agent
-	agentResp service.DetailedAgentResponse
-	err       error
+	agents           []service.Agent
+	agentFilter      service.AgentFilter
+	geoJSON          service.FeatureCollection
+	agentResp        service.DetailedAgentResponse
+	ingestResult     service.IngestResult
+	queryResp        service.MetricQueryResponse
+	lastQuery        service.MetricQuery
+	session          service.MonitoringSession
+	sessions         []service.MonitoringSession
+	assigned         []service.AssignedSession
+	group            service.AgentGroup
+	groups           []service.AgentGroup
+	reconcile        service.ReconcileResult
+	agentConfig      service.AgentConfig
+	override         map[string]any
+	rule             service.AlertRule
+	rules            []service.AlertRule
+	alert            service.Alert
+	alerts           []service.Alert
+	lastFilter       service.AlertFilter
+	history          []service.AlertTransition
+	webhook          service.WebhookSubscription
+	webhooks         []service.WebhookSubscription
+	delivery         service.WebhookDelivery
+	deliveries       []service.WebhookDelivery
+	prefixGroups     []service.PrefixGroup
+	asns             []service.ASN
+	asn              uint32
+	agentStats       service.AgentStats
+	statsQuery       service.AgentStatsQuery
+	events           []service.Event
+	eventFilter      service.EventFilter
+	emailRoute       service.EmailRoute
+	emailRoutes      []service.EmailRoute
+	emails           []service.EmailNotification
+	silence          service.Silence
+	silences         []service.Silence
+	window           service.MaintenanceWindow
+	windows          []service.MaintenanceWindow
+	provenance       []service.FieldProvenance
+	provenanceFilter service.ProvenanceFilter
+	labels           map[string]string
+	patch            service.AgentPatchRequest
+	region           service.Region
+	regions          []service.Region
+	site             service.Site
+	sites            []service.Site
+	siteFilter       service.SiteFilter
+	stateRequest     service.AgentStateRequest
+	transitions      []service.AgentStateTransition
+	err              error
 }
 
-func (m *MockService) AddAgent(ipAddress string) error {
+func (m *MockService) AddAgent(ipAddress string, labels map[string]string) error {
+	m.labels = labels
 	return m.err
 }
 
-func (m *MockService) GetAgents() ([]service.Agent, error) {
+func (m *MockService) PatchAgent(id int, req service.AgentPatchRequest) (service.DetailedAgentResponse, error) {
+	m.patch = req
+	return m.agentResp, m.err
+}
+
+func (m *MockService) GetAgents(filter service.AgentFilter) ([]service.Agent, error) {
+	m.agentFilter = filter
+	return m.agents, m.err
+}
+
+func (m *MockService) GetNearestAgents(id int, q service.NearestQuery) ([]service.Agent, error) {
 	return m.agents, m.err
 }
 
+func (m *MockService) GetAgentsGeoJSON(filter service.AgentFilter) (service.FeatureCollection, error) {
+	m.agentFilter = filter
+	return m.geoJSON, m.err
+}
+
 func (m *MockService) GetAgent(id int) (service.DetailedAgentResponse, error) {
 	return m.agentResp, m.err
 }
 
+func (m *MockService) DeleteAgent(id int) error {
+	return m.err
+}
+
+func (m *MockService) RestoreAgent(id int) (service.DetailedAgentResponse, error) {
+	return m.agentResp, m.err
+}
+
+func (m *MockService) IngestMetrics(agentID int, batch service.MetricBatch) (service.IngestResult, error) {
+	return m.ingestResult, m.err
+}
+
+func (m *MockService) QueryMetrics(agentID int, q service.MetricQuery) (service.MetricQueryResponse, error) {
+	m.lastQuery = q
+	return m.queryResp, m.err
+}
+
+func (m *MockService) CreateSession(req service.MonitoringSessionRequest) (service.MonitoringSession, error) {
+	return m.session, m.err
+}
+
+func (m *MockService) GetSessions() ([]service.MonitoringSession, error) {
+	return m.sessions, m.err
+}
+
+func (m *MockService) GetSession(id int) (service.MonitoringSession, error) {
+	return m.session, m.err
+}
+
+func (m *MockService) UpdateSession(id int, req service.MonitoringSessionRequest) (service.MonitoringSession, error) {
+	return m.session, m.err
+}
+
+func (m *MockService) DeleteSession(id int) error {
+	return m.err
+}
+
+func (m *MockService) GetAgentSessions(agentID int) ([]service.AssignedSession, error) {
+	return m.assigned, m.err
+}
+
+func (m *MockService) IngestSessionMetrics(sessionID int, batch service.MetricBatch) (service.IngestResult, error) {
+	return m.ingestResult, m.err
+}
+
+func (m *MockService) QuerySessionMetrics(sessionID int, q service.MetricQuery) (service.MetricQueryResponse, error) {
+	m.lastQuery = q
+	return m.queryResp, m.err
+}
+
+func (m *MockService) CreateGroup(req service.AgentGroupRequest) (service.AgentGroup, error) {
+	return m.group, m.err
+}
+
+func (m *MockService) GetGroups() ([]service.AgentGroup, error) {
+	return m.groups, m.err
+}
+
+func (m *MockService) GetGroup(id int) (service.AgentGroup, error) {
+	return m.group, m.err
+}
+
+func (m *MockService) UpdateGroup(id int, req service.AgentGroupRequest) (service.AgentGroup, error) {
+	return m.group, m.err
+}
+
+func (m *MockService) DeleteGroup(id int) error {
+	return m.err
+}
+
+func (m *MockService) AddGroupMember(groupID, agentID int) (service.ReconcileResult, error) {
+	return m.reconcile, m.err
+}
+
+func (m *MockService) RemoveGroupMember(groupID, agentID int) (service.ReconcileResult, error) {
+	return m.reconcile, m.err
+}
+
+func (m *MockService) ReconcileGroup(groupID int) (service.ReconcileResult, error) {
+	return m.reconcile, m.err
+}
+
+func (m *MockService) GetAgentConfig(agentID int) (service.AgentConfig, error) {
+	return m.agentConfig, m.err
+}
+
+func (m *MockService) GetConfigOverride(scope string, scopeID int) (map[string]any, error) {
+	return m.override, m.err
+}
+
+func (m *MockService) SetConfigOverride(scope string, scopeID int, override map[string]any) error {
+	m.override = override
+	return m.err
+}
+
+func (m *MockService) DeleteConfigOverride(scope string, scopeID int) error {
+	return m.err
+}
+
+func (m *MockService) CreateAlertRule(req service.AlertRuleRequest) (service.AlertRule, error) {
+	return m.rule, m.err
+}
+
+func (m *MockService) GetAlertRules() ([]service.AlertRule, error) {
+	return m.rules, m.err
+}
+
+func (m *MockService) GetAlertRule(id int) (service.AlertRule, error) {
+	return m.rule, m.err
+}
+
+func (m *MockService) UpdateAlertRule(id int, req service.AlertRuleRequest) (service.AlertRule, error) {
+	return m.rule, m.err
+}
+
+func (m *MockService) DeleteAlertRule(id int) error {
+	return m.err
+}
+
+func (m *MockService) GetAlerts(filter service.AlertFilter) ([]service.Alert, error) {
+	m.lastFilter = filter
+	return m.alerts, m.err
+}
+
+func (m *MockService) GetAlert(id int) (service.Alert, error) {
+	return m.alert, m.err
+}
+
+func (m *MockService) GetAlertHistory(id int) ([]service.AlertTransition, error) {
+	return m.history, m.err
+}
+
+func (m *MockService) RecordHeartbeat(agentID int) error {
+	return m.err
+}
+
+func (m *MockService) CreateWebhook(req service.WebhookSubscriptionRequest) (service.WebhookSubscription, error) {
+	return m.webhook, m.err
+}
+
+func (m *MockService) GetWebhooks() ([]service.WebhookSubscription, error) {
+	return m.webhooks, m.err
+}
+
+func (m *MockService) GetWebhook(id int) (service.WebhookSubscription, error) {
+	return m.webhook, m.err
+}
+
+func (m *MockService) UpdateWebhook(id int, req service.WebhookSubscriptionRequest) (service.WebhookSubscription, error) {
+	return m.webhook, m.err
+}
+
+func (m *MockService) DeleteWebhook(id int) error {
+	return m.err
+}
+
+func (m *MockService) SendTestWebhook(id int, now time.Time) (service.WebhookDelivery, error) {
+	return m.delivery, m.err
+}
+
+func (m *MockService) GetPrefixGroups() ([]service.PrefixGroup, error) {
+	return m.prefixGroups, m.err
+}
+
+func (m *MockService) GetASNs() ([]service.ASN, error) {
+	return m.asns, m.err
+}
+
+func (m *MockService) GetASNAgents(asn uint32) ([]service.Agent, error) {
+	m.asn = asn
+	return m.agents, m.err
+}
+
+func (m *MockService) GetAgentStats(q service.AgentStatsQuery, now time.Time) (service.AgentStats, error) {
+	m.statsQuery = q
+	return m.agentStats, m.err
+}
+
+func (m *MockService) SetAgentOverrides(id int, req service.AgentOverrideRequest) (service.DetailedAgentResponse, error) {
+	return m.agentResp, m.err
+}
+
+func (m *MockService) SetAgentState(id int, req service.AgentStateRequest) (service.DetailedAgentResponse, error) {
+	m.stateRequest = req
+	return m.agentResp, m.err
+}
+
+func (m *MockService) GetAgentStateHistory(id int) ([]service.AgentStateTransition, error) {
+	return m.transitions, m.err
+}
+
+func (m *MockService) GetAgentProvenance(id int) ([]service.FieldProvenance, error) {
+	return m.provenance, m.err
+}
+
+func (m *MockService) GetProvenanceHistory(id int, filter service.ProvenanceFilter) ([]service.FieldProvenance, error) {
+	m.provenanceFilter = filter
+	return m.provenance, m.err
+}
+
+func (m *MockService) ClearAgentOverride(id int, field string) (service.DetailedAgentResponse, error) {
+	return m.agentResp, m.err
+}
+
+func (m *MockService) CreateRegion(req service.RegionRequest) (service.Region, error) {
+	return m.region, m.err
+}
+
+func (m *MockService) GetRegions() ([]service.Region, error) {
+	return m.regions, m.err
+}
+
+func (m *MockService) GetRegion(id int) (service.Region, error) {
+	return m.region, m.err
+}
+
+func (m *MockService) UpdateRegion(id int, req service.RegionRequest) (service.Region, error) {
+	return m.region, m.err
+}
+
+func (m *MockService) DeleteRegion(id int) error {
+	return m.err
+}
+
+func (m *MockService) CreateSite(req service.SiteRequest, now time.Time) (service.Site, error) {
+	return m.site, m.err
+}
+
+func (m *MockService) GetSites(filter service.SiteFilter, now time.Time) ([]service.Site, error) {
+	m.siteFilter = filter
+	return m.sites, m.err
+}
+
+func (m *MockService) GetSite(id int, now time.Time) (service.Site, error) {
+	return m.site, m.err
+}
+
+func (m *MockService) UpdateSite(id int, req service.SiteRequest, now time.Time) (service.Site, error) {
+	return m.site, m.err
+}
+
+func (m *MockService) DeleteSite(id int) error {
+	return m.err
+}
+
+func (m *MockService) GetSiteAgents(id int) ([]service.Agent, error) {
+	return m.agents, m.err
+}
+
+func (m *MockService) GetEvents(filter service.EventFilter) ([]service.Event, error) {
+	m.eventFilter = filter
+	return m.events, m.err
+}
+
+func (m *MockService) GetDeliveries(filter service.DeliveryFilter) ([]service.WebhookDelivery, error) {
+	return m.deliveries, m.err
+}
+
+func (m *MockService) RetryDelivery(id int, now time.Time) (service.WebhookDelivery, error) {
+	return m.delivery, m.err
+}
+
+func (m *MockService) CreateEmailRoute(req service.EmailRouteRequest) (service.EmailRoute, error) {
+	return m.emailRoute, m.err
+}
+
+func (m *MockService) GetEmailRoutes() ([]service.EmailRoute, error) {
+	return m.emailRoutes, m.err
+}
+
+func (m *MockService) GetEmailRoute(id int) (service.EmailRoute, error) {
+	return m.emailRoute, m.err
+}
+
+func (m *MockService) UpdateEmailRoute(id int, req service.EmailRouteRequest) (service.EmailRoute, error) {
+	return m.emailRoute, m.err
+}
+
+func (m *MockService) DeleteEmailRoute(id int) error {
+	return m.err
+}
+
+func (m *MockService) GetEmailNotifications(filter service.EmailNotificationFilter) ([]service.EmailNotification, error) {
+	return m.emails, m.err
+}
+
+func (m *MockService) CreateSilence(req service.SilenceRequest, now time.Time) (service.Silence, error) {
+	return m.silence, m.err
+}
+
+func (m *MockService) GetSilences(filter service.SilenceFilter, now time.Time) ([]service.Silence, error) {
+	return m.silences, m.err
+}
+
+func (m *MockService) GetSilence(id int, now time.Time) (service.Silence, error) {
+	return m.silence, m.err
+}
+
+func (m *MockService) UpdateSilence(id int, req service.SilenceRequest, now time.Time) (service.Silence, error) {
+	return m.silence, m.err
+}
+
+func (m *MockService) ExpireSilence(id int, now time.Time) (service.Silence, error) {
+	return m.silence, m.err
+}
+
+func (m *MockService) CreateMaintenanceWindow(req service.MaintenanceWindowRequest, now time.Time) (service.MaintenanceWindow, error) {
+	return m.window, m.err
+}
+
+func (m *MockService) GetMaintenanceWindows(now time.Time) ([]service.MaintenanceWindow, error) {
+	return m.windows, m.err
+}
+
+func (m *MockService) GetMaintenanceWindow(id int, now time.Time) (service.MaintenanceWindow, error) {
+	return m.window, m.err
+}
+
+func (m *MockService) UpdateMaintenanceWindow(id int, req service.MaintenanceWindowRequest, now time.Time) (service.MaintenanceWindow, error) {
+	return m.window, m.err
+}
+
+func (m *MockService) DeleteMaintenanceWindow(id int) error {
+	return m.err
+}
+
 func getEchoInstance() *echo.Echo {
 	e := echo.New()
 	e.Validator = utils.NewValidator()
@@ -70,6 +461,20 @@ func TestAddAgentHandler(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
 	})
 
+	t.Run("Deleted Agent", func(t *testing.T) {
+		mockService.err = service.ErrAgentDeleted
+		requestBody := `{"ip_address": "8.8.8.8"}`
+
+		req := httptest.NewRequest(http.MethodPost, "/agents", bytes.NewReader([]byte(requestBody)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := app.addAgent(c)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
 	t.Run("Database Error", func(t *testing.T) {
 		mockService.err = errors.New("DB error")
 		requestBody := `{"ip_address": "8.8.8.8"}`
@@ -110,6 +515,74 @@ func TestGetAgentsHandler(t *testing.T) {
 		assert.JSONEq(t, expectedResponse, rec.Body.String())
 	})
 
+	t.Run("Filter By Country And Region", func(t *testing.T) {
+		mockService.err = nil
+
+		req := httptest.NewRequest(http.MethodGet, "/agents?country=CA&region=Quebec", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := app.getAgents(c)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, service.AgentFilter{Country: "CA", Region: "Quebec"}, mockService.agentFilter)
+	})
+
+	t.Run("Filter By Network Flags", func(t *testing.T) {
+		mockService.err = nil
+
+		req := httptest.NewRequest(http.MethodGet, "/agents?proxy=true&mobile=false", nil)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, app.getAgents(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.NotNil(t, mockService.agentFilter.Proxy) && assert.NotNil(t, mockService.agentFilter.Mobile) {
+			assert.True(t, *mockService.agentFilter.Proxy)
+			assert.False(t, *mockService.agentFilter.Mobile)
+		}
+		assert.Nil(t, mockService.agentFilter.Hosting)
+	})
+
+	t.Run("Invalid Network Flag", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/agents?hosting=maybe", nil)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, app.getAgents(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Filter By Prefix", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/agents?prefix=8.8.8.0/24", nil)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, app.getAgents(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "8.8.8.0/24", mockService.agentFilter.Prefix)
+
+		req = httptest.NewRequest(http.MethodGet, "/agents?prefix=8.8.8.8", nil)
+		rec = httptest.NewRecorder()
+		assert.NoError(t, app.getAgents(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Near Without Radius", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/agents?near=45.5,-73.57", nil)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, app.getAgents(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Malformed Location", func(t *testing.T) {
+		mockService.err = service.ErrInvalidAgentFilter
+
+		req := httptest.NewRequest(http.MethodGet, "/agents?near=north&radius_km=50", nil)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, app.getAgents(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
 	t.Run("Database Error", func(t *testing.T) {
 		mockService.err = errors.New("DB error")
 
@@ -123,11 +596,68 @@ func TestGetAgentsHandler(t *testing.T) {
 	})
 }
 
+func TestGetNearestAgentsHandler(t *testing.T) {
+	e := getEchoInstance()
+	distance := 12.5
+	mockService := &MockService{agents: []service.Agent{{ID: 2, IPAddress: "1.1.1.1", DistanceKm: &distance}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	nearest := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/agents/1/nearest?"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, app.getNearestAgents(c))
+		return rec
+	}
+
+	t.Run("Successfully Retrieve Nearest Agents", func(t *testing.T) {
+		rec := nearest("limit=5")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `[{"id":2, "ip_address":"1.1.1.1", "distance_km":12.5}]`, rec.Body.String())
+	})
+
+	t.Run("Limit Too Large", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, nearest("limit=1000").Code)
+	})
+
+	t.Run("Agent Without Location", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotLocated
+		assert.Equal(t, http.StatusBadRequest, nearest("").Code)
+	})
+}
+
+func TestGetAgentsGeoJSONHandler(t *testing.T) {
+	e := getEchoInstance()
+	lat, lon := 45.5, -73.57
+	mockService := &MockService{
+		geoJSON: service.FeatureCollection{Type: "FeatureCollection", Features: []service.Feature{{
+			Type: "Feature", ID: 1,
+			Geometry: service.Point{Type: "Point", Coordinates: [2]float64{lon, lat}},
+			Properties: service.DetailedAgentResponse{ID: 1, IPAddress: "8.8.8.8", Geolocation: service.Geolocation{
+				City: "Montreal", Latitude: &lat, Longitude: &lon,
+			}},
+		}}},
+	}
+	app := &application{logger: e.Logger, service: mockService}
+
+	req := httptest.NewRequest(http.MethodGet, "/agents/geojson?country=ca", nil)
+	rec := httptest.NewRecorder()
+
+	assert.NoError(t, app.getAgentsGeoJSON(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/geo+json", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `"geometry":{"type":"Point","coordinates":[-73.57,45.5]}`)
+	assert.Equal(t, "ca", mockService.agentFilter.Country)
+}
+
 func TestGetAgentHandler(t *testing.T) {
 	e := getEchoInstance()
 	mockService := &MockService{
 		agentResp: service.DetailedAgentResponse{
 			ID: 1, IPAddress: "8.8.8.8", ASN: "15169", ISP: "Google LLC",
+			NetworkFlags: service.NetworkFlags{Hosting: true},
 		},
 	}
 	app := &application{logger: e.Logger, service: mockService}
@@ -145,7 +675,7 @@ func TestGetAgentHandler(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, rec.Code)
 
-		expectedResponse := `{"id":1, "ip_address":"8.8.8.8", "asn":"15169", "isp":"Google LLC"}`
+		expectedResponse := `{"id":1, "ip_address":"8.8.8.8", "asn":"15169", "isp":"Google LLC", "hosting":true, "proxy":false, "mobile":false}`
 		assert.JSONEq(t, expectedResponse, rec.Body.String())
 	})
 
@@ -175,3 +705,74 @@ func TestGetAgentHandler(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
 	})
 }
+
+func TestDeleteAgentHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{}
+	app := &application{logger: e.Logger, service: mockService}
+
+	remove := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/agents/"+id, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, app.deleteAgent(c))
+		return rec
+	}
+
+	t.Run("Successfully Delete Agent", func(t *testing.T) {
+		mockService.err = nil
+		assert.Equal(t, http.StatusNoContent, remove("1").Code)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+		assert.Equal(t, http.StatusNotFound, remove("99").Code)
+	})
+}
+
+func TestRestoreAgentHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{agentResp: service.DetailedAgentResponse{ID: 1, IPAddress: "8.8.8.8"}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	restore := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/agents/"+id+"/restore", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, app.restoreAgent(c))
+		return rec
+	}
+
+	t.Run("Successfully Restore Agent", func(t *testing.T) {
+		rec := restore("1")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"ip_address":"8.8.8.8"`)
+	})
+
+	t.Run("Agent Not Deleted", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotDeleted
+		assert.Equal(t, http.StatusBadRequest, restore("1").Code)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+		assert.Equal(t, http.StatusNotFound, restore("99").Code)
+	})
+}
+
+func TestGetDeletedAgentsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{agents: []service.Agent{{ID: 2, IPAddress: "1.1.1.1"}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	req := httptest.NewRequest(http.MethodGet, "/agents?deleted=true", nil)
+	rec := httptest.NewRecorder()
+
+	assert.NoError(t, app.getAgents(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, mockService.agentFilter.Deleted)
+}
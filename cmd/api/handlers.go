@@ -2,11 +2,13 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"github.com/Shaughny/obkio-test/internal/service"
 	"github.com/Shaughny/obkio-test/internal/utils"
 	"github.com/labstack/echo/v4"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 // addAgent handles the POST /agents request
@@ -31,10 +33,16 @@ func (app *application) addAgent(c echo.Context) error {
 	}
 
 	// Add the agent to the database
-	err = app.service.AddAgent(agentRequest.IPAddress)
+	err = app.service.AddAgent(agentRequest.IPAddress, agentRequest.Labels)
 	if err != nil {
 		// Log and return an internal server error if insertion fails
 		app.logger.Errorf("Failed to add agent: %v", err)
+		switch {
+		case errors.Is(err, service.ErrInvalidLabels):
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		case errors.Is(err, service.ErrAgentDeleted):
+			return c.JSON(http.StatusConflict, utils.ConflictResponse(err))
+		}
 		return c.JSON(http.StatusInternalServerError, utils.ServerErrorResponse(err))
 	}
 
@@ -43,10 +51,23 @@ func (app *application) addAgent(c echo.Context) error {
 }
 
 // getAgents handles the GET /agents request
-// It retrieves all registered agents from the database
+// It retrieves all registered agents from the database, optionally only those in a country or region
 func (app *application) getAgents(c echo.Context) error {
+	var filter service.AgentFilter
+	err := c.Bind(&filter)
+	if err != nil {
+		app.logger.Errorf("Failed to bind agent filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to validate agent filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
 	// Retrieve all agents from the service
-	agents, err := app.service.GetAgents()
+	agents, err := app.service.GetAgents(filter)
 	if err != nil {
 		// Log the error and check if the error is because no agents were found
 		app.logger.Errorf("Failed to retrieve agents: %v", err)
@@ -54,6 +75,9 @@ func (app *application) getAgents(c echo.Context) error {
 			// Return 404 Not Found if no agents exist
 			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
 		}
+		if errors.Is(err, service.ErrInvalidAgentFilter) {
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		}
 		// Return 500 Internal Server Error for any other failure
 		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
 	}
@@ -62,13 +86,77 @@ func (app *application) getAgents(c echo.Context) error {
 	return c.JSON(http.StatusOK, agents)
 }
 
+// getAgentsGeoJSON handles the GET /agents/geojson request
+// It exports the located agents as a GeoJSON FeatureCollection for map overlays
+func (app *application) getAgentsGeoJSON(c echo.Context) error {
+	var filter service.AgentFilter
+	err := c.Bind(&filter)
+	if err != nil {
+		app.logger.Errorf("Failed to bind agent filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to validate agent filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	collection, err := app.service.GetAgentsGeoJSON(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to export agents as GeoJSON: %v", err)
+		if errors.Is(err, service.ErrInvalidAgentFilter) {
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/geo+json")
+	return c.JSON(http.StatusOK, collection)
+}
+
+// getNearestAgents handles the GET /agents/:id/nearest request
+// It lists the agents closest to an agent by great-circle distance, for picking monitoring targets
+func (app *application) getNearestAgents(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	var query service.NearestQuery
+	err = c.Bind(&query)
+	if err != nil {
+		app.logger.Errorf("Failed to bind nearest agents query: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(query)
+	if err != nil {
+		app.logger.Errorf("Failed to validate nearest agents query: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	agents, err := app.service.GetNearestAgents(id, query)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve agents nearest to agent %d: %v", id, err)
+		switch {
+		case errors.Is(err, service.ErrAgentNotFound):
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		case errors.Is(err, service.ErrAgentNotLocated):
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, agents)
+}
+
 // getAgent handles the GET /agents/:id request
-// It retrieves details of a specific agent based on their ID
+// It retrieves details of a specific agent based on their ID, with where they come from
+// when include=provenance is given
 func (app *application) getAgent(c echo.Context) error {
-	id := c.Param("id")
-
-	// Convert the ID from string to integer
-	intId, err := strconv.Atoi(id)
+	intId, err := readIDParam(c, "id")
 	if err != nil {
 		// Log and return a bad request error if the ID is not a valid integer
 		app.logger.Errorf("Invalid agent ID: %v", err)
@@ -76,6 +164,17 @@ func (app *application) getAgent(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(customErr))
 	}
 
+	// Only provenance can be included for now
+	var withProvenance bool
+	if include := c.QueryParam("include"); include != "" {
+		for _, name := range strings.Split(include, ",") {
+			if name != "provenance" {
+				return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(fmt.Errorf("unknown include %q", name)))
+			}
+			withProvenance = true
+		}
+	}
+
 	// Retrieve the agent details from the database
 	agent, err := app.service.GetAgent(intId)
 	if err != nil {
@@ -88,7 +187,85 @@ func (app *application) getAgent(c echo.Context) error {
 		// Return 500 Internal Server Error for any other failure
 		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
 	}
+	if withProvenance {
+		agent.Provenance, err = app.service.GetAgentProvenance(intId)
+		if err != nil {
+			app.logger.Errorf("Failed to retrieve provenance of agent with ID %d: %v", intId, err)
+			return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+		}
+	}
 
 	// Return the agent details
 	return c.JSON(http.StatusOK, agent)
 }
+
+// deleteAgent handles the DELETE /agents/:id request
+// It hides an agent, which is purged with its metrics and sessions once the grace period is over
+func (app *application) deleteAgent(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	err = app.service.DeleteAgent(id)
+	if err != nil {
+		app.logger.Errorf("Failed to delete agent with ID %d: %v", id, err)
+		if errors.Is(err, service.ErrAgentNotFound) {
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// restoreAgent handles the POST /agents/:id/restore request
+// It brings back a deleted agent that was not purged yet
+func (app *application) restoreAgent(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	agent, err := app.service.RestoreAgent(id)
+	if err != nil {
+		app.logger.Errorf("Failed to restore agent with ID %d: %v", id, err)
+		switch {
+		case errors.Is(err, service.ErrAgentNotFound):
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		case errors.Is(err, service.ErrAgentNotDeleted):
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, agent)
+}
+
+// recordHeartbeat handles the POST /agents/:id/heartbeat request
+// Agents call it to show they are alive between metric batches
+func (app *application) recordHeartbeat(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	err = app.service.RecordHeartbeat(id)
+	if err != nil {
+		app.logger.Errorf("Failed to record heartbeat of agent %d: %v", id, err)
+		if errors.Is(err, service.ErrAgentNotFound) {
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// readIDParam converts the named path parameter to an integer ID
+func readIDParam(c echo.Context, name string) (int, error) {
+	return strconv.Atoi(c.Param(name))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runPeriodically calls job every interval until ctx is cancelled, logging failures.
+// It is meant to be started in its own goroutine.
+func (app *application) runPeriodically(ctx context.Context, name string, interval time.Duration, job func(now time.Time) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := job(now)
+			if err != nil {
+				app.logger.Errorf("Background job %s failed: %v", name, err)
+			}
+		}
+	}
+}
+
+// envDuration reads a Go duration such as 90s or 48h from the environment,
+// returning fallback if the variable is unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envInt reads an integer from the environment, returning fallback if the
+// variable is unset or invalid
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envBool reads a boolean such as true or 1 from the environment, returning
+// fallback if the variable is unset or invalid
+func envBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envList reads a comma-separated list from the environment, skipping empty entries
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
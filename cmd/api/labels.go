@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// patchAgent handles the PATCH /agents/:id request
+// It sets the given labels of an agent, removes those set to null and moves it to a site
+func (app *application) patchAgent(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	var patchRequest service.AgentPatchRequest
+	err = c.Bind(&patchRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind agent patch request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(patchRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate agent patch request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	agent, err := app.service.PatchAgent(id, patchRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to patch agent with ID %d: %v", id, err)
+		switch {
+		case errors.Is(err, service.ErrAgentNotFound):
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		case errors.Is(err, service.ErrInvalidLabels), errors.Is(err, service.ErrInvalidSite):
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, agent)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddAgentWithLabelsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{}
+	app := &application{logger: e.Logger, service: mockService}
+
+	add := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/agents", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.addAgent(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Labels Passed On", func(t *testing.T) {
+		rec := add(`{"ip_address": "8.8.8.8", "labels": {"env": "prod", "site": "mtl"}}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, map[string]string{"env": "prod", "site": "mtl"}, mockService.labels)
+	})
+
+	t.Run("Invalid Labels", func(t *testing.T) {
+		mockService.err = service.ErrInvalidLabels
+		rec := add(`{"ip_address": "8.8.8.8", "labels": {"env": "prod ready"}}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestPatchAgentHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{agentResp: service.DetailedAgentResponse{ID: 1, Labels: map[string]string{"env": "prod"}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	patch := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/agents/"+id, bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, app.patchAgent(c))
+		return rec
+	}
+
+	t.Run("Set And Remove Labels", func(t *testing.T) {
+		rec := patch("1", `{"labels": {"env": "prod", "site": null}}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"labels":{"env":"prod"}`)
+		if assert.Contains(t, mockService.patch.Labels, "site") {
+			assert.Nil(t, mockService.patch.Labels["site"])
+			assert.Equal(t, "prod", *mockService.patch.Labels["env"])
+		}
+	})
+
+	t.Run("Invalid ID Format", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, patch("abc", `{}`).Code)
+	})
+
+	t.Run("Negative Site ID", func(t *testing.T) {
+		mockService.patch = service.AgentPatchRequest{}
+		assert.Equal(t, http.StatusBadRequest, patch("1", `{"site_id": -1}`).Code)
+		assert.Nil(t, mockService.patch.SiteID)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+		assert.Equal(t, http.StatusNotFound, patch("99", `{"labels": {"env": "prod"}}`).Code)
+	})
+}
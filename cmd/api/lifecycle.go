@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// setAgentState handles the PUT /agents/:id/state request
+// It moves an agent to another lifecycle state, recording the reason
+func (app *application) setAgentState(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	var stateRequest service.AgentStateRequest
+	err = c.Bind(&stateRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind state request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(stateRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate state request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	agent, err := app.service.SetAgentState(id, stateRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to change state of agent with ID %d: %v", id, err)
+		switch {
+		case errors.Is(err, service.ErrAgentNotFound):
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		case errors.Is(err, service.ErrInvalidTransition):
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, agent)
+}
+
+// getAgentStateHistory handles the GET /agents/:id/state/history request
+// It lists the lifecycle state changes of an agent in the order they happened
+func (app *application) getAgentStateHistory(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	history, err := app.service.GetAgentStateHistory(id)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve state history of agent with ID %d: %v", id, err)
+		if errors.Is(err, service.ErrAgentNotFound) {
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
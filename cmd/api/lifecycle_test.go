@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetAgentStateHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{agentResp: service.DetailedAgentResponse{ID: 1, State: service.AgentStateDecommissioned}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/agents/1/state", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, app.setAgentState(c))
+		return rec
+	}
+
+	t.Run("Successfully Decommission Agent", func(t *testing.T) {
+		rec := put(`{"state": "decommissioned", "reason": "office closed"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, service.AgentStateRequest{State: "decommissioned", Reason: "office closed"}, mockService.stateRequest)
+		assert.Contains(t, rec.Body.String(), `"state":"decommissioned"`)
+	})
+
+	t.Run("Unknown State", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, put(`{"state": "retired", "reason": "office closed"}`).Code)
+	})
+
+	t.Run("Missing Reason", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, put(`{"state": "paused"}`).Code)
+	})
+
+	t.Run("Invalid Transition", func(t *testing.T) {
+		mockService.err = service.ErrInvalidTransition
+		assert.Equal(t, http.StatusBadRequest, put(`{"state": "active", "reason": "back online"}`).Code)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+		assert.Equal(t, http.StatusNotFound, put(`{"state": "paused", "reason": "moving"}`).Code)
+	})
+}
+
+func TestGetAgentStateHistoryHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{transitions: []service.AgentStateTransition{
+		{AgentID: 1, To: service.AgentStatePending, Reason: "registered"},
+		{AgentID: 1, From: service.AgentStatePending, To: service.AgentStateActive, Reason: "first report"},
+	}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	req := httptest.NewRequest(http.MethodGet, "/agents/1/state/history", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	assert.NoError(t, app.getAgentStateHistory(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `{"agent_id":1,"from":"pending","to":"active","reason":"first report"`)
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"github.com/Shaughny/obkio-test/config"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"github.com/Shaughny/obkio-test/internal/notify"
+	"github.com/Shaughny/obkio-test/internal/prefix"
 	"github.com/Shaughny/obkio-test/internal/service"
 	"github.com/Shaughny/obkio-test/internal/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"golang.org/x/time/rate"
+	"net/http"
 	"os"
 	"strconv"
+	"time"
 )
 
 // Application struct contains logger and service layer.
@@ -25,12 +31,74 @@ func main() {
 	DB := config.InitializeDB()
 
 	// Initialize application dependencies
-	app := &application{
-		logger: e.Logger, //
-		service: &service.Service{
-			DB: DB,
+	svc := &service.Service{
+		DB:            DB,
+		ServerURLs:    envList("SERVER_URLS"),
+		HTTPClient:    &http.Client{Timeout: envDuration("WEBHOOK_TIMEOUT", 10*time.Second)},
+		IPInfoURL:     os.Getenv("IP_API_URL"),
+		OfflineAfter:  envDuration("AGENT_OFFLINE_AFTER", 5*time.Minute),
+		PurgeAfter:    envDuration("AGENT_PURGE_AFTER", 30*24*time.Hour),
+		StatsCacheTTL: envDuration("STATS_CACHE_TTL", 30*time.Second),
+		Retention: service.RetentionPolicy{
+			Raw:    envDuration("RETENTION_RAW", 48*time.Hour),
+			Minute: envDuration("RETENTION_1M", 14*24*time.Hour),
+			Hour:   envDuration("RETENTION_1H", 90*24*time.Hour),
+			Day:    envDuration("RETENTION_1D", 0),
 		},
 	}
+	// Reverse DNS and RDAP enrichment of agent addresses are opt-in
+	if envBool("ENRICH_REVERSE_DNS", false) {
+		svc.ReverseDNS = &enrich.ReverseDNS{
+			Timeout:  envDuration("REVERSE_DNS_TIMEOUT", 2*time.Second),
+			CacheTTL: envDuration("ENRICHMENT_CACHE_TTL", enrich.DefaultCacheTTL),
+		}
+	}
+	if url := os.Getenv("RDAP_URL"); url != "" {
+		svc.RDAP = &enrich.RDAP{
+			BaseURL:  url,
+			Timeout:  envDuration("RDAP_TIMEOUT", 5*time.Second),
+			CacheTTL: envDuration("ENRICHMENT_CACHE_TTL", enrich.DefaultCacheTTL),
+		}
+	}
+	// Announced prefixes are resolved once a prefix-to-AS table is provided
+	if path := os.Getenv("PREFIX_TABLE_PATH"); path != "" {
+		table, err := prefix.Load(path)
+		if err != nil {
+			e.Logger.Fatal(err)
+		}
+		svc.Prefixes = table
+		err = svc.RefreshAgentPrefixes()
+		if err != nil {
+			e.Logger.Fatal(err)
+		}
+	}
+	// Email notifications are only sent once an SMTP server is configured
+	if os.Getenv("SMTP_HOST") != "" {
+		mailer := &notify.Mailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     envInt("SMTP_PORT", 587),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+			Security: os.Getenv("SMTP_SECURITY"),
+			Timeout:  envDuration("SMTP_TIMEOUT", 30*time.Second),
+		}
+		if mailer.Security == "" {
+			mailer.Security = notify.SecurityStartTLS
+		}
+		svc.Mailer = mailer
+	}
+	if dir := os.Getenv("EMAIL_TEMPLATE_DIR"); dir != "" {
+		templates, err := notify.LoadTemplates(dir)
+		if err != nil {
+			e.Logger.Fatal(err)
+		}
+		svc.EmailTemplates = templates
+	}
+	app := &application{
+		logger:  e.Logger, //
+		service: svc,
+	}
 
 	// Middleware setup
 	e.Use(middleware.Logger())
@@ -46,7 +114,117 @@ func main() {
 	// Define routes
 	e.GET("/agents", app.getAgents)
 	e.POST("/agents", app.addAgent)
+	e.GET("/agents/geojson", app.getAgentsGeoJSON)
 	e.GET("/agents/:id", app.getAgent)
+	e.GET("/agents/:id/nearest", app.getNearestAgents)
+	e.PATCH("/agents/:id", app.patchAgent)
+	e.DELETE("/agents/:id", app.deleteAgent)
+	e.POST("/agents/:id/restore", app.restoreAgent)
+	e.PUT("/agents/:id/overrides", app.setAgentOverrides)
+	e.DELETE("/agents/:id/overrides/:field", app.clearAgentOverride)
+	e.GET("/agents/:id/provenance", app.getProvenanceHistory)
+	e.PUT("/agents/:id/state", app.setAgentState)
+	e.GET("/agents/:id/state/history", app.getAgentStateHistory)
+	e.POST("/agents/:id/heartbeat", app.recordHeartbeat)
+	e.POST("/agents/:id/metrics", app.ingestMetrics)
+	e.GET("/agents/:id/metrics", app.queryMetrics)
+	e.GET("/agents/:id/sessions", app.getAgentSessions)
+	e.GET("/agents/:id/config", app.getAgentConfig)
+	e.GET("/agents/:id/config/overrides", app.getConfigOverride(service.ConfigScopeAgent))
+	e.PUT("/agents/:id/config/overrides", app.setConfigOverride(service.ConfigScopeAgent))
+	e.DELETE("/agents/:id/config/overrides", app.deleteConfigOverride(service.ConfigScopeAgent))
+	e.GET("/prefixes", app.getPrefixes)
+	e.GET("/asns", app.getASNs)
+	e.GET("/asns/:asn/agents", app.getASNAgents)
+	e.GET("/stats/agents", app.getAgentStats)
+
+	e.POST("/regions", app.createRegion)
+	e.GET("/regions", app.getRegions)
+	e.GET("/regions/:id", app.getRegion)
+	e.PUT("/regions/:id", app.updateRegion)
+	e.DELETE("/regions/:id", app.deleteRegion)
+	e.POST("/sites", app.createSite)
+	e.GET("/sites", app.getSites)
+	e.GET("/sites/:id", app.getSite)
+	e.PUT("/sites/:id", app.updateSite)
+	e.DELETE("/sites/:id", app.deleteSite)
+	e.GET("/sites/:id/agents", app.getSiteAgents)
+
+	e.POST("/sessions", app.createSession)
+	e.GET("/sessions", app.getSessions)
+	e.GET("/sessions/:id", app.getSession)
+	e.PUT("/sessions/:id", app.updateSession)
+	e.DELETE("/sessions/:id", app.deleteSession)
+	e.POST("/sessions/:id/metrics", app.ingestSessionMetrics)
+	e.GET("/sessions/:id/metrics", app.querySessionMetrics)
+
+	e.POST("/groups", app.createGroup)
+	e.GET("/groups", app.getGroups)
+	e.GET("/groups/:id", app.getGroup)
+	e.PUT("/groups/:id", app.updateGroup)
+	e.DELETE("/groups/:id", app.deleteGroup)
+	e.PUT("/groups/:id/members/:agent_id", app.addGroupMember)
+	e.DELETE("/groups/:id/members/:agent_id", app.removeGroupMember)
+	e.POST("/groups/:id/reconcile", app.reconcileGroup)
+	e.GET("/groups/:id/config", app.getConfigOverride(service.ConfigScopeGroup))
+	e.PUT("/groups/:id/config", app.setConfigOverride(service.ConfigScopeGroup))
+	e.DELETE("/groups/:id/config", app.deleteConfigOverride(service.ConfigScopeGroup))
+
+	e.GET("/config/defaults", app.getConfigOverride(service.ConfigScopeGlobal))
+	e.PUT("/config/defaults", app.setConfigOverride(service.ConfigScopeGlobal))
+	e.DELETE("/config/defaults", app.deleteConfigOverride(service.ConfigScopeGlobal))
+
+	e.POST("/alert-rules", app.createAlertRule)
+	e.GET("/alert-rules", app.getAlertRules)
+	e.GET("/alert-rules/:id", app.getAlertRule)
+	e.PUT("/alert-rules/:id", app.updateAlertRule)
+	e.DELETE("/alert-rules/:id", app.deleteAlertRule)
+	e.GET("/alerts", app.getAlerts)
+	e.GET("/alerts/:id", app.getAlert)
+	e.GET("/alerts/:id/history", app.getAlertHistory)
+
+	e.POST("/webhooks", app.createWebhook)
+	e.GET("/webhooks", app.getWebhooks)
+	e.GET("/webhooks/:id", app.getWebhook)
+	e.PUT("/webhooks/:id", app.updateWebhook)
+	e.DELETE("/webhooks/:id", app.deleteWebhook)
+	e.POST("/webhooks/:id/test", app.testWebhook)
+	e.GET("/webhook-deliveries", app.getDeliveries)
+	e.GET("/events", app.getEvents)
+	e.POST("/webhook-deliveries/:id/retry", app.retryDelivery)
+
+	e.POST("/email-routes", app.createEmailRoute)
+	e.GET("/email-routes", app.getEmailRoutes)
+	e.GET("/email-routes/:id", app.getEmailRoute)
+	e.PUT("/email-routes/:id", app.updateEmailRoute)
+	e.DELETE("/email-routes/:id", app.deleteEmailRoute)
+	e.GET("/email-notifications", app.getEmailNotifications)
+
+	e.POST("/silences", app.createSilence)
+	e.GET("/silences", app.getSilences)
+	e.GET("/silences/:id", app.getSilence)
+	e.PUT("/silences/:id", app.updateSilence)
+	e.DELETE("/silences/:id", app.expireSilence)
+	e.POST("/maintenance-windows", app.createMaintenanceWindow)
+	e.GET("/maintenance-windows", app.getMaintenanceWindows)
+	e.GET("/maintenance-windows/:id", app.getMaintenanceWindow)
+	e.PUT("/maintenance-windows/:id", app.updateMaintenanceWindow)
+	e.DELETE("/maintenance-windows/:id", app.deleteMaintenanceWindow)
+
+	// Start background jobs
+	ctx := context.Background()
+	go app.runPeriodically(ctx, "metric compaction", envDuration("COMPACTION_INTERVAL", time.Minute), svc.CompactMetrics)
+	go app.runPeriodically(ctx, "metric retention", envDuration("RETENTION_INTERVAL", time.Hour), func(now time.Time) error {
+		_, err := svc.ApplyRetention(now)
+		return err
+	})
+	go app.runPeriodically(ctx, "agent purge", envDuration("PURGE_INTERVAL", time.Hour), func(now time.Time) error {
+		_, err := svc.PurgeDeletedAgents(now)
+		return err
+	})
+	go app.runPeriodically(ctx, "alert evaluation", envDuration("ALERT_INTERVAL", 30*time.Second), svc.EvaluateAlerts)
+	go app.runPeriodically(ctx, "webhook delivery", envDuration("WEBHOOK_INTERVAL", 10*time.Second), svc.DeliverWebhooks)
+	go app.runPeriodically(ctx, "email notification", envDuration("EMAIL_INTERVAL", 30*time.Second), svc.SendEmailNotifications)
 
 	// Start the server
 	app.logger.Fatal(e.Start(":" + os.Getenv("PORT")))
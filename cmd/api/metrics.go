@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// ingestMetrics handles the POST /agents/:id/metrics request
+// It receives a batch of network measurements from an agent and stores them
+func (app *application) ingestMetrics(c echo.Context) error {
+	agentID, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	var batch service.MetricBatch
+	err = c.Bind(&batch)
+	if err != nil {
+		app.logger.Errorf("Failed to bind metric batch: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	// Validate the batch (sequence number, sample count and measurement ranges)
+	err = c.Validate(batch)
+	if err != nil {
+		app.logger.Errorf("Failed to validate metric batch: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	result, err := app.service.IngestMetrics(agentID, batch)
+	if err != nil {
+		app.logger.Errorf("Failed to ingest metrics for agent %d: %v", agentID, err)
+		if errors.Is(err, service.ErrAgentNotFound) {
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	// A replayed batch is acknowledged so the agent stops retrying it
+	if result.Duplicate {
+		return c.JSON(http.StatusOK, result)
+	}
+	return c.JSON(http.StatusCreated, result)
+}
+
+// queryMetrics handles the GET /agents/:id/metrics request
+// It aggregates an agent's samples of one metric over a time range into fixed-size steps
+func (app *application) queryMetrics(c echo.Context) error {
+	agentID, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	var params service.MetricQueryRequest
+	err = c.Bind(&params)
+	if err != nil {
+		app.logger.Errorf("Failed to bind metric query: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(params)
+	if err != nil {
+		app.logger.Errorf("Failed to validate metric query: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	query, err := service.ParseMetricQuery(params, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to parse metric query: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	response, err := app.service.QueryMetrics(agentID, query)
+	if err != nil {
+		app.logger.Errorf("Failed to query metrics for agent %d: %v", agentID, err)
+		if errors.Is(err, service.ErrAgentNotFound) {
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		}
+		if errors.Is(err, service.ErrInvalidMetricQuery) {
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
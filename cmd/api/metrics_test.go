@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIngestMetricsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{}
+	app := &application{logger: e.Logger, service: mockService}
+
+	newContext := func(id, body string) (*httptest.ResponseRecorder, func() error) {
+		req := httptest.NewRequest(http.MethodPost, "/agents/"+id+"/metrics", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		return rec, func() error { return app.ingestMetrics(c) }
+	}
+
+	validBody := `{"sequence": 1, "samples": [
+		{"timestamp": "2025-01-01T00:00:00Z", "target": "8.8.8.8", "latency_ms": 12.5, "packet_loss": 0}
+	]}`
+
+	t.Run("Successfully Ingest Batch", func(t *testing.T) {
+		mockService.err = nil
+		mockService.ingestResult = service.IngestResult{Accepted: 1}
+
+		rec, handle := newContext("1", validBody)
+		assert.NoError(t, handle())
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.JSONEq(t, `{"accepted": 1, "duplicate": false}`, rec.Body.String())
+	})
+
+	t.Run("Duplicate Batch", func(t *testing.T) {
+		mockService.err = nil
+		mockService.ingestResult = service.IngestResult{Duplicate: true}
+
+		rec, handle := newContext("1", validBody)
+		assert.NoError(t, handle())
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Invalid Sample", func(t *testing.T) {
+		body := `{"sequence": 2, "samples": [{"timestamp": "2025-01-01T00:00:00Z", "target": "8.8.8.8", "packet_loss": 150}]}`
+
+		rec, handle := newContext("1", body)
+		assert.NoError(t, handle())
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Missing Sequence", func(t *testing.T) {
+		body := `{"samples": [{"timestamp": "2025-01-01T00:00:00Z", "target": "8.8.8.8"}]}`
+
+		rec, handle := newContext("1", body)
+		assert.NoError(t, handle())
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+
+		rec, handle := newContext("99", validBody)
+		assert.NoError(t, handle())
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestQueryMetricsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{}
+	app := &application{logger: e.Logger, service: mockService}
+
+	query := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, app.queryMetrics(c))
+		return rec
+	}
+
+	t.Run("Successfully Query Metrics", func(t *testing.T) {
+		value := 12.5
+		mockService.err = nil
+		mockService.queryResp = service.MetricQueryResponse{
+			AgentID: 1, Metric: "latency_ms", Aggregation: "p95", Step: 60, Resolution: "raw",
+			Timestamps: []int64{1700000000, 1700000060},
+			Series:     []service.MetricSeries{{Target: "8.8.8.8", Values: []*float64{&value, nil}}},
+		}
+
+		rec := query("/agents/1/metrics?from=1700000000&to=1700000120&step=1m&agg=p95")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"agent_id":1, "metric":"latency_ms", "aggregation":"p95", "step":60, "resolution":"raw",
+			"timestamps":[1700000000, 1700000060], "series":[{"target":"8.8.8.8", "values":[12.5, null]}]}`, rec.Body.String())
+		assert.Equal(t, time.Minute, mockService.lastQuery.Step)
+		assert.Equal(t, "p95", mockService.lastQuery.Aggregation)
+	})
+
+	t.Run("Unknown Aggregation", func(t *testing.T) {
+		rec := query("/agents/1/metrics?agg=median")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Invalid Time", func(t *testing.T) {
+		rec := query("/agents/1/metrics?from=yesterday")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Invalid Range", func(t *testing.T) {
+		mockService.err = service.ErrInvalidMetricQuery
+		rec := query("/agents/1/metrics?from=1700000120&to=1700000000")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
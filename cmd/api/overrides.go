@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// setAgentOverrides handles the PUT /agents/:id/overrides request
+// It sets the given details of an agent by hand and locks them against lookups
+func (app *application) setAgentOverrides(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	var overrideRequest service.AgentOverrideRequest
+	err = c.Bind(&overrideRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind override request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(overrideRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate override request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	agent, err := app.service.SetAgentOverrides(id, overrideRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to override details of agent with ID %d: %v", id, err)
+		return app.overrideErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, agent)
+}
+
+// clearAgentOverride handles the DELETE /agents/:id/overrides/:field request
+// It unlocks a detail of an agent and restores the value the provider reported
+func (app *application) clearAgentOverride(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	agent, err := app.service.ClearAgentOverride(id, c.Param("field"))
+	if err != nil {
+		app.logger.Errorf("Failed to clear %s override of agent with ID %d: %v", c.Param("field"), id, err)
+		return app.overrideErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, agent)
+}
+
+// overrideErrorResponse maps override errors to HTTP responses
+func (app *application) overrideErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrAgentNotFound), errors.Is(err, service.ErrOverrideNotFound):
+		return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+	case errors.Is(err, service.ErrInvalidOverride):
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+	return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+}
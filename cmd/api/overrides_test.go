@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetAgentOverridesHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{agentResp: service.DetailedAgentResponse{
+		ID: 1, IPAddress: "203.0.113.7", ASN: "AS577", ISP: "Bell Leased Line",
+		Overrides: []service.AgentOverride{{Field: "isp", Value: "Bell Leased Line", ProviderValue: "Rogers", SetBy: "noc"}},
+	}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	override := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/agents/"+id+"/overrides", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, app.setAgentOverrides(c))
+		return rec
+	}
+
+	t.Run("Successfully Override ISP", func(t *testing.T) {
+		rec := override("1", `{"isp": "Bell Leased Line", "set_by": "noc"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"provider_value":"Rogers"`)
+	})
+
+	t.Run("Missing Set By", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, override("1", `{"isp": "Bell Leased Line"}`).Code)
+	})
+
+	t.Run("Latitude Out Of Range", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, override("1", `{"latitude": 91, "set_by": "noc"}`).Code)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+		assert.Equal(t, http.StatusNotFound, override("99", `{"isp": "Bell", "set_by": "noc"}`).Code)
+	})
+}
+
+func TestClearAgentOverrideHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{err: service.ErrOverrideNotFound}
+	app := &application{logger: e.Logger, service: mockService}
+
+	unlock := func(field string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/agents/1/overrides/"+field, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id", "field")
+		c.SetParamValues("1", field)
+		assert.NoError(t, app.clearAgentOverride(c))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusNotFound, unlock("isp").Code)
+
+	mockService.err = service.ErrInvalidOverride
+	assert.Equal(t, http.StatusBadRequest, unlock("ip_address").Code)
+}
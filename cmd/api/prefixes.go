@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// getPrefixes handles the GET /prefixes request
+// It groups the agents by the BGP prefix announcing their address, most populated first
+func (app *application) getPrefixes(c echo.Context) error {
+	groups, err := app.service.GetPrefixGroups()
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve prefix groups: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, groups)
+}
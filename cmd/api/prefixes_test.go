@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetPrefixesHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{prefixGroups: []service.PrefixGroup{
+		{Prefix: "8.8.8.0/24", OriginASN: "AS15169", AgentCount: 2, AgentIDs: []int{1, 4}},
+	}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	list := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/prefixes", nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.getPrefixes(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Successfully Group Agents", func(t *testing.T) {
+		rec := list()
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `[{"prefix":"8.8.8.0/24","origin_asn":"AS15169","agent_count":2,"agent_ids":[1,4]}]`, rec.Body.String())
+	})
+
+	t.Run("Database Error", func(t *testing.T) {
+		mockService.err = errors.New("database error")
+		assert.Equal(t, http.StatusInternalServerError, list().Code)
+	})
+}
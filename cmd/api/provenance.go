@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// getProvenanceHistory handles the GET /agents/:id/provenance request
+// It lists every value the providers reported for the details of an agent, latest first
+func (app *application) getProvenanceHistory(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	var filter service.ProvenanceFilter
+	err = c.Bind(&filter)
+	if err != nil {
+		app.logger.Errorf("Failed to bind provenance filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to validate provenance filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	history, err := app.service.GetProvenanceHistory(id, filter)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve provenance history of agent with ID %d: %v", id, err)
+		if errors.Is(err, service.ErrAgentNotFound) {
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
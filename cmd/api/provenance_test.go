@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetAgentWithProvenanceHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{
+		agentResp:  service.DetailedAgentResponse{ID: 1, IPAddress: "8.8.8.8", ASN: "AS15169", ISP: "Google LLC"},
+		provenance: []service.FieldProvenance{{Field: "isp", Provider: service.ProviderIPAPI, Value: "Google LLC"}},
+	}
+	app := &application{logger: e.Logger, service: mockService}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/agents/1?"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, app.getAgent(c))
+		return rec
+	}
+
+	t.Run("Provenance Included", func(t *testing.T) {
+		rec := get("include=provenance")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"provenance":[{"field":"isp","provider":"ip-api","value":"Google LLC"`)
+	})
+
+	t.Run("Provenance Left Out", func(t *testing.T) {
+		rec := get("")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "provenance")
+	})
+
+	t.Run("Unknown Include", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("include=metrics").Code)
+	})
+}
+
+func TestGetProvenanceHistoryHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{provenance: []service.FieldProvenance{{Field: "isp", Provider: service.ProviderManual}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	list := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/agents/1/provenance?"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, app.getProvenanceHistory(c))
+		return rec
+	}
+
+	t.Run("Filter By Field", func(t *testing.T) {
+		rec := list("field=isp&limit=10")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, service.ProvenanceFilter{Field: "isp", Limit: 10}, mockService.provenanceFilter)
+		assert.Contains(t, rec.Body.String(), `"provider":"manual"`)
+	})
+
+	t.Run("Limit Too Large", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, list("limit=5000").Code)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+		assert.Equal(t, http.StatusNotFound, list("").Code)
+	})
+}
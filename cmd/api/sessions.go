@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// createSession handles the POST /sessions request
+// It creates a monitoring session between a source and a destination agent
+func (app *application) createSession(c echo.Context) error {
+	var sessionRequest service.MonitoringSessionRequest
+	err := c.Bind(&sessionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind session request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(sessionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate session request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	session, err := app.service.CreateSession(sessionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to create session: %v", err)
+		return app.sessionErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, session)
+}
+
+// getSessions handles the GET /sessions request
+// It retrieves all monitoring sessions
+func (app *application) getSessions(c echo.Context) error {
+	sessions, err := app.service.GetSessions()
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve sessions: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// getSession handles the GET /sessions/:id request
+// It retrieves a single monitoring session
+func (app *application) getSession(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid session ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid session ID")))
+	}
+
+	session, err := app.service.GetSession(id)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve session with ID %d: %v", id, err)
+		return app.sessionErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, session)
+}
+
+// updateSession handles the PUT /sessions/:id request
+// It replaces the protocol, interval, packet size and enabled flag of a monitoring session
+func (app *application) updateSession(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid session ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid session ID")))
+	}
+
+	var sessionRequest service.MonitoringSessionRequest
+	err = c.Bind(&sessionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind session request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(sessionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate session request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	session, err := app.service.UpdateSession(id, sessionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to update session with ID %d: %v", id, err)
+		return app.sessionErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, session)
+}
+
+// deleteSession handles the DELETE /sessions/:id request
+// It removes a monitoring session; results already stored are kept
+func (app *application) deleteSession(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid session ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid session ID")))
+	}
+
+	err = app.service.DeleteSession(id)
+	if err != nil {
+		app.logger.Errorf("Failed to delete session with ID %d: %v", id, err)
+		return app.sessionErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getAgentSessions handles the GET /agents/:id/sessions request
+// Agents poll it to find the sessions they have to run or answer
+func (app *application) getAgentSessions(c echo.Context) error {
+	agentID, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid agent ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid agent ID")))
+	}
+
+	sessions, err := app.service.GetAgentSessions(agentID)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve sessions of agent %d: %v", agentID, err)
+		if errors.Is(err, service.ErrAgentNotFound) {
+			return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// ingestSessionMetrics handles the POST /sessions/:id/metrics request
+// It stores a batch of results measured by the source agent of a session
+func (app *application) ingestSessionMetrics(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid session ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid session ID")))
+	}
+
+	var batch service.MetricBatch
+	err = c.Bind(&batch)
+	if err != nil {
+		app.logger.Errorf("Failed to bind metric batch: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(batch)
+	if err != nil {
+		app.logger.Errorf("Failed to validate metric batch: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	result, err := app.service.IngestSessionMetrics(id, batch)
+	if err != nil {
+		app.logger.Errorf("Failed to ingest metrics for session %d: %v", id, err)
+		return app.sessionErrorResponse(c, err)
+	}
+
+	if result.Duplicate {
+		return c.JSON(http.StatusOK, result)
+	}
+	return c.JSON(http.StatusCreated, result)
+}
+
+// querySessionMetrics handles the GET /sessions/:id/metrics request
+// It aggregates the results of a session over a time range, with the same parameters as agent metrics
+func (app *application) querySessionMetrics(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid session ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid session ID")))
+	}
+
+	var params service.MetricQueryRequest
+	err = c.Bind(&params)
+	if err != nil {
+		app.logger.Errorf("Failed to bind metric query: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(params)
+	if err != nil {
+		app.logger.Errorf("Failed to validate metric query: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	query, err := service.ParseMetricQuery(params, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to parse metric query: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	response, err := app.service.QuerySessionMetrics(id, query)
+	if err != nil {
+		app.logger.Errorf("Failed to query metrics for session %d: %v", id, err)
+		if errors.Is(err, service.ErrInvalidMetricQuery) {
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		}
+		return app.sessionErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// sessionErrorResponse maps monitoring session errors to HTTP responses
+func (app *application) sessionErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrSessionNotFound):
+		return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+	case errors.Is(err, service.ErrInvalidSession):
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+	return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+}
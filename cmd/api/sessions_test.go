@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateSessionHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{
+		session: service.MonitoringSession{
+			ID: 1, SourceAgentID: 1, SourceIP: "8.8.8.8", DestinationAgentID: 2, DestinationIP: "1.1.1.1",
+			Protocol: "udp", IntervalSeconds: 10, PacketSize: 64, Enabled: true,
+		},
+	}
+	app := &application{logger: e.Logger, service: mockService}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sessions", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.createSession(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Successfully Create Session", func(t *testing.T) {
+		mockService.err = nil
+
+		rec := create(`{"source_agent_id": 1, "destination_agent_id": 2, "protocol": "udp", "interval_seconds": 10, "packet_size": 64}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"destination_ip":"1.1.1.1"`)
+	})
+
+	t.Run("Same Source And Destination", func(t *testing.T) {
+		rec := create(`{"source_agent_id": 1, "destination_agent_id": 1, "protocol": "udp", "interval_seconds": 10, "packet_size": 64}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Unknown Protocol", func(t *testing.T) {
+		rec := create(`{"source_agent_id": 1, "destination_agent_id": 2, "protocol": "sctp", "interval_seconds": 10, "packet_size": 64}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Unknown Agent", func(t *testing.T) {
+		mockService.err = fmt.Errorf("%w: agent 2 not found", service.ErrInvalidSession)
+
+		rec := create(`{"source_agent_id": 1, "destination_agent_id": 2, "protocol": "tcp", "interval_seconds": 10, "packet_size": 64}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestGetAgentSessionsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{}
+	app := &application{logger: e.Logger, service: mockService}
+
+	poll := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/agents/"+id+"/sessions", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, app.getAgentSessions(c))
+		return rec
+	}
+
+	t.Run("Successfully Poll Sessions", func(t *testing.T) {
+		mockService.err = nil
+		mockService.assigned = []service.AssignedSession{
+			{MonitoringSession: service.MonitoringSession{ID: 1, SourceAgentID: 1, DestinationAgentID: 2}, Role: service.RoleSource},
+		}
+
+		rec := poll("1")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"role":"source"`)
+	})
+
+	t.Run("Agent Not Found", func(t *testing.T) {
+		mockService.err = service.ErrAgentNotFound
+
+		rec := poll("99")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestDeleteSessionHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{}
+	app := &application{logger: e.Logger, service: mockService}
+
+	remove := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/sessions/"+id, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, app.deleteSession(c))
+		return rec
+	}
+
+	t.Run("Successfully Delete Session", func(t *testing.T) {
+		mockService.err = nil
+		assert.Equal(t, http.StatusNoContent, remove("1").Code)
+	})
+
+	t.Run("Session Not Found", func(t *testing.T) {
+		mockService.err = service.ErrSessionNotFound
+		assert.Equal(t, http.StatusNotFound, remove("99").Code)
+	})
+
+	t.Run("Database Error", func(t *testing.T) {
+		mockService.err = errors.New("DB error")
+		assert.Equal(t, http.StatusInternalServerError, remove("1").Code)
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// createSilence handles the POST /silences request
+// It suppresses notifications about the alerts of matching agents until the silence expires
+func (app *application) createSilence(c echo.Context) error {
+	var silenceRequest service.SilenceRequest
+	err := c.Bind(&silenceRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind silence request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(silenceRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate silence request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	silence, err := app.service.CreateSilence(silenceRequest, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to create silence: %v", err)
+		return app.silenceErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, silence)
+}
+
+// getSilences handles the GET /silences request
+// It lists silences, optionally only the pending, active or expired ones
+func (app *application) getSilences(c echo.Context) error {
+	var filter service.SilenceFilter
+	err := c.Bind(&filter)
+	if err != nil {
+		app.logger.Errorf("Failed to bind silence filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to validate silence filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	silences, err := app.service.GetSilences(filter, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve silences: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, silences)
+}
+
+// getSilence handles the GET /silences/:id request
+// It retrieves a single silence
+func (app *application) getSilence(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid silence ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid silence ID")))
+	}
+
+	silence, err := app.service.GetSilence(id, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve silence with ID %d: %v", id, err)
+		return app.silenceErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, silence)
+}
+
+// updateSilence handles the PUT /silences/:id request
+// It replaces the matchers, time range, attribution and comment of a silence
+func (app *application) updateSilence(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid silence ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid silence ID")))
+	}
+
+	var silenceRequest service.SilenceRequest
+	err = c.Bind(&silenceRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind silence request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(silenceRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate silence request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	silence, err := app.service.UpdateSilence(id, silenceRequest, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to update silence with ID %d: %v", id, err)
+		return app.silenceErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, silence)
+}
+
+// expireSilence handles the DELETE /silences/:id request
+// It ends a silence now; the expired silence is kept and returned
+func (app *application) expireSilence(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid silence ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid silence ID")))
+	}
+
+	silence, err := app.service.ExpireSilence(id, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to expire silence with ID %d: %v", id, err)
+		return app.silenceErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, silence)
+}
+
+// createMaintenanceWindow handles the POST /maintenance-windows request
+// It suppresses notifications about matching alerts every time its schedule opens the window
+func (app *application) createMaintenanceWindow(c echo.Context) error {
+	var windowRequest service.MaintenanceWindowRequest
+	err := c.Bind(&windowRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind maintenance window request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(windowRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate maintenance window request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	window, err := app.service.CreateMaintenanceWindow(windowRequest, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to create maintenance window: %v", err)
+		return app.silenceErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, window)
+}
+
+// getMaintenanceWindows handles the GET /maintenance-windows request
+// It retrieves all maintenance windows with whether they are open and when they next open
+func (app *application) getMaintenanceWindows(c echo.Context) error {
+	windows, err := app.service.GetMaintenanceWindows(time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve maintenance windows: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, windows)
+}
+
+// getMaintenanceWindow handles the GET /maintenance-windows/:id request
+// It retrieves a single maintenance window
+func (app *application) getMaintenanceWindow(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid maintenance window ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid maintenance window ID")))
+	}
+
+	window, err := app.service.GetMaintenanceWindow(id, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve maintenance window with ID %d: %v", id, err)
+		return app.silenceErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, window)
+}
+
+// updateMaintenanceWindow handles the PUT /maintenance-windows/:id request
+// It replaces the schedule, matchers and settings of a maintenance window
+func (app *application) updateMaintenanceWindow(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid maintenance window ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid maintenance window ID")))
+	}
+
+	var windowRequest service.MaintenanceWindowRequest
+	err = c.Bind(&windowRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind maintenance window request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(windowRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate maintenance window request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	window, err := app.service.UpdateMaintenanceWindow(id, windowRequest, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to update maintenance window with ID %d: %v", id, err)
+		return app.silenceErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, window)
+}
+
+// deleteMaintenanceWindow handles the DELETE /maintenance-windows/:id request
+// It removes a maintenance window
+func (app *application) deleteMaintenanceWindow(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid maintenance window ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid maintenance window ID")))
+	}
+
+	err = app.service.DeleteMaintenanceWindow(id)
+	if err != nil {
+		app.logger.Errorf("Failed to delete maintenance window with ID %d: %v", id, err)
+		return app.silenceErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// silenceErrorResponse maps silence and maintenance window errors to HTTP responses
+func (app *application) silenceErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrSilenceNotFound), errors.Is(err, service.ErrMaintenanceWindowNotFound):
+		return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+	case errors.Is(err, service.ErrInvalidSilence), errors.Is(err, service.ErrInvalidMaintenanceWindow):
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+	return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+}
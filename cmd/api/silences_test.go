@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateSilenceHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{silence: service.Silence{ID: 1, State: service.SilenceActive, CreatedBy: "noc"}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/silences", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.createSilence(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Successfully Create Silence", func(t *testing.T) {
+		rec := create(`{"asn": "AS15169", "ends_at": "2027-01-01T06:00:00Z", "created_by": "noc", "comment": "ISP maintenance"}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"state":"active"`)
+	})
+
+	t.Run("Missing Comment", func(t *testing.T) {
+		rec := create(`{"asn": "AS15169", "ends_at": "2027-01-01T06:00:00Z", "created_by": "noc"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("No Matcher", func(t *testing.T) {
+		mockService.err = service.ErrInvalidSilence
+		rec := create(`{"ends_at": "2027-01-01T06:00:00Z", "created_by": "noc", "comment": "ISP maintenance"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestGetSilencesHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{silences: []service.Silence{{ID: 1, State: service.SilenceExpired}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	list := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/silences?"+query, nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.getSilences(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Filter By State", func(t *testing.T) {
+		rec := list("state=expired")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"state":"expired"`)
+	})
+
+	t.Run("Unknown State", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, list("state=muted").Code)
+	})
+}
+
+func TestExpireSilenceHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{err: service.ErrSilenceNotFound}
+	app := &application{logger: e.Logger, service: mockService}
+
+	req := httptest.NewRequest(http.MethodDelete, "/silences/7", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+
+	assert.NoError(t, app.expireSilence(c))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestCreateMaintenanceWindowHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{window: service.MaintenanceWindow{ID: 1, Name: "weekly", Schedule: "0 2 * * sun"}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/maintenance-windows", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.createMaintenanceWindow(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Successfully Create Maintenance Window", func(t *testing.T) {
+		rec := create(`{"name": "weekly", "schedule": "0 2 * * sun", "duration_seconds": 7200, "group_id": 3, "created_by": "noc"}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"schedule":"0 2 * * sun"`)
+	})
+
+	t.Run("Duration Too Short", func(t *testing.T) {
+		rec := create(`{"name": "weekly", "schedule": "0 2 * * sun", "duration_seconds": 30, "group_id": 3, "created_by": "noc"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Invalid Schedule", func(t *testing.T) {
+		mockService.err = service.ErrInvalidMaintenanceWindow
+		rec := create(`{"name": "weekly", "schedule": "every sunday", "duration_seconds": 7200, "group_id": 3, "created_by": "noc"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
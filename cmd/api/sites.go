@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// createRegion handles the POST /regions request
+// It creates a region, optionally nested in a parent region
+func (app *application) createRegion(c echo.Context) error {
+	var regionRequest service.RegionRequest
+	err := c.Bind(&regionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind region request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(regionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate region request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	region, err := app.service.CreateRegion(regionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to create region: %v", err)
+		return app.siteErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, region)
+}
+
+// getRegions handles the GET /regions request
+// It retrieves all regions
+func (app *application) getRegions(c echo.Context) error {
+	regions, err := app.service.GetRegions()
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve regions: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, regions)
+}
+
+// getRegion handles the GET /regions/:id request
+// It retrieves a single region
+func (app *application) getRegion(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid region ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid region ID")))
+	}
+
+	region, err := app.service.GetRegion(id)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve region with ID %d: %v", id, err)
+		return app.siteErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, region)
+}
+
+// updateRegion handles the PUT /regions/:id request
+// It renames a region or moves it to another parent region
+func (app *application) updateRegion(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid region ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid region ID")))
+	}
+
+	var regionRequest service.RegionRequest
+	err = c.Bind(&regionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind region request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(regionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate region request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	region, err := app.service.UpdateRegion(id, regionRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to update region with ID %d: %v", id, err)
+		return app.siteErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, region)
+}
+
+// deleteRegion handles the DELETE /regions/:id request
+// It removes a region that holds no regions or sites
+func (app *application) deleteRegion(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid region ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid region ID")))
+	}
+
+	err = app.service.DeleteRegion(id)
+	if err != nil {
+		app.logger.Errorf("Failed to delete region with ID %d: %v", id, err)
+		return app.siteErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// createSite handles the POST /sites request
+// It creates a site agents can be assigned to
+func (app *application) createSite(c echo.Context) error {
+	var siteRequest service.SiteRequest
+	err := c.Bind(&siteRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind site request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(siteRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate site request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	site, err := app.service.CreateSite(siteRequest, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to create site: %v", err)
+		return app.siteErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, site)
+}
+
+// getSites handles the GET /sites request
+// It lists the sites with the status of their agents, optionally only those in a region
+func (app *application) getSites(c echo.Context) error {
+	var filter service.SiteFilter
+	err := c.Bind(&filter)
+	if err != nil {
+		app.logger.Errorf("Failed to bind site filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to validate site filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	sites, err := app.service.GetSites(filter, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve sites: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, sites)
+}
+
+// getSite handles the GET /sites/:id request
+// It retrieves a single site with the status of its agents
+func (app *application) getSite(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid site ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid site ID")))
+	}
+
+	site, err := app.service.GetSite(id, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve site with ID %d: %v", id, err)
+		return app.siteErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, site)
+}
+
+// updateSite handles the PUT /sites/:id request
+// It replaces the name, address, coordinates and region of a site
+func (app *application) updateSite(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid site ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid site ID")))
+	}
+
+	var siteRequest service.SiteRequest
+	err = c.Bind(&siteRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind site request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(siteRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate site request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	site, err := app.service.UpdateSite(id, siteRequest, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to update site with ID %d: %v", id, err)
+		return app.siteErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, site)
+}
+
+// deleteSite handles the DELETE /sites/:id request
+// It removes a site; its agents are kept without a site
+func (app *application) deleteSite(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid site ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid site ID")))
+	}
+
+	err = app.service.DeleteSite(id)
+	if err != nil {
+		app.logger.Errorf("Failed to delete site with ID %d: %v", id, err)
+		return app.siteErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getSiteAgents handles the GET /sites/:id/agents request
+// It retrieves the agents assigned to a site
+func (app *application) getSiteAgents(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid site ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid site ID")))
+	}
+
+	agents, err := app.service.GetSiteAgents(id)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve agents of site with ID %d: %v", id, err)
+		return app.siteErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, agents)
+}
+
+// siteErrorResponse maps site and region errors to HTTP responses
+func (app *application) siteErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrSiteNotFound), errors.Is(err, service.ErrRegionNotFound):
+		return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+	case errors.Is(err, service.ErrInvalidSite), errors.Is(err, service.ErrInvalidRegion):
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+	return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateSiteHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{site: service.Site{ID: 1, Name: "Montreal office", Status: service.SiteEmpty}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sites", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.createSite(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Successfully Create Site", func(t *testing.T) {
+		rec := create(`{"name": "Montreal office", "latitude": 45.5, "longitude": -73.57, "region_id": 2}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"status":"empty"`)
+	})
+
+	t.Run("Latitude Without Longitude", func(t *testing.T) {
+		rec := create(`{"name": "Montreal office", "latitude": 45.5}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Unknown Region", func(t *testing.T) {
+		mockService.err = service.ErrInvalidSite
+		rec := create(`{"name": "Montreal office", "region_id": 99}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestGetSitesHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{sites: []service.Site{{ID: 1, Status: service.SiteDegraded}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	req := httptest.NewRequest(http.MethodGet, "/sites?region_id=3", nil)
+	rec := httptest.NewRecorder()
+	assert.NoError(t, app.getSites(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 3, mockService.siteFilter.RegionID)
+	assert.Contains(t, rec.Body.String(), `"status":"degraded"`)
+}
+
+func TestGetSiteAgentsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{agents: []service.Agent{{ID: 4, IPAddress: "10.0.0.4"}}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	get := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/sites/"+id+"/agents", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, app.getSiteAgents(c))
+		return rec
+	}
+
+	t.Run("Agents Of Site", func(t *testing.T) {
+		rec := get("1")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `[{"id":4,"ip_address":"10.0.0.4"}]`, rec.Body.String())
+	})
+
+	t.Run("Site Not Found", func(t *testing.T) {
+		mockService.err = service.ErrSiteNotFound
+		assert.Equal(t, http.StatusNotFound, get("99").Code)
+	})
+}
+
+func TestDeleteRegionHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{err: service.ErrInvalidRegion}
+	app := &application{logger: e.Logger, service: mockService}
+
+	req := httptest.NewRequest(http.MethodDelete, "/regions/2", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("2")
+
+	assert.NoError(t, app.deleteRegion(c))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// getAgentStats handles the GET /stats/agents request
+// It counts the agents by ISP, AS, country or status with their share of the fleet
+func (app *application) getAgentStats(c echo.Context) error {
+	var query service.AgentStatsQuery
+	err := c.Bind(&query)
+	if err != nil {
+		app.logger.Errorf("Failed to bind agent stats query: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(query)
+	if err != nil {
+		app.logger.Errorf("Failed to validate agent stats query: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	stats, err := app.service.GetAgentStats(query, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to compute agent stats: %v", err)
+		if errors.Is(err, service.ErrInvalidAgentFilter) {
+			return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+		}
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetAgentStatsHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{agentStats: service.AgentStats{
+		GroupBy: "isp", Total: 4,
+		Groups: []service.AgentStatsGroup{{Key: "Google LLC", Count: 3, Percentage: 75}, {Key: "Rogers", Count: 1, Percentage: 25}},
+	}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	stats := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/stats/agents?"+query, nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.getAgentStats(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Group By ISP", func(t *testing.T) {
+		rec := stats("group_by=isp")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `{"key":"Google LLC","count":3,"percentage":75}`)
+		assert.Equal(t, service.AgentStatsQuery{GroupBy: "isp"}, mockService.statsQuery)
+	})
+
+	t.Run("Missing Grouping", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, stats("").Code)
+	})
+
+	t.Run("Unknown Grouping", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, stats("group_by=city").Code)
+	})
+}
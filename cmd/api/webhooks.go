@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/Shaughny/obkio-test/internal/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// createWebhook handles the POST /webhooks request
+// It subscribes a URL to events; the response holds the signing secret, which is not shown again
+func (app *application) createWebhook(c echo.Context) error {
+	var webhookRequest service.WebhookSubscriptionRequest
+	err := c.Bind(&webhookRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind webhook request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(webhookRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate webhook request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	webhook, err := app.service.CreateWebhook(webhookRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to create webhook: %v", err)
+		return app.webhookErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, webhook)
+}
+
+// getWebhooks handles the GET /webhooks request
+// It retrieves all webhook subscriptions
+func (app *application) getWebhooks(c echo.Context) error {
+	webhooks, err := app.service.GetWebhooks()
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve webhooks: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+// getWebhook handles the GET /webhooks/:id request
+// It retrieves a single webhook subscription
+func (app *application) getWebhook(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid webhook ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid webhook ID")))
+	}
+
+	webhook, err := app.service.GetWebhook(id)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve webhook with ID %d: %v", id, err)
+		return app.webhookErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// updateWebhook handles the PUT /webhooks/:id request
+// It replaces the URL, event types and enabled flag of a subscription, and its secret if one is given
+func (app *application) updateWebhook(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid webhook ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid webhook ID")))
+	}
+
+	var webhookRequest service.WebhookSubscriptionRequest
+	err = c.Bind(&webhookRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to bind webhook request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(webhookRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to validate webhook request: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	webhook, err := app.service.UpdateWebhook(id, webhookRequest)
+	if err != nil {
+		app.logger.Errorf("Failed to update webhook with ID %d: %v", id, err)
+		return app.webhookErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// deleteWebhook handles the DELETE /webhooks/:id request
+// It removes a webhook subscription together with its deliveries
+func (app *application) deleteWebhook(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid webhook ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid webhook ID")))
+	}
+
+	err = app.service.DeleteWebhook(id)
+	if err != nil {
+		app.logger.Errorf("Failed to delete webhook with ID %d: %v", id, err)
+		return app.webhookErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// testWebhook handles the POST /webhooks/:id/test request
+// It sends a webhook.test event right away and returns the outcome of the delivery
+func (app *application) testWebhook(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid webhook ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid webhook ID")))
+	}
+
+	delivery, err := app.service.SendTestWebhook(id, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to test webhook with ID %d: %v", id, err)
+		return app.webhookErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+// getDeliveries handles the GET /webhook-deliveries request
+// It lists deliveries, filtered by status (status=dead gives the dead-letter queue) or subscription
+func (app *application) getDeliveries(c echo.Context) error {
+	var filter service.DeliveryFilter
+	err := c.Bind(&filter)
+	if err != nil {
+		app.logger.Errorf("Failed to bind delivery filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+
+	err = c.Validate(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to validate delivery filter: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.ValidationErrorResponse(err))
+	}
+
+	deliveries, err := app.service.GetDeliveries(filter)
+	if err != nil {
+		app.logger.Errorf("Failed to retrieve webhook deliveries: %v", err)
+		return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+	}
+
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+// retryDelivery handles the POST /webhook-deliveries/:id/retry request
+// It puts a dead delivery back in the queue
+func (app *application) retryDelivery(c echo.Context) error {
+	id, err := readIDParam(c, "id")
+	if err != nil {
+		app.logger.Errorf("Invalid delivery ID: %v", err)
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(errors.New("invalid delivery ID")))
+	}
+
+	delivery, err := app.service.RetryDelivery(id, time.Now())
+	if err != nil {
+		app.logger.Errorf("Failed to retry webhook delivery with ID %d: %v", id, err)
+		return app.webhookErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+// webhookErrorResponse maps webhook errors to HTTP responses
+func (app *application) webhookErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrWebhookNotFound), errors.Is(err, service.ErrDeliveryNotFound):
+		return c.JSON(http.StatusNotFound, utils.NotFoundResponse(err))
+	case errors.Is(err, service.ErrInvalidDelivery):
+		return c.JSON(http.StatusBadRequest, utils.BadRequestResponse(err))
+	}
+	return c.JSON(http.StatusInternalServerError, utils.GenericErrorResponse())
+}
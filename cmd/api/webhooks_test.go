@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Shaughny/obkio-test/internal/service"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateWebhookHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{webhook: service.WebhookSubscription{ID: 1, Secret: "generated"}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		assert.NoError(t, app.createWebhook(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Successfully Create Webhook", func(t *testing.T) {
+		rec := create(`{"url": "https://hooks.example.com/obkio", "event_types": ["agent.created", "alert.firing"]}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"secret":"generated"`)
+	})
+
+	t.Run("Unknown Event Type", func(t *testing.T) {
+		rec := create(`{"url": "https://hooks.example.com/obkio", "event_types": ["agent.exploded"]}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Invalid URL", func(t *testing.T) {
+		rec := create(`{"url": "not a url", "event_types": ["agent.created"]}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestRetryDeliveryHandler(t *testing.T) {
+	e := getEchoInstance()
+	mockService := &MockService{delivery: service.WebhookDelivery{ID: 3, Status: service.DeliveryPending}}
+	app := &application{logger: e.Logger, service: mockService}
+
+	retry := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhook-deliveries/3/retry", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("3")
+		assert.NoError(t, app.retryDelivery(c))
+		return rec
+	}
+
+	t.Run("Requeues Dead Delivery", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, retry().Code)
+	})
+
+	t.Run("Delivery Not Dead", func(t *testing.T) {
+		mockService.err = service.ErrInvalidDelivery
+		assert.Equal(t, http.StatusBadRequest, retry().Code)
+	})
+
+	t.Run("Delivery Not Found", func(t *testing.T) {
+		mockService.err = service.ErrDeliveryNotFound
+		assert.Equal(t, http.StatusNotFound, retry().Code)
+	})
+}
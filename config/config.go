@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -24,25 +25,567 @@ func InitializeDB() *sql.DB {
 	}
 
 	fmt.Println("Connected to SQLite database successfully at", dbPath)
+	err = enableIncrementalVacuum(DB)
+	if err != nil {
+		log.Fatalf("Failed to enable incremental vacuum: %v", err)
+	}
 	createTables()
 	return DB
 }
 
 // createTables creates the necessary tables in the database
 func createTables() {
-	createTableSQL := `
+	err := Migrate(DB)
+	if err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+	}
+
+	fmt.Println("Tables created successfully")
+}
+
+// enableIncrementalVacuum switches the database to incremental auto-vacuum so that
+// space freed by metric retention can be returned to the file system without a
+// full VACUUM. Existing database files need a one-time VACUUM to pick up the mode.
+func enableIncrementalVacuum(db *sql.DB) error {
+	ctx := context.Background()
+
+	// The pragma and the VACUUM must run on the same connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Close()
+
+	var mode int
+	err = conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode)
+	if err != nil {
+		return fmt.Errorf("error reading auto_vacuum mode: %w", err)
+	}
+	if mode == 2 { // INCREMENTAL
+		return nil
+	}
+
+	_, err = conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL")
+	if err != nil {
+		return fmt.Errorf("error setting auto_vacuum mode: %w", err)
+	}
+	_, err = conn.ExecContext(ctx, "VACUUM")
+	if err != nil {
+		return fmt.Errorf("error vacuuming database: %w", err)
+	}
+	return nil
+}
+
+// migrations holds the schema changes in the order they must be applied.
+// The index of a migration plus one is the schema version it produces, so
+// entries must only ever be appended.
+var migrations = []string{
+	// 1: agents
+	`
 	CREATE TABLE IF NOT EXISTS agents (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		ip_address varchar(15) UNIQUE NOT NULL,
 		asn TEXT,
 		isp TEXT,
 		last_updated DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
+	);`,
+
+	// 2: raw network metric samples and ingested batch sequence numbers
+	`
+	CREATE TABLE IF NOT EXISTS metrics (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		agent_id INTEGER NOT NULL REFERENCES agents(id),
+		target TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		latency_ms REAL,
+		jitter_ms REAL,
+		packet_loss REAL,
+		throughput_kbps REAL
+	);
+	CREATE INDEX IF NOT EXISTS idx_metrics_agent_ts ON metrics (agent_id, ts);
+	CREATE INDEX IF NOT EXISTS idx_metrics_agent_target_ts ON metrics (agent_id, target, ts);
+	CREATE INDEX IF NOT EXISTS idx_metrics_ts ON metrics (ts);
+
+	CREATE TABLE IF NOT EXISTS metric_batches (
+		agent_id INTEGER NOT NULL REFERENCES agents(id),
+		sequence INTEGER NOT NULL,
+		sample_count INTEGER NOT NULL,
+		received_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_metric_batches_sequence ON metric_batches (agent_id, sequence);`,
+
+	// 3: pre-computed metric aggregates and how far each resolution has been rolled up
+	`
+	CREATE TABLE IF NOT EXISTS metric_rollups (
+		agent_id INTEGER NOT NULL REFERENCES agents(id),
+		target TEXT NOT NULL,
+		metric TEXT NOT NULL,
+		resolution INTEGER NOT NULL,
+		bucket_start INTEGER NOT NULL,
+		count INTEGER NOT NULL,
+		sum REAL NOT NULL,
+		min REAL NOT NULL,
+		max REAL NOT NULL,
+		sketch TEXT NOT NULL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_metric_rollups_key ON metric_rollups (agent_id, resolution, metric, bucket_start, target);
+	CREATE INDEX IF NOT EXISTS idx_metric_rollups_bucket ON metric_rollups (resolution, bucket_start);
+
+	CREATE TABLE IF NOT EXISTS rollup_watermarks (
+		resolution INTEGER PRIMARY KEY,
+		watermark INTEGER NOT NULL
+	);`,
+
+	// 4: monitoring sessions between agent pairs; session results are stored as metrics tagged with the session
+	`
+	CREATE TABLE IF NOT EXISTS monitoring_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source_agent_id INTEGER NOT NULL REFERENCES agents(id),
+		destination_agent_id INTEGER NOT NULL REFERENCES agents(id),
+		protocol TEXT NOT NULL CHECK (protocol IN ('udp', 'tcp', 'icmp')),
+		interval_seconds INTEGER NOT NULL,
+		packet_size INTEGER NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		CHECK (source_agent_id <> destination_agent_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_monitoring_sessions_source ON monitoring_sessions (source_agent_id);
+	CREATE INDEX IF NOT EXISTS idx_monitoring_sessions_destination ON monitoring_sessions (destination_agent_id);
+
+	ALTER TABLE metrics ADD COLUMN session_id INTEGER NOT NULL DEFAULT 0;
+	DROP INDEX IF EXISTS idx_metrics_agent_ts;
+	DROP INDEX IF EXISTS idx_metrics_agent_target_ts;
+	CREATE INDEX IF NOT EXISTS idx_metrics_agent_session_ts ON metrics (agent_id, session_id, ts);
+	CREATE INDEX IF NOT EXISTS idx_metrics_agent_session_target_ts ON metrics (agent_id, session_id, target, ts);
+
+	ALTER TABLE metric_batches ADD COLUMN session_id INTEGER NOT NULL DEFAULT 0;
+	DROP INDEX IF EXISTS idx_metric_batches_sequence;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_metric_batches_sequence ON metric_batches (agent_id, session_id, sequence);
+
+	ALTER TABLE metric_rollups ADD COLUMN session_id INTEGER NOT NULL DEFAULT 0;
+	DROP INDEX IF EXISTS idx_metric_rollups_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_metric_rollups_key ON metric_rollups (agent_id, session_id, resolution, metric, bucket_start, target);`,
+
+	// 5: agent groups that provision monitoring sessions between their members
+	`
+	CREATE TABLE IF NOT EXISTS agent_groups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		topology TEXT NOT NULL CHECK (topology IN ('full_mesh', 'hub_and_spoke', 'custom')),
+		hub_agent_id INTEGER REFERENCES agents(id),
+		protocol TEXT NOT NULL CHECK (protocol IN ('udp', 'tcp', 'icmp')),
+		interval_seconds INTEGER NOT NULL,
+		packet_size INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS agent_group_members (
+		group_id INTEGER NOT NULL REFERENCES agent_groups(id),
+		agent_id INTEGER NOT NULL REFERENCES agents(id),
+		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (group_id, agent_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_agent_group_members_agent ON agent_group_members (agent_id);
+
+	CREATE TABLE IF NOT EXISTS agent_group_links (
+		group_id INTEGER NOT NULL REFERENCES agent_groups(id),
+		source_agent_id INTEGER NOT NULL REFERENCES agents(id),
+		destination_agent_id INTEGER NOT NULL REFERENCES agents(id),
+		PRIMARY KEY (group_id, source_agent_id, destination_agent_id)
+	);
+
+	ALTER TABLE monitoring_sessions ADD COLUMN group_id INTEGER REFERENCES agent_groups(id);
+	CREATE INDEX IF NOT EXISTS idx_monitoring_sessions_group ON monitoring_sessions (group_id);`,
+
+	// 6: layered agent configuration overrides and the version last served to each agent
+	`
+	CREATE TABLE IF NOT EXISTS config_overrides (
+		scope TEXT NOT NULL CHECK (scope IN ('global', 'group', 'agent')),
+		scope_id INTEGER NOT NULL DEFAULT 0,
+		document TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (scope, scope_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS agent_config_versions (
+		agent_id INTEGER PRIMARY KEY REFERENCES agents(id),
+		version INTEGER NOT NULL,
+		hash TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`,
+
+	// 7: threshold alert rules, the alerts they raise and their state history
+	`
+	CREATE TABLE IF NOT EXISTS alert_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		metric TEXT NOT NULL,
+		scope TEXT NOT NULL CHECK (scope IN ('all', 'agent', 'group', 'session')),
+		scope_id INTEGER NOT NULL DEFAULT 0,
+		comparator TEXT NOT NULL CHECK (comparator IN ('gt', 'gte', 'lt', 'lte')),
+		threshold REAL NOT NULL,
+		duration_seconds INTEGER NOT NULL,
+		window_seconds INTEGER NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS alerts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_id INTEGER NOT NULL REFERENCES alert_rules(id),
+		agent_id INTEGER NOT NULL REFERENCES agents(id),
+		session_id INTEGER NOT NULL DEFAULT 0,
+		state TEXT NOT NULL CHECK (state IN ('pending', 'firing', 'resolved')),
+		value REAL,
+		started_at DATETIME NOT NULL,
+		fired_at DATETIME,
+		resolved_at DATETIME,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_alerts_state ON alerts (state);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_active ON alerts (rule_id, agent_id, session_id) WHERE state <> 'resolved';
+
+	CREATE TABLE IF NOT EXISTS alert_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		alert_id INTEGER NOT NULL REFERENCES alerts(id),
+		state TEXT NOT NULL,
+		value REAL,
+		at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_alert_history_alert ON alert_history (alert_id);`,
+
+	// 8: agent liveness, agent offline alert rules and recovery hysteresis
+	`
+	ALTER TABLE agents ADD COLUMN last_seen_at DATETIME;
+	ALTER TABLE alert_rules ADD COLUMN type TEXT NOT NULL DEFAULT 'threshold' CHECK (type IN ('threshold', 'agent_offline'));
+	ALTER TABLE alert_rules ADD COLUMN recovery_seconds INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE alerts ADD COLUMN recovering_since DATETIME;`,
+
+	// 9: published events, webhook subscriptions and their delivery queue
+	`
+	CREATE TABLE IF NOT EXISTS events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		agent_id INTEGER,
+		data TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_events_agent ON events (agent_id);
+
+	CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		event_types TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions(id),
+		event_id INTEGER NOT NULL REFERENCES events(id),
+		status TEXT NOT NULL CHECK (status IN ('pending', 'delivered', 'dead')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at INTEGER, -- Unix seconds
+		last_status_code INTEGER,
+		last_error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		delivered_at INTEGER -- Unix seconds
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);`,
+
+	// 10: email notification routes and their outbox
+	`CREATE TABLE IF NOT EXISTS email_routes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		event_types TEXT NOT NULL,
+		rule_id INTEGER REFERENCES alert_rules(id),
+		group_id INTEGER REFERENCES agent_groups(id),
+		digest_seconds INTEGER NOT NULL DEFAULT 0,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS email_notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		route_id INTEGER NOT NULL REFERENCES email_routes(id),
+		event_id INTEGER NOT NULL REFERENCES events(id),
+		status TEXT NOT NULL CHECK (status IN ('pending', 'sent', 'dead')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		send_after INTEGER NOT NULL, -- Unix seconds
+		last_error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		sent_at INTEGER -- Unix seconds
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_notifications_due ON email_notifications (status, route_id, send_after);`,
+
+	// 11: alert silences, recurring maintenance windows and suppressed alerts
+	`CREATE TABLE IF NOT EXISTS silences (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		agent_id INTEGER REFERENCES agents(id),
+		group_id INTEGER REFERENCES agent_groups(id),
+		asn TEXT,
+		starts_at DATETIME NOT NULL,
+		ends_at DATETIME NOT NULL,
+		created_by TEXT NOT NULL,
+		comment TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_silences_ends_at ON silences (ends_at);
+
+	CREATE TABLE IF NOT EXISTS maintenance_windows (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		schedule TEXT NOT NULL, -- Cron expression of the start times
+		duration_seconds INTEGER NOT NULL,
+		timezone TEXT NOT NULL DEFAULT 'UTC',
+		agent_id INTEGER REFERENCES agents(id),
+		group_id INTEGER REFERENCES agent_groups(id),
+		asn TEXT,
+		created_by TEXT NOT NULL,
+		comment TEXT NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	ALTER TABLE alerts ADD COLUMN suppressed_by TEXT;`,
+
+	// 12: event lookups by type and ISP change alert rules. SQLite cannot alter a CHECK
+	// constraint, so alert_rules is rebuilt with the new type allowed.
+	`
+	CREATE INDEX IF NOT EXISTS idx_events_type ON events (type, created_at);
+
+	CREATE TABLE alert_rules_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		metric TEXT NOT NULL,
+		scope TEXT NOT NULL CHECK (scope IN ('all', 'agent', 'group', 'session')),
+		scope_id INTEGER NOT NULL DEFAULT 0,
+		comparator TEXT NOT NULL CHECK (comparator IN ('gt', 'gte', 'lt', 'lte')),
+		threshold REAL NOT NULL,
+		duration_seconds INTEGER NOT NULL,
+		window_seconds INTEGER NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		type TEXT NOT NULL DEFAULT 'threshold' CHECK (type IN ('threshold', 'agent_offline', 'isp_changed')),
+		recovery_seconds INTEGER NOT NULL DEFAULT 0
+	);
+	INSERT INTO alert_rules_new SELECT
+		id, name, metric, scope, scope_id, comparator, threshold, duration_seconds, window_seconds, enabled,
+		created_at, updated_at, type, recovery_seconds
+	FROM alert_rules;
+	DROP TABLE alert_rules;
+	ALTER TABLE alert_rules_new RENAME TO alert_rules;`,
+
+	// 13: agent geolocation
+	`
+	ALTER TABLE agents ADD COLUMN country TEXT;
+	ALTER TABLE agents ADD COLUMN country_code TEXT;
+	ALTER TABLE agents ADD COLUMN region TEXT;
+	ALTER TABLE agents ADD COLUMN region_name TEXT;
+	ALTER TABLE agents ADD COLUMN city TEXT;
+	ALTER TABLE agents ADD COLUMN latitude REAL;
+	ALTER TABLE agents ADD COLUMN longitude REAL;
+	ALTER TABLE agents ADD COLUMN timezone TEXT;
+	CREATE INDEX IF NOT EXISTS idx_agents_location ON agents (country_code, region);`,
+
+	// 14: bounding box lookups for distance queries
+	`CREATE INDEX IF NOT EXISTS idx_agents_coordinates ON agents (latitude, longitude);`,
+
+	// 15: hosting, proxy and mobile network flags of agents
+	`
+	ALTER TABLE agents ADD COLUMN hosting BOOLEAN NOT NULL DEFAULT 0;
+	ALTER TABLE agents ADD COLUMN proxy BOOLEAN NOT NULL DEFAULT 0;
+	ALTER TABLE agents ADD COLUMN mobile BOOLEAN NOT NULL DEFAULT 0;`,
+
+	// 16: reverse DNS name and RDAP network registration of agents, NULL until looked up
+	`
+	ALTER TABLE agents ADD COLUMN ptr TEXT;
+	ALTER TABLE agents ADD COLUMN rdap_name TEXT;
+	ALTER TABLE agents ADD COLUMN rdap_handle TEXT;
+	ALTER TABLE agents ADD COLUMN rdap_abuse_email TEXT;`,
+
+	// 17: BGP prefix announcing the address of agents and its origin AS
+	`
+	ALTER TABLE agents ADD COLUMN announced_prefix TEXT;
+	ALTER TABLE agents ADD COLUMN origin_asn TEXT;
+	CREATE INDEX IF NOT EXISTS idx_agents_announced_prefix ON agents (announced_prefix);`,
 
-	_, err := DB.Exec(createTableSQL)
+	// 18: catalog of autonomous systems referenced by agents with numeric ASNs, seeded from
+	// the AS15169 form stored so far
+	`
+	CREATE TABLE IF NOT EXISTS asns (
+		asn INTEGER PRIMARY KEY CHECK (asn BETWEEN 0 AND 4294967295),
+		name TEXT NOT NULL DEFAULT '',
+		country TEXT NOT NULL DEFAULT '',
+		registry TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	ALTER TABLE agents ADD COLUMN asn_id INTEGER REFERENCES asns(asn);
+	UPDATE agents SET asn_id = CAST(SUBSTR(asn, 3) AS INTEGER)
+	WHERE UPPER(SUBSTR(asn, 1, 2)) = 'AS' AND SUBSTR(asn, 3) GLOB '[0-9]*'
+	  AND CAST(SUBSTR(asn, 3) AS INTEGER) BETWEEN 0 AND 4294967295;
+	INSERT INTO asns (asn) SELECT DISTINCT asn_id FROM agents WHERE asn_id IS NOT NULL;
+	CREATE INDEX IF NOT EXISTS idx_agents_asn_id ON agents (asn_id);`,
+
+	// 19: agent details set by hand, with the values the provider reports. The values have
+	// no declared type so coordinates stay numbers
+	`
+	CREATE TABLE IF NOT EXISTS agent_overrides (
+		agent_id INTEGER NOT NULL REFERENCES agents(id),
+		field TEXT NOT NULL,
+		value,
+		provider_value,
+		set_by TEXT NOT NULL,
+		set_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (agent_id, field)
+	);`,
+
+	// 20: where each enriched agent detail comes from. A row is added whenever a provider
+	// reports a different value, so earlier ones are kept as history
+	`
+	CREATE TABLE IF NOT EXISTS agent_provenance (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		agent_id INTEGER NOT NULL REFERENCES agents(id),
+		field TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		value,
+		response_hash TEXT NOT NULL DEFAULT '',
+		confidence REAL,
+		fetched_at DATETIME NOT NULL,
+		recorded_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_agent_provenance_field ON agent_provenance (agent_id, field, id);`,
+
+	// 21: key/value labels of agents, indexed by label for selectors
+	`
+	CREATE TABLE IF NOT EXISTS agent_labels (
+		agent_id INTEGER NOT NULL REFERENCES agents(id),
+		key TEXT NOT NULL,
+		value TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (agent_id, key)
+	);
+	CREATE INDEX IF NOT EXISTS idx_agent_labels_key_value ON agent_labels (key, value);`,
+
+	// 22: regions nested in parent regions, the sites within them and the site of each agent
+	`
+	CREATE TABLE IF NOT EXISTS regions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		parent_id INTEGER REFERENCES regions(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_regions_parent_id ON regions (parent_id);
+
+	CREATE TABLE IF NOT EXISTS sites (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		address TEXT NOT NULL DEFAULT '',
+		latitude REAL,
+		longitude REAL,
+		region_id INTEGER REFERENCES regions(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_sites_region_id ON sites (region_id);
+
+	ALTER TABLE agents ADD COLUMN site_id INTEGER REFERENCES sites(id);
+	CREATE INDEX IF NOT EXISTS idx_agents_site_id ON agents (site_id);`,
+
+	// 23: lifecycle state of agents, existing agents being active, and its transitions
+	`
+	ALTER TABLE agents ADD COLUMN state TEXT NOT NULL DEFAULT 'active';
+	CREATE INDEX IF NOT EXISTS idx_agents_state ON agents (state);
+
+	CREATE TABLE IF NOT EXISTS agent_state_transitions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		agent_id INTEGER NOT NULL REFERENCES agents(id),
+		from_state TEXT NOT NULL,
+		to_state TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_agent_state_transitions_agent_id ON agent_state_transitions (agent_id);`,
+
+	// 24: soft deletion of agents, purged after a grace period
+	`
+	ALTER TABLE agents ADD COLUMN deleted_at DATETIME;
+	CREATE INDEX IF NOT EXISTS idx_agents_deleted_at ON agents (deleted_at);`,
+
+	// 25: configuration versions are bumped when the configuration is written, not
+	// compared by hash when it is read
+	`ALTER TABLE agent_config_versions DROP COLUMN hash;`,
+
+	// 26: webhook deliveries claimed by the run sending them
+	`
+	CREATE TABLE webhook_deliveries_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions(id),
+		event_id INTEGER NOT NULL REFERENCES events(id),
+		status TEXT NOT NULL CHECK (status IN ('pending', 'in_flight', 'delivered', 'dead')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at INTEGER, -- Unix seconds, when the claim expires for in flight deliveries
+		last_status_code INTEGER,
+		last_error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		delivered_at INTEGER -- Unix seconds
+	);
+	INSERT INTO webhook_deliveries_new SELECT
+		id, subscription_id, event_id, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at
+	FROM webhook_deliveries;
+	DROP TABLE webhook_deliveries;
+	ALTER TABLE webhook_deliveries_new RENAME TO webhook_deliveries;
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);`,
+
+	// 27: label selector matchers of silences and maintenance windows
+	`
+	ALTER TABLE silences ADD COLUMN selector TEXT;
+	ALTER TABLE maintenance_windows ADD COLUMN selector TEXT;`,
+}
+
+// Migrate brings the database schema up to date. The current schema version
+// is tracked with SQLite's user_version pragma so each migration runs once.
+func Migrate(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
 	if err != nil {
-		log.Fatalf("Failed to create tables: %v", err)
+		return fmt.Errorf("error reading schema version: %w", err)
 	}
 
-	fmt.Println("Tables created successfully")
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("error starting migration %d: %w", i+1, err)
+		}
+		_, err = tx.Exec(migrations[i])
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error applying migration %d: %w", i+1, err)
+		}
+		// PRAGMA statements cannot take bound parameters
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error recording migration %d: %w", i+1, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return fmt.Errorf("error committing migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,63 @@
+package cron
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, expr := range []string{
+		"* * * * *", "*/15 9-17 * * 1-5", "0 2 1,15 * *", "30 3 * jan-mar sat,sun", "0 0 * * 7", "5/20 * * * *", "@daily",
+	} {
+		_, err := Parse(expr)
+		assert.NoError(t, err, expr)
+	}
+
+	for _, expr := range []string{
+		"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often",
+	} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Saturday 2025-03-01 10:07 UTC
+	from := time.Date(2025, 3, 1, 10, 7, 30, 0, time.UTC)
+
+	for _, tc := range []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2025, 3, 1, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 3, 1, 10, 15, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2025, 3, 2, 2, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 0 15 * mon", time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		assert.Equal(t, tc.expected, MustParse(tc.expr).Next(from), tc.expr)
+	}
+
+	t.Run("Never fires", func(t *testing.T) {
+		assert.True(t, MustParse("0 0 30 2 *").Next(from).IsZero())
+	})
+
+	t.Run("Follows the location", func(t *testing.T) {
+		zone := time.FixedZone("UTC+5:30", 5*3600+1800)
+		next := MustParse("0 * * * *").Next(from.In(zone))
+		assert.Equal(t, time.Date(2025, 3, 1, 16, 0, 0, 0, zone), next)
+	})
+}
+
+func TestMatches(t *testing.T) {
+	s := MustParse("*/10 22-23 * * fri")
+	assert.True(t, s.Matches(time.Date(2025, 2, 28, 22, 30, 59, 0, time.UTC)))
+	assert.False(t, s.Matches(time.Date(2025, 2, 28, 22, 35, 0, 0, time.UTC)))
+	assert.False(t, s.Matches(time.Date(2025, 3, 1, 22, 30, 0, 0, time.UTC)))
+}
@@ -0,0 +1,69 @@
+// Package enrich looks up optional details about agent addresses: the reverse DNS
+// name and the RDAP registration of the network. Every lookup has its own timeout
+// and results are cached, so re-registering agents does not hammer the servers.
+package enrich
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long lookup results are cached unless a lookup sets its own TTL.
+const DefaultCacheTTL = 24 * time.Hour
+
+// maxCacheEntries is the size above which expired entries are swept on insertion.
+const maxCacheEntries = 10000
+
+// Cache holds lookup results by key until they expire. The zero value is an empty cache.
+type Cache[V any] struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry[V]
+}
+
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// Get returns the value cached for key, if it has not expired.
+func (c *Cache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// Put caches value for key during ttl. Nothing is cached when ttl is not positive.
+func (c *Cache[V]) Put(key string, value V, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[string]cacheEntry[V])
+	}
+	if len(c.entries) >= maxCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = cacheEntry[V]{value: value, expires: now.Add(ttl)}
+}
+
+// cacheTTL resolves a configured TTL: zero means DefaultCacheTTL and negative disables caching
+func cacheTTL(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		return DefaultCacheTTL
+	}
+	return ttl
+}
@@ -0,0 +1,66 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultDNSTimeout bounds a reverse DNS lookup unless ReverseDNS sets its own timeout.
+const defaultDNSTimeout = 2 * time.Second
+
+// ReverseDNS looks up the PTR record of addresses.
+type ReverseDNS struct {
+	Resolver *net.Resolver // The system resolver is used when nil
+	Timeout  time.Duration // Defaults to 2 seconds
+	CacheTTL time.Duration // Defaults to DefaultCacheTTL; negative disables caching
+
+	cache Cache[sourced[string]]
+}
+
+// Lookup returns the name the PTR record of ip points to, without the trailing dot.
+// An address without a PTR record has an empty name.
+func (r *ReverseDNS) Lookup(ctx context.Context, ip string) (string, error) {
+	name, _, err := r.LookupSource(ctx, ip)
+	return name, err
+}
+
+// LookupSource is Lookup also returning the source of the name. DNS answers are not
+// exposed by the resolver, so the response hash is that of the names it returned, one
+// per line.
+func (r *ReverseDNS) LookupSource(ctx context.Context, ip string) (string, Source, error) {
+	if cached, ok := r.cache.Get(ip); ok {
+		return cached.value, cached.source, nil
+	}
+
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = defaultDNSTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	names, err := resolver.LookupAddr(ctx, ip)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		names, err = nil, nil
+	}
+	if err != nil {
+		return "", Source{}, fmt.Errorf("error looking up PTR record of %s: %w", ip, err)
+	}
+
+	var name string
+	if len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	source := NewSource([]byte(strings.Join(names, "\n")))
+	r.cache.Put(ip, sourced[string]{name, source}, cacheTTL(r.CacheTTL))
+	return name, source, nil
+}
@@ -0,0 +1,135 @@
+package enrich_test
+
+import (
+	"context"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"github.com/Shaughny/obkio-test/internal/enrich/enrichtest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReverseDNS(t *testing.T) {
+	server := enrichtest.NewDNSServer()
+	defer server.Close()
+	server.SetPTR("8.8.8.8", "dns.google")
+	server.SetPTR("2001:4860:4860::8888", "dns.google")
+
+	lookup := &enrich.ReverseDNS{Resolver: server.Resolver()}
+
+	t.Run("PTR record", func(t *testing.T) {
+		for _, ip := range []string{"8.8.8.8", "2001:4860:4860::8888"} {
+			name, err := lookup.Lookup(context.Background(), ip)
+			assert.NoError(t, err, ip)
+			assert.Equal(t, "dns.google", name, ip)
+		}
+	})
+
+	t.Run("No PTR record", func(t *testing.T) {
+		name, err := lookup.Lookup(context.Background(), "10.0.0.1")
+		assert.NoError(t, err)
+		assert.Empty(t, name)
+	})
+
+	t.Run("Results are cached", func(t *testing.T) {
+		queries := server.Queries()
+		name, err := lookup.Lookup(context.Background(), "8.8.8.8")
+		assert.NoError(t, err)
+		assert.Equal(t, "dns.google", name)
+		_, err = lookup.Lookup(context.Background(), "10.0.0.1")
+		assert.NoError(t, err)
+		assert.Equal(t, queries, server.Queries())
+
+		uncached := &enrich.ReverseDNS{Resolver: server.Resolver(), CacheTTL: -1}
+		_, err = uncached.Lookup(context.Background(), "8.8.8.8")
+		assert.NoError(t, err)
+		assert.Greater(t, server.Queries(), queries)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		silent := enrichtest.NewDNSServer()
+		defer silent.Close()
+		silent.SetPTR("8.8.4.4", "dns.google")
+		silent.Silence()
+
+		slow := &enrich.ReverseDNS{Resolver: silent.Resolver(), Timeout: 100 * time.Millisecond}
+		start := time.Now()
+		_, err := slow.Lookup(context.Background(), "8.8.4.4")
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 2*time.Second)
+	})
+}
+
+func TestRDAP(t *testing.T) {
+	server := enrichtest.NewRDAPServer()
+	defer server.Close()
+	google := enrich.Network{Name: "GOGL", Handle: "NET-8-8-8-0-2", AbuseEmail: "network-abuse@google.com"}
+	server.SetNetwork("8.8.8.8", google)
+
+	lookup := &enrich.RDAP{BaseURL: server.URL}
+
+	t.Run("Registration", func(t *testing.T) {
+		network, err := lookup.Lookup(context.Background(), "8.8.8.8")
+		assert.NoError(t, err)
+		assert.Equal(t, google, network)
+	})
+
+	t.Run("AS number", func(t *testing.T) {
+		ripe := enrich.AutNum{Name: "AS-EXAMPLE", Country: "NL", Registry: "RIPE NCC"}
+		server.SetAutNum(64500, ripe)
+		autnum, err := lookup.LookupAutNum(context.Background(), 64500)
+		assert.NoError(t, err)
+		assert.Equal(t, ripe, autnum)
+
+		autnum, err = lookup.LookupAutNum(context.Background(), 64501)
+		assert.NoError(t, err)
+		assert.Equal(t, enrich.AutNum{}, autnum)
+	})
+
+	t.Run("Unknown address", func(t *testing.T) {
+		network, err := lookup.Lookup(context.Background(), "10.0.0.1")
+		assert.NoError(t, err)
+		assert.Equal(t, enrich.Network{}, network)
+	})
+
+	t.Run("Results are cached", func(t *testing.T) {
+		queries := server.Queries()
+		network, err := lookup.Lookup(context.Background(), "8.8.8.8")
+		assert.NoError(t, err)
+		assert.Equal(t, google, network)
+		assert.Equal(t, queries, server.Queries())
+	})
+
+	t.Run("Source", func(t *testing.T) {
+		fresh := &enrich.RDAP{BaseURL: server.URL}
+		_, source, err := fresh.LookupSource(context.Background(), "8.8.8.8")
+		assert.NoError(t, err)
+		assert.Len(t, source.ResponseHash, 64)
+		assert.WithinDuration(t, time.Now(), source.FetchedAt, time.Minute)
+
+		// Cached results keep the time of the original answer
+		_, cached, err := fresh.LookupSource(context.Background(), "8.8.8.8")
+		assert.NoError(t, err)
+		assert.Equal(t, source, cached)
+	})
+
+	t.Run("Server errors are not cached", func(t *testing.T) {
+		failing := enrichtest.NewRDAPServer()
+		defer failing.Close()
+		failing.Fail(http.StatusTooManyRequests)
+
+		uncached := &enrich.RDAP{BaseURL: failing.URL}
+		for range 2 {
+			_, err := uncached.Lookup(context.Background(), "8.8.8.8")
+			assert.Error(t, err)
+		}
+		assert.Equal(t, 2, failing.Queries())
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		slow := &enrich.RDAP{BaseURL: server.URL, Timeout: time.Nanosecond, CacheTTL: -1}
+		_, err := slow.Lookup(context.Background(), "8.8.8.8")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,153 @@
+// Package enrichtest provides in-process DNS and RDAP servers for testing address enrichment.
+package enrichtest
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"sync"
+)
+
+// DNSServer is a minimal DNS server on the loopback interface that answers PTR
+// queries for the records it was given and NXDOMAIN for everything else.
+type DNSServer struct {
+	Addr string
+
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	ptr     map[string]string // Reverse name, such as 1.0.0.10.in-addr.arpa., to target
+	queries int
+	silent  bool
+	wg      sync.WaitGroup
+}
+
+// NewDNSServer starts a DNS server listening for UDP queries.
+func NewDNSServer() *DNSServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("enrichtest: listening: %v", err))
+	}
+	s := &DNSServer{Addr: conn.LocalAddr().String(), conn: conn, ptr: make(map[string]string)}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// SetPTR makes the server answer PTR queries for ip with name.
+func (s *DNSServer) SetPTR(ip, name string) {
+	reverse, err := dnsmessage.NewName(reverseName(net.ParseIP(ip)))
+	if err != nil {
+		panic(fmt.Sprintf("enrichtest: reverse name of %s: %v", ip, err))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ptr[reverse.String()] = name
+}
+
+// Silence makes the server stop answering, so lookups time out.
+func (s *DNSServer) Silence() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silent = true
+}
+
+// Queries returns the number of queries received.
+func (s *DNSServer) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+// Resolver returns a resolver sending every query to the server.
+func (s *DNSServer) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", s.Addr)
+		},
+	}
+}
+
+// Close stops the server.
+func (s *DNSServer) Close() {
+	s.conn.Close()
+	s.wg.Wait()
+}
+
+func (s *DNSServer) serve() {
+	defer s.wg.Done()
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		reply, ok := s.answer(buf[:n])
+		if ok {
+			s.conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+// answer builds the reply to a query, or reports false when there is none to send
+func (s *DNSServer) answer(query []byte) ([]byte, bool) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, false
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	s.queries++
+	silent := s.silent
+	target, found := s.ptr[question.Name.String()]
+	s.mu.Unlock()
+	if silent {
+		return nil, false
+	}
+
+	reply := dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true, RecursionDesired: header.RecursionDesired}
+	if !found || question.Type != dnsmessage.TypePTR {
+		reply.RCode = dnsmessage.RCodeNameError
+	}
+	builder := dnsmessage.NewBuilder(nil, reply)
+	builder.EnableCompression()
+	err = builder.StartQuestions()
+	if err == nil {
+		err = builder.Question(question)
+	}
+	if err == nil && reply.RCode == dnsmessage.RCodeSuccess {
+		err = builder.StartAnswers()
+		if err == nil {
+			err = builder.PTRResource(
+				dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: 300},
+				dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(target + ".")},
+			)
+		}
+	}
+	if err != nil {
+		return nil, false
+	}
+	msg, err := builder.Finish()
+	return msg, err == nil
+}
+
+// reverseName is the in-addr.arpa or ip6.arpa name of an address
+func reverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0])
+	}
+	const hex = "0123456789abcdef"
+	name := make([]byte, 0, len(ip)*4+len("ip6.arpa."))
+	for i := len(ip) - 1; i >= 0; i-- {
+		name = append(name, hex[ip[i]&0xf], '.', hex[ip[i]>>4], '.')
+	}
+	return string(name) + "ip6.arpa."
+}
@@ -0,0 +1,129 @@
+package enrichtest
+
+import (
+	"encoding/json"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RDAPServer answers RDAP IP network and autnum queries for the registrations it was
+// given and 404 for everything else. Abuse contacts are nested in a registrant entity,
+// the way ARIN publishes them.
+type RDAPServer struct {
+	URL string
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	networks map[string]enrich.Network
+	autnums  map[string]enrich.AutNum
+	queries  int
+	status   int
+}
+
+// whoisServers are the WHOIS servers autnum objects name to tell their registry
+var whoisServers = map[string]string{
+	"AFRINIC":  "whois.afrinic.net",
+	"APNIC":    "whois.apnic.net",
+	"ARIN":     "whois.arin.net",
+	"LACNIC":   "whois.lacnic.net",
+	"RIPE NCC": "whois.ripe.net",
+}
+
+// NewRDAPServer starts an RDAP server.
+func NewRDAPServer() *RDAPServer {
+	s := &RDAPServer{networks: make(map[string]enrich.Network), autnums: make(map[string]enrich.AutNum)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.server.URL
+	return s
+}
+
+// SetNetwork makes the server answer queries for ip with network.
+func (s *RDAPServer) SetNetwork(ip string, network enrich.Network) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.networks[ip] = network
+}
+
+// SetAutNum makes the server answer queries for asn with autnum.
+func (s *RDAPServer) SetAutNum(asn uint32, autnum enrich.AutNum) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.autnums[strconv.FormatUint(uint64(asn), 10)] = autnum
+}
+
+// Fail makes the server answer every query with status.
+func (s *RDAPServer) Fail(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// Queries returns the number of queries received.
+func (s *RDAPServer) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+// Close stops the server.
+func (s *RDAPServer) Close() {
+	s.server.Close()
+}
+
+func (s *RDAPServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.queries++
+	status := s.status
+	network, isNetwork := s.networks[strings.TrimPrefix(r.URL.Path, "/ip/")]
+	autnum, isAutNum := s.autnums[strings.TrimPrefix(r.URL.Path, "/autnum/")]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/rdap+json")
+	switch {
+	case status != 0:
+		w.WriteHeader(status)
+	case isNetwork && strings.HasPrefix(r.URL.Path, "/ip/"):
+		json.NewEncoder(w).Encode(networkObject(network))
+	case isAutNum && strings.HasPrefix(r.URL.Path, "/autnum/"):
+		json.NewEncoder(w).Encode(map[string]any{
+			"objectClassName": "autnum",
+			"handle":          "AS" + strings.TrimPrefix(r.URL.Path, "/autnum/"),
+			"name":            autnum.Name,
+			"country":         autnum.Country,
+			"port43":          whoisServers[autnum.Registry],
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errorCode": 404, "title": "Not Found"}`))
+	}
+}
+
+// networkObject is the RDAP IP network object of a registration
+func networkObject(network enrich.Network) map[string]any {
+	abuse := map[string]any{
+		"objectClassName": "entity",
+		"handle":          "ABUSE-ARIN",
+		"roles":           []string{"abuse"},
+		"vcardArray": []any{"vcard", []any{
+			[]any{"version", map[string]any{}, "text", "4.0"},
+			[]any{"fn", map[string]any{}, "text", "Abuse"},
+			[]any{"email", map[string]any{}, "text", network.AbuseEmail},
+		}},
+	}
+	return map[string]any{
+		"objectClassName": "ip network",
+		"handle":          network.Handle,
+		"name":            network.Name,
+		"entities": []any{map[string]any{
+			"objectClassName": "entity",
+			"handle":          "ORG-1",
+			"roles":           []string{"registrant"},
+			"entities":        []any{abuse},
+		}},
+	}
+}
@@ -0,0 +1,196 @@
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRDAPTimeout bounds an RDAP lookup, redirects included, unless RDAP sets its own timeout.
+const defaultRDAPTimeout = 5 * time.Second
+
+// Network is the registration of the network an address belongs to.
+type Network struct {
+	Name       string `json:"name,omitempty"`   // Such as GOGL
+	Handle     string `json:"handle,omitempty"` // Registry identifier, such as NET-8-8-8-0-2
+	AbuseEmail string `json:"abuse_email,omitempty"`
+}
+
+// RDAP looks up the registration of networks and AS numbers with the Registration
+// Data Access Protocol (RFC 9083), the successor of WHOIS.
+type RDAP struct {
+	BaseURL  string       // Such as https://rdap.org, which redirects to the registry of the address
+	Client   *http.Client // http.DefaultClient is used when nil
+	Timeout  time.Duration
+	CacheTTL time.Duration // Defaults to DefaultCacheTTL; negative disables caching
+
+	cache       Cache[sourced[Network]]
+	autnumCache Cache[sourced[AutNum]]
+}
+
+// rdapObject holds the parts of an RDAP IP network, autnum or entity object that are used
+type rdapObject struct {
+	Handle     string            `json:"handle"`
+	Name       string            `json:"name"`
+	Country    string            `json:"country"`
+	Port43     string            `json:"port43"` // WHOIS server of the registry
+	Roles      []string          `json:"roles"`
+	VCardArray []json.RawMessage `json:"vcardArray"`
+	Entities   []rdapObject      `json:"entities"`
+}
+
+// AutNum is the registration of an autonomous system number.
+type AutNum struct {
+	Name     string `json:"name,omitempty"`     // Such as GOOGLE
+	Country  string `json:"country,omitempty"`  // ISO 3166-1 alpha-2, when the registry publishes it
+	Registry string `json:"registry,omitempty"` // Regional Internet Registry, such as ARIN
+}
+
+// registries maps the WHOIS servers of the Regional Internet Registries to their names
+var registries = map[string]string{
+	"whois.afrinic.net": "AFRINIC",
+	"whois.apnic.net":   "APNIC",
+	"whois.arin.net":    "ARIN",
+	"whois.lacnic.net":  "LACNIC",
+	"whois.ripe.net":    "RIPE NCC",
+}
+
+// Lookup returns the registration of the network ip belongs to. An address no registry
+// knows about has an empty registration.
+func (r *RDAP) Lookup(ctx context.Context, ip string) (Network, error) {
+	network, _, err := r.LookupSource(ctx, ip)
+	return network, err
+}
+
+// LookupSource is Lookup also returning the source of the registration.
+func (r *RDAP) LookupSource(ctx context.Context, ip string) (Network, Source, error) {
+	if cached, ok := r.cache.Get(ip); ok {
+		return cached.value, cached.source, nil
+	}
+
+	var object rdapObject
+	found, source, err := r.get(ctx, "/ip/"+ip, &object)
+	if err != nil {
+		return Network{}, Source{}, fmt.Errorf("error looking up RDAP registration of %s: %w", ip, err)
+	}
+	var network Network
+	if found {
+		network = Network{Name: object.Name, Handle: object.Handle, AbuseEmail: abuseEmail(object.Entities)}
+	}
+
+	r.cache.Put(ip, sourced[Network]{network, source}, cacheTTL(r.CacheTTL))
+	return network, source, nil
+}
+
+// LookupAutNum returns the registration of an AS number. A number no registry knows
+// about has an empty registration.
+func (r *RDAP) LookupAutNum(ctx context.Context, asn uint32) (AutNum, error) {
+	key := "AS" + strconv.FormatUint(uint64(asn), 10)
+	if cached, ok := r.autnumCache.Get(key); ok {
+		return cached.value, nil
+	}
+
+	var object rdapObject
+	found, source, err := r.get(ctx, "/autnum/"+strconv.FormatUint(uint64(asn), 10), &object)
+	if err != nil {
+		return AutNum{}, fmt.Errorf("error looking up RDAP registration of %s: %w", key, err)
+	}
+	var autnum AutNum
+	if found {
+		autnum = AutNum{Name: object.Name, Country: strings.ToUpper(object.Country), Registry: registries[strings.ToLower(object.Port43)]}
+	}
+
+	r.autnumCache.Put(key, sourced[AutNum]{autnum, source}, cacheTTL(r.CacheTTL))
+	return autnum, nil
+}
+
+// get fetches an RDAP object into v, reporting false when the registry does not know it
+func (r *RDAP) get(ctx context.Context, path string, v any) (bool, Source, error) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = defaultRDAPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(r.BaseURL, "/")+path, nil)
+	if err != nil {
+		return false, Source{}, fmt.Errorf("error creating RDAP request: %w", err)
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, Source{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return false, Source{}, fmt.Errorf("request failed with status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, Source{}, fmt.Errorf("error reading response: %w", err)
+	}
+	source := NewSource(body)
+	if resp.StatusCode == http.StatusNotFound {
+		return false, source, nil
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return false, Source{}, fmt.Errorf("error decoding response: %w", err)
+	}
+	return true, source, nil
+}
+
+// abuseEmail finds the email address of the first entity with the abuse role. Registries
+// such as ARIN nest the abuse contact within the organisation entity.
+func abuseEmail(entities []rdapObject) string {
+	for _, entity := range entities {
+		if slices.Contains(entity.Roles, "abuse") {
+			if email := vcardEmail(entity.VCardArray); email != "" {
+				return email
+			}
+		}
+		if email := abuseEmail(entity.Entities); email != "" {
+			return email
+		}
+	}
+	return ""
+}
+
+// vcardEmail reads the email property of a jCard (RFC 7095) such as
+// ["vcard", [["version", {}, "text", "4.0"], ["email", {}, "text", "abuse@example.com"]]]
+func vcardEmail(vcard []json.RawMessage) string {
+	if len(vcard) < 2 {
+		return ""
+	}
+	var properties [][]json.RawMessage
+	if json.Unmarshal(vcard[1], &properties) != nil {
+		return ""
+	}
+	for _, property := range properties {
+		if len(property) < 4 {
+			continue
+		}
+		var name, value string
+		if json.Unmarshal(property[0], &name) != nil || name != "email" {
+			continue
+		}
+		if json.Unmarshal(property[3], &value) == nil {
+			return value
+		}
+	}
+	return ""
+}
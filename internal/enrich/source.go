@@ -0,0 +1,25 @@
+package enrich
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Source tells where a lookup result comes from, to audit the data derived from it.
+type Source struct {
+	FetchedAt    time.Time // When the server answered; cached results keep the time of the original answer
+	ResponseHash string    // Hex encoded SHA-256 of the raw response, empty when there was none
+}
+
+// NewSource describes a response received now.
+func NewSource(response []byte) Source {
+	sum := sha256.Sum256(response)
+	return Source{FetchedAt: time.Now().UTC(), ResponseHash: hex.EncodeToString(sum[:])}
+}
+
+// sourced is a cached lookup result with its source
+type sourced[V any] struct {
+	value  V
+	source Source
+}
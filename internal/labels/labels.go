@@ -0,0 +1,221 @@
+// Package labels validates agent labels and parses Kubernetes-style label selectors such
+// as "env=prod,site in (mtl,tor),!decommissioned".
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Operators of a selector requirement.
+const (
+	Equals       = "="
+	NotEquals    = "!="
+	In           = "in"
+	NotIn        = "notin"
+	Exists       = "exists"  // The key alone
+	DoesNotExist = "!exists" // The key preceded by !
+)
+
+// Requirement is a condition on the value of one label.
+type Requirement struct {
+	Key      string
+	Operator string
+	Values   []string // One value for = and !=, at least one for in and notin, none otherwise
+}
+
+// Selector selects the label sets meeting all of its requirements. An empty selector
+// selects everything.
+type Selector []Requirement
+
+var (
+	namePattern   = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	prefixPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// ValidateKey checks a label key: a name of at most 63 letters, digits, dashes,
+// underscores and dots starting and ending with a letter or digit, optionally prefixed
+// with a DNS subdomain and a slash, as in example.com/site.
+func ValidateKey(key string) error {
+	name := key
+	if prefix, rest, ok := strings.Cut(key, "/"); ok {
+		if len(prefix) > 253 || !prefixPattern.MatchString(prefix) {
+			return fmt.Errorf("invalid label key %q: prefix must be a DNS subdomain", key)
+		}
+		name = rest
+	}
+	if len(name) > 63 || !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+	return nil
+}
+
+// ValidateValue checks a label value, which is empty or follows the rules of key names.
+func ValidateValue(value string) error {
+	if value != "" && (len(value) > 63 || !namePattern.MatchString(value)) {
+		return fmt.Errorf("invalid label value %q", value)
+	}
+	return nil
+}
+
+// Validate checks the keys and values of a label set.
+func Validate(set map[string]string) error {
+	for key, value := range set {
+		err := ValidateKey(key)
+		if err != nil {
+			return err
+		}
+		err = ValidateValue(value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse parses a selector made of comma separated requirements of these forms:
+//
+//	key=value, key==value   the label is set to the value
+//	key!=value              the label is not set to the value, or not set at all
+//	key in (v1,v2)          the label is set to one of the values
+//	key notin (v1,v2)       the label is not set to any of the values, or not set at all
+//	key                     the label is set
+//	!key                    the label is not set
+func Parse(selector string) (Selector, error) {
+	var s Selector
+	terms, err := split(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, term := range terms {
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, r)
+	}
+	return s, nil
+}
+
+// Matches reports whether a label set meets every requirement of the selector.
+func (s Selector) Matches(set map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(set) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether a label set meets the requirement.
+func (r Requirement) Matches(set map[string]string) bool {
+	value, ok := set[r.Key]
+	switch r.Operator {
+	case Equals, In:
+		return ok && slices.Contains(r.Values, value)
+	case NotEquals, NotIn:
+		return !ok || !slices.Contains(r.Values, value)
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+// String formats the selector back in its canonical form.
+func (s Selector) String() string {
+	terms := make([]string, len(s))
+	for i, r := range s {
+		switch r.Operator {
+		case Equals, NotEquals:
+			terms[i] = r.Key + r.Operator + r.Values[0]
+		case In, NotIn:
+			terms[i] = r.Key + " " + r.Operator + " (" + strings.Join(r.Values, ",") + ")"
+		case Exists:
+			terms[i] = r.Key
+		case DoesNotExist:
+			terms[i] = "!" + r.Key
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// split cuts a selector at the commas that are not within a value list
+func split(selector string) ([]string, error) {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+			if depth > 1 {
+				return nil, fmt.Errorf("unexpected ( in selector %q", selector)
+			}
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unexpected ) in selector %q", selector)
+			}
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unterminated value list in selector %q", selector)
+	}
+	terms = append(terms, selector[start:])
+	if len(terms) == 1 && strings.TrimSpace(terms[0]) == "" {
+		return nil, nil
+	}
+	return terms, nil
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return Requirement{}, fmt.Errorf("empty requirement in selector")
+	}
+
+	var r Requirement
+	switch {
+	case strings.HasPrefix(term, "!") && !strings.Contains(term, "="):
+		r = Requirement{Key: strings.TrimSpace(term[1:]), Operator: DoesNotExist}
+	case strings.Contains(term, "("):
+		open := strings.Index(term, "(")
+		fields := strings.Fields(term[:open])
+		if len(fields) != 2 || (fields[1] != In && fields[1] != NotIn) || !strings.HasSuffix(term, ")") {
+			return Requirement{}, fmt.Errorf("invalid requirement %q: expected key in (values) or key notin (values)", term)
+		}
+		r = Requirement{Key: fields[0], Operator: fields[1]}
+		for _, value := range strings.Split(term[open+1:len(term)-1], ",") {
+			r.Values = append(r.Values, strings.TrimSpace(value))
+		}
+	case strings.Contains(term, "!="):
+		key, value, _ := strings.Cut(term, "!=")
+		r = Requirement{Key: strings.TrimSpace(key), Operator: NotEquals, Values: []string{strings.TrimSpace(value)}}
+	case strings.Contains(term, "="):
+		key, value, _ := strings.Cut(term, "=")
+		value = strings.TrimPrefix(value, "=")
+		r = Requirement{Key: strings.TrimSpace(key), Operator: Equals, Values: []string{strings.TrimSpace(value)}}
+	default:
+		r = Requirement{Key: term, Operator: Exists}
+	}
+
+	err := ValidateKey(r.Key)
+	if err != nil {
+		return Requirement{}, err
+	}
+	for _, value := range r.Values {
+		err = ValidateValue(value)
+		if err != nil {
+			return Requirement{}, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,62 @@
+package labels
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse("env=prod, site in (mtl, tor),!decommissioned,tier!=edge,example.com/owner,customer notin (acme),zone==a")
+	assert.NoError(t, err)
+	assert.Equal(t, Selector{
+		{Key: "env", Operator: Equals, Values: []string{"prod"}},
+		{Key: "site", Operator: In, Values: []string{"mtl", "tor"}},
+		{Key: "decommissioned", Operator: DoesNotExist},
+		{Key: "tier", Operator: NotEquals, Values: []string{"edge"}},
+		{Key: "example.com/owner", Operator: Exists},
+		{Key: "customer", Operator: NotIn, Values: []string{"acme"}},
+		{Key: "zone", Operator: Equals, Values: []string{"a"}},
+	}, s)
+	assert.Equal(t, "env=prod,site in (mtl,tor),!decommissioned,tier!=edge,example.com/owner,customer notin (acme),zone=a", s.String())
+
+	empty, err := Parse(" ")
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+
+	for _, selector := range []string{
+		"env=prod,", "site in mtl", "site in (mtl", "site in ((mtl))", "site within (mtl)", "-env", "env=pr od",
+		"Example.com/env", "env=" + strings.Repeat("a", 64),
+	} {
+		_, err := Parse(selector)
+		assert.Error(t, err, selector)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	set := map[string]string{"env": "prod", "site": "mtl"}
+	for selector, expected := range map[string]bool{
+		"":                           true,
+		"env=prod":                   true,
+		"env=prod,site in (mtl,tor)": true,
+		"env=prod,site in (tor)":     false,
+		"site notin (tor)":           true,
+		"customer notin (acme)":      true,
+		"customer!=acme":             true,
+		"env!=prod":                  false,
+		"site":                       true,
+		"!site":                      false,
+		"!decommissioned":            true,
+	} {
+		s, err := Parse(selector)
+		assert.NoError(t, err, selector)
+		assert.Equal(t, expected, s.Matches(set), selector)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(map[string]string{"env": "prod", "example.com/site": "mtl-1", "decommissioned": ""}))
+	assert.Error(t, Validate(map[string]string{"": "prod"}))
+	assert.Error(t, Validate(map[string]string{"env": "prod!"}))
+	assert.Error(t, Validate(map[string]string{"-env": "prod"}))
+}
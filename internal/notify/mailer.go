@@ -0,0 +1,209 @@
+// Package notify sends notifications by email over SMTP.
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ways of securing the connection to the SMTP server.
+const (
+	SecurityNone     = "none"     // Plain text, for local relays only
+	SecurityStartTLS = "starttls" // Upgrade a plain connection with STARTTLS, usually on port 587
+	SecurityTLS      = "tls"      // TLS from the first byte, usually on port 465
+)
+
+// defaultTimeout bounds a whole SMTP conversation when the mailer sets no timeout.
+const defaultTimeout = 30 * time.Second
+
+// Message is an email with a plain text body and an optional HTML alternative.
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Sender delivers email messages.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// Mailer sends messages through an SMTP server.
+type Mailer struct {
+	Host     string
+	Port     int
+	Username string // Authenticates with PLAIN when set
+	Password string
+	From     string
+	Security string // SecurityNone, SecurityStartTLS or SecurityTLS
+
+	// TLSConfig overrides the TLS settings, for instance to trust a private CA
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+}
+
+// Send delivers msg to all of its recipients in one SMTP transaction.
+func (m *Mailer) Send(msg Message) error {
+	if len(msg.To) == 0 {
+		return errors.New("message has no recipients")
+	}
+	body, err := m.encode(msg)
+	if err != nil {
+		return err
+	}
+
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	switch m.Security {
+	case SecurityTLS:
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, m.tlsConfig())
+	case SecurityNone, SecurityStartTLS:
+		conn, err = dialer.Dial("tcp", addr)
+	default:
+		return fmt.Errorf("unknown SMTP security %q", m.Security)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error greeting SMTP server: %w", err)
+	}
+	defer client.Close()
+
+	if m.Security == SecurityStartTLS {
+		err = client.StartTLS(m.tlsConfig())
+		if err != nil {
+			return fmt.Errorf("error starting TLS: %w", err)
+		}
+	}
+	if m.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host))
+		if err != nil {
+			return fmt.Errorf("error authenticating: %w", err)
+		}
+	}
+
+	err = client.Mail(m.From)
+	if err != nil {
+		return fmt.Errorf("error setting sender: %w", err)
+	}
+	for _, recipient := range msg.To {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return fmt.Errorf("error adding recipient %s: %w", recipient, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("error starting message: %w", err)
+	}
+	_, err = w.Write(body)
+	if err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("error sending message: %w", err)
+	}
+	return client.Quit()
+}
+
+func (m *Mailer) tlsConfig() *tls.Config {
+	if m.TLSConfig != nil {
+		return m.TLSConfig.Clone()
+	}
+	return &tls.Config{ServerName: m.Host}
+}
+
+// encode renders msg as a MIME message, multipart/alternative when it has an HTML body
+func (m *Mailer) encode(msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+
+	id := make([]byte, 12)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, fmt.Errorf("error generating message ID: %w", err)
+	}
+	domain := m.From[strings.LastIndex(m.From, "@")+1:]
+
+	header("From", m.From)
+	header("To", strings.Join(msg.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+hex.EncodeToString(id)+"@"+domain+">")
+	header("MIME-Version", "1.0")
+
+	if msg.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		err = writeQuotedPrintable(&buf, msg.Text)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating message part: %w", err)
+		}
+		err = writeQuotedPrintable(w, part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = parts.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error closing message: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	_, err := qp.Write([]byte(content))
+	if err != nil {
+		return fmt.Errorf("error encoding message body: %w", err)
+	}
+	return qp.Close()
+}
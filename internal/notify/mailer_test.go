@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"github.com/Shaughny/obkio-test/internal/notify/notifytest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMailerSend(t *testing.T) {
+	msg := Message{
+		To:      []string{"oncall@example.com", "noc@example.com"},
+		Subject: "Latency alert é",
+		Text:    "Latency is high.\n",
+		HTML:    "<p>Latency is <b>high</b>.</p>",
+	}
+
+	for _, tc := range []struct {
+		name      string
+		security  string
+		newServer func() *notifytest.Server
+	}{
+		{"Plain", SecurityNone, notifytest.NewServer},
+		{"STARTTLS", SecurityStartTLS, notifytest.NewServer},
+		{"Implicit TLS", SecurityTLS, notifytest.NewTLSServer},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := tc.newServer()
+			defer server.Close()
+			server.RequireAuth("alerts", "secret")
+
+			mailer := &Mailer{
+				Host:      server.Host,
+				Port:      server.Port,
+				Username:  "alerts",
+				Password:  "secret",
+				From:      "monitor@example.com",
+				Security:  tc.security,
+				TLSConfig: server.ClientTLSConfig(),
+			}
+			assert.NoError(t, mailer.Send(msg))
+
+			mails := server.Mails()
+			if !assert.Len(t, mails, 1) {
+				return
+			}
+			assert.Equal(t, "monitor@example.com", mails[0].From)
+			assert.Equal(t, msg.To, mails[0].To)
+			assert.Equal(t, "alerts", mails[0].Username)
+			assert.Equal(t, tc.security != SecurityNone, mails[0].TLS)
+			assert.Contains(t, mails[0].Data, "Subject: =?utf-8?q?Latency_alert_=C3=A9?=")
+			assert.Contains(t, mails[0].Data, "multipart/alternative")
+			assert.Contains(t, mails[0].Data, "Latency is high.")
+			assert.Contains(t, mails[0].Data, "<b>high</b>")
+		})
+	}
+
+	t.Run("Untrusted certificate", func(t *testing.T) {
+		server := notifytest.NewServer()
+		defer server.Close()
+
+		mailer := &Mailer{Host: server.Host, Port: server.Port, From: "monitor@example.com", Security: SecurityStartTLS}
+		assert.Error(t, mailer.Send(msg))
+		assert.Empty(t, server.Mails())
+	})
+
+	t.Run("Wrong credentials", func(t *testing.T) {
+		server := notifytest.NewServer()
+		defer server.Close()
+		server.RequireAuth("alerts", "secret")
+
+		mailer := &Mailer{
+			Host: server.Host, Port: server.Port, Username: "alerts", Password: "wrong",
+			From: "monitor@example.com", Security: SecurityStartTLS, TLSConfig: server.ClientTLSConfig(),
+		}
+		assert.ErrorContains(t, mailer.Send(msg), "authenticating")
+	})
+
+	t.Run("Rejected message", func(t *testing.T) {
+		server := notifytest.NewServer()
+		defer server.Close()
+		server.Fail("554 mailbox unavailable")
+
+		mailer := &Mailer{Host: server.Host, Port: server.Port, From: "monitor@example.com", Security: SecurityNone}
+		assert.ErrorContains(t, mailer.Send(msg), "mailbox unavailable")
+	})
+
+	t.Run("Text only", func(t *testing.T) {
+		server := notifytest.NewServer()
+		defer server.Close()
+
+		mailer := &Mailer{Host: server.Host, Port: server.Port, From: "monitor@example.com", Security: SecurityNone}
+		assert.NoError(t, mailer.Send(Message{To: []string{"oncall@example.com"}, Subject: "Hi", Text: "Plain body"}))
+
+		mails := server.Mails()
+		if !assert.Len(t, mails, 1) {
+			return
+		}
+		assert.Contains(t, mails[0].Data, "Content-Type: text/plain; charset=utf-8")
+		assert.Contains(t, mails[0].Data, "Plain body")
+		assert.NotContains(t, mails[0].Data, "multipart")
+	})
+}
+
+func TestTemplates(t *testing.T) {
+	templates, err := ParseTemplates(
+		"[{{.State}}]\n{{.Name}}",
+		"{{.Name}} is {{.State}}",
+		"<p>{{.Name}} is {{.State}}</p>",
+	)
+	assert.NoError(t, err)
+
+	msg, err := templates.Render(map[string]string{"Name": "<latency>", "State": "firing"})
+	assert.NoError(t, err)
+	assert.Equal(t, "[firing] <latency>", msg.Subject)
+	assert.Equal(t, "<latency> is firing", msg.Text)
+	assert.Equal(t, "<p>&lt;latency&gt; is firing</p>", msg.HTML)
+
+	t.Run("Invalid template", func(t *testing.T) {
+		_, err := ParseTemplates("{{.Name", "", "")
+		assert.ErrorContains(t, err, "subject")
+	})
+
+	t.Run("Load from directory", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, SubjectFile), []byte("{{.Name}}"), 0o644))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, TextBodyFile), []byte("Body of {{.Name}}"), 0o644))
+
+		templates, err := LoadTemplates(dir)
+		assert.NoError(t, err)
+		msg, err := templates.Render(map[string]string{"Name": "rule"})
+		assert.NoError(t, err)
+		assert.Equal(t, Message{Subject: "rule", Text: "Body of rule"}, msg)
+
+		_, err = LoadTemplates(t.TempDir())
+		assert.Error(t, err)
+	})
+}
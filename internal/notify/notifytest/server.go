@@ -0,0 +1,291 @@
+// Package notifytest provides an in-process SMTP server for testing code that sends email.
+package notifytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mail is a message accepted by the server.
+type Mail struct {
+	From     string
+	To       []string
+	Data     string // Headers and body as received, with dot-stuffing removed
+	Username string // Set when the client authenticated
+	TLS      bool   // Whether the message was sent over TLS
+}
+
+// Server is a minimal SMTP server listening on the loopback interface. It speaks
+// enough of the protocol for net/smtp: EHLO, STARTTLS, AUTH PLAIN, MAIL, RCPT and DATA.
+type Server struct {
+	Host string
+	Port int
+
+	listener net.Listener
+	tls      *tls.Config
+	roots    *x509.CertPool
+	implicit bool // TLS from the first byte instead of STARTTLS
+
+	mu       sync.Mutex
+	mails    []Mail
+	failure  string
+	username string
+	password string
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server that accepts plain connections and offers STARTTLS.
+func NewServer() *Server {
+	return start(false)
+}
+
+// NewTLSServer starts a server that only accepts TLS connections.
+func NewTLSServer() *Server {
+	return start(true)
+}
+
+func start(implicit bool) *Server {
+	config, roots, err := selfSigned()
+	if err != nil {
+		panic(fmt.Sprintf("notifytest: generating certificate: %v", err))
+	}
+
+	var listener net.Listener
+	if implicit {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", config)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		panic(fmt.Sprintf("notifytest: listening: %v", err))
+	}
+
+	addr := listener.Addr().(*net.TCPAddr)
+	s := &Server{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		listener: listener,
+		tls:      config,
+		roots:    roots,
+		implicit: implicit,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// ClientTLSConfig returns a TLS configuration trusting the server's certificate.
+func (s *Server) ClientTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: s.roots, ServerName: s.Host}
+}
+
+// RequireAuth makes the server reject messages unless the client logs in with the credentials.
+func (s *Server) RequireAuth(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username, s.password = username, password
+}
+
+// Fail makes the server reject every message with the given reply, such as
+// "554 mailbox unavailable". An empty reply accepts messages again.
+func (s *Server) Fail(reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failure = reply
+}
+
+// Mails returns the messages accepted so far.
+func (s *Server) Mails() []Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Mail(nil), s.mails...)
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle runs one SMTP session
+func (s *Server) handle(conn net.Conn) {
+	tp := textproto.NewConn(conn)
+	secure := s.implicit
+	var mail Mail
+	var username string
+
+	tp.PrintfLine("220 notifytest ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if secure {
+				tp.PrintfLine("250-notifytest\r\n250 AUTH PLAIN")
+			} else {
+				tp.PrintfLine("250-notifytest\r\n250-AUTH PLAIN\r\n250 STARTTLS")
+			}
+		case "STARTTLS":
+			if secure {
+				tp.PrintfLine("503 already using TLS")
+				continue
+			}
+			tp.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			err = tlsConn.Handshake()
+			if err != nil {
+				return
+			}
+			conn, tp, secure = tlsConn, textproto.NewConn(tlsConn), true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			if !strings.EqualFold(mechanism, "PLAIN") {
+				tp.PrintfLine("504 unsupported mechanism")
+				continue
+			}
+			credentials, _ := base64.StdEncoding.DecodeString(initial)
+			fields := strings.Split(string(credentials), "\x00")
+			if len(fields) != 3 || !s.validLogin(fields[1], fields[2]) {
+				tp.PrintfLine("535 authentication failed")
+				continue
+			}
+			username = fields[1]
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			if !s.authorized(username) {
+				tp.PrintfLine("530 authentication required")
+				continue
+			}
+			mail = Mail{From: address(arg), Username: username, TLS: secure}
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			mail.To = append(mail.To, address(arg))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			if len(mail.To) == 0 {
+				tp.PrintfLine("503 no recipients")
+				continue
+			}
+			tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.Data = string(data)
+			tp.PrintfLine(s.accept(mail))
+			mail = Mail{}
+		case "RSET":
+			mail = Mail{}
+			tp.PrintfLine("250 ok")
+		case "NOOP":
+			tp.PrintfLine("250 ok")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+func (s *Server) validLogin(username, password string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.username == "" || (username == s.username && password == s.password)
+}
+
+func (s *Server) authorized(username string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.username == "" || username == s.username
+}
+
+// accept stores a message unless the server is set to fail, and returns the reply
+func (s *Server) accept(mail Mail) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failure != "" {
+		return s.failure
+	}
+	s.mails = append(s.mails, mail)
+	return "250 ok: queued as " + strconv.Itoa(len(s.mails))
+}
+
+// address extracts the address from a "FROM:<a@b>" or "TO:<a@b>" argument
+func address(arg string) string {
+	start := strings.Index(arg, "<")
+	end := strings.Index(arg, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return arg[start+1 : end]
+}
+
+// selfSigned generates a certificate for the loopback address and a pool trusting it
+func selfSigned() (*tls.Config, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "notifytest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}},
+	}
+	return config, roots, nil
+}
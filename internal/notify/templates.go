@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Template files read by LoadTemplates. The HTML body is optional.
+const (
+	SubjectFile  = "subject.tmpl"
+	TextBodyFile = "body.txt.tmpl"
+	HTMLBodyFile = "body.html.tmpl"
+)
+
+// Templates render the subject and bodies of a message from the same data. The HTML
+// body goes through html/template so values are escaped.
+type Templates struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template // nil sends text-only messages
+}
+
+// ParseTemplates parses the subject, text body and HTML body templates. An empty HTML
+// template sends text-only messages.
+func ParseTemplates(subject, text, html string) (*Templates, error) {
+	var t Templates
+	var err error
+	t.subject, err = texttemplate.New("subject").Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing subject template: %w", err)
+	}
+	t.text, err = texttemplate.New("text").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing text body template: %w", err)
+	}
+	if html != "" {
+		t.html, err = htmltemplate.New("html").Parse(html)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing HTML body template: %w", err)
+		}
+	}
+	return &t, nil
+}
+
+// LoadTemplates parses the template files of a directory.
+func LoadTemplates(dir string) (*Templates, error) {
+	read := func(name string, optional bool) (string, error) {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading template: %w", err)
+		}
+		return string(content), nil
+	}
+
+	subject, err := read(SubjectFile, false)
+	if err != nil {
+		return nil, err
+	}
+	text, err := read(TextBodyFile, false)
+	if err != nil {
+		return nil, err
+	}
+	html, err := read(HTMLBodyFile, true)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplates(subject, text, html)
+}
+
+// Render executes the templates with data. The subject is folded onto a single line.
+func (t *Templates) Render(data any) (Message, error) {
+	var msg Message
+	var buf bytes.Buffer
+
+	err := t.subject.Execute(&buf, data)
+	if err != nil {
+		return msg, fmt.Errorf("error rendering subject: %w", err)
+	}
+	msg.Subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	err = t.text.Execute(&buf, data)
+	if err != nil {
+		return msg, fmt.Errorf("error rendering text body: %w", err)
+	}
+	msg.Text = buf.String()
+
+	if t.html != nil {
+		buf.Reset()
+		err = t.html.Execute(&buf, data)
+		if err != nil {
+			return msg, fmt.Errorf("error rendering HTML body: %w", err)
+		}
+		msg.HTML = buf.String()
+	}
+	return msg, nil
+}
@@ -0,0 +1,169 @@
+// Package prefix resolves addresses to the BGP prefix announcing them and its origin AS,
+// using a prefix-to-AS table such as the CAIDA Routeviews pfx2as datasets or a dump
+// derived from an MRT RIB.
+package prefix
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route is an announced prefix and the AS originating it.
+type Route struct {
+	Prefix netip.Prefix
+	// Origin is the origin AS number. Prefixes announced by several ASes (MOAS) or
+	// originated by an AS set are attributed to the first one listed.
+	Origin uint32
+}
+
+// OriginASN returns the origin AS in the AS15169 form.
+func (r Route) OriginASN() string {
+	return "AS" + strconv.FormatUint(uint64(r.Origin), 10)
+}
+
+// Table finds the most specific announced prefix containing an address.
+// It is safe for concurrent lookups once loaded.
+type Table struct {
+	routes map[netip.Prefix]uint32
+	// Prefix lengths present in the table, longest first
+	v4Lengths []int
+	v6Lengths []int
+	hash      string
+	loadedAt  time.Time
+}
+
+// Load reads a prefix table from a file. See Parse for the format.
+func Load(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening prefix table: %w", err)
+	}
+	defer f.Close()
+
+	table, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading prefix table %s: %w", path, err)
+	}
+	return table, nil
+}
+
+// Parse reads a prefix table with one route per line, either in the pfx2as form of an
+// address, a prefix length and an origin separated by tabs or spaces:
+//
+//	8.8.8.0	24	15169
+//
+// or with the prefix in CIDR notation:
+//
+//	2001:4860::/32 15169
+//
+// Origins may be MOAS lists joined with underscores (15169_36040) or AS sets joined with
+// commas; an AS prefix is allowed. Blank lines and lines starting with # are skipped.
+func Parse(r io.Reader) (*Table, error) {
+	t := &Table{routes: make(map[netip.Prefix]uint32)}
+	digest := sha256.New()
+	scanner := bufio.NewScanner(io.TeeReader(r, digest))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		route, err := parseRoute(strings.Fields(text))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		t.routes[route.Prefix] = route.Origin
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	t.hash = hex.EncodeToString(digest.Sum(nil))
+	t.loadedAt = time.Now().UTC()
+
+	for p := range t.routes {
+		if p.Addr().Is4() {
+			t.v4Lengths = append(t.v4Lengths, p.Bits())
+		} else {
+			t.v6Lengths = append(t.v6Lengths, p.Bits())
+		}
+	}
+	for _, lengths := range []*[]int{&t.v4Lengths, &t.v6Lengths} {
+		slices.Sort(*lengths)
+		*lengths = slices.Compact(*lengths)
+		slices.Reverse(*lengths)
+	}
+	return t, nil
+}
+
+// Len returns the number of prefixes in the table.
+func (t *Table) Len() int {
+	return len(t.routes)
+}
+
+// Hash returns the hex encoded SHA-256 of the table as read, identifying the dataset
+// lookups were answered from.
+func (t *Table) Hash() string {
+	return t.hash
+}
+
+// LoadedAt returns when the table was read.
+func (t *Table) LoadedAt() time.Time {
+	return t.loadedAt
+}
+
+// Lookup returns the most specific prefix containing addr, reporting false when the
+// address is not announced.
+func (t *Table) Lookup(addr netip.Addr) (Route, bool) {
+	addr = addr.Unmap()
+	lengths := t.v6Lengths
+	if addr.Is4() {
+		lengths = t.v4Lengths
+	}
+	for _, bits := range lengths {
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if origin, ok := t.routes[p]; ok {
+			return Route{Prefix: p, Origin: origin}, true
+		}
+	}
+	return Route{}, false
+}
+
+func parseRoute(fields []string) (Route, error) {
+	var cidr, origin string
+	switch len(fields) {
+	case 2:
+		cidr, origin = fields[0], fields[1]
+	case 3:
+		cidr, origin = fields[0]+"/"+fields[1], fields[2]
+	default:
+		return Route{}, fmt.Errorf("expected a prefix and an origin AS, got %d fields", len(fields))
+	}
+
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return Route{}, fmt.Errorf("invalid prefix %q", cidr)
+	}
+	p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()).Masked()
+
+	first := strings.FieldsFunc(origin, func(r rune) bool { return r == '_' || r == ',' || r == '{' || r == '}' })
+	if len(first) == 0 {
+		return Route{}, fmt.Errorf("invalid origin AS %q", origin)
+	}
+	asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(first[0]), "AS"), 10, 32)
+	if err != nil {
+		return Route{}, fmt.Errorf("invalid origin AS %q", origin)
+	}
+	return Route{Prefix: p, Origin: uint32(asn)}, nil
+}
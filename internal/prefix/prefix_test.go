@@ -0,0 +1,80 @@
+package prefix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTable = `# pfx2as and CIDR lines can be mixed
+8.0.0.0	9	3356
+8.8.8.0	24	15169
+8.8.4.0 24 15169_36040
+1.1.1.0/24 AS13335
+2001:4860::/32 15169
+2001:4860:4860::/48 {15169,36040}
+`
+
+func TestLookup(t *testing.T) {
+	table, err := Parse(strings.NewReader(testTable))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, table.Len())
+
+	for _, tc := range []struct {
+		addr   string
+		prefix string
+		origin string
+	}{
+		{"8.8.8.8", "8.8.8.0/24", "AS15169"},
+		{"8.8.9.1", "8.0.0.0/9", "AS3356"},   // Only the covering prefix
+		{"8.8.4.4", "8.8.4.0/24", "AS15169"}, // MOAS
+		{"1.1.1.1", "1.1.1.0/24", "AS13335"},
+		{"::ffff:1.1.1.1", "1.1.1.0/24", "AS13335"},
+		{"2001:4860:4860::8888", "2001:4860:4860::/48", "AS15169"},
+		{"2001:4860:1::1", "2001:4860::/32", "AS15169"},
+	} {
+		route, ok := table.Lookup(netip.MustParseAddr(tc.addr))
+		if assert.True(t, ok, tc.addr) {
+			assert.Equal(t, tc.prefix, route.Prefix.String(), tc.addr)
+			assert.Equal(t, tc.origin, route.OriginASN(), tc.addr)
+		}
+	}
+
+	for _, addr := range []string{"10.0.0.1", "9.0.0.1", "2606:4700::1111"} {
+		_, ok := table.Lookup(netip.MustParseAddr(addr))
+		assert.False(t, ok, addr)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{
+		"8.8.8.0/24",
+		"8.8.8.0 33 15169",
+		"8.8.8.0/24 Google",
+		"8.8.8.0/24 15169 extra fields",
+	} {
+		_, err := Parse(strings.NewReader("1.1.1.0/24 13335\n" + line))
+		assert.ErrorContains(t, err, "line 2", line)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routeviews-rv2-pfx2as.txt")
+	assert.NoError(t, os.WriteFile(path, []byte(testTable), 0o600))
+
+	table, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, table.Len())
+	sum := sha256.Sum256([]byte(testTable))
+	assert.Equal(t, hex.EncodeToString(sum[:]), table.Hash())
+	assert.WithinDuration(t, time.Now(), table.LoadedAt(), time.Minute)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
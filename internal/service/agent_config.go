@@ -0,0 +1,313 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Scopes of configuration overrides, from lowest to highest precedence.
+const (
+	ConfigScopeGlobal = "global"
+	ConfigScopeGroup  = "group"
+	ConfigScopeAgent  = "agent"
+)
+
+// AgentSettings holds the tunable part of an agent's configuration.
+// Overrides are partial JSON documents of this shape.
+type AgentSettings struct {
+	ServerURLs []string        `json:"server_urls"`
+	Intervals  map[string]int  `json:"intervals"` // Seconds between recurring agent tasks
+	Features   map[string]bool `json:"features"`
+}
+
+// AgentConfig is the configuration document an agent polls for.
+type AgentConfig struct {
+	AgentID int `json:"agent_id"`
+	Version int `json:"version"`
+	AgentSettings
+	Tests []AssignedSession `json:"tests"`
+
+	// ETag identifies this exact document so agents can poll with If-None-Match
+	ETag string `json:"-"`
+}
+
+// configLayer identifies the override document of one scope
+type configLayer struct {
+	scope   string
+	scopeID int
+}
+
+// ErrConfigOverrideNotFound is returned when no configuration override exists for a scope.
+var ErrConfigOverrideNotFound = errors.New("configuration override not found")
+
+// ErrInvalidConfig is returned when a configuration override does not fit the agent settings.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// defaultAgentSettings are the built-in settings every override is applied on top of.
+func (s *Service) defaultAgentSettings() map[string]any {
+	serverURLs := make([]any, 0, len(s.ServerURLs))
+	for _, url := range s.ServerURLs {
+		serverURLs = append(serverURLs, url)
+	}
+	return map[string]any{
+		"server_urls": serverURLs,
+		"intervals": map[string]any{
+			"config_poll":  300,
+			"heartbeat":    30,
+			"metrics_push": 60,
+		},
+		"features": map[string]any{
+			"throughput_tests": false,
+			"traceroute":       false,
+		},
+	}
+}
+
+// GetAgentConfig composes an agent's configuration from the built-in defaults, the global
+// override, the overrides of its groups (in group ID order) and its own override, together
+// with the monitoring sessions assigned to it. Its version is bumped by every write that
+// changes the document, reading it changes nothing.
+func (s *Service) GetAgentConfig(agentID int) (AgentConfig, error) {
+	config := AgentConfig{AgentID: agentID}
+
+	tests, err := s.GetAgentSessions(agentID)
+	if err != nil {
+		return config, err
+	}
+	config.Tests = tests
+
+	settings := s.defaultAgentSettings()
+	layers := []configLayer{{ConfigScopeGlobal, 0}}
+	groupIDs, err := queryIDs(s.DB, "SELECT group_id FROM agent_group_members WHERE agent_id = $1 ORDER BY group_id", agentID)
+	if err != nil {
+		return config, err
+	}
+	for _, groupID := range groupIDs {
+		layers = append(layers, configLayer{ConfigScopeGroup, groupID})
+	}
+	layers = append(layers, configLayer{ConfigScopeAgent, agentID})
+
+	for _, layer := range layers {
+		override, err := s.GetConfigOverride(layer.scope, layer.scopeID)
+		if errors.Is(err, ErrConfigOverrideNotFound) {
+			continue
+		}
+		if err != nil {
+			return config, err
+		}
+		settings = mergeSettings(settings, override)
+	}
+
+	config.AgentSettings, err = decodeSettings(settings)
+	if err != nil {
+		return config, err
+	}
+
+	// The version is bumped by the writes changing the document, the hash tells apart
+	// documents changed by anything else, such as new defaults
+	content, err := json.Marshal(config)
+	if err != nil {
+		return config, fmt.Errorf("error encoding agent configuration: %w", err)
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	err = s.DB.QueryRow("SELECT version FROM agent_config_versions WHERE agent_id = $1", agentID).Scan(&config.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		config.Version = 1
+	} else if err != nil {
+		return config, fmt.Errorf("error fetching configuration version: %w", err)
+	}
+
+	config.ETag = fmt.Sprintf(`"%d-%s"`, config.Version, hash[:16])
+	return config, nil
+}
+
+// GetConfigOverride retrieves the override document stored for a scope.
+// The global scope always uses scope ID 0.
+func (s *Service) GetConfigOverride(scope string, scopeID int) (map[string]any, error) {
+	var document string
+	err := s.DB.QueryRow(
+		"SELECT document FROM config_overrides WHERE scope = $1 AND scope_id = $2", scope, scopeID,
+	).Scan(&document)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrConfigOverrideNotFound
+		}
+		return nil, fmt.Errorf("error fetching configuration override: %w", err)
+	}
+
+	var override map[string]any
+	err = json.Unmarshal([]byte(document), &override)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding configuration override: %w", err)
+	}
+	return override, nil
+}
+
+// SetConfigOverride stores the override document for a scope, replacing any previous one.
+// Keys set to null remove the value inherited from lower scopes.
+func (s *Service) SetConfigOverride(scope string, scopeID int, override map[string]any) error {
+	err := s.checkConfigScope(scope, scopeID)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the override produces valid settings on its own
+	_, err = decodeSettings(mergeSettings(s.defaultAgentSettings(), override))
+	if err != nil {
+		return err
+	}
+
+	document, err := json.Marshal(override)
+	if err != nil {
+		return fmt.Errorf("error encoding configuration override: %w", err)
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO config_overrides (scope, scope_id, document) VALUES ($1, $2, $3)
+	ON CONFLICT(scope, scope_id) DO UPDATE SET document = EXCLUDED.document, updated_at = CURRENT_TIMESTAMP`,
+		scope, scopeID, string(document),
+	)
+	if err != nil {
+		return fmt.Errorf("error storing configuration override: %w", err)
+	}
+	err = bumpScopeConfigVersions(tx, scope, scopeID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing configuration override: %w", err)
+	}
+	return nil
+}
+
+// DeleteConfigOverride removes the override document of a scope.
+func (s *Service) DeleteConfigOverride(scope string, scopeID int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM config_overrides WHERE scope = $1 AND scope_id = $2", scope, scopeID)
+	if err != nil {
+		return fmt.Errorf("error deleting configuration override: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting configuration override: %w", err)
+	}
+	if deleted == 0 {
+		return ErrConfigOverrideNotFound
+	}
+	err = bumpScopeConfigVersions(tx, scope, scopeID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing configuration override deletion: %w", err)
+	}
+	return nil
+}
+
+// checkConfigScope makes sure the agent or group an override targets exists
+func (s *Service) checkConfigScope(scope string, scopeID int) error {
+	switch scope {
+	case ConfigScopeGlobal:
+		return nil
+	case ConfigScopeAgent:
+		return s.agentExists(scopeID)
+	case ConfigScopeGroup:
+		return s.groupExists(scopeID)
+	}
+	return fmt.Errorf("%w: unknown scope %q", ErrInvalidConfig, scope)
+}
+
+// bumpScopeConfigVersions bumps the configuration version of the agents an override scope applies to
+func bumpScopeConfigVersions(tx dbtx, scope string, scopeID int) error {
+	switch scope {
+	case ConfigScopeGlobal:
+		return bumpConfigVersions(tx, "SELECT id FROM agents")
+	case ConfigScopeGroup:
+		return bumpConfigVersions(tx, "SELECT agent_id FROM agent_group_members WHERE group_id = $1", scopeID)
+	}
+	return bumpConfigVersions(tx, "SELECT $1", scopeID)
+}
+
+// bumpConfigVersions moves the agents whose IDs query returns to a new configuration
+// version. Agents without a stored version are still at their first one.
+func bumpConfigVersions(tx dbtx, query string, args ...any) error {
+	_, err := tx.Exec(`
+	WITH bumped(agent_id) AS (`+query+`)
+	INSERT INTO agent_config_versions (agent_id, version)
+	SELECT agent_id, 2 FROM bumped WHERE true
+	ON CONFLICT(agent_id) DO UPDATE SET version = version + 1, updated_at = CURRENT_TIMESTAMP`, args...)
+	if err != nil {
+		return fmt.Errorf("error bumping configuration versions: %w", err)
+	}
+	return nil
+}
+
+// mergeSettings applies override on top of base like a JSON merge patch (RFC 7396):
+// objects are merged recursively, null removes a key and any other value replaces it.
+func mergeSettings(base, override map[string]any) map[string]any {
+	merged := make(map[string]any, len(base))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		overrideMap, isMap := value.(map[string]any)
+		baseMap, baseIsMap := merged[key].(map[string]any)
+		if isMap && baseIsMap {
+			merged[key] = mergeSettings(baseMap, overrideMap)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// decodeSettings converts a merged settings document into AgentSettings, rejecting unknown keys and wrong types
+func decodeSettings(document map[string]any) (AgentSettings, error) {
+	var settings AgentSettings
+
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return settings, fmt.Errorf("error encoding settings: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&settings)
+	if err != nil {
+		return settings, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	if settings.ServerURLs == nil {
+		settings.ServerURLs = []string{}
+	}
+	for name, seconds := range settings.Intervals {
+		if seconds <= 0 {
+			return settings, fmt.Errorf("%w: interval %q must be positive", ErrInvalidConfig, name)
+		}
+	}
+	return settings, nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAgentConfig(t *testing.T) {
+	svc := &Service{DB: db, ServerURLs: []string{"https://collector.example.com"}}
+	agentID := insertTestAgent(t, "10.0.4.1")
+
+	group, err := svc.CreateGroup(AgentGroupRequest{Name: "config", Topology: TopologyFullMesh, Protocol: "udp", IntervalSeconds: 10, PacketSize: 64})
+	assert.NoError(t, err)
+	_, err = svc.AddGroupMember(group.ID, agentID)
+	assert.NoError(t, err)
+
+	t.Run("Starts from the defaults", func(t *testing.T) {
+		config, err := svc.GetAgentConfig(agentID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, config.Version) // Joining the group bumped it
+		assert.Equal(t, []string{"https://collector.example.com"}, config.ServerURLs)
+		assert.Equal(t, 60, config.Intervals["metrics_push"])
+		assert.NotEmpty(t, config.ETag)
+	})
+
+	t.Run("Reading does not change the version", func(t *testing.T) {
+		first, err := svc.GetAgentConfig(agentID)
+		assert.NoError(t, err)
+		second, err := svc.GetAgentConfig(agentID)
+		assert.NoError(t, err)
+		assert.Equal(t, first.Version, second.Version)
+		assert.Equal(t, first.ETag, second.ETag)
+	})
+
+	t.Run("Writes bump the version of the agents they apply to", func(t *testing.T) {
+		peerID := insertTestAgent(t, "10.0.4.2")
+		defer svc.purgeAgent(peerID)
+
+		before, err := svc.GetAgentConfig(agentID)
+		assert.NoError(t, err)
+		session, err := svc.CreateSession(MonitoringSessionRequest{
+			SourceAgentID: agentID, DestinationAgentID: peerID, Protocol: "udp", IntervalSeconds: 10, PacketSize: 64,
+		})
+		assert.NoError(t, err)
+		created, err := svc.GetAgentConfig(agentID)
+		assert.NoError(t, err)
+		assert.Equal(t, before.Version+1, created.Version)
+		assert.NotEqual(t, before.ETag, created.ETag)
+
+		err = svc.DeleteSession(session.ID)
+		assert.NoError(t, err)
+		deleted, err := svc.GetAgentConfig(agentID)
+		assert.NoError(t, err)
+		assert.Equal(t, created.Version+1, deleted.Version)
+		peer, err := svc.GetAgentConfig(peerID)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, peer.Version)
+	})
+
+	t.Run("Agent overrides win over group and global overrides", func(t *testing.T) {
+		before, err := svc.GetAgentConfig(agentID)
+		assert.NoError(t, err)
+		err = svc.SetConfigOverride(ConfigScopeGlobal, 0, map[string]any{"intervals": map[string]any{"metrics_push": 30, "heartbeat": 20}})
+		assert.NoError(t, err)
+		defer svc.DeleteConfigOverride(ConfigScopeGlobal, 0)
+		err = svc.SetConfigOverride(ConfigScopeGroup, group.ID, map[string]any{"intervals": map[string]any{"metrics_push": 15}, "features": map[string]any{"traceroute": true}})
+		assert.NoError(t, err)
+		err = svc.SetConfigOverride(ConfigScopeAgent, agentID, map[string]any{"features": map[string]any{"traceroute": false}})
+		assert.NoError(t, err)
+
+		config, err := svc.GetAgentConfig(agentID)
+		assert.NoError(t, err)
+		assert.Equal(t, before.Version+3, config.Version)
+		assert.Equal(t, 15, config.Intervals["metrics_push"])
+		assert.Equal(t, 20, config.Intervals["heartbeat"])
+		assert.Equal(t, 300, config.Intervals["config_poll"])
+		assert.False(t, config.Features["traceroute"])
+	})
+
+	t.Run("Rejects invalid overrides", func(t *testing.T) {
+		err := svc.SetConfigOverride(ConfigScopeAgent, agentID, map[string]any{"unknown": 1})
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+		err = svc.SetConfigOverride(ConfigScopeAgent, agentID, map[string]any{"intervals": map[string]any{"heartbeat": 0}})
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+		err = svc.SetConfigOverride(ConfigScopeGroup, 999999, map[string]any{})
+		assert.ErrorIs(t, err, ErrGroupNotFound)
+	})
+
+	t.Run("Deleting a missing override", func(t *testing.T) {
+		err := svc.DeleteConfigOverride(ConfigScopeGroup, 999999)
+		assert.ErrorIs(t, err, ErrConfigOverrideNotFound)
+	})
+}
@@ -5,71 +5,299 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"github.com/Shaughny/obkio-test/internal/labels"
 	"io"
 	"net/http"
+	"net/netip"
+	"reflect"
 	"strings"
+	"time"
 )
 
 // Agent represents a minimal agent model with just ID and IP address.
 type Agent struct {
-	ID        int    `json:"id"`
-	IPAddress string `json:"ip_address"`
+	ID         int      `json:"id"`
+	IPAddress  string   `json:"ip_address"`
+	DistanceKm *float64 `json:"distance_km,omitempty"` // Great-circle distance, in distance queries
 }
 
 // AgentRequest defines the structure for incoming agent registration requests.
 type AgentRequest struct {
-	IPAddress string `json:"ip_address" validate:"required,ip"` // Ensures IP format validation
+	IPAddress string            `json:"ip_address" validate:"required,ip"` // Ensures IP format validation
+	Labels    map[string]string `json:"labels"`                            // Set on the agent, keeping its other labels
 }
 
-// DetailedAgentResponse provides full details about an agent, including ASN and ISP.
+// DetailedAgentResponse provides full details about an agent, including ASN, ISP and location.
 type DetailedAgentResponse struct {
 	ID        int    `json:"id"`
 	IPAddress string `json:"ip_address"`
 	ASN       string `json:"asn"`
 	ISP       string `json:"isp"`
+	Geolocation
+	NetworkFlags
+	AnnouncedPrefix string            `json:"announced_prefix,omitempty"` // Most specific BGP prefix containing the IP address
+	OriginASN       string            `json:"origin_asn,omitempty"`       // AS originating the announced prefix
+	PTR             string            `json:"ptr,omitempty"`              // Reverse DNS name of the IP address
+	RDAP            *enrich.Network   `json:"rdap,omitempty"`             // Registration of the network, once looked up
+	LastSeenAt      *time.Time        `json:"last_seen_at,omitempty"`     // Last heartbeat or metric batch
+	Overrides       []AgentOverride   `json:"overrides,omitempty"`        // Details set by hand, locked against lookups
+	Provenance      []FieldProvenance `json:"provenance,omitempty"`       // Where each detail comes from, when asked for
+	Labels          map[string]string `json:"labels,omitempty"`
+	SiteID          *int              `json:"site_id,omitempty"` // Site the agent lives in
+	State           string            `json:"state,omitempty"`   // Lifecycle state
+}
+
+// NetworkFlags tell what kind of network the IP address of an agent belongs to, as
+// reported by ip-api. An office agent on a proxy or mobile network is likely egressing
+// through a VPN or a cellular backup link.
+type NetworkFlags struct {
+	Hosting bool `json:"hosting"` // Hosting provider or data center
+	Proxy   bool `json:"proxy"`   // Proxy, VPN or Tor exit
+	Mobile  bool `json:"mobile"`  // Cellular network
+}
+
+// Geolocation is where the IP address of an agent is located, as reported by ip-api.
+type Geolocation struct {
+	Country     string   `json:"country,omitempty"`
+	CountryCode string   `json:"country_code,omitempty"` // ISO 3166-1 alpha-2, such as CA
+	Region      string   `json:"region,omitempty"`       // Region or state code, such as QC
+	RegionName  string   `json:"region_name,omitempty"`
+	City        string   `json:"city,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+	Timezone    string   `json:"timezone,omitempty"` // IANA timezone, such as America/Toronto
 }
 
 // DetailedAgentRequest is used internally when fetching IP details from an external API.
 type DetailedAgentRequest struct {
-	IPAddress string `json:"ip_address" validate:"required"`
-	ASN       string `json:"as" validate:"required"`
-	ISP       string `json:"isp" validate:"required"`
+	IPAddress   string   `json:"ip_address" validate:"required"`
+	ASN         string   `json:"as" validate:"required"`
+	ISP         string   `json:"isp" validate:"required"`
+	Country     string   `json:"country"`
+	CountryCode string   `json:"countryCode"`
+	Region      string   `json:"region"`
+	RegionName  string   `json:"regionName"`
+	City        string   `json:"city"`
+	Lat         *float64 `json:"lat"`
+	Lon         *float64 `json:"lon"`
+	Timezone    string   `json:"timezone"`
+	NetworkFlags
+	ASName string        `json:"-"` // Organization announcing the address, split off the AS
+	Source enrich.Source `json:"-"` // ip-api response the details were read from
+}
+
+// AgentFilter narrows down the agents listed. Countries and regions match either
+// their code or their name, ignoring case. With Near, only the agents within RadiusKm
+// of the point are listed, nearest first.
+type AgentFilter struct {
+	Country  string  `query:"country" validate:"omitempty,max=100"`
+	Region   string  `query:"region" validate:"omitempty,max=100"`
+	Prefix   string  `query:"prefix" validate:"omitempty,cidr"`       // Announced prefix, such as 8.8.8.0/24
+	Selector string  `query:"selector" validate:"omitempty,max=1000"` // Label selector, such as env=prod,site in (mtl,tor)
+	Near     string  `query:"near" validate:"omitempty,max=64"`       // Latitude and longitude, such as 45.50,-73.57
+	RadiusKm float64 `query:"radius_km" validate:"required_with=Near,omitempty,gt=0,lte=20038"`
+	Hosting  *bool   `query:"hosting"`
+	Proxy    *bool   `query:"proxy"`
+	Mobile   *bool   `query:"mobile"`
+
+	// State lists the agents in a lifecycle state. Decommissioned and archived agents
+	// are left out unless asked for.
+	State string `query:"state" validate:"omitempty,oneof=pending active paused decommissioned archived"`
+
+	// Deleted lists the deleted agents that can still be restored instead of the others
+	Deleted bool `query:"deleted"`
 }
 
 // ErrAgentNotFound is returned when an agent is not found in the database.
 var ErrAgentNotFound = errors.New("agent not found")
 
-// AddAgent inserts an agent into the database or updates its details if it already exists.
-func (s *Service) AddAgent(ipAddress string) error {
+// defaultIPInfoURL is the ip-api.com endpoint agents are looked up with unless IPInfoURL is set.
+const defaultIPInfoURL = "http://ip-api.com/json/"
+
+// ipInfoFields are the fields asked of ip-api; the network flags are not returned by default.
+const ipInfoFields = "status,message,country,countryCode,region,regionName,city,lat,lon,timezone,isp,as,mobile,proxy,hosting"
+
+// AddAgent inserts an agent into the database or updates its details if it already exists,
+// setting the given labels. Deleted agents have to be restored before registering again.
+func (s *Service) AddAgent(ipAddress string, agentLabels map[string]string) error {
+	err := labels.Validate(agentLabels)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidLabels, err)
+	}
+
 	// Fetch IP details from an external API
-	agent, err := getIPInformation(ipAddress)
+	agent, err := s.getIPInformation(ipAddress)
 	if err != nil {
 		return fmt.Errorf("error getting IP information: %w", err)
 	}
+	enriched := s.enrichAgent(ipAddress, agent.ASN)
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Look up the current details to tell registrations from updates
+	var previous DetailedAgentResponse
+	var deleted bool
+	err = tx.QueryRow(`
+	SELECT id, asn, isp, hosting, proxy, mobile, deleted_at IS NOT NULL FROM agents WHERE ip_address = $1`, ipAddress).Scan(
+		&previous.ID, &previous.ASN, &previous.ISP, &previous.Hosting, &previous.Proxy, &previous.Mobile, &deleted)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error fetching agent: %w", err)
+	}
+	if deleted {
+		return fmt.Errorf("%w: agent %d has to be restored", ErrAgentDeleted, previous.ID)
+	}
+
+	// Details set by hand are locked; the reported ones are kept as provenance
+	reported := agent
+	var stored DetailedAgentResponse
+	if exists {
+		stored, err = storedAgent(tx, previous.ID)
+		if err != nil {
+			return err
+		}
+		err = applyOverrides(tx, previous.ID, &agent)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Add the AS to the catalog for the agent to reference it
+	asnID, err := upsertASN(tx, agent.ASN, agent.ASName, enriched.autnum)
+	if err != nil {
+		return err
+	}
 
 	// SQL query to insert or update the agent record in the database
 	query := `
-	INSERT INTO agents (ip_address, asn, isp, last_updated) 
-	VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+	INSERT INTO agents (ip_address, asn, isp, country, country_code, region, region_name, city, latitude, longitude,
+	                    timezone, hosting, proxy, mobile, ptr, rdap_name, rdap_handle, rdap_abuse_email, asn_id, state, last_updated) 
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, CURRENT_TIMESTAMP)
 	ON CONFLICT(ip_address) DO UPDATE 
 	SET asn = EXCLUDED.asn,
+	    asn_id = EXCLUDED.asn_id,
 	    isp = EXCLUDED.isp,
-	    last_updated = CURRENT_TIMESTAMP;
+	    country = EXCLUDED.country,
+	    country_code = EXCLUDED.country_code,
+	    region = EXCLUDED.region,
+	    region_name = EXCLUDED.region_name,
+	    city = EXCLUDED.city,
+	    latitude = EXCLUDED.latitude,
+	    longitude = EXCLUDED.longitude,
+	    timezone = EXCLUDED.timezone,
+	    hosting = EXCLUDED.hosting,
+	    proxy = EXCLUDED.proxy,
+	    mobile = EXCLUDED.mobile,
+	    ptr = COALESCE(EXCLUDED.ptr, ptr),
+	    rdap_name = COALESCE(EXCLUDED.rdap_name, rdap_name),
+	    rdap_handle = COALESCE(EXCLUDED.rdap_handle, rdap_handle),
+	    rdap_abuse_email = COALESCE(EXCLUDED.rdap_abuse_email, rdap_abuse_email),
+	    last_updated = CURRENT_TIMESTAMP
+	RETURNING id, state;
 	`
 
 	// Execute the query
-	_, err = s.DB.Exec(query, agent.IPAddress, agent.ASN, agent.ISP)
+	rdapName, rdapHandle, rdapAbuseEmail := enriched.rdapColumns()
+	details := DetailedAgentResponse{IPAddress: agent.IPAddress, ASN: agent.ASN, ISP: agent.ISP, Geolocation: Geolocation{
+		Country: agent.Country, CountryCode: agent.CountryCode, Region: agent.Region, RegionName: agent.RegionName,
+		City: agent.City, Latitude: agent.Lat, Longitude: agent.Lon, Timezone: agent.Timezone,
+	}, NetworkFlags: agent.NetworkFlags}
+	err = tx.QueryRow(query, agent.IPAddress, agent.ASN, agent.ISP, agent.Country, agent.CountryCode, agent.Region,
+		agent.RegionName, agent.City, agent.Lat, agent.Lon, agent.Timezone, agent.Hosting, agent.Proxy, agent.Mobile,
+		enriched.ptr, rdapName, rdapHandle, rdapAbuseEmail, asnID, AgentStatePending,
+	).Scan(&details.ID, &details.State)
 	if err != nil {
 		return fmt.Errorf("error inserting agent: %w", err)
 	}
+	if !exists {
+		_, err = recordTransition(tx, details.ID, "", AgentStatePending, "registered")
+		if err != nil {
+			return err
+		}
+	}
+	err = recordAgentProvenance(tx, details.ID, reported, enriched)
+	if err != nil {
+		return err
+	}
+	details.AnnouncedPrefix, details.OriginASN, err = s.resolvePrefix(tx, details.ID, agent.IPAddress)
+	if err != nil {
+		return err
+	}
+	err = setAgentLabels(tx, details.ID, agentLabels)
+	if err != nil {
+		return err
+	}
+	details.Labels, err = queryAgentLabels(tx, details.ID)
+	if err != nil {
+		return err
+	}
+
+	// Let webhook subscribers know, unless registering again changed nothing
+	if !exists {
+		err = publishEvent(tx, EventAgentCreated, details.ID, details)
+		if err != nil {
+			return err
+		}
+	} else {
+		var current DetailedAgentResponse
+		current, err = storedAgent(tx, details.ID)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(stored, current) {
+			err = publishEvent(tx, EventAgentUpdated, details.ID, details)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if exists && (previous.ASN != agent.ASN || previous.ISP != agent.ISP) {
+		err = publishEvent(tx, EventAgentISPChanged, details.ID, ISPChange{
+			AgentID: details.ID, IPAddress: agent.IPAddress,
+			PreviousASN: previous.ASN, PreviousISP: previous.ISP, ASN: agent.ASN, ISP: agent.ISP,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if exists && previous.NetworkFlags != agent.NetworkFlags {
+		err = publishEvent(tx, EventAgentNetworkChanged, details.ID, NetworkChange{
+			AgentID: details.ID, IPAddress: agent.IPAddress, Previous: previous.NetworkFlags, Current: agent.NetworkFlags,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing agent: %w", err)
+	}
 
 	return nil
 }
 
-// GetAgents retrieves a list of all registered agents from the database.
-func (s *Service) GetAgents() ([]Agent, error) {
-	query := "SELECT id, ip_address FROM agents"
-	rows, err := s.DB.Query(query)
+// GetAgents retrieves a list of the registered agents matching the filter from the database.
+func (s *Service) GetAgents(filter AgentFilter) ([]Agent, error) {
+	if filter.Near != "" {
+		lat, lon, err := parseCoordinates(filter.Near)
+		if err != nil {
+			return nil, err
+		}
+		return s.agentsWithin(lat, lon, filter.RadiusKm, filter, 0)
+	}
+
+	where, args, err := filter.where()
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT id, ip_address FROM agents" + where
+	rows, err := s.DB.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error executing query: %w", err)
 	}
@@ -96,12 +324,12 @@ func (s *Service) GetAgents() ([]Agent, error) {
 }
 
 // GetAgent retrieves an agent's detailed information from the database based on the given ID.
+// Deleted agents are not found.
 func (s *Service) GetAgent(ID int) (DetailedAgentResponse, error) {
-	query := "SELECT id, ip_address, asn, isp FROM agents WHERE id = $1"
-	var agent DetailedAgentResponse
+	query := detailedAgentColumns + " WHERE id = $1 AND deleted_at IS NULL"
 
 	// Execute the query and scan the result into the agent struct
-	err := s.DB.QueryRow(query, ID).Scan(&agent.ID, &agent.IPAddress, &agent.ASN, &agent.ISP)
+	agent, err := scanDetailedAgent(s.DB.QueryRow(query, ID))
 	if err != nil {
 		// Return a custom error if no rows were found
 		if errors.Is(err, sql.ErrNoRows) {
@@ -110,16 +338,61 @@ func (s *Service) GetAgent(ID int) (DetailedAgentResponse, error) {
 		return agent, fmt.Errorf("error fetching agent from database: %w", err)
 	}
 
+	agent.Overrides, err = queryAgentOverrides(s.DB, ID)
+	if err != nil {
+		return agent, err
+	}
+	agent.Labels, err = queryAgentLabels(s.DB, ID)
+	if err != nil {
+		return agent, err
+	}
+
 	return agent, nil
 }
 
+// RecordHeartbeat marks an agent as alive without it having to send metrics. A pending
+// agent becomes active.
+func (s *Service) RecordHeartbeat(agentID int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE agents SET last_seen_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL", agentID)
+	if err != nil {
+		return fmt.Errorf("error recording heartbeat: %w", err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error recording heartbeat: %w", err)
+	}
+	if updated == 0 {
+		return ErrAgentNotFound
+	}
+	err = activateAgent(tx, agentID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing heartbeat: %w", err)
+	}
+	return nil
+}
+
 // getIPInformation fetches ASN and ISP details for a given IP address from an external API.
-func getIPInformation(ip string) (DetailedAgentRequest, error) {
+func (s *Service) getIPInformation(ip string) (DetailedAgentRequest, error) {
 	var agent DetailedAgentRequest
-	url := "http://ip-api.com/json/"
+	url := s.IPInfoURL
+	if url == "" {
+		url = defaultIPInfoURL
+	}
 
 	// Call the external API to get IP details
-	resp, err := http.Get(url + ip)
+	resp, err := http.Get(strings.TrimSuffix(url, "/") + "/" + ip + "?fields=" + ipInfoFields)
 	if err != nil {
 		return agent, fmt.Errorf("error fetching IP information: %w", err)
 	}
@@ -136,15 +409,108 @@ func getIPInformation(ip string) (DetailedAgentRequest, error) {
 	if err != nil {
 		return agent, fmt.Errorf("error unmarshalling response body: %w", err)
 	}
+	agent.Source = enrich.NewSource(body)
 
 	// Ensure the API returned valid data
 	if agent.ASN == "" || agent.ISP == "" {
 		return agent, fmt.Errorf("IP API information incomplete for IP: %s", ip)
 	}
 
-	// Set the IP address and normalize ASN field
+	// Set the IP address and split the organization name off the ASN, as in "AS15169 Google LLC"
 	agent.IPAddress = ip
-	agent.ASN = strings.Split(agent.ASN, " ")[0]
+	agent.ASN, agent.ASName, _ = strings.Cut(agent.ASN, " ")
+
+	return agent, nil
+}
+
+// where builds the WHERE clause selecting the agents in the filter's country, region,
+// announced prefix and state with the filter's network flags and labels, deleted or not
+func (f AgentFilter) where() (string, []any, error) {
+	var conditions []string
+	var args []any
+	switch {
+	case f.Deleted:
+		conditions = append(conditions, "deleted_at IS NOT NULL")
+	case f.State == "":
+		conditions = append(conditions, listedAgents)
+	default:
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if f.State != "" {
+		args = append(args, f.State)
+		conditions = append(conditions, fmt.Sprintf("state = $%d", len(args)))
+	}
+	if f.Country != "" {
+		args = append(args, f.Country)
+		conditions = append(conditions, fmt.Sprintf("(country_code = $%d COLLATE NOCASE OR country = $%d COLLATE NOCASE)", len(args), len(args)))
+	}
+	if f.Region != "" {
+		args = append(args, f.Region)
+		conditions = append(conditions, fmt.Sprintf("(region = $%d COLLATE NOCASE OR region_name = $%d COLLATE NOCASE)", len(args), len(args)))
+	}
+	if f.Prefix != "" {
+		prefix := f.Prefix
+		if p, err := netip.ParsePrefix(prefix); err == nil {
+			prefix = p.Masked().String()
+		}
+		args = append(args, prefix)
+		conditions = append(conditions, fmt.Sprintf("announced_prefix = $%d", len(args)))
+	}
+	flags := []struct {
+		column string
+		value  *bool
+	}{{"hosting", f.Hosting}, {"proxy", f.Proxy}, {"mobile", f.Mobile}}
+	for _, flag := range flags {
+		if flag.value != nil {
+			args = append(args, *flag.value)
+			conditions = append(conditions, fmt.Sprintf("%s = $%d", flag.column, len(args)))
+		}
+	}
+	if f.Selector != "" {
+		selector, err := labels.Parse(f.Selector)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidAgentFilter, err)
+		}
+		for _, r := range selector {
+			var condition string
+			condition, args = labelCondition(r, args)
+			conditions = append(conditions, condition)
+		}
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+const detailedAgentColumns = `
+	SELECT id, ip_address, asn, isp, COALESCE(country, ''), COALESCE(country_code, ''), COALESCE(region, ''),
+	       COALESCE(region_name, ''), COALESCE(city, ''), latitude, longitude, COALESCE(timezone, ''),
+	       hosting, proxy, mobile, COALESCE(announced_prefix, ''), COALESCE(origin_asn, ''), COALESCE(ptr, ''),
+	       rdap_name, rdap_handle, rdap_abuse_email, last_seen_at, site_id, state
+	FROM agents`
 
+// storedAgent returns the stored details and labels of an agent, to tell whether a change
+// actually changed anything
+func storedAgent(tx dbtx, id int) (DetailedAgentResponse, error) {
+	agent, err := scanDetailedAgent(tx.QueryRow(detailedAgentColumns+" WHERE id = $1", id))
+	if err != nil {
+		return agent, fmt.Errorf("error fetching agent: %w", err)
+	}
+	agent.Labels, err = queryAgentLabels(tx, id)
+	if err != nil {
+		return agent, err
+	}
 	return agent, nil
 }
+
+func scanDetailedAgent(row rowScanner) (DetailedAgentResponse, error) {
+	var agent DetailedAgentResponse
+	var rdapName, rdapHandle, rdapAbuseEmail sql.NullString
+	err := row.Scan(&agent.ID, &agent.IPAddress, &agent.ASN, &agent.ISP, &agent.Country, &agent.CountryCode,
+		&agent.Region, &agent.RegionName, &agent.City, &agent.Latitude, &agent.Longitude, &agent.Timezone,
+		&agent.Hosting, &agent.Proxy, &agent.Mobile, &agent.AnnouncedPrefix, &agent.OriginASN, &agent.PTR,
+		&rdapName, &rdapHandle, &rdapAbuseEmail, &agent.LastSeenAt, &agent.SiteID, &agent.State)
+	// The registration columns are all set together once the network was looked up
+	if rdapHandle.Valid {
+		agent.RDAP = &enrich.Network{Name: rdapName.String, Handle: rdapHandle.String, AbuseEmail: rdapAbuseEmail.String}
+	}
+	return agent, err
+}
@@ -0,0 +1,804 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// States an alert moves through.
+const (
+	AlertPending  = "pending"  // The condition holds but not yet for the rule's duration
+	AlertFiring   = "firing"   // The condition has held for at least the rule's duration
+	AlertResolved = "resolved" // The condition no longer holds
+)
+
+// Types of alert rules.
+const (
+	AlertTypeThreshold    = "threshold"     // Compares the average of a metric to a threshold
+	AlertTypeAgentOffline = "agent_offline" // Fires when an agent has not reported for the window
+	AlertTypeISPChanged   = "isp_changed"   // Fires when an agent's ISP or ASN changed within the window
+)
+
+// Scopes an alert rule can watch.
+const (
+	AlertScopeAll     = "all"
+	AlertScopeAgent   = "agent"
+	AlertScopeGroup   = "group"
+	AlertScopeSession = "session"
+)
+
+// AlertRule raises an alert for every agent series whose average metric over the
+// window compares to the threshold for at least the duration. Agent offline rules
+// compare the seconds since an agent last reported to the window instead, and ISP
+// change rules count the agent.isp_changed events of an agent within the window.
+type AlertRule struct {
+	ID              int       `json:"id"`
+	Name            string    `json:"name"`
+	Type            string    `json:"type"`
+	Metric          string    `json:"metric,omitempty"`
+	Scope           string    `json:"scope"`
+	ScopeID         int       `json:"scope_id,omitempty"` // Agent, group or session watched by the rule
+	Comparator      string    `json:"comparator"`
+	Threshold       float64   `json:"threshold"`
+	DurationSeconds int       `json:"duration_seconds"`
+	WindowSeconds   int       `json:"window_seconds"`
+	RecoverySeconds int       `json:"recovery_seconds"` // How long a firing condition must stay clear before resolving
+	Enabled         bool      `json:"enabled"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// AlertRuleRequest defines the structure for creating or updating an alert rule.
+// Agent offline and ISP change rules take no metric or comparator. ISP change rules fire
+// once an agent changed ISP threshold times within the window, once by default.
+type AlertRuleRequest struct {
+	Name            string   `json:"name" validate:"required,max=100"`
+	Type            string   `json:"type" validate:"omitempty,oneof=threshold agent_offline isp_changed"` // Defaults to threshold
+	Metric          string   `json:"metric" validate:"required_unless=Type agent_offline Type isp_changed,omitempty,oneof=latency_ms jitter_ms packet_loss throughput_kbps"`
+	Scope           string   `json:"scope" validate:"required,oneof=all agent group session"`
+	ScopeID         int      `json:"scope_id" validate:"required_unless=Scope all,omitempty,gte=1"`
+	Comparator      string   `json:"comparator" validate:"required_unless=Type agent_offline Type isp_changed,omitempty,oneof=gt gte lt lte"`
+	Threshold       *float64 `json:"threshold" validate:"required_unless=Type agent_offline Type isp_changed"`
+	DurationSeconds int      `json:"duration_seconds" validate:"gte=0,lte=86400"`
+	WindowSeconds   int      `json:"window_seconds" validate:"omitempty,gte=10,lte=86400"`  // Defaults to 5 minutes
+	RecoverySeconds *int     `json:"recovery_seconds" validate:"omitempty,gte=0,lte=86400"` // Defaults to 0, or 60 for agent offline rules
+	Enabled         *bool    `json:"enabled"`                                               // Defaults to true
+}
+
+// Alert is raised by a rule for one agent, or one monitoring session of an agent.
+type Alert struct {
+	ID         int        `json:"id"`
+	RuleID     int        `json:"rule_id"`
+	RuleName   string     `json:"rule_name"`
+	AgentID    int        `json:"agent_id"`
+	SessionID  int        `json:"session_id,omitempty"`
+	State      string     `json:"state"`
+	Value      *float64   `json:"value"` // Last evaluated value of the rule's metric
+	StartedAt  time.Time  `json:"started_at"`
+	FiredAt    *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	// RecoveringSince is set while a firing alert waits out its rule's recovery period
+	RecoveringSince *time.Time `json:"recovering_since,omitempty"`
+
+	// SuppressedBy names the silence or maintenance window that kept the alert's last
+	// change from being notified, such as "silence 3"
+	SuppressedBy *string `json:"suppressed_by,omitempty"`
+}
+
+// AlertFilter narrows down the alerts listed.
+type AlertFilter struct {
+	State   string `query:"state" validate:"omitempty,oneof=pending firing resolved"`
+	RuleID  int    `query:"rule_id" validate:"omitempty,gte=1"`
+	AgentID int    `query:"agent_id" validate:"omitempty,gte=1"`
+
+	// Suppressed keeps only alerts whose last change was, or was not, silenced
+	Suppressed *bool `query:"suppressed"`
+}
+
+// AlertTransition is a state change recorded in an alert's history.
+type AlertTransition struct {
+	State string    `json:"state"`
+	Value *float64  `json:"value"`
+	At    time.Time `json:"at"`
+}
+
+// ErrAlertRuleNotFound is returned when an alert rule is not found in the database.
+var ErrAlertRuleNotFound = errors.New("alert rule not found")
+
+// ErrAlertNotFound is returned when an alert is not found in the database.
+var ErrAlertNotFound = errors.New("alert not found")
+
+// ErrInvalidAlertRule is returned when an alert rule watches something that does not exist.
+var ErrInvalidAlertRule = errors.New("invalid alert rule")
+
+// defaultAlertWindow is the window averaged when a rule does not set one.
+const defaultAlertWindow = 5 * time.Minute
+
+// defaultOfflineRecovery is how long an agent must keep reporting before its
+// offline alert resolves, unless the rule sets its own recovery period.
+const defaultOfflineRecovery = time.Minute
+
+// alertSeries identifies the metrics of one agent, on its own or within a session
+type alertSeries struct {
+	agentID, sessionID int
+}
+
+const alertRuleColumns = `
+	SELECT id, name, type, metric, scope, scope_id, comparator, threshold, duration_seconds, window_seconds,
+	       recovery_seconds, enabled, created_at, updated_at
+	FROM alert_rules`
+
+const alertColumns = `
+	SELECT a.id, a.rule_id, r.name, a.agent_id, a.session_id, a.state, a.value,
+	       a.started_at, a.fired_at, a.resolved_at, a.updated_at, a.recovering_since, a.suppressed_by
+	FROM alerts a
+	JOIN alert_rules r ON r.id = a.rule_id`
+
+// CreateAlertRule creates an alert rule.
+func (s *Service) CreateAlertRule(req AlertRuleRequest) (AlertRule, error) {
+	req = withAlertRuleDefaults(req)
+	err := s.checkAlertRule(req)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	res, err := s.DB.Exec(`
+	INSERT INTO alert_rules (name, type, metric, scope, scope_id, comparator, threshold, duration_seconds, window_seconds,
+	                         recovery_seconds, enabled)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		req.Name, req.Type, req.Metric, req.Scope, req.ScopeID, req.Comparator, *req.Threshold,
+		req.DurationSeconds, req.WindowSeconds, *req.RecoverySeconds, *req.Enabled,
+	)
+	if err != nil {
+		return AlertRule{}, fmt.Errorf("error inserting alert rule: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return AlertRule{}, fmt.Errorf("error reading alert rule ID: %w", err)
+	}
+	return s.GetAlertRule(int(id))
+}
+
+// GetAlertRules retrieves all alert rules.
+func (s *Service) GetAlertRules() ([]AlertRule, error) {
+	rows, err := s.DB.Query(alertRuleColumns + " ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return rules, nil
+}
+
+// GetAlertRule retrieves an alert rule by ID.
+func (s *Service) GetAlertRule(id int) (AlertRule, error) {
+	rule, err := scanAlertRule(s.DB.QueryRow(alertRuleColumns+" WHERE id = $1", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rule, ErrAlertRuleNotFound
+		}
+		return rule, fmt.Errorf("error fetching alert rule: %w", err)
+	}
+	return rule, nil
+}
+
+// UpdateAlertRule replaces an alert rule. Its open alerts are re-evaluated against
+// the new settings on the next evaluation.
+func (s *Service) UpdateAlertRule(id int, req AlertRuleRequest) (AlertRule, error) {
+	req = withAlertRuleDefaults(req)
+	err := s.checkAlertRule(req)
+	if err != nil {
+		return AlertRule{}, err
+	}
+
+	res, err := s.DB.Exec(`
+	UPDATE alert_rules
+	SET name = $1, type = $2, metric = $3, scope = $4, scope_id = $5, comparator = $6, threshold = $7,
+	    duration_seconds = $8, window_seconds = $9, recovery_seconds = $10, enabled = $11, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $12`,
+		req.Name, req.Type, req.Metric, req.Scope, req.ScopeID, req.Comparator, *req.Threshold,
+		req.DurationSeconds, req.WindowSeconds, *req.RecoverySeconds, *req.Enabled, id,
+	)
+	if err != nil {
+		return AlertRule{}, fmt.Errorf("error updating alert rule: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return AlertRule{}, fmt.Errorf("error updating alert rule: %w", err)
+	}
+	if updated == 0 {
+		return AlertRule{}, ErrAlertRuleNotFound
+	}
+	return s.GetAlertRule(id)
+}
+
+// DeleteAlertRule removes an alert rule together with its alerts, their history and
+// the email routes filtering on it.
+func (s *Service) DeleteAlertRule(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM alert_history WHERE alert_id IN (SELECT id FROM alerts WHERE rule_id = $1)",
+		"DELETE FROM alerts WHERE rule_id = $1",
+		"DELETE FROM email_notifications WHERE route_id IN (SELECT id FROM email_routes WHERE rule_id = $1)",
+		"DELETE FROM email_routes WHERE rule_id = $1",
+	} {
+		_, err = tx.Exec(query, id)
+		if err != nil {
+			return fmt.Errorf("error deleting alerts of rule: %w", err)
+		}
+	}
+
+	res, err := tx.Exec("DELETE FROM alert_rules WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting alert rule: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting alert rule: %w", err)
+	}
+	if deleted == 0 {
+		return ErrAlertRuleNotFound
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing alert rule deletion: %w", err)
+	}
+	return nil
+}
+
+// GetAlerts retrieves alerts matching the filter, most recently started first.
+func (s *Service) GetAlerts(filter AlertFilter) ([]Alert, error) {
+	query := alertColumns + " WHERE 1 = 1"
+	var args []any
+	if filter.State != "" {
+		args = append(args, filter.State)
+		query += fmt.Sprintf(" AND a.state = $%d", len(args))
+	}
+	if filter.RuleID != 0 {
+		args = append(args, filter.RuleID)
+		query += fmt.Sprintf(" AND a.rule_id = $%d", len(args))
+	}
+	if filter.AgentID != 0 {
+		args = append(args, filter.AgentID)
+		query += fmt.Sprintf(" AND a.agent_id = $%d", len(args))
+	}
+	if filter.Suppressed != nil {
+		if *filter.Suppressed {
+			query += " AND a.suppressed_by IS NOT NULL"
+		} else {
+			query += " AND a.suppressed_by IS NULL"
+		}
+	}
+
+	rows, err := s.DB.Query(query+" ORDER BY a.started_at DESC, a.id DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		alerts = append(alerts, alert)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return alerts, nil
+}
+
+// GetAlert retrieves an alert by ID.
+func (s *Service) GetAlert(id int) (Alert, error) {
+	alert, err := scanAlert(s.DB.QueryRow(alertColumns+" WHERE a.id = $1", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return alert, ErrAlertNotFound
+		}
+		return alert, fmt.Errorf("error fetching alert: %w", err)
+	}
+	return alert, nil
+}
+
+// GetAlertHistory retrieves the state changes of an alert in the order they happened.
+func (s *Service) GetAlertHistory(id int) ([]AlertTransition, error) {
+	_, err := s.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.Query("SELECT state, value, at FROM alert_history WHERE alert_id = $1 ORDER BY id", id)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	history := []AlertTransition{}
+	for rows.Next() {
+		var transition AlertTransition
+		err = rows.Scan(&transition.State, &transition.Value, &transition.At)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		history = append(history, transition)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return history, nil
+}
+
+// EvaluateAlerts checks every alert rule against the metrics ingested up to now,
+// opening, firing and resolving alerts as their conditions change. Deleted, decommissioned
+// and archived agents are not watched, so their open alerts resolve.
+func (s *Service) EvaluateAlerts(now time.Time) error {
+	rules, err := s.GetAlertRules()
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		err = s.evaluateRule(rule, now)
+		if err != nil {
+			return fmt.Errorf("error evaluating alert rule %d: %w", rule.ID, err)
+		}
+	}
+	return nil
+}
+
+// evaluateRule moves the alerts of one rule to the state its latest values call for
+func (s *Service) evaluateRule(rule AlertRule, now time.Time) error {
+	var values map[alertSeries]float64
+	if rule.Enabled {
+		var err error
+		switch rule.Type {
+		case AlertTypeAgentOffline:
+			values, err = s.offlineValues(rule, now)
+		case AlertTypeISPChanged:
+			values, err = s.ispChangeValues(rule, now)
+		default:
+			values, err = s.ruleValues(rule, now)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	open, err := s.openAlerts(rule.ID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for series, value := range values {
+		alert, isOpen := open[series]
+		delete(open, series)
+
+		breaching := compareThreshold(value, rule.Comparator, rule.Threshold)
+		switch {
+		case breaching && !isOpen:
+			err = openAlert(tx, rule, series, value, now)
+		case breaching:
+			err = advanceAlert(tx, rule, alert, value, now)
+		case isOpen:
+			err = recoverAlert(tx, rule, alert, &value, now)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Series without recent data, or a disabled rule, no longer breach
+	for _, alert := range open {
+		err = recoverAlert(tx, rule, alert, nil, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing alert states: %w", err)
+	}
+	return nil
+}
+
+// ruleValues averages the rule's metric over its window for every series in its scope
+func (s *Service) ruleValues(rule AlertRule, now time.Time) (map[alertSeries]float64, error) {
+	column := rule.Metric // Validated against metricColumns when the rule was stored
+	query := fmt.Sprintf(`
+	SELECT agent_id, session_id, AVG(%s) FROM metrics
+	WHERE ts > $1 AND ts <= $2 AND %s IS NOT NULL
+	  AND agent_id IN (SELECT id FROM agents WHERE %s)`, column, column, alertableAgents)
+	args := []any{now.Add(-time.Duration(rule.WindowSeconds) * time.Second).Unix(), now.Unix()}
+
+	switch rule.Scope {
+	case AlertScopeAgent:
+		query += " AND agent_id = $3"
+		args = append(args, rule.ScopeID)
+	case AlertScopeGroup:
+		query += " AND agent_id IN (SELECT agent_id FROM agent_group_members WHERE group_id = $3)"
+		args = append(args, rule.ScopeID)
+	case AlertScopeSession:
+		query += " AND session_id = $3"
+		args = append(args, rule.ScopeID)
+	}
+
+	rows, err := s.DB.Query(query+" GROUP BY agent_id, session_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying metrics: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[alertSeries]float64)
+	for rows.Next() {
+		var series alertSeries
+		var value float64
+		err = rows.Scan(&series.agentID, &series.sessionID, &value)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning metric: %w", err)
+		}
+		values[series] = value
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating metrics: %w", err)
+	}
+	return values, nil
+}
+
+// offlineValues returns the seconds since every agent in the rule's scope last reported.
+// Pending agents are left out until they enroll; agents made active without ever reporting
+// count from the time they were registered.
+func (s *Service) offlineValues(rule AlertRule, now time.Time) (map[alertSeries]float64, error) {
+	query := "SELECT id, last_seen_at, last_updated FROM agents WHERE " + alertableAgents + " AND state <> '" + AgentStatePending + "'"
+	var args []any
+	switch rule.Scope {
+	case AlertScopeAgent:
+		query += " AND id = $1"
+		args = append(args, rule.ScopeID)
+	case AlertScopeGroup:
+		query += " AND id IN (SELECT agent_id FROM agent_group_members WHERE group_id = $1)"
+		args = append(args, rule.ScopeID)
+	}
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying agents: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[alertSeries]float64)
+	for rows.Next() {
+		var agentID int
+		var lastSeen, registered *time.Time
+		err = rows.Scan(&agentID, &lastSeen, &registered)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning agent: %w", err)
+		}
+		if lastSeen == nil {
+			lastSeen = registered
+		}
+		if lastSeen == nil {
+			continue
+		}
+		values[alertSeries{agentID: agentID}] = max(now.Sub(*lastSeen).Seconds(), 0)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating agents: %w", err)
+	}
+	return values, nil
+}
+
+// ispChangeValues counts the ISP changes of every agent in the rule's scope that changed
+// ISP within the window. Agents without a change are left out, resolving their alerts.
+func (s *Service) ispChangeValues(rule AlertRule, now time.Time) (map[alertSeries]float64, error) {
+	query := `
+	SELECT agent_id, COUNT(*) FROM events
+	WHERE type = $1 AND agent_id IS NOT NULL AND unixepoch(created_at) > $2 AND unixepoch(created_at) <= $3
+	  AND agent_id IN (SELECT id FROM agents WHERE ` + alertableAgents + `)`
+	args := []any{EventAgentISPChanged, now.Add(-time.Duration(rule.WindowSeconds) * time.Second).Unix(), now.Unix()}
+	switch rule.Scope {
+	case AlertScopeAgent:
+		query += " AND agent_id = $4"
+		args = append(args, rule.ScopeID)
+	case AlertScopeGroup:
+		query += " AND agent_id IN (SELECT agent_id FROM agent_group_members WHERE group_id = $4)"
+		args = append(args, rule.ScopeID)
+	}
+
+	rows, err := s.DB.Query(query+" GROUP BY agent_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying ISP changes: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[alertSeries]float64)
+	for rows.Next() {
+		var agentID int
+		var changes float64
+		err = rows.Scan(&agentID, &changes)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning ISP changes: %w", err)
+		}
+		values[alertSeries{agentID: agentID}] = changes
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating ISP changes: %w", err)
+	}
+	return values, nil
+}
+
+// openAlerts returns the pending and firing alerts of a rule by series
+func (s *Service) openAlerts(ruleID int) (map[alertSeries]Alert, error) {
+	rows, err := s.DB.Query(alertColumns+" WHERE a.rule_id = $1 AND a.state <> 'resolved'", ruleID)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	open := make(map[alertSeries]Alert)
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		open[alertSeries{alert.AgentID, alert.SessionID}] = alert
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return open, nil
+}
+
+// openAlert raises a pending alert, firing it straight away if the rule has no duration
+func openAlert(tx dbtx, rule AlertRule, series alertSeries, value float64, now time.Time) error {
+	res, err := tx.Exec(`
+	INSERT INTO alerts (rule_id, agent_id, session_id, state, value, started_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $6)`,
+		rule.ID, series.agentID, series.sessionID, AlertPending, value, now.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting alert: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error reading alert ID: %w", err)
+	}
+
+	err = recordAlertTransition(tx, int(id), AlertPending, &value, now)
+	if err != nil {
+		return err
+	}
+	if rule.DurationSeconds == 0 {
+		return fireAlert(tx, int(id), value, now)
+	}
+	return nil
+}
+
+// advanceAlert refreshes the value of a breaching alert and fires it once the rule's duration has passed
+func advanceAlert(tx dbtx, rule AlertRule, alert Alert, value float64, now time.Time) error {
+	if alert.State == AlertPending && now.Sub(alert.StartedAt) >= time.Duration(rule.DurationSeconds)*time.Second {
+		return fireAlert(tx, alert.ID, value, now)
+	}
+
+	_, err := tx.Exec(
+		"UPDATE alerts SET value = $1, recovering_since = NULL, updated_at = $2 WHERE id = $3", value, now.UTC(), alert.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating alert: %w", err)
+	}
+	return nil
+}
+
+// recoverAlert handles an alert whose condition stopped holding. A firing alert is only
+// resolved once the condition has stayed clear for the rule's recovery period, so a
+// flapping series keeps a single alert instead of firing over and over.
+func recoverAlert(tx dbtx, rule AlertRule, alert Alert, value *float64, now time.Time) error {
+	recovery := time.Duration(rule.RecoverySeconds) * time.Second
+	if alert.State == AlertPending || !rule.Enabled || recovery == 0 ||
+		(alert.RecoveringSince != nil && now.Sub(*alert.RecoveringSince) >= recovery) {
+		return resolveAlert(tx, alert.ID, value, now)
+	}
+
+	_, err := tx.Exec(`
+	UPDATE alerts SET value = COALESCE($1, value), recovering_since = COALESCE(recovering_since, $2), updated_at = $2
+	WHERE id = $3`, value, now.UTC(), alert.ID)
+	if err != nil {
+		return fmt.Errorf("error updating alert: %w", err)
+	}
+	return nil
+}
+
+func fireAlert(tx dbtx, id int, value float64, now time.Time) error {
+	_, err := tx.Exec(
+		"UPDATE alerts SET state = $1, value = $2, fired_at = $3, recovering_since = NULL, updated_at = $3 WHERE id = $4",
+		AlertFiring, value, now.UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error firing alert: %w", err)
+	}
+	err = recordAlertTransition(tx, id, AlertFiring, &value, now)
+	if err != nil {
+		return err
+	}
+	return publishAlertEvent(tx, EventAlertFiring, id, now)
+}
+
+// resolveAlert closes an alert. The last value is kept when there is no new one.
+func resolveAlert(tx dbtx, id int, value *float64, now time.Time) error {
+	_, err := tx.Exec(
+		"UPDATE alerts SET state = $1, value = COALESCE($2, value), resolved_at = $3, recovering_since = NULL, updated_at = $3 WHERE id = $4",
+		AlertResolved, value, now.UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error resolving alert: %w", err)
+	}
+	err = recordAlertTransition(tx, id, AlertResolved, value, now)
+	if err != nil {
+		return err
+	}
+	return publishAlertEvent(tx, EventAlertResolved, id, now)
+}
+
+func recordAlertTransition(tx dbtx, id int, state string, value *float64, now time.Time) error {
+	_, err := tx.Exec(
+		"INSERT INTO alert_history (alert_id, state, value, at) VALUES ($1, $2, $3, $4)",
+		id, state, value, now.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("error recording alert history: %w", err)
+	}
+	return nil
+}
+
+// compareThreshold reports whether value breaches threshold according to comparator
+func compareThreshold(value float64, comparator string, threshold float64) bool {
+	switch comparator {
+	case "gt":
+		return value > threshold
+	case "gte":
+		return value >= threshold
+	case "lt":
+		return value < threshold
+	case "lte":
+		return value <= threshold
+	}
+	return false
+}
+
+// checkAlertRule makes sure a rule watches a known metric and an existing agent, group or session
+func (s *Service) checkAlertRule(req AlertRuleRequest) error {
+	switch req.Type {
+	case AlertTypeThreshold:
+		if !slices.Contains(metricColumns, req.Metric) {
+			return fmt.Errorf("%w: unknown metric %q", ErrInvalidAlertRule, req.Metric)
+		}
+	case AlertTypeAgentOffline:
+		if req.Scope == AlertScopeSession {
+			return fmt.Errorf("%w: agent offline rules cannot watch a session", ErrInvalidAlertRule)
+		}
+	case AlertTypeISPChanged:
+		if req.Scope == AlertScopeSession {
+			return fmt.Errorf("%w: ISP change rules cannot watch a session", ErrInvalidAlertRule)
+		}
+		if *req.Threshold < 1 {
+			return fmt.Errorf("%w: ISP change rules need a threshold of at least 1 change", ErrInvalidAlertRule)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidAlertRule, req.Type)
+	}
+
+	var err error
+	switch req.Scope {
+	case AlertScopeAll:
+		return nil
+	case AlertScopeAgent:
+		err = s.agentExists(req.ScopeID)
+	case AlertScopeGroup:
+		err = s.groupExists(req.ScopeID)
+	case AlertScopeSession:
+		_, err = s.GetSession(req.ScopeID)
+	default:
+		return fmt.Errorf("%w: unknown scope %q", ErrInvalidAlertRule, req.Scope)
+	}
+	if errors.Is(err, ErrAgentNotFound) || errors.Is(err, ErrGroupNotFound) || errors.Is(err, ErrSessionNotFound) {
+		return fmt.Errorf("%w: %s %d not found", ErrInvalidAlertRule, req.Scope, req.ScopeID)
+	}
+	return err
+}
+
+// withAlertRuleDefaults fills in the optional settings of a rule. Agent offline rules are
+// stored as "seconds since the agent last reported > window" and ISP change rules as
+// "changes within the window >= threshold".
+func withAlertRuleDefaults(req AlertRuleRequest) AlertRuleRequest {
+	if req.Type == "" {
+		req.Type = AlertTypeThreshold
+	}
+	if req.WindowSeconds == 0 {
+		req.WindowSeconds = int(defaultAlertWindow / time.Second)
+	}
+	if req.Enabled == nil {
+		enabled := true
+		req.Enabled = &enabled
+	}
+
+	recovery := 0
+	if req.Type == AlertTypeAgentOffline {
+		recovery = int(defaultOfflineRecovery / time.Second)
+		threshold := float64(req.WindowSeconds)
+		req.Metric, req.Comparator, req.Threshold = "", "gt", &threshold
+	}
+	if req.Type == AlertTypeISPChanged {
+		if req.Threshold == nil {
+			threshold := 1.0
+			req.Threshold = &threshold
+		}
+		req.Metric, req.Comparator = "", "gte"
+	}
+	if req.RecoverySeconds == nil {
+		req.RecoverySeconds = &recovery
+	}
+	return req
+}
+
+func scanAlertRule(row rowScanner) (AlertRule, error) {
+	var rule AlertRule
+	err := row.Scan(
+		&rule.ID, &rule.Name, &rule.Type, &rule.Metric, &rule.Scope, &rule.ScopeID, &rule.Comparator, &rule.Threshold,
+		&rule.DurationSeconds, &rule.WindowSeconds, &rule.RecoverySeconds, &rule.Enabled, &rule.CreatedAt, &rule.UpdatedAt,
+	)
+	return rule, err
+}
+
+func scanAlert(row rowScanner) (Alert, error) {
+	var alert Alert
+	err := row.Scan(
+		&alert.ID, &alert.RuleID, &alert.RuleName, &alert.AgentID, &alert.SessionID, &alert.State, &alert.Value,
+		&alert.StartedAt, &alert.FiredAt, &alert.ResolvedAt, &alert.UpdatedAt, &alert.RecoveringSince, &alert.SuppressedBy,
+	)
+	return alert, err
+}
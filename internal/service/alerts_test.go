@@ -0,0 +1,172 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAlertEvaluation(t *testing.T) {
+	svc := &Service{DB: db}
+	agentID := insertTestAgent(t, "10.0.5.1")
+	now := time.Unix(1800000000, 0)
+
+	ingest := func(sequence int64, at time.Time, latency float64) {
+		t.Helper()
+		_, err := svc.IngestMetrics(agentID, MetricBatch{
+			Sequence: sequence,
+			Samples:  []MetricSample{{Timestamp: at, Target: "8.8.8.8", LatencyMs: floatPtr(latency)}},
+		})
+		assert.NoError(t, err)
+	}
+
+	rule, err := svc.CreateAlertRule(AlertRuleRequest{
+		Name: "high latency", Metric: "latency_ms", Scope: AlertScopeAgent, ScopeID: agentID,
+		Comparator: "gt", Threshold: floatPtr(100), DurationSeconds: 60, WindowSeconds: 60,
+	})
+	assert.NoError(t, err)
+	assert.True(t, rule.Enabled)
+
+	alertOf := func() Alert {
+		t.Helper()
+		alerts, err := svc.GetAlerts(AlertFilter{RuleID: rule.ID})
+		assert.NoError(t, err)
+		assert.Len(t, alerts, 1)
+		return alerts[0]
+	}
+
+	t.Run("Breach opens a pending alert", func(t *testing.T) {
+		ingest(1, now.Add(-30*time.Second), 150)
+		assert.NoError(t, svc.EvaluateAlerts(now))
+
+		alert := alertOf()
+		assert.Equal(t, AlertPending, alert.State)
+		assert.Equal(t, agentID, alert.AgentID)
+		assert.Equal(t, 150.0, *alert.Value)
+	})
+
+	t.Run("Fires once the duration has passed", func(t *testing.T) {
+		ingest(2, now.Add(30*time.Second), 170)
+		assert.NoError(t, svc.EvaluateAlerts(now.Add(time.Minute)))
+
+		alert := alertOf()
+		assert.Equal(t, AlertFiring, alert.State)
+		assert.NotNil(t, alert.FiredAt)
+	})
+
+	t.Run("Resolves when the metric recovers", func(t *testing.T) {
+		ingest(3, now.Add(90*time.Second), 20)
+		assert.NoError(t, svc.EvaluateAlerts(now.Add(2*time.Minute)))
+
+		alert := alertOf()
+		assert.Equal(t, AlertResolved, alert.State)
+		assert.NotNil(t, alert.ResolvedAt)
+
+		history, err := svc.GetAlertHistory(alert.ID)
+		assert.NoError(t, err)
+		states := []string{}
+		for _, transition := range history {
+			states = append(states, transition.State)
+		}
+		assert.Equal(t, []string{AlertPending, AlertFiring, AlertResolved}, states)
+	})
+
+	t.Run("Fires immediately without a duration", func(t *testing.T) {
+		instant, err := svc.CreateAlertRule(AlertRuleRequest{
+			Name: "any latency", Metric: "latency_ms", Scope: AlertScopeAgent, ScopeID: agentID,
+			Comparator: "gte", Threshold: floatPtr(0), WindowSeconds: 60,
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, svc.EvaluateAlerts(now.Add(2*time.Minute)))
+
+		alerts, err := svc.GetAlerts(AlertFilter{RuleID: instant.ID, State: AlertFiring})
+		assert.NoError(t, err)
+		assert.Len(t, alerts, 1)
+
+		assert.NoError(t, svc.DeleteAlertRule(instant.ID))
+		_, err = svc.GetAlert(alerts[0].ID)
+		assert.ErrorIs(t, err, ErrAlertNotFound)
+	})
+
+	t.Run("Rejects rules watching missing agents", func(t *testing.T) {
+		_, err := svc.CreateAlertRule(AlertRuleRequest{
+			Name: "missing", Metric: "latency_ms", Scope: AlertScopeAgent, ScopeID: 999999,
+			Comparator: "gt", Threshold: floatPtr(1),
+		})
+		assert.ErrorIs(t, err, ErrInvalidAlertRule)
+	})
+}
+
+func TestAgentOfflineAlerts(t *testing.T) {
+	svc := &Service{DB: db}
+	agentID := insertTestAgent(t, "10.0.5.2")
+	start := time.Unix(1800000000, 0)
+
+	seen := func(at time.Time) {
+		t.Helper()
+		_, err := db.Exec("UPDATE agents SET last_seen_at = ? WHERE id = ?", at.UTC(), agentID)
+		assert.NoError(t, err)
+	}
+	evaluate := func(at time.Time) []Alert {
+		t.Helper()
+		assert.NoError(t, svc.EvaluateAlerts(at))
+		alerts, err := svc.GetAlerts(AlertFilter{AgentID: agentID})
+		assert.NoError(t, err)
+		return alerts
+	}
+
+	rule, err := svc.CreateAlertRule(AlertRuleRequest{
+		Name: "agent offline", Type: AlertTypeAgentOffline, Scope: AlertScopeAgent, ScopeID: agentID, WindowSeconds: 60,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 60, rule.RecoverySeconds)
+	seen(start)
+
+	t.Run("Quiet within the window", func(t *testing.T) {
+		assert.Empty(t, evaluate(start.Add(30*time.Second)))
+	})
+
+	t.Run("Fires when the agent goes silent", func(t *testing.T) {
+		alerts := evaluate(start.Add(2 * time.Minute))
+		assert.Len(t, alerts, 1)
+		assert.Equal(t, AlertFiring, alerts[0].State)
+		assert.Equal(t, 120.0, *alerts[0].Value)
+	})
+
+	t.Run("Flapping keeps a single alert", func(t *testing.T) {
+		seen(start.Add(150 * time.Second))
+		alerts := evaluate(start.Add(160 * time.Second))
+		assert.Equal(t, AlertFiring, alerts[0].State)
+		assert.NotNil(t, alerts[0].RecoveringSince)
+
+		// Silent again before the recovery period is over
+		alerts = evaluate(start.Add(240 * time.Second))
+		assert.Len(t, alerts, 1)
+		assert.Equal(t, AlertFiring, alerts[0].State)
+		assert.Nil(t, alerts[0].RecoveringSince)
+	})
+
+	t.Run("Resolves once the agent keeps reporting", func(t *testing.T) {
+		seen(start.Add(250 * time.Second))
+		alerts := evaluate(start.Add(260 * time.Second))
+		assert.Equal(t, AlertFiring, alerts[0].State)
+
+		seen(start.Add(320 * time.Second))
+		alerts = evaluate(start.Add(330 * time.Second))
+		assert.Len(t, alerts, 1)
+		assert.Equal(t, AlertResolved, alerts[0].State)
+	})
+
+	t.Run("Heartbeats and metrics mark the agent as seen", func(t *testing.T) {
+		assert.NoError(t, svc.RecordHeartbeat(agentID))
+		agent, err := svc.GetAgent(agentID)
+		assert.NoError(t, err)
+		assert.NotNil(t, agent.LastSeenAt)
+		assert.ErrorIs(t, svc.RecordHeartbeat(999999), ErrAgentNotFound)
+	})
+
+	t.Run("Rejects session scope", func(t *testing.T) {
+		_, err := svc.CreateAlertRule(AlertRuleRequest{Name: "offline", Type: AlertTypeAgentOffline, Scope: AlertScopeSession, ScopeID: 1})
+		assert.ErrorIs(t, err, ErrInvalidAlertRule)
+	})
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ASN is an autonomous system in the catalog, added when the first agent announced by
+// it registers.
+type ASN struct {
+	ASN        uint32    `json:"asn"`
+	Name       string    `json:"name"`     // Organization, such as Google LLC
+	Country    string    `json:"country"`  // Country of the registration, from RDAP
+	Registry   string    `json:"registry"` // Regional Internet Registry, such as ARIN, from RDAP
+	AgentCount int       `json:"agent_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ErrASNNotFound is returned when an AS number is not in the catalog.
+var ErrASNNotFound = errors.New("ASN not found")
+
+// ErrInvalidASN is returned when an AS number cannot be parsed.
+var ErrInvalidASN = errors.New("invalid ASN")
+
+// ParseASN parses an AS number written as 15169 or AS15169.
+func ParseASN(s string) (uint32, error) {
+	number := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "AS")
+	asn, err := strconv.ParseUint(number, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidASN, s)
+	}
+	return uint32(asn), nil
+}
+
+const asnColumns = `
+	SELECT n.asn, n.name, n.country, n.registry, COUNT(a.id), n.created_at, n.updated_at
+	FROM asns n LEFT JOIN agents a ON a.asn_id = n.asn AND ` + listedAgents
+
+// GetASNs retrieves the AS catalog with the number of listed agents in each AS.
+func (s *Service) GetASNs() ([]ASN, error) {
+	rows, err := s.DB.Query(asnColumns + " GROUP BY n.asn ORDER BY n.asn")
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	asns := []ASN{}
+	for rows.Next() {
+		var asn ASN
+		err = rows.Scan(&asn.ASN, &asn.Name, &asn.Country, &asn.Registry, &asn.AgentCount, &asn.CreatedAt, &asn.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		asns = append(asns, asn)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return asns, nil
+}
+
+// GetASNAgents retrieves the listed agents announced by an AS.
+func (s *Service) GetASNAgents(asn uint32) ([]Agent, error) {
+	var exists bool
+	err := s.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM asns WHERE asn = $1)", asn).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching ASN: %w", err)
+	}
+	if !exists {
+		return nil, ErrASNNotFound
+	}
+
+	rows, err := s.DB.Query("SELECT id, ip_address FROM agents WHERE asn_id = $1 AND "+listedAgents+" ORDER BY id", asn)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	agents := []Agent{}
+	for rows.Next() {
+		var agent Agent
+		err = rows.Scan(&agent.ID, &agent.IPAddress)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		agents = append(agents, agent)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return agents, nil
+}
+
+// upsertASN adds an AS to the catalog or refreshes its details, returning the number
+// agents reference it by. The organization name reported by ip-api is preferred to the
+// RDAP name; without an RDAP registration the stored country and registry are kept.
+// Nothing is stored and nil is returned when asn is not a valid AS number.
+func upsertASN(tx dbtx, asn, name string, autnum *enrich.AutNum) (*uint32, error) {
+	number, err := ParseASN(asn)
+	if err != nil {
+		return nil, nil
+	}
+
+	var country, registry *string
+	if autnum != nil {
+		if name == "" {
+			name = autnum.Name
+		}
+		country, registry = &autnum.Country, &autnum.Registry
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO asns (asn, name, country, registry) VALUES ($1, $2, COALESCE($3, ''), COALESCE($4, ''))
+	ON CONFLICT(asn) DO UPDATE
+	SET name = CASE WHEN EXCLUDED.name != '' THEN EXCLUDED.name ELSE name END,
+	    country = COALESCE($3, country),
+	    registry = COALESCE($4, registry),
+	    updated_at = CURRENT_TIMESTAMP`,
+		number, name, country, registry,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error storing ASN: %w", err)
+	}
+	return &number, nil
+}
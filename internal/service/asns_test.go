@@ -0,0 +1,101 @@
+package service
+
+import (
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"github.com/Shaughny/obkio-test/internal/enrich/enrichtest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestASNCatalog(t *testing.T) {
+	announcers := map[string]string{
+		"10.0.12.1": "AS64530 Example Networks",
+		"10.0.12.2": "AS64530 Example Networks",
+		"10.0.12.3": "AS64531",
+	}
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "isp": "Example", "as": "` + announcers[strings.TrimPrefix(r.URL.Path, "/")] + `"}`))
+	}))
+	defer ipAPI.Close()
+	rdap := enrichtest.NewRDAPServer()
+	defer rdap.Close()
+	rdap.SetAutNum(64530, enrich.AutNum{Name: "EXAMPLE-NET", Country: "CA", Registry: "ARIN"})
+	rdap.SetAutNum(64531, enrich.AutNum{Name: "OTHER-NET", Country: "NL", Registry: "RIPE NCC"})
+
+	svc := &Service{DB: db, IPInfoURL: ipAPI.URL, RDAP: &enrich.RDAP{BaseURL: rdap.URL, CacheTTL: -1}}
+	agents := map[string]int{}
+	for ip := range announcers {
+		assert.NoError(t, svc.AddAgent(ip, nil))
+		var id int
+		assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = $1", ip).Scan(&id))
+		agents[ip] = id
+		defer svc.purgeAgent(id)
+	}
+	catalog := func() map[uint32]ASN {
+		t.Helper()
+		asns, err := svc.GetASNs()
+		assert.NoError(t, err)
+		byNumber := map[uint32]ASN{}
+		for _, asn := range asns {
+			byNumber[asn.ASN] = asn
+		}
+		return byNumber
+	}
+
+	t.Run("ASNs are cataloged", func(t *testing.T) {
+		asns := catalog()
+		example := asns[64530]
+		assert.Equal(t, "Example Networks", example.Name)
+		assert.Equal(t, "CA", example.Country)
+		assert.Equal(t, "ARIN", example.Registry)
+		assert.Equal(t, 2, example.AgentCount)
+
+		// Without an organization from ip-api the RDAP name is used
+		assert.Equal(t, "OTHER-NET", asns[64531].Name)
+		assert.Equal(t, "RIPE NCC", asns[64531].Registry)
+
+		var asnID int
+		assert.NoError(t, db.QueryRow("SELECT asn_id FROM agents WHERE id = $1", agents["10.0.12.1"]).Scan(&asnID))
+		assert.Equal(t, 64530, asnID)
+	})
+
+	t.Run("Agents of an AS", func(t *testing.T) {
+		listed, err := svc.GetASNAgents(64530)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{min(agents["10.0.12.1"], agents["10.0.12.2"]), max(agents["10.0.12.1"], agents["10.0.12.2"])}, ids(listed))
+
+		_, err = svc.GetASNAgents(64599)
+		assert.ErrorIs(t, err, ErrASNNotFound)
+	})
+
+	t.Run("Moving to another AS", func(t *testing.T) {
+		announcers["10.0.12.2"] = "AS64531 Other Networks"
+		assert.NoError(t, svc.AddAgent("10.0.12.2", nil))
+
+		asns := catalog()
+		assert.Equal(t, 1, asns[64530].AgentCount)
+		assert.Equal(t, 2, asns[64531].AgentCount)
+		assert.Equal(t, "Other Networks", asns[64531].Name)
+	})
+
+	t.Run("Failed RDAP lookups keep the registration", func(t *testing.T) {
+		rdap.Fail(http.StatusServiceUnavailable)
+		assert.NoError(t, svc.AddAgent("10.0.12.1", nil))
+		assert.Equal(t, "CA", catalog()[64530].Country)
+	})
+}
+
+func TestParseASN(t *testing.T) {
+	for input, want := range map[string]uint32{"15169": 15169, "AS15169": 15169, "as4200000000": 4200000000, " AS0 ": 0} {
+		asn, err := ParseASN(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, asn, input)
+	}
+	for _, input := range []string{"", "AS", "Google", "AS-15169", "AS4294967296"} {
+		_, err := ParseASN(input)
+		assert.ErrorIs(t, err, ErrInvalidASN, input)
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrAgentNotDeleted is returned when restoring an agent that was not deleted.
+var ErrAgentNotDeleted = errors.New("agent not deleted")
+
+// ErrAgentDeleted is returned when registering the IP address of a deleted agent,
+// which has to be restored instead.
+var ErrAgentDeleted = errors.New("agent deleted")
+
+// sessionPartners selects the agents testing with the agent with ID $1, whose sessions
+// change when it is deleted, restored or purged
+const sessionPartners = `
+	SELECT destination_agent_id FROM monitoring_sessions WHERE source_agent_id = $1
+	UNION SELECT source_agent_id FROM monitoring_sessions WHERE destination_agent_id = $1`
+
+// DeleteAgent hides an agent and tears down its group sessions until it is restored,
+// or purged with its metrics and memberships once PurgeAfter has passed.
+func (s *Service) DeleteAgent(id int) error {
+	agent, err := s.GetAgent(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE agents SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting agent: %w", err)
+	}
+	err = bumpConfigVersions(tx, sessionPartners, id)
+	if err != nil {
+		return err
+	}
+	err = reconcileAgentGroups(tx, id)
+	if err != nil {
+		return err
+	}
+	err = publishEvent(tx, EventAgentDeleted, id, agent)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing agent deletion: %w", err)
+	}
+	return nil
+}
+
+// RestoreAgent brings back a deleted agent that was not purged yet.
+func (s *Service) RestoreAgent(id int) (DetailedAgentResponse, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return DetailedAgentResponse{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deletedAt *time.Time
+	err = tx.QueryRow("SELECT deleted_at FROM agents WHERE id = $1", id).Scan(&deletedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DetailedAgentResponse{}, ErrAgentNotFound
+		}
+		return DetailedAgentResponse{}, fmt.Errorf("error fetching agent: %w", err)
+	}
+	if deletedAt == nil {
+		return DetailedAgentResponse{}, fmt.Errorf("%w: agent %d", ErrAgentNotDeleted, id)
+	}
+
+	_, err = tx.Exec("UPDATE agents SET deleted_at = NULL WHERE id = $1", id)
+	if err != nil {
+		return DetailedAgentResponse{}, fmt.Errorf("error restoring agent: %w", err)
+	}
+	err = bumpConfigVersions(tx, sessionPartners, id)
+	if err != nil {
+		return DetailedAgentResponse{}, err
+	}
+	err = reconcileAgentGroups(tx, id)
+	if err != nil {
+		return DetailedAgentResponse{}, err
+	}
+	return s.commitAgentChange(tx, id)
+}
+
+// PurgeDeletedAgents removes the agents deleted more than PurgeAfter before now with
+// their dependent data, returning how many were purged. Deleted agents are kept when
+// PurgeAfter is zero.
+func (s *Service) PurgeDeletedAgents(now time.Time) (int, error) {
+	if s.PurgeAfter <= 0 {
+		return 0, nil
+	}
+
+	rows, err := s.DB.Query("SELECT id FROM agents WHERE unixepoch(deleted_at) < $1", now.Add(-s.PurgeAfter).Unix())
+	if err != nil {
+		return 0, fmt.Errorf("error querying deleted agents: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning deleted agent: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return 0, fmt.Errorf("error iterating deleted agents: %w", err)
+	}
+
+	// Each agent is purged on its own so a failure keeps the ones already purged
+	for i, id := range ids {
+		err = s.purgeAgent(id)
+		if err != nil {
+			return i, fmt.Errorf("error purging agent %d: %w", id, err)
+		}
+	}
+	return len(ids), nil
+}
+
+// purgeAgent removes an agent with its metrics, memberships and sessions, then
+// reconciles the groups it belonged to
+func (s *Service) purgeAgent(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	groupIDs, err := agentGroupIDs(tx, id)
+	if err != nil {
+		return err
+	}
+
+	// The agents it tests with lose their sessions
+	err = bumpConfigVersions(tx, sessionPartners, id)
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM metrics WHERE session_id IN (SELECT id FROM monitoring_sessions WHERE source_agent_id = $1 OR destination_agent_id = $1)",
+		"DELETE FROM metric_batches WHERE session_id IN (SELECT id FROM monitoring_sessions WHERE source_agent_id = $1 OR destination_agent_id = $1)",
+		"DELETE FROM metric_rollups WHERE session_id IN (SELECT id FROM monitoring_sessions WHERE source_agent_id = $1 OR destination_agent_id = $1)",
+		"DELETE FROM monitoring_sessions WHERE source_agent_id = $1 OR destination_agent_id = $1",
+		"DELETE FROM agent_group_members WHERE agent_id = $1",
+		"DELETE FROM agent_group_links WHERE source_agent_id = $1 OR destination_agent_id = $1",
+		"UPDATE agent_groups SET hub_agent_id = NULL, updated_at = CURRENT_TIMESTAMP WHERE hub_agent_id = $1",
+		"DELETE FROM metrics WHERE agent_id = $1",
+		"DELETE FROM metric_batches WHERE agent_id = $1",
+		"DELETE FROM metric_rollups WHERE agent_id = $1",
+		"DELETE FROM config_overrides WHERE scope = 'agent' AND scope_id = $1",
+		"DELETE FROM alert_history WHERE alert_id IN (SELECT id FROM alerts WHERE agent_id = $1)",
+		"DELETE FROM alerts WHERE agent_id = $1",
+		"DELETE FROM agent_config_versions WHERE agent_id = $1",
+		"DELETE FROM silences WHERE agent_id = $1",
+		"DELETE FROM maintenance_windows WHERE agent_id = $1",
+		"DELETE FROM agent_overrides WHERE agent_id = $1",
+		"DELETE FROM agent_provenance WHERE agent_id = $1",
+		"DELETE FROM agent_labels WHERE agent_id = $1",
+		"DELETE FROM agent_state_transitions WHERE agent_id = $1",
+		"DELETE FROM agents WHERE id = $1",
+	} {
+		_, err = tx.Exec(query, id)
+		if err != nil {
+			return fmt.Errorf("error deleting agent: %w", err)
+		}
+	}
+
+	for _, groupID := range groupIDs {
+		_, err = reconcileGroup(tx, groupID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing agent purge: %w", err)
+	}
+	return nil
+}
+
+// reconcileAgentGroups reconciles the groups an agent belongs to or is the hub of
+func reconcileAgentGroups(tx dbtx, agentID int) error {
+	groupIDs, err := agentGroupIDs(tx, agentID)
+	if err != nil {
+		return err
+	}
+	for _, groupID := range groupIDs {
+		_, err = reconcileGroup(tx, groupID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
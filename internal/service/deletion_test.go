@@ -0,0 +1,146 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSoftDeleteAgents(t *testing.T) {
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "as": "AS64500 Test ISP", "isp": "Test ISP"}`))
+	}))
+	defer ipAPI.Close()
+	svc := &Service{DB: db, IPInfoURL: ipAPI.URL, PurgeAfter: 24 * time.Hour}
+	id := insertTestAgent(t, "10.0.18.1")
+	defer svc.purgeAgent(id)
+	peer := insertTestAgent(t, "10.0.18.2")
+	defer svc.purgeAgent(peer)
+	_, err := db.Exec("INSERT OR IGNORE INTO asns (asn) VALUES (64540)")
+	assert.NoError(t, err)
+	_, err = db.Exec("UPDATE agents SET announced_prefix = '10.0.18.0/24', asn_id = 64540 WHERE id = $1", id)
+	assert.NoError(t, err)
+	session, err := svc.CreateSession(MonitoringSessionRequest{
+		SourceAgentID: id, DestinationAgentID: peer, Protocol: "udp", IntervalSeconds: 60, PacketSize: 64,
+	})
+	assert.NoError(t, err)
+	// The peer measures a session towards the agent, storing the results under its own ID
+	inbound, err := svc.CreateSession(MonitoringSessionRequest{
+		SourceAgentID: peer, DestinationAgentID: id, Protocol: "udp", IntervalSeconds: 60, PacketSize: 64,
+	})
+	assert.NoError(t, err)
+	_, err = svc.IngestSessionMetrics(inbound.ID, MetricBatch{Sequence: 1, Samples: []MetricSample{
+		{Timestamp: time.Unix(1700000000, 0), Target: "10.0.18.1", LatencyMs: floatPtr(10)},
+	}})
+	assert.NoError(t, err)
+	_, err = svc.IngestMetrics(id, MetricBatch{Sequence: 1, Samples: []MetricSample{
+		{Timestamp: time.Unix(1700000000, 0), Target: "8.8.8.8", LatencyMs: floatPtr(10)},
+	}})
+	assert.NoError(t, err)
+
+	countMetrics := func() int {
+		t.Helper()
+		var count int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM metrics WHERE agent_id = ?", id).Scan(&count))
+		return count
+	}
+	listed := func(filter AgentFilter) bool {
+		t.Helper()
+		agents, err := svc.GetAgents(filter)
+		assert.NoError(t, err)
+		return slices.Contains(ids(agents), id)
+	}
+	// visible reports whether the agent shows up in the prefix, ASN and session endpoints
+	visible := func() [4]bool {
+		t.Helper()
+		var seen [4]bool
+		groups, err := svc.GetPrefixGroups()
+		assert.NoError(t, err)
+		for _, group := range groups {
+			seen[0] = seen[0] || slices.Contains(group.AgentIDs, id)
+		}
+		asns, err := svc.GetASNs()
+		assert.NoError(t, err)
+		for _, asn := range asns {
+			seen[1] = seen[1] || asn.ASN == 64540 && asn.AgentCount > 0
+		}
+		agents, err := svc.GetASNAgents(64540)
+		assert.NoError(t, err)
+		seen[2] = slices.Contains(ids(agents), id)
+		_, err = svc.GetSession(session.ID)
+		seen[3] = err == nil
+		return seen
+	}
+
+	peerVersion := func() int {
+		t.Helper()
+		config, err := svc.GetAgentConfig(peer)
+		assert.NoError(t, err)
+		return config.Version
+	}
+
+	t.Run("Deleted agents are hidden", func(t *testing.T) {
+		before := peerVersion()
+		assert.NoError(t, svc.DeleteAgent(id))
+		assert.Equal(t, before+1, peerVersion()) // Its sessions with the agent are gone
+		assert.False(t, listed(AgentFilter{}))
+		assert.True(t, listed(AgentFilter{Deleted: true}))
+		_, err := svc.GetAgent(id)
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+		assert.ErrorIs(t, svc.RecordHeartbeat(id), ErrAgentNotFound)
+		assert.ErrorIs(t, svc.DeleteAgent(id), ErrAgentNotFound)
+		assert.Equal(t, 1, countMetrics())
+		assert.Equal(t, [4]bool{}, visible())
+
+		sessions, err := svc.GetAgentSessions(peer)
+		assert.NoError(t, err)
+		assert.Empty(t, sessions)
+
+		// Registering again does not bring it back behind the restore endpoint's back
+		assert.ErrorIs(t, svc.AddAgent("10.0.18.1", nil), ErrAgentDeleted)
+		assert.True(t, listed(AgentFilter{Deleted: true}))
+	})
+
+	t.Run("Restore brings them back", func(t *testing.T) {
+		before := peerVersion()
+		agent, err := svc.RestoreAgent(id)
+		assert.NoError(t, err)
+		assert.Equal(t, before+1, peerVersion())
+		assert.Equal(t, id, agent.ID)
+		assert.True(t, listed(AgentFilter{}))
+		assert.False(t, listed(AgentFilter{Deleted: true}))
+		assert.Equal(t, [4]bool{true, true, true, true}, visible())
+
+		_, err = svc.RestoreAgent(id)
+		assert.ErrorIs(t, err, ErrAgentNotDeleted)
+		_, err = svc.RestoreAgent(999999)
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+	})
+
+	t.Run("Purged after the grace period", func(t *testing.T) {
+		assert.NoError(t, svc.DeleteAgent(id))
+		_, err := svc.PurgeDeletedAgents(time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+		assert.True(t, listed(AgentFilter{Deleted: true}))
+
+		kept := &Service{DB: db}
+		_, err = kept.PurgeDeletedAgents(time.Now().Add(48 * time.Hour))
+		assert.NoError(t, err)
+		assert.True(t, listed(AgentFilter{Deleted: true}))
+
+		_, err = svc.PurgeDeletedAgents(time.Now().Add(48 * time.Hour))
+		assert.NoError(t, err)
+		assert.False(t, listed(AgentFilter{Deleted: true}))
+		assert.Equal(t, 0, countMetrics())
+		var sessionRows int
+		assert.NoError(t, db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM metrics WHERE session_id = $1) + (SELECT COUNT(*) FROM metric_batches WHERE session_id = $1)`,
+			inbound.ID).Scan(&sessionRows))
+		assert.Equal(t, 0, sessionRows)
+		_, err = svc.RestoreAgent(id)
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+	})
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// NearestQuery defines how many of the agents closest to another agent are listed.
+type NearestQuery struct {
+	Limit int `query:"limit" validate:"omitempty,gte=1,lte=100"` // Defaults to 10
+}
+
+// ErrInvalidAgentFilter is returned when an agent filter has a malformed location or radius.
+var ErrInvalidAgentFilter = errors.New("invalid agent filter")
+
+// ErrAgentNotLocated is returned when distances are asked for around an agent without a known location.
+var ErrAgentNotLocated = errors.New("agent location unknown")
+
+// earthRadiusKm is the mean radius of the Earth.
+const earthRadiusKm = 6371.0088
+
+// maxDistanceKm is half the Earth's circumference, the farthest two points can be apart.
+const maxDistanceKm = math.Pi * earthRadiusKm
+
+// defaultNearestLimit is how many agents are listed when the query sets no limit.
+const defaultNearestLimit = 10
+
+// nearestStartRadiusKm is the first radius searched for the nearest agents. It doubles
+// until enough agents are found.
+const nearestStartRadiusKm = 50.0
+
+// GetNearestAgents lists the agents closest to an agent by great-circle distance, nearest first.
+func (s *Service) GetNearestAgents(id int, q NearestQuery) ([]Agent, error) {
+	origin, err := s.GetAgent(id)
+	if err != nil {
+		return nil, err
+	}
+	if origin.Latitude == nil || origin.Longitude == nil {
+		return nil, ErrAgentNotLocated
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultNearestLimit
+	}
+
+	// Widen the search until it holds enough agents. Every agent closer than the
+	// farthest one found is then within the searched circle.
+	for radius := nearestStartRadiusKm; ; radius *= 2 {
+		radius = min(radius, maxDistanceKm)
+		agents, err := s.agentsWithin(*origin.Latitude, *origin.Longitude, radius, AgentFilter{}, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(agents) >= q.Limit || radius == maxDistanceKm {
+			return agents[:min(q.Limit, len(agents))], nil
+		}
+	}
+}
+
+// agentsWithin lists the agents matching the filter within radiusKm of a point, nearest
+// first, leaving out the agent excluded. Candidates are narrowed down with a bounding box
+// over the indexed coordinates before their great-circle distance is worked out.
+func (s *Service) agentsWithin(lat, lon, radiusKm float64, filter AgentFilter, excluded int) ([]Agent, error) {
+	where, args, err := filter.where()
+	if err != nil {
+		return nil, err
+	}
+	box, boxArgs := boundingBox(lat, lon, radiusKm, len(args))
+	if where == "" {
+		where = " WHERE " + box
+	} else {
+		where += " AND " + box
+	}
+	args = append(args, boxArgs...)
+	args = append(args, excluded)
+	where += fmt.Sprintf(" AND id <> $%d", len(args))
+
+	rows, err := s.DB.Query("SELECT id, ip_address, latitude, longitude FROM agents"+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	agents := []Agent{}
+	for rows.Next() {
+		var agent Agent
+		var agentLat, agentLon float64
+		err = rows.Scan(&agent.ID, &agent.IPAddress, &agentLat, &agentLon)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		distance := greatCircleKm(lat, lon, agentLat, agentLon)
+		if distance <= radiusKm {
+			agent.DistanceKm = &distance
+			agents = append(agents, agent)
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	slices.SortFunc(agents, func(a, b Agent) int {
+		if *a.DistanceKm != *b.DistanceKm {
+			return cmp.Compare(*a.DistanceKm, *b.DistanceKm)
+		}
+		return a.ID - b.ID
+	})
+	return agents, nil
+}
+
+// boundingBox returns the SQL condition selecting the coordinates within the latitude and
+// longitude range around a circle. Its placeholders are numbered after the n already used.
+func boundingBox(lat, lon, radiusKm float64, n int) (string, []any) {
+	angle := radiusKm / earthRadiusKm * 180 / math.Pi
+	minLat, maxLat := lat-angle, lat+angle
+
+	// Circles reaching a pole cover every longitude
+	if minLat <= -90 || maxLat >= 90 {
+		return fmt.Sprintf("latitude BETWEEN $%d AND $%d AND longitude IS NOT NULL", n+1, n+2),
+			[]any{max(minLat, -90), min(maxLat, 90)}
+	}
+
+	// Degrees of longitude shrink towards the poles
+	lonAngle := math.Asin(math.Sin(radiusKm/earthRadiusKm)/math.Cos(lat*math.Pi/180)) * 180 / math.Pi
+	minLon, maxLon := lon-lonAngle, lon+lonAngle
+	condition := fmt.Sprintf("latitude BETWEEN $%d AND $%d AND longitude BETWEEN $%d AND $%d", n+1, n+2, n+3, n+4)
+	switch {
+	case minLon < -180: // Wraps across the antimeridian
+		return fmt.Sprintf("latitude BETWEEN $%d AND $%d AND (longitude BETWEEN $%d AND $%d OR longitude >= $%d)",
+			n+1, n+2, n+3, n+4, n+5), []any{minLat, maxLat, -180.0, maxLon, minLon + 360}
+	case maxLon > 180:
+		return fmt.Sprintf("latitude BETWEEN $%d AND $%d AND (longitude BETWEEN $%d AND $%d OR longitude <= $%d)",
+			n+1, n+2, n+3, n+4, n+5), []any{minLat, maxLat, minLon, 180.0, maxLon - 360}
+	}
+	return condition, []any{minLat, maxLat, minLon, maxLon}
+}
+
+// greatCircleKm is the haversine distance between two points on the Earth.
+func greatCircleKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// parseCoordinates parses a "lat,lon" pair such as "45.50,-73.57"
+func parseCoordinates(value string) (lat, lon float64, err error) {
+	latText, lonText, found := strings.Cut(value, ",")
+	if !found {
+		return 0, 0, fmt.Errorf("%w: near must be latitude,longitude", ErrInvalidAgentFilter)
+	}
+	lat, err = strconv.ParseFloat(strings.TrimSpace(latText), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("%w: invalid latitude %q", ErrInvalidAgentFilter, latText)
+	}
+	lon, err = strconv.ParseFloat(strings.TrimSpace(lonText), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("%w: invalid longitude %q", ErrInvalidAgentFilter, lonText)
+	}
+	return lat, lon, nil
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDistanceQueries(t *testing.T) {
+	svc := &Service{DB: db}
+
+	locate := func(ip, country string, lat, lon float64) int {
+		t.Helper()
+		id := insertTestAgent(t, ip)
+		_, err := db.Exec("UPDATE agents SET country_code = $1, latitude = $2, longitude = $3 WHERE id = $4",
+			country, lat, lon, id)
+		assert.NoError(t, err)
+		return id
+	}
+	montreal := locate("10.0.11.1", "CA", 45.5019, -73.5674)
+	ottawa := locate("10.0.11.2", "CA", 45.4215, -75.6972)
+	toronto := locate("10.0.11.3", "CA", 43.6532, -79.3832)
+	burlington := locate("10.0.11.4", "US", 44.4759, -73.2121)
+	suva := locate("10.0.11.5", "FJ", -18.1248, 178.4501)
+	taveuni := locate("10.0.11.6", "FJ", -16.8500, -179.9700)
+	alert := locate("10.0.11.7", "CA", 82.5018, -62.3481)
+	station := locate("10.0.11.8", "NO", 89.9000, 120.0000)
+	unlocated := insertTestAgent(t, "10.0.11.9")
+	for _, id := range []int{montreal, ottawa, toronto, burlington, suva, taveuni, alert, station, unlocated} {
+		defer svc.purgeAgent(id)
+	}
+
+	t.Run("Great circle distance", func(t *testing.T) {
+		assert.InDelta(t, 504, greatCircleKm(45.5019, -73.5674, 43.6532, -79.3832), 2)
+		assert.InDelta(t, 0, greatCircleKm(45.5019, -73.5674, 45.5019, -73.5674), 0.001)
+		assert.InDelta(t, maxDistanceKm, greatCircleKm(0, 0, 0, 180), 0.001)
+	})
+
+	t.Run("Agents within a radius", func(t *testing.T) {
+		agents, err := svc.GetAgents(AgentFilter{Near: "45.5019,-73.5674", RadiusKm: 200})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{montreal, burlington, ottawa}, ids(agents))
+		if assert.Len(t, agents, 3) {
+			assert.InDelta(t, 0, *agents[0].DistanceKm, 0.001)
+			assert.InDelta(t, 166, *agents[2].DistanceKm, 2)
+		}
+
+		agents, err = svc.GetAgents(AgentFilter{Near: "45.5019,-73.5674", RadiusKm: 600, Country: "CA"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{montreal, ottawa, toronto}, ids(agents))
+	})
+
+	t.Run("Radius across the antimeridian", func(t *testing.T) {
+		agents, err := svc.GetAgents(AgentFilter{Near: "-17.5,179.9", RadiusKm: 300})
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []int{suva, taveuni}, ids(agents))
+	})
+
+	t.Run("Radius around a pole", func(t *testing.T) {
+		agents, err := svc.GetAgents(AgentFilter{Near: "90,0", RadiusKm: 900})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{station, alert}, ids(agents))
+	})
+
+	t.Run("Nearest agents", func(t *testing.T) {
+		agents, err := svc.GetNearestAgents(montreal, NearestQuery{Limit: 3})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{burlington, ottawa, toronto}, ids(agents))
+
+		// The search widens until it reaches the far side of the world
+		agents, err = svc.GetNearestAgents(suva, NearestQuery{Limit: 100})
+		assert.NoError(t, err)
+		assert.Len(t, agents, 7)
+		assert.Equal(t, taveuni, agents[0].ID)
+	})
+
+	t.Run("Invalid queries", func(t *testing.T) {
+		_, err := svc.GetNearestAgents(unlocated, NearestQuery{})
+		assert.ErrorIs(t, err, ErrAgentNotLocated)
+
+		_, err = svc.GetNearestAgents(999999, NearestQuery{})
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+
+		for _, near := range []string{"45.5", "north,west", "91,0", "0,181"} {
+			_, err = svc.GetAgents(AgentFilter{Near: near, RadiusKm: 10})
+			assert.ErrorIs(t, err, ErrInvalidAgentFilter, near)
+		}
+	})
+}
+
+// ids lists the IDs of agents in order
+func ids(agents []Agent) []int {
+	var ids []int
+	for _, agent := range agents {
+		ids = append(ids, agent.ID)
+	}
+	return ids
+}
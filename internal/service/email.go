@@ -0,0 +1,550 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Shaughny/obkio-test/internal/notify"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statuses of an email notification.
+const (
+	EmailPending = "pending" // Waiting to be sent, alone or in a digest
+	EmailSent    = "sent"
+	EmailDead    = "dead" // Gave up after maxEmailAttempts
+)
+
+// maxEmailAttempts is the number of attempts before a notification is given up on.
+const maxEmailAttempts = 5
+
+// emailBatchSize is the number of routes mailed per run.
+const emailBatchSize = 50
+
+// Default email templates. They receive an EmailDigest and can be replaced with
+// Service.EmailTemplates.
+const (
+	defaultEmailSubject = `{{if eq (len .Events) 1}}{{with index .Events 0}}[{{.Alert.State}}] {{.Alert.RuleName}} on agent {{.Alert.AgentID}}{{end}}` +
+		`{{else}}[{{.Route}}] {{len .Events}} alert notifications{{end}}`
+
+	defaultEmailText = `{{range .Events}}[{{.Alert.State}}] {{.Alert.RuleName}}
+  Agent:   {{.Alert.AgentID}}{{if .Alert.SessionID}}
+  Session: {{.Alert.SessionID}}{{end}}{{if .Value}}
+  Value:   {{.Value}}{{end}}
+  Since:   {{.At.Format "2006-01-02 15:04:05 MST"}}
+
+{{end}}--
+Sent to {{.Recipient}} by the "{{.Route}}" notification route.
+`
+
+	defaultEmailHTML = `<html><body>
+<table cellpadding="6" style="border-collapse: collapse">
+<tr><th align="left">State</th><th align="left">Rule</th><th align="left">Agent</th><th align="left">Session</th><th align="left">Value</th><th align="left">Since</th></tr>
+{{range .Events}}<tr>
+<td><b>{{.Alert.State}}</b></td><td>{{.Alert.RuleName}}</td><td>{{.Alert.AgentID}}</td>
+<td>{{if .Alert.SessionID}}{{.Alert.SessionID}}{{end}}</td><td>{{.Value}}</td><td>{{.At.Format "2006-01-02 15:04:05 MST"}}</td>
+</tr>
+{{end}}</table>
+<p style="color: #888">Sent to {{.Recipient}} by the "{{.Route}}" notification route.</p>
+</body></html>
+`
+)
+
+var defaultEmailTemplates = mustParseTemplates(defaultEmailSubject, defaultEmailText, defaultEmailHTML)
+
+// EmailRoute sends the alert events of the listed types to a recipient, optionally
+// only those of one rule or of the agents in one group. Routes with a digest interval
+// batch the events raised during the interval into a single email.
+type EmailRoute struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	Recipient     string    `json:"recipient"`
+	EventTypes    []string  `json:"event_types"`
+	RuleID        int       `json:"rule_id,omitempty"`
+	GroupID       int       `json:"group_id,omitempty"`
+	DigestSeconds int       `json:"digest_seconds"` // 0 sends every event right away
+	Enabled       bool      `json:"enabled"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// EmailRouteRequest defines the structure for creating or updating an email route.
+type EmailRouteRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Recipient     string   `json:"recipient" validate:"required,email,max=254"`
+	EventTypes    []string `json:"event_types" validate:"required,min=1,dive,oneof=alert.firing alert.resolved"`
+	RuleID        int      `json:"rule_id" validate:"omitempty,gte=1"`
+	GroupID       int      `json:"group_id" validate:"omitempty,gte=1"`
+	DigestSeconds int      `json:"digest_seconds" validate:"gte=0,lte=86400"`
+	Enabled       *bool    `json:"enabled"` // Defaults to true
+}
+
+// EmailNotification is one event queued for one email route.
+type EmailNotification struct {
+	ID        int        `json:"id"`
+	RouteID   int        `json:"route_id"`
+	EventID   int        `json:"event_id"`
+	EventType string     `json:"event_type"`
+	Status    string     `json:"status"`
+	Attempts  int        `json:"attempts"`
+	SendAfter time.Time  `json:"send_after"`
+	LastError *string    `json:"last_error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+}
+
+// EmailNotificationFilter narrows down the notifications listed.
+type EmailNotificationFilter struct {
+	Status  string `query:"status" validate:"omitempty,oneof=pending sent dead"`
+	RouteID int    `query:"route_id" validate:"omitempty,gte=1"`
+}
+
+// EmailDigest is the data the email templates are rendered with.
+type EmailDigest struct {
+	Recipient string
+	Route     string
+	Events    []EmailEvent
+}
+
+// EmailEvent is an alert event in an email.
+type EmailEvent struct {
+	Type  string
+	Alert Alert
+	Value string    // The alert's value formatted with two decimals, empty when it has none
+	At    time.Time // When the alert changed state
+}
+
+// ErrEmailRouteNotFound is returned when an email route is not found in the database.
+var ErrEmailRouteNotFound = errors.New("email route not found")
+
+// ErrInvalidEmailRoute is returned when an email route filters on a rule or group that does not exist.
+var ErrInvalidEmailRoute = errors.New("invalid email route")
+
+const emailRouteColumns = `
+	SELECT id, name, recipient, event_types, COALESCE(rule_id, 0), COALESCE(group_id, 0), digest_seconds,
+	       enabled, created_at, updated_at
+	FROM email_routes`
+
+const emailNotificationColumns = `
+	SELECT n.id, n.route_id, n.event_id, e.type, n.status, n.attempts, n.send_after, n.last_error, n.created_at, n.sent_at
+	FROM email_notifications n
+	JOIN events e ON e.id = n.event_id`
+
+// CreateEmailRoute creates an email route.
+func (s *Service) CreateEmailRoute(req EmailRouteRequest) (EmailRoute, error) {
+	err := s.checkEmailRoute(req)
+	if err != nil {
+		return EmailRoute{}, err
+	}
+
+	res, err := s.DB.Exec(`
+	INSERT INTO email_routes (name, recipient, event_types, rule_id, group_id, digest_seconds, enabled)
+	VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, 0), $6, $7)`,
+		req.Name, req.Recipient, strings.Join(req.EventTypes, ","), req.RuleID, req.GroupID, req.DigestSeconds,
+		req.Enabled == nil || *req.Enabled,
+	)
+	if err != nil {
+		return EmailRoute{}, fmt.Errorf("error inserting email route: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return EmailRoute{}, fmt.Errorf("error reading email route ID: %w", err)
+	}
+	return s.GetEmailRoute(int(id))
+}
+
+// GetEmailRoutes retrieves all email routes.
+func (s *Service) GetEmailRoutes() ([]EmailRoute, error) {
+	return queryEmailRoutes(s.DB, emailRouteColumns+" ORDER BY id")
+}
+
+// GetEmailRoute retrieves an email route by ID.
+func (s *Service) GetEmailRoute(id int) (EmailRoute, error) {
+	route, err := scanEmailRoute(s.DB.QueryRow(emailRouteColumns+" WHERE id = $1", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return route, ErrEmailRouteNotFound
+		}
+		return route, fmt.Errorf("error fetching email route: %w", err)
+	}
+	return route, nil
+}
+
+// UpdateEmailRoute replaces the settings of an email route. Notifications already
+// queued keep their schedule.
+func (s *Service) UpdateEmailRoute(id int, req EmailRouteRequest) (EmailRoute, error) {
+	err := s.checkEmailRoute(req)
+	if err != nil {
+		return EmailRoute{}, err
+	}
+
+	res, err := s.DB.Exec(`
+	UPDATE email_routes
+	SET name = $1, recipient = $2, event_types = $3, rule_id = NULLIF($4, 0), group_id = NULLIF($5, 0),
+	    digest_seconds = $6, enabled = $7, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $8`,
+		req.Name, req.Recipient, strings.Join(req.EventTypes, ","), req.RuleID, req.GroupID, req.DigestSeconds,
+		req.Enabled == nil || *req.Enabled, id,
+	)
+	if err != nil {
+		return EmailRoute{}, fmt.Errorf("error updating email route: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return EmailRoute{}, fmt.Errorf("error updating email route: %w", err)
+	}
+	if updated == 0 {
+		return EmailRoute{}, ErrEmailRouteNotFound
+	}
+	return s.GetEmailRoute(id)
+}
+
+// DeleteEmailRoute removes an email route together with its notifications.
+func (s *Service) DeleteEmailRoute(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM email_notifications WHERE route_id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting email notifications: %w", err)
+	}
+	res, err := tx.Exec("DELETE FROM email_routes WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting email route: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting email route: %w", err)
+	}
+	if deleted == 0 {
+		return ErrEmailRouteNotFound
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing email route deletion: %w", err)
+	}
+	return nil
+}
+
+// GetEmailNotifications retrieves email notifications matching the filter, newest first.
+func (s *Service) GetEmailNotifications(filter EmailNotificationFilter) ([]EmailNotification, error) {
+	query := emailNotificationColumns + " WHERE 1 = 1"
+	var args []any
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		query += fmt.Sprintf(" AND n.status = $%d", len(args))
+	}
+	if filter.RouteID != 0 {
+		args = append(args, filter.RouteID)
+		query += fmt.Sprintf(" AND n.route_id = $%d", len(args))
+	}
+
+	rows, err := s.DB.Query(query+" ORDER BY n.id DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []EmailNotification{}
+	for rows.Next() {
+		notification, err := scanEmailNotification(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		notifications = append(notifications, notification)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return notifications, nil
+}
+
+// SendEmailNotifications mails the pending notifications of every enabled route whose
+// oldest notification is due. All of a route's pending notifications go out in one
+// email, so events queued during a digest interval arrive together. Nothing is sent
+// when the service has no mailer.
+func (s *Service) SendEmailNotifications(now time.Time) error {
+	if s.Mailer == nil {
+		return nil
+	}
+
+	routes, err := queryEmailRoutes(s.DB, emailRouteColumns+`
+	WHERE enabled = 1 AND id IN (
+		SELECT route_id FROM email_notifications WHERE status = 'pending'
+		GROUP BY route_id HAVING MIN(send_after) <= $1
+	)
+	ORDER BY id
+	LIMIT `+strconv.Itoa(emailBatchSize), now.Unix())
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, route := range routes {
+		err = s.sendEmail(route, now)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pendingEmail is a queued notification loaded together with its event
+type pendingEmail struct {
+	id, attempts int
+	event        EmailEvent
+}
+
+// sendEmail renders and sends the due notifications of a route, then records the outcome.
+// Notifications backing off after a failed send wait for their turn.
+func (s *Service) sendEmail(route EmailRoute, now time.Time) error {
+	rows, err := s.DB.Query(`
+	SELECT n.id, n.attempts, e.type, e.data
+	FROM email_notifications n
+	JOIN events e ON e.id = n.event_id
+	WHERE n.route_id = $1 AND n.status = 'pending' AND n.send_after <= $2
+	ORDER BY n.id`, route.ID, now.Unix())
+	if err != nil {
+		return fmt.Errorf("error querying email notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var pending []pendingEmail
+	for rows.Next() {
+		var p pendingEmail
+		var data string
+		err = rows.Scan(&p.id, &p.attempts, &p.event.Type, &data)
+		if err != nil {
+			return fmt.Errorf("error scanning email notification: %w", err)
+		}
+		err = json.Unmarshal([]byte(data), &p.event.Alert)
+		if err != nil {
+			return fmt.Errorf("error decoding alert event: %w", err)
+		}
+		p.event.At = p.event.Alert.UpdatedAt
+		if p.event.Alert.Value != nil {
+			p.event.Value = strconv.FormatFloat(*p.event.Alert.Value, 'f', 2, 64)
+		}
+		pending = append(pending, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("error iterating email notifications: %w", err)
+	}
+	rows.Close()
+
+	digest := EmailDigest{Recipient: route.Recipient, Route: route.Name}
+	for _, p := range pending {
+		digest.Events = append(digest.Events, p.event)
+	}
+
+	templates := s.EmailTemplates
+	if templates == nil {
+		templates = defaultEmailTemplates
+	}
+	msg, sendErr := templates.Render(digest)
+	if sendErr == nil {
+		msg.To = []string{route.Recipient}
+		sendErr = s.Mailer.Send(msg)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, p := range pending {
+		err = recordEmailAttempt(tx, p, sendErr, now)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing email notification attempt: %w", err)
+	}
+
+	if sendErr != nil {
+		return fmt.Errorf("error sending email for route %d: %w", route.ID, sendErr)
+	}
+	return nil
+}
+
+// recordEmailAttempt marks a notification as sent, or schedules another attempt with
+// the same backoff as webhook deliveries until it runs out of attempts
+func recordEmailAttempt(tx dbtx, p pendingEmail, sendErr error, now time.Time) error {
+	attempts := p.attempts + 1
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = tx.Exec(
+			"UPDATE email_notifications SET status = $1, attempts = $2, last_error = NULL, sent_at = $3 WHERE id = $4",
+			EmailSent, attempts, now.Unix(), p.id,
+		)
+	case attempts >= maxEmailAttempts:
+		_, err = tx.Exec(
+			"UPDATE email_notifications SET status = $1, attempts = $2, last_error = $3 WHERE id = $4",
+			EmailDead, attempts, sendErr.Error(), p.id,
+		)
+	default:
+		_, err = tx.Exec(
+			"UPDATE email_notifications SET attempts = $1, last_error = $2, send_after = $3 WHERE id = $4",
+			attempts, sendErr.Error(), now.Add(deliveryBackoff(attempts)).Unix(), p.id,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("error recording email notification attempt: %w", err)
+	}
+	return nil
+}
+
+// queueEmails queues an alert event for every enabled email route it matches. A
+// digest route collects events until the first one queued has waited for the
+// route's digest interval.
+func queueEmails(tx dbtx, eventType string, eventID int, alert Alert, now time.Time) error {
+	routes, err := queryEmailRoutes(tx, emailRouteColumns+" WHERE enabled = 1")
+	if err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		matches, err := route.matches(tx, eventType, alert)
+		if err != nil {
+			return err
+		}
+		if !matches {
+			continue
+		}
+
+		sendAfter := now.Unix()
+		if route.DigestSeconds > 0 {
+			var open *int64
+			err = tx.QueryRow(
+				"SELECT MIN(send_after) FROM email_notifications WHERE route_id = $1 AND status = 'pending'", route.ID,
+			).Scan(&open)
+			if err != nil {
+				return fmt.Errorf("error checking open digest: %w", err)
+			}
+			sendAfter = now.Add(time.Duration(route.DigestSeconds) * time.Second).Unix()
+			if open != nil {
+				sendAfter = *open
+			}
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO email_notifications (route_id, event_id, status, send_after) VALUES ($1, $2, $3, $4)",
+			route.ID, eventID, EmailPending, sendAfter,
+		)
+		if err != nil {
+			return fmt.Errorf("error queueing email notification: %w", err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the route sends the event of an alert
+func (r EmailRoute) matches(tx dbtx, eventType string, alert Alert) (bool, error) {
+	if !slices.Contains(r.EventTypes, eventType) || (r.RuleID != 0 && r.RuleID != alert.RuleID) {
+		return false, nil
+	}
+	if r.GroupID == 0 {
+		return true, nil
+	}
+
+	var member bool
+	err := tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM agent_group_members WHERE group_id = $1 AND agent_id = $2)", r.GroupID, alert.AgentID,
+	).Scan(&member)
+	if err != nil {
+		return false, fmt.Errorf("error checking group membership: %w", err)
+	}
+	return member, nil
+}
+
+// checkEmailRoute verifies that the rule and group a route filters on exist
+func (s *Service) checkEmailRoute(req EmailRouteRequest) error {
+	if req.RuleID != 0 {
+		_, err := s.GetAlertRule(req.RuleID)
+		if errors.Is(err, ErrAlertRuleNotFound) {
+			return fmt.Errorf("%w: alert rule %d not found", ErrInvalidEmailRoute, req.RuleID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if req.GroupID != 0 {
+		err := s.groupExists(req.GroupID)
+		if errors.Is(err, ErrGroupNotFound) {
+			return fmt.Errorf("%w: group %d not found", ErrInvalidEmailRoute, req.GroupID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryEmailRoutes(tx dbtx, query string, args ...any) ([]EmailRoute, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	routes := []EmailRoute{}
+	for rows.Next() {
+		route, err := scanEmailRoute(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		routes = append(routes, route)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return routes, nil
+}
+
+func scanEmailRoute(row rowScanner) (EmailRoute, error) {
+	var route EmailRoute
+	var eventTypes string
+	err := row.Scan(&route.ID, &route.Name, &route.Recipient, &eventTypes, &route.RuleID, &route.GroupID,
+		&route.DigestSeconds, &route.Enabled, &route.CreatedAt, &route.UpdatedAt)
+	route.EventTypes = strings.Split(eventTypes, ",")
+	return route, err
+}
+
+func scanEmailNotification(row rowScanner) (EmailNotification, error) {
+	var notification EmailNotification
+	var sendAfter int64
+	var sentAt *int64
+	err := row.Scan(
+		&notification.ID, &notification.RouteID, &notification.EventID, &notification.EventType, &notification.Status,
+		&notification.Attempts, &sendAfter, &notification.LastError, &notification.CreatedAt, &sentAt,
+	)
+	notification.SendAfter = time.Unix(sendAfter, 0).UTC()
+	notification.SentAt = unixTime(sentAt)
+	return notification, err
+}
+
+func mustParseTemplates(subject, text, html string) *notify.Templates {
+	templates, err := notify.ParseTemplates(subject, text, html)
+	if err != nil {
+		panic(err)
+	}
+	return templates
+}
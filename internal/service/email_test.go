@@ -0,0 +1,242 @@
+package service
+
+import (
+	"github.com/Shaughny/obkio-test/internal/notify"
+	"github.com/Shaughny/obkio-test/internal/notify/notifytest"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/quotedprintable"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mailText returns a received message with its quoted-printable encoding undone
+func mailText(t *testing.T, mail notifytest.Mail) string {
+	t.Helper()
+	text, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(mail.Data)))
+	assert.NoError(t, err)
+	return string(text)
+}
+
+func TestEmailNotifications(t *testing.T) {
+	server := notifytest.NewServer()
+	defer server.Close()
+
+	svc := &Service{DB: db, Mailer: &notify.Mailer{
+		Host: server.Host, Port: server.Port, From: "monitor@example.com", Security: notify.SecurityNone,
+	}}
+	now := time.Unix(1810000000, 0)
+
+	first := insertTestAgent(t, "10.0.7.1")
+	second := insertTestAgent(t, "10.0.7.2")
+	defer svc.purgeAgent(first)
+	defer svc.purgeAgent(second)
+	sequences := map[int]int64{}
+	ingest := func(agentID int, at time.Time, latency float64) {
+		t.Helper()
+		sequences[agentID]++
+		_, err := svc.IngestMetrics(agentID, MetricBatch{
+			Sequence: sequences[agentID],
+			Samples:  []MetricSample{{Timestamp: at, Target: "8.8.8.8", LatencyMs: floatPtr(latency)}},
+		})
+		assert.NoError(t, err)
+	}
+
+	request := AgentGroupRequest{Topology: TopologyFullMesh, Protocol: "udp", IntervalSeconds: 10, PacketSize: 64}
+	request.Name = "email rule scope"
+	watched, err := svc.CreateGroup(request)
+	assert.NoError(t, err)
+	defer svc.DeleteGroup(watched.ID)
+	request.Name = "email route filter"
+	filtered, err := svc.CreateGroup(request)
+	assert.NoError(t, err)
+	defer svc.DeleteGroup(filtered.ID)
+	for _, member := range []struct{ group, agent int }{{watched.ID, first}, {watched.ID, second}, {filtered.ID, first}} {
+		_, err = svc.AddGroupMember(member.group, member.agent)
+		assert.NoError(t, err)
+	}
+
+	rule, err := svc.CreateAlertRule(AlertRuleRequest{
+		Name: "email latency", Metric: "latency_ms", Scope: AlertScopeGroup, ScopeID: watched.ID,
+		Comparator: "gt", Threshold: floatPtr(100), WindowSeconds: 60,
+	})
+	assert.NoError(t, err)
+	defer svc.DeleteAlertRule(rule.ID)
+
+	oncall, err := svc.CreateEmailRoute(EmailRouteRequest{
+		Name: "on call", Recipient: "oncall@example.com", EventTypes: []string{EventAlertFiring}, RuleID: rule.ID,
+	})
+	assert.NoError(t, err)
+	assert.True(t, oncall.Enabled)
+	defer svc.DeleteEmailRoute(oncall.ID)
+
+	noc, err := svc.CreateEmailRoute(EmailRouteRequest{
+		Name: "noc digest", Recipient: "noc@example.com", EventTypes: []string{EventAlertFiring, EventAlertResolved},
+		GroupID: filtered.ID, DigestSeconds: 300,
+	})
+	assert.NoError(t, err)
+	defer svc.DeleteEmailRoute(noc.ID)
+
+	disabled := false
+	muted, err := svc.CreateEmailRoute(EmailRouteRequest{
+		Name: "muted", Recipient: "muted@example.com", EventTypes: []string{EventAlertFiring}, Enabled: &disabled,
+	})
+	assert.NoError(t, err)
+	defer svc.DeleteEmailRoute(muted.ID)
+
+	mailsTo := func(recipient string) []notifytest.Mail {
+		var mails []notifytest.Mail
+		for _, mail := range server.Mails() {
+			if mail.To[0] == recipient {
+				mails = append(mails, mail)
+			}
+		}
+		return mails
+	}
+
+	t.Run("Immediate route mails right away", func(t *testing.T) {
+		ingest(first, now.Add(-10*time.Second), 150)
+		ingest(second, now.Add(-10*time.Second), 250)
+		assert.NoError(t, svc.EvaluateAlerts(now))
+		assert.NoError(t, svc.SendEmailNotifications(now))
+
+		mails := mailsTo("oncall@example.com")
+		if !assert.Len(t, mails, 1) {
+			return
+		}
+		text := mailText(t, mails[0])
+		assert.Contains(t, text, "Subject: [on call] 2 alert notifications")
+		assert.Contains(t, text, "Agent:   "+strconv.Itoa(first))
+		assert.Contains(t, text, "Agent:   "+strconv.Itoa(second))
+		assert.Contains(t, text, "Value:   250.00")
+		assert.Contains(t, text, "<b>firing</b>")
+
+		assert.Empty(t, mailsTo("noc@example.com"))
+		assert.Empty(t, mailsTo("muted@example.com"))
+	})
+
+	t.Run("Digest route batches events of its group", func(t *testing.T) {
+		ingest(first, now.Add(50*time.Second), 20)
+		ingest(second, now.Add(50*time.Second), 20)
+		assert.NoError(t, svc.EvaluateAlerts(now.Add(time.Minute)))
+		assert.NoError(t, svc.SendEmailNotifications(now.Add(time.Minute)))
+		assert.Empty(t, mailsTo("noc@example.com"))
+
+		pending, err := svc.GetEmailNotifications(EmailNotificationFilter{RouteID: noc.ID, Status: EmailPending})
+		assert.NoError(t, err)
+		assert.Len(t, pending, 2)
+		for _, notification := range pending {
+			assert.Equal(t, now.Add(5*time.Minute).UTC(), notification.SendAfter)
+		}
+
+		assert.NoError(t, svc.SendEmailNotifications(now.Add(5*time.Minute)))
+		mails := mailsTo("noc@example.com")
+		if !assert.Len(t, mails, 1) {
+			return
+		}
+		text := mailText(t, mails[0])
+		assert.Contains(t, text, "Subject: [noc digest] 2 alert notifications")
+		assert.Contains(t, text, "[firing] email latency")
+		assert.Contains(t, text, "[resolved] email latency")
+		assert.NotContains(t, text, "Agent:   "+strconv.Itoa(second))
+
+		sent, err := svc.GetEmailNotifications(EmailNotificationFilter{RouteID: noc.ID, Status: EmailSent})
+		assert.NoError(t, err)
+		assert.Len(t, sent, 2)
+	})
+
+	t.Run("Failed sends are retried with backoff", func(t *testing.T) {
+		server.Fail("554 mailbox unavailable")
+		ingest(first, now.Add(390*time.Second), 300)
+		at := now.Add(400 * time.Second)
+		assert.NoError(t, svc.EvaluateAlerts(at))
+		assert.ErrorContains(t, svc.SendEmailNotifications(at), "mailbox unavailable")
+
+		pending, err := svc.GetEmailNotifications(EmailNotificationFilter{RouteID: oncall.ID, Status: EmailPending})
+		assert.NoError(t, err)
+		if !assert.Len(t, pending, 1) {
+			return
+		}
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.Contains(t, *pending[0].LastError, "mailbox unavailable")
+		assert.Equal(t, at.Add(deliveryBackoff(1)).UTC(), pending[0].SendAfter)
+
+		// A new event goes out on its own while the failed one backs off
+		server.Fail("")
+		ingest(second, now.Add(395*time.Second), 300)
+		assert.NoError(t, svc.EvaluateAlerts(at.Add(time.Second)))
+		assert.NoError(t, svc.SendEmailNotifications(at.Add(time.Second)))
+		mails := mailsTo("oncall@example.com")
+		if !assert.Len(t, mails, 2) {
+			return
+		}
+		assert.Contains(t, mails[1].Data, "Subject: [firing] email latency on agent "+strconv.Itoa(second))
+
+		assert.NoError(t, svc.SendEmailNotifications(at.Add(deliveryBackoff(1))))
+		mails = mailsTo("oncall@example.com")
+		if !assert.Len(t, mails, 3) {
+			return
+		}
+		assert.Contains(t, mails[2].Data, "Subject: [firing] email latency on agent "+strconv.Itoa(first))
+	})
+
+	t.Run("Custom templates", func(t *testing.T) {
+		templates, err := notify.ParseTemplates("{{len .Events}} for {{.Recipient}}", "Route {{.Route}}", "")
+		assert.NoError(t, err)
+		custom := &Service{DB: db, Mailer: svc.Mailer, EmailTemplates: templates}
+
+		ingest(first, now.Add(590*time.Second), 20)
+		at := now.Add(10 * time.Minute)
+		assert.NoError(t, svc.EvaluateAlerts(at))
+		assert.NoError(t, custom.SendEmailNotifications(at.Add(5*time.Minute)))
+
+		mails := mailsTo("noc@example.com")
+		if !assert.Len(t, mails, 2) {
+			return
+		}
+		assert.Contains(t, mails[1].Data, "Subject: 2 for noc@example.com")
+		assert.Contains(t, mails[1].Data, "Route noc digest")
+	})
+
+	t.Run("No mailer", func(t *testing.T) {
+		ingest(first, now.Add(890*time.Second), 300)
+		at := now.Add(15 * time.Minute)
+		assert.NoError(t, svc.EvaluateAlerts(at))
+		assert.NoError(t, (&Service{DB: db}).SendEmailNotifications(at))
+
+		pending, err := svc.GetEmailNotifications(EmailNotificationFilter{RouteID: oncall.ID, Status: EmailPending})
+		assert.NoError(t, err)
+		assert.Len(t, pending, 1)
+
+		// They do not pile up
+		result, err := (&Service{DB: db}).ApplyRetention(at)
+		assert.NoError(t, err)
+		assert.Positive(t, result.EmailNotifications)
+		pending, err = svc.GetEmailNotifications(EmailNotificationFilter{RouteID: oncall.ID, Status: EmailPending})
+		assert.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+
+	t.Run("Invalid filters", func(t *testing.T) {
+		_, err := svc.CreateEmailRoute(EmailRouteRequest{
+			Name: "ghost", Recipient: "ghost@example.com", EventTypes: []string{EventAlertFiring}, RuleID: 999999,
+		})
+		assert.ErrorIs(t, err, ErrInvalidEmailRoute)
+		_, err = svc.UpdateEmailRoute(oncall.ID, EmailRouteRequest{
+			Name: "ghost", Recipient: "ghost@example.com", EventTypes: []string{EventAlertFiring}, GroupID: 999999,
+		})
+		assert.ErrorIs(t, err, ErrInvalidEmailRoute)
+		_, err = svc.GetEmailRoute(999999)
+		assert.ErrorIs(t, err, ErrEmailRouteNotFound)
+	})
+
+	t.Run("Deleting the rule removes its routes", func(t *testing.T) {
+		assert.NoError(t, svc.DeleteAlertRule(rule.ID))
+		_, err := svc.GetEmailRoute(oncall.ID)
+		assert.ErrorIs(t, err, ErrEmailRouteNotFound)
+		_, err = svc.GetEmailRoute(noc.ID)
+		assert.NoError(t, err)
+	})
+}
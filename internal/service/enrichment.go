@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+)
+
+// enrichment holds the results of the optional lookups run when an agent registers.
+// Lookups that are disabled or failed are nil, so the previously stored values are kept.
+type enrichment struct {
+	ptr        *string
+	ptrSource  enrich.Source
+	rdap       *enrich.Network
+	rdapSource enrich.Source
+	autnum     *enrich.AutNum // Registration of the AS announcing the address
+}
+
+// enrichAgent runs the optional reverse DNS and RDAP lookups of an agent address and its
+// AS. They are best effort: a server being down or slow must not prevent agents from
+// registering.
+func (s *Service) enrichAgent(ip, asn string) enrichment {
+	var result enrichment
+	ctx := context.Background()
+	if s.ReverseDNS != nil {
+		name, source, err := s.ReverseDNS.LookupSource(ctx, ip)
+		if err == nil {
+			result.ptr, result.ptrSource = &name, source
+		}
+	}
+	if s.RDAP != nil {
+		network, source, err := s.RDAP.LookupSource(ctx, ip)
+		if err == nil {
+			result.rdap, result.rdapSource = &network, source
+		}
+		number, err := ParseASN(asn)
+		if err == nil {
+			autnum, err := s.RDAP.LookupAutNum(ctx, number)
+			if err == nil {
+				result.autnum = &autnum
+			}
+		}
+	}
+	return result
+}
+
+// rdapColumns returns the values stored in the rdap_name, rdap_handle and rdap_abuse_email
+// columns, which are all NULL when the registration was not looked up
+func (e enrichment) rdapColumns() (name, handle, abuseEmail *string) {
+	if e.rdap == nil {
+		return nil, nil, nil
+	}
+	return &e.rdap.Name, &e.rdap.Handle, &e.rdap.AbuseEmail
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"github.com/Shaughny/obkio-test/internal/enrich/enrichtest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAgentEnrichment(t *testing.T) {
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "isp": "Google LLC", "as": "AS15169 Google LLC"}`))
+	}))
+	defer ipAPI.Close()
+	dns := enrichtest.NewDNSServer()
+	defer dns.Close()
+	rdap := enrichtest.NewRDAPServer()
+	defer rdap.Close()
+
+	dns.SetPTR("10.0.11.1", "agent-1.example.net")
+	google := enrich.Network{Name: "GOGL", Handle: "NET-10-0-11-0-1", AbuseEmail: "network-abuse@example.net"}
+	rdap.SetNetwork("10.0.11.1", google)
+
+	svc := &Service{
+		DB:         db,
+		IPInfoURL:  ipAPI.URL,
+		ReverseDNS: &enrich.ReverseDNS{Resolver: dns.Resolver(), Timeout: 200 * time.Millisecond, CacheTTL: -1},
+		RDAP:       &enrich.RDAP{BaseURL: rdap.URL, Timeout: time.Second, CacheTTL: -1},
+	}
+	register := func(ip string) DetailedAgentResponse {
+		t.Helper()
+		assert.NoError(t, svc.AddAgent(ip, nil))
+		var id int
+		assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = $1", ip).Scan(&id))
+		agent, err := svc.GetAgent(id)
+		assert.NoError(t, err)
+		return agent
+	}
+
+	agent := register("10.0.11.1")
+	defer svc.purgeAgent(agent.ID)
+
+	t.Run("PTR and registration are stored", func(t *testing.T) {
+		assert.Equal(t, "agent-1.example.net", agent.PTR)
+		if assert.NotNil(t, agent.RDAP) {
+			assert.Equal(t, google, *agent.RDAP)
+		}
+	})
+
+	t.Run("Lookups without results", func(t *testing.T) {
+		agent := register("10.0.11.2")
+		defer svc.purgeAgent(agent.ID)
+		assert.Empty(t, agent.PTR)
+		if assert.NotNil(t, agent.RDAP) {
+			assert.Equal(t, enrich.Network{}, *agent.RDAP)
+		}
+	})
+
+	t.Run("Disabled lookups", func(t *testing.T) {
+		plain := &Service{DB: db, IPInfoURL: ipAPI.URL}
+		assert.NoError(t, plain.AddAgent("10.0.11.3", nil))
+		var id int
+		assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = '10.0.11.3'").Scan(&id))
+		defer plain.purgeAgent(id)
+		agent, err := plain.GetAgent(id)
+		assert.NoError(t, err)
+		assert.Empty(t, agent.PTR)
+		assert.Nil(t, agent.RDAP)
+	})
+	t.Run("Failed lookups keep what was found before", func(t *testing.T) {
+		dns.Silence()
+		rdap.Fail(http.StatusServiceUnavailable)
+		agent := register("10.0.11.1")
+		assert.Equal(t, "agent-1.example.net", agent.PTR)
+		if assert.NotNil(t, agent.RDAP) {
+			assert.Equal(t, google, *agent.RDAP)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Types of events published to webhook subscribers. Alert events are also sent by email.
+const (
+	EventAgentCreated        = "agent.created"
+	EventAgentUpdated        = "agent.updated"
+	EventAgentDeleted        = "agent.deleted"
+	EventAgentISPChanged     = "agent.isp_changed"
+	EventAgentNetworkChanged = "agent.network_changed" // Hosting, proxy or mobile flag changed
+	EventAgentStateChanged   = "agent.state_changed"   // Lifecycle state changed
+	EventAlertFiring         = "alert.firing"
+	EventAlertResolved       = "alert.resolved"
+	EventWebhookTest         = "webhook.test" // Only sent by the test delivery endpoint
+)
+
+// Event is something that happened to an agent or an alert.
+type Event struct {
+	ID        int             `json:"id"`
+	Type      string          `json:"type"`
+	AgentID   *int            `json:"agent_id,omitempty"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// EventFilter narrows down the events listed.
+type EventFilter struct {
+	Type    string `query:"type" validate:"omitempty,oneof=agent.created agent.updated agent.deleted agent.isp_changed agent.network_changed agent.state_changed alert.firing alert.resolved webhook.test"`
+	AgentID int    `query:"agent_id" validate:"omitempty,gte=1"`
+	Limit   int    `query:"limit" validate:"omitempty,gte=1,lte=1000"` // Defaults to 100
+}
+
+// defaultEventLimit is how many events are listed when the filter sets no limit.
+const defaultEventLimit = 100
+
+// ISPChange is the data of an agent.isp_changed event.
+type ISPChange struct {
+	AgentID     int    `json:"agent_id"`
+	IPAddress   string `json:"ip_address"`
+	PreviousASN string `json:"previous_asn"`
+	PreviousISP string `json:"previous_isp"`
+	ASN         string `json:"asn"`
+	ISP         string `json:"isp"`
+}
+
+// GetEvents retrieves the events matching the filter, latest first.
+func (s *Service) GetEvents(filter EventFilter) ([]Event, error) {
+	query := "SELECT id, type, agent_id, data, created_at FROM events WHERE 1 = 1"
+	var args []any
+	if filter.Type != "" {
+		args = append(args, filter.Type)
+		query += fmt.Sprintf(" AND type = $%d", len(args))
+	}
+	if filter.AgentID != 0 {
+		args = append(args, filter.AgentID)
+		query += fmt.Sprintf(" AND agent_id = $%d", len(args))
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultEventLimit
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		var data string
+		err = rows.Scan(&event.ID, &event.Type, &event.AgentID, &data, &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		event.Data = json.RawMessage(data)
+		events = append(events, event)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return events, nil
+}
+
+// NetworkChange is the data of an agent.network_changed event.
+type NetworkChange struct {
+	AgentID   int          `json:"agent_id"`
+	IPAddress string       `json:"ip_address"`
+	Previous  NetworkFlags `json:"previous"`
+	Current   NetworkFlags `json:"current"`
+}
+
+// publishEvent records an event and queues a delivery for every enabled webhook
+// subscribed to its type. It runs in the caller's transaction so an event is
+// only published if the change it describes is committed.
+func publishEvent(tx dbtx, eventType string, agentID int, data any) error {
+	_, err := publishWebhookEvent(tx, eventType, agentID, data)
+	return err
+}
+
+// publishWebhookEvent is publishEvent returning the ID of the recorded event
+func publishWebhookEvent(tx dbtx, eventType string, agentID int, data any) (int, error) {
+	eventID, err := insertEvent(tx, eventType, agentID, data)
+	if err != nil {
+		return 0, err
+	}
+
+	subscriptions, err := querySubscriptions(tx, "SELECT "+subscriptionColumns+" FROM webhook_subscriptions WHERE enabled = 1")
+	if err != nil {
+		return 0, err
+	}
+	for _, subscription := range subscriptions {
+		if !subscription.wants(eventType) {
+			continue
+		}
+		_, err = queueDelivery(tx, subscription.ID, eventID, DeliveryPending, time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+	return eventID, nil
+}
+
+// insertEvent records an event and returns its ID
+func insertEvent(tx dbtx, eventType string, agentID int, data any) (int, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding event: %w", err)
+	}
+
+	var agent *int
+	if agentID != 0 {
+		agent = &agentID
+	}
+	res, err := tx.Exec("INSERT INTO events (type, agent_id, data) VALUES ($1, $2, $3)", eventType, agent, string(encoded))
+	if err != nil {
+		return 0, fmt.Errorf("error inserting event: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error reading event ID: %w", err)
+	}
+	return int(id), nil
+}
+
+// publishAlertEvent publishes the current state of an alert to webhooks and queues
+// it for the email routes it matches. While a silence or maintenance window matches
+// the alert, the event is recorded but nobody is notified.
+func publishAlertEvent(tx dbtx, eventType string, alertID int, now time.Time) error {
+	alert, err := scanAlert(tx.QueryRow(alertColumns+" WHERE a.id = $1", alertID))
+	if err != nil {
+		return fmt.Errorf("error fetching alert: %w", err)
+	}
+
+	alert.SuppressedBy, err = alertSuppression(tx, alert, now)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE alerts SET suppressed_by = $1 WHERE id = $2", alert.SuppressedBy, alert.ID)
+	if err != nil {
+		return fmt.Errorf("error recording alert suppression: %w", err)
+	}
+	if alert.SuppressedBy != nil {
+		_, err = insertEvent(tx, eventType, alert.AgentID, alert)
+		return err
+	}
+
+	eventID, err := publishWebhookEvent(tx, eventType, alert.AgentID, alert)
+	if err != nil {
+		return err
+	}
+	return queueEmails(tx, eventType, eventID, alert, now)
+}
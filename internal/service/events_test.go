@@ -0,0 +1,189 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestISPChangeEvents(t *testing.T) {
+	// Stand in for ip-api.com, answering with whatever ISP the agent is on at the moment
+	var mu sync.Mutex
+	asn, isp := "AS64500 Primary Fiber", "Primary Fiber"
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, `{"status": "success", "as": %q, "isp": %q}`, asn, isp)
+	}))
+	defer ipAPI.Close()
+	switchISP := func(newASN, newISP string) {
+		mu.Lock()
+		defer mu.Unlock()
+		asn, isp = newASN, newISP
+	}
+
+	svc := &Service{DB: db, IPInfoURL: ipAPI.URL}
+	assert.NoError(t, svc.AddAgent("10.0.9.1", nil))
+	var agentID int
+	assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = '10.0.9.1'").Scan(&agentID))
+	defer svc.purgeAgent(agentID)
+
+	rule, err := svc.CreateAlertRule(AlertRuleRequest{
+		Name: "failover", Type: AlertTypeISPChanged, Scope: AlertScopeAgent, ScopeID: agentID, WindowSeconds: 600,
+	})
+	assert.NoError(t, err)
+	defer svc.DeleteAlertRule(rule.ID)
+	assert.Equal(t, "gte", rule.Comparator)
+	assert.Equal(t, 1.0, rule.Threshold)
+
+	changes := func() []Event {
+		t.Helper()
+		events, err := svc.GetEvents(EventFilter{Type: EventAgentISPChanged, AgentID: agentID})
+		assert.NoError(t, err)
+		return events
+	}
+
+	t.Run("Re-enrichment with the same ISP", func(t *testing.T) {
+		assert.NoError(t, svc.AddAgent("10.0.9.1", nil))
+		assert.Empty(t, changes())
+
+		// Nothing changed, so subscribers are not told about it
+		events, err := svc.GetEvents(EventFilter{AgentID: agentID})
+		assert.NoError(t, err)
+		if !assert.Len(t, events, 1) {
+			return
+		}
+		assert.Equal(t, EventAgentCreated, events[0].Type)
+
+		assert.NoError(t, svc.AddAgent("10.0.9.1", map[string]string{"site": "mtl"}))
+		events, err = svc.GetEvents(EventFilter{AgentID: agentID})
+		assert.NoError(t, err)
+		if !assert.Len(t, events, 2) {
+			return
+		}
+		assert.Equal(t, EventAgentUpdated, events[0].Type)
+	})
+
+	t.Run("Failover to a backup link", func(t *testing.T) {
+		switchISP("AS64511 Backup LTE", "Backup LTE")
+		assert.NoError(t, svc.AddAgent("10.0.9.1", nil))
+
+		events := changes()
+		if !assert.Len(t, events, 1) {
+			return
+		}
+		var change ISPChange
+		assert.NoError(t, json.Unmarshal(events[0].Data, &change))
+		assert.Equal(t, ISPChange{
+			AgentID: agentID, IPAddress: "10.0.9.1",
+			PreviousASN: "AS64500", PreviousISP: "Primary Fiber", ASN: "AS64511", ISP: "Backup LTE",
+		}, change)
+	})
+
+	t.Run("ISP change alerts", func(t *testing.T) {
+		now := time.Now()
+		assert.NoError(t, svc.EvaluateAlerts(now))
+		alerts, err := svc.GetAlerts(AlertFilter{RuleID: rule.ID})
+		assert.NoError(t, err)
+		if !assert.Len(t, alerts, 1) {
+			return
+		}
+		assert.Equal(t, AlertFiring, alerts[0].State)
+		assert.Equal(t, 1.0, *alerts[0].Value)
+
+		// Resolves once the change falls out of the window
+		assert.NoError(t, svc.EvaluateAlerts(now.Add(11*time.Minute)))
+		alert, err := svc.GetAlert(alerts[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, AlertResolved, alert.State)
+	})
+
+	t.Run("Invalid ISP change rules", func(t *testing.T) {
+		zero := 0.0
+		_, err := svc.CreateAlertRule(AlertRuleRequest{
+			Name: "any change", Type: AlertTypeISPChanged, Scope: AlertScopeAll, Threshold: &zero,
+		})
+		assert.ErrorIs(t, err, ErrInvalidAlertRule)
+
+		_, err = svc.CreateAlertRule(AlertRuleRequest{
+			Name: "session", Type: AlertTypeISPChanged, Scope: AlertScopeSession, ScopeID: 1,
+		})
+		assert.ErrorIs(t, err, ErrInvalidAlertRule)
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		events, err := svc.GetEvents(EventFilter{AgentID: agentID, Limit: 1})
+		assert.NoError(t, err)
+		if !assert.Len(t, events, 1) {
+			return
+		}
+		assert.Equal(t, EventAlertResolved, events[0].Type)
+	})
+}
+
+func TestNetworkChangeEvents(t *testing.T) {
+	// Stand in for ip-api.com, flagging the agent as mobile once its office link goes down
+	var mu sync.Mutex
+	mobile := false
+	var fields string
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fields = r.URL.Query().Get("fields")
+		fmt.Fprintf(w, `{"status": "success", "as": "AS64500 Office ISP", "isp": "Office ISP", "hosting": false, "proxy": true, "mobile": %t}`, mobile)
+	}))
+	defer ipAPI.Close()
+
+	svc := &Service{DB: db, IPInfoURL: ipAPI.URL}
+	assert.NoError(t, svc.AddAgent("10.0.12.1", nil))
+	var agentID int
+	assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = '10.0.12.1'").Scan(&agentID))
+	defer svc.purgeAgent(agentID)
+	assert.Contains(t, fields, "hosting")
+
+	t.Run("Flags are stored", func(t *testing.T) {
+		agent, err := svc.GetAgent(agentID)
+		assert.NoError(t, err)
+		assert.Equal(t, NetworkFlags{Proxy: true}, agent.NetworkFlags)
+
+		yes, no := true, false
+		agents, err := svc.GetAgents(AgentFilter{Proxy: &yes, Mobile: &no})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{agentID}, ids(agents))
+
+		agents, err = svc.GetAgents(AgentFilter{Hosting: &yes})
+		assert.NoError(t, err)
+		assert.NotContains(t, ids(agents), agentID)
+	})
+
+	t.Run("Unchanged flags", func(t *testing.T) {
+		assert.NoError(t, svc.AddAgent("10.0.12.1", nil))
+		events, err := svc.GetEvents(EventFilter{Type: EventAgentNetworkChanged, AgentID: agentID})
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+	})
+
+	t.Run("Failover to a cellular link", func(t *testing.T) {
+		mu.Lock()
+		mobile = true
+		mu.Unlock()
+		assert.NoError(t, svc.AddAgent("10.0.12.1", nil))
+
+		events, err := svc.GetEvents(EventFilter{Type: EventAgentNetworkChanged, AgentID: agentID})
+		assert.NoError(t, err)
+		if !assert.Len(t, events, 1) {
+			return
+		}
+		var change NetworkChange
+		assert.NoError(t, json.Unmarshal(events[0].Data, &change))
+		assert.Equal(t, NetworkChange{
+			AgentID: agentID, IPAddress: "10.0.12.1",
+			Previous: NetworkFlags{Proxy: true}, Current: NetworkFlags{Proxy: true, Mobile: true},
+		}, change)
+	})
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"fmt"
+)
+
+// FeatureCollection is a GeoJSON (RFC 7946) collection of features.
+type FeatureCollection struct {
+	Type     string    `json:"type"` // Always FeatureCollection
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON feature locating one agent.
+type Feature struct {
+	Type       string                `json:"type"` // Always Feature
+	ID         int                   `json:"id"`
+	Geometry   Point                 `json:"geometry"`
+	Properties DetailedAgentResponse `json:"properties"`
+}
+
+// Point is a GeoJSON point. Coordinates are longitude then latitude.
+type Point struct {
+	Type        string     `json:"type"` // Always Point
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// GetAgentsGeoJSON exports the agents matching the filter as GeoJSON point features.
+// Agents whose location is unknown are left out.
+func (s *Service) GetAgentsGeoJSON(filter AgentFilter) (FeatureCollection, error) {
+	var nearby map[int]bool
+	if filter.Near != "" {
+		agents, err := s.GetAgents(filter)
+		if err != nil {
+			return FeatureCollection{}, err
+		}
+		nearby = make(map[int]bool, len(agents))
+		for _, agent := range agents {
+			nearby[agent.ID] = true
+		}
+	}
+
+	where, args, err := filter.where()
+	if err != nil {
+		return FeatureCollection{}, err
+	}
+	if where == "" {
+		where = " WHERE latitude IS NOT NULL AND longitude IS NOT NULL"
+	} else {
+		where += " AND latitude IS NOT NULL AND longitude IS NOT NULL"
+	}
+
+	rows, err := s.DB.Query(detailedAgentColumns+where+" ORDER BY id", args...)
+	if err != nil {
+		return FeatureCollection{}, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	collection := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	for rows.Next() {
+		agent, err := scanDetailedAgent(rows)
+		if err != nil {
+			return FeatureCollection{}, fmt.Errorf("error scanning row: %w", err)
+		}
+		if nearby != nil && !nearby[agent.ID] {
+			continue
+		}
+		collection.Features = append(collection.Features, Feature{
+			Type:       "Feature",
+			ID:         agent.ID,
+			Geometry:   Point{Type: "Point", Coordinates: [2]float64{*agent.Longitude, *agent.Latitude}},
+			Properties: agent,
+		})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return FeatureCollection{}, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return collection, nil
+}
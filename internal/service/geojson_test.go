@@ -0,0 +1,98 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAgentGeolocation(t *testing.T) {
+	// Stand in for ip-api.com with two located addresses and one it cannot locate
+	locations := map[string]string{
+		"10.0.10.1": `{"status": "success", "country": "Canada", "countryCode": "CA", "region": "QC", "regionName": "Quebec",
+			"city": "Montreal", "lat": 45.5019, "lon": -73.5674, "timezone": "America/Toronto", "isp": "Videotron", "as": "AS5769 Videotron Ltee"}`,
+		"10.0.10.2": `{"status": "success", "country": "Canada", "countryCode": "CA", "region": "ON", "regionName": "Ontario",
+			"city": "Toronto", "lat": 43.6532, "lon": -79.3832, "timezone": "America/Toronto", "isp": "Rogers", "as": "AS812 Rogers"}`,
+		"10.0.10.3": `{"status": "success", "isp": "Unknown", "as": "AS64500 Unknown"}`,
+	}
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(locations[strings.TrimPrefix(r.URL.Path, "/")]))
+	}))
+	defer ipAPI.Close()
+
+	svc := &Service{DB: db, IPInfoURL: ipAPI.URL}
+	ids := map[string]int{}
+	for ip := range locations {
+		assert.NoError(t, svc.AddAgent(ip, nil))
+		var id int
+		assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = $1", ip).Scan(&id))
+		ids[ip] = id
+		defer svc.purgeAgent(id)
+	}
+
+	t.Run("Location is stored", func(t *testing.T) {
+		agent, err := svc.GetAgent(ids["10.0.10.1"])
+		assert.NoError(t, err)
+		assert.Equal(t, "Canada", agent.Country)
+		assert.Equal(t, "CA", agent.CountryCode)
+		assert.Equal(t, "QC", agent.Region)
+		assert.Equal(t, "Quebec", agent.RegionName)
+		assert.Equal(t, "Montreal", agent.City)
+		assert.Equal(t, "America/Toronto", agent.Timezone)
+		if assert.NotNil(t, agent.Latitude) && assert.NotNil(t, agent.Longitude) {
+			assert.Equal(t, 45.5019, *agent.Latitude)
+			assert.Equal(t, -73.5674, *agent.Longitude)
+		}
+
+		unlocated, err := svc.GetAgent(ids["10.0.10.3"])
+		assert.NoError(t, err)
+		assert.Nil(t, unlocated.Latitude)
+		assert.Empty(t, unlocated.Country)
+	})
+
+	t.Run("Filter by country and region", func(t *testing.T) {
+		agents, err := svc.GetAgents(AgentFilter{Country: "ca"})
+		assert.NoError(t, err)
+		assert.Len(t, agents, 2)
+
+		agents, err = svc.GetAgents(AgentFilter{Country: "Canada", Region: "ontario"})
+		assert.NoError(t, err)
+		if assert.Len(t, agents, 1) {
+			assert.Equal(t, ids["10.0.10.2"], agents[0].ID)
+		}
+
+		agents, err = svc.GetAgents(AgentFilter{Region: "QC"})
+		assert.NoError(t, err)
+		if assert.Len(t, agents, 1) {
+			assert.Equal(t, ids["10.0.10.1"], agents[0].ID)
+		}
+	})
+
+	t.Run("GeoJSON export", func(t *testing.T) {
+		collection, err := svc.GetAgentsGeoJSON(AgentFilter{Country: "CA"})
+		assert.NoError(t, err)
+		assert.Equal(t, "FeatureCollection", collection.Type)
+		if !assert.Len(t, collection.Features, 2) {
+			return
+		}
+
+		montreal := collection.Features[0]
+		if montreal.ID != ids["10.0.10.1"] {
+			montreal = collection.Features[1]
+		}
+		encoded, err := json.Marshal(montreal)
+		assert.NoError(t, err)
+		assert.Contains(t, string(encoded), `"geometry":{"type":"Point","coordinates":[-73.5674,45.5019]}`)
+		assert.Contains(t, string(encoded), `"city":"Montreal"`)
+
+		// Agents without a location are left out
+		collection, err = svc.GetAgentsGeoJSON(AgentFilter{})
+		assert.NoError(t, err)
+		for _, feature := range collection.Features {
+			assert.NotEqual(t, ids["10.0.10.3"], feature.ID)
+		}
+	})
+}
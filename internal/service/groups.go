@@ -0,0 +1,639 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Topologies that decide which monitoring sessions a group provisions between its members.
+const (
+	TopologyFullMesh    = "full_mesh"     // Every member tests every other member
+	TopologyHubAndSpoke = "hub_and_spoke" // The hub and every other member test each other
+	TopologyCustom      = "custom"        // Only the listed links are tested
+)
+
+// AgentGroup is a set of agents whose monitoring sessions are provisioned automatically.
+type AgentGroup struct {
+	ID              int         `json:"id"`
+	Name            string      `json:"name"`
+	Topology        string      `json:"topology"`
+	HubAgentID      *int        `json:"hub_agent_id,omitempty"`
+	Protocol        string      `json:"protocol"`
+	IntervalSeconds int         `json:"interval_seconds"`
+	PacketSize      int         `json:"packet_size"`
+	MemberIDs       []int       `json:"member_ids"`
+	Links           []GroupLink `json:"links,omitempty"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+// GroupLink is a directed test between two members of a custom topology group.
+type GroupLink struct {
+	SourceAgentID      int `json:"source_agent_id" validate:"required,gte=1"`
+	DestinationAgentID int `json:"destination_agent_id" validate:"required,gte=1,nefield=SourceAgentID"`
+}
+
+// AgentGroupRequest defines the structure for creating or updating an agent group.
+// The protocol, interval and packet size apply to every session the group provisions.
+type AgentGroupRequest struct {
+	Name            string      `json:"name" validate:"required,max=100"`
+	Topology        string      `json:"topology" validate:"required,oneof=full_mesh hub_and_spoke custom"`
+	HubAgentID      *int        `json:"hub_agent_id" validate:"required_if=Topology hub_and_spoke,omitempty,gte=1"`
+	Protocol        string      `json:"protocol" validate:"required,oneof=udp tcp icmp"`
+	IntervalSeconds int         `json:"interval_seconds" validate:"required,gte=1,lte=3600"`
+	PacketSize      int         `json:"packet_size" validate:"required,gte=20,lte=9000"`
+	Links           []GroupLink `json:"links" validate:"omitempty,dive"` // Only used by the custom topology
+}
+
+// ReconcileResult reports the session changes made to bring a group in line with its topology.
+type ReconcileResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
+}
+
+// ErrGroupNotFound is returned when an agent group is not found in the database.
+var ErrGroupNotFound = errors.New("agent group not found")
+
+// ErrInvalidGroup is returned when an agent group request conflicts with existing data.
+var ErrInvalidGroup = errors.New("invalid agent group")
+
+// dbtx is implemented by both *sql.DB and *sql.Tx so helpers can run inside or outside a transaction
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// sessionPair identifies a directed test between two agents
+type sessionPair struct {
+	source, destination int
+}
+
+// CreateGroup creates an agent group. The hub of a hub-and-spoke group becomes its first member.
+func (s *Service) CreateGroup(req AgentGroupRequest) (AgentGroup, error) {
+	err := s.checkGroupRequest(0, req)
+	if err != nil {
+		return AgentGroup{}, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return AgentGroup{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+	INSERT INTO agent_groups (name, topology, hub_agent_id, protocol, interval_seconds, packet_size)
+	VALUES ($1, $2, $3, $4, $5, $6)`,
+		req.Name, req.Topology, req.HubAgentID, req.Protocol, req.IntervalSeconds, req.PacketSize,
+	)
+	if err != nil {
+		return AgentGroup{}, fmt.Errorf("error inserting agent group: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return AgentGroup{}, fmt.Errorf("error reading agent group ID: %w", err)
+	}
+
+	err = saveGroupTopology(tx, int(id), req)
+	if err != nil {
+		return AgentGroup{}, err
+	}
+	_, err = reconcileGroup(tx, int(id))
+	if err != nil {
+		return AgentGroup{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return AgentGroup{}, fmt.Errorf("error committing agent group: %w", err)
+	}
+	return s.GetGroup(int(id))
+}
+
+// GetGroups retrieves all agent groups with their members.
+func (s *Service) GetGroups() ([]AgentGroup, error) {
+	rows, err := s.DB.Query("SELECT id FROM agent_groups ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	groups := []AgentGroup{}
+	for _, id := range ids {
+		group, err := s.GetGroup(id)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// GetGroup retrieves an agent group with its members and custom links.
+func (s *Service) GetGroup(id int) (AgentGroup, error) {
+	var group AgentGroup
+	err := s.DB.QueryRow(`
+	SELECT id, name, topology, hub_agent_id, protocol, interval_seconds, packet_size, created_at, updated_at
+	FROM agent_groups WHERE id = $1`, id).Scan(
+		&group.ID, &group.Name, &group.Topology, &group.HubAgentID, &group.Protocol,
+		&group.IntervalSeconds, &group.PacketSize, &group.CreatedAt, &group.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return group, ErrGroupNotFound
+		}
+		return group, fmt.Errorf("error fetching agent group: %w", err)
+	}
+
+	group.MemberIDs, err = groupMembers(s.DB, id)
+	if err != nil {
+		return group, err
+	}
+	group.Links, err = groupLinks(s.DB, id)
+	if err != nil {
+		return group, err
+	}
+	return group, nil
+}
+
+// UpdateGroup replaces the settings of an agent group and reconciles its sessions.
+func (s *Service) UpdateGroup(id int, req AgentGroupRequest) (AgentGroup, error) {
+	err := s.checkGroupRequest(id, req)
+	if err != nil {
+		return AgentGroup{}, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return AgentGroup{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+	UPDATE agent_groups
+	SET name = $1, topology = $2, hub_agent_id = $3, protocol = $4, interval_seconds = $5,
+	    packet_size = $6, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $7`,
+		req.Name, req.Topology, req.HubAgentID, req.Protocol, req.IntervalSeconds, req.PacketSize, id,
+	)
+	if err != nil {
+		return AgentGroup{}, fmt.Errorf("error updating agent group: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return AgentGroup{}, fmt.Errorf("error updating agent group: %w", err)
+	}
+	if updated == 0 {
+		return AgentGroup{}, ErrGroupNotFound
+	}
+
+	err = saveGroupTopology(tx, id, req)
+	if err != nil {
+		return AgentGroup{}, err
+	}
+	_, err = reconcileGroup(tx, id)
+	if err != nil {
+		return AgentGroup{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return AgentGroup{}, fmt.Errorf("error committing agent group: %w", err)
+	}
+	return s.GetGroup(id)
+}
+
+// DeleteGroup removes an agent group together with the sessions it provisioned, the alert
+// rules scoped to it and the email routes, silences and maintenance windows matching on it.
+func (s *Service) DeleteGroup(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM agent_groups WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting agent group: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting agent group: %w", err)
+	}
+	if deleted == 0 {
+		return ErrGroupNotFound
+	}
+
+	// Members lose the group's sessions and configuration override
+	err = bumpConfigVersions(tx, "SELECT agent_id FROM agent_group_members WHERE group_id = $1", id)
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM monitoring_sessions WHERE group_id = $1",
+		"DELETE FROM agent_group_members WHERE group_id = $1",
+		"DELETE FROM agent_group_links WHERE group_id = $1",
+		"DELETE FROM config_overrides WHERE scope = 'group' AND scope_id = $1",
+		`DELETE FROM alert_history WHERE alert_id IN (
+		 SELECT id FROM alerts WHERE rule_id IN (SELECT id FROM alert_rules WHERE scope = 'group' AND scope_id = $1))`,
+		"DELETE FROM alerts WHERE rule_id IN (SELECT id FROM alert_rules WHERE scope = 'group' AND scope_id = $1)",
+		`DELETE FROM email_notifications WHERE route_id IN (
+		 SELECT id FROM email_routes WHERE group_id = $1 OR rule_id IN (SELECT id FROM alert_rules WHERE scope = 'group' AND scope_id = $1))`,
+		"DELETE FROM email_routes WHERE group_id = $1 OR rule_id IN (SELECT id FROM alert_rules WHERE scope = 'group' AND scope_id = $1)",
+		"DELETE FROM alert_rules WHERE scope = 'group' AND scope_id = $1",
+		"DELETE FROM silences WHERE group_id = $1",
+		"DELETE FROM maintenance_windows WHERE group_id = $1",
+	} {
+		_, err = tx.Exec(query, id)
+		if err != nil {
+			return fmt.Errorf("error deleting agent group: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing agent group deletion: %w", err)
+	}
+	return nil
+}
+
+// AddGroupMember adds an agent to a group and provisions its sessions. Adding an existing member is a no-op.
+func (s *Service) AddGroupMember(groupID, agentID int) (ReconcileResult, error) {
+	err := s.agentExists(agentID)
+	if err != nil {
+		return ReconcileResult{}, err
+	}
+
+	return s.updateGroupMembers(groupID, func(tx *sql.Tx) error {
+		res, err := tx.Exec("INSERT OR IGNORE INTO agent_group_members (group_id, agent_id) VALUES ($1, $2)", groupID, agentID)
+		if err != nil {
+			return err
+		}
+		return bumpMemberConfigVersion(tx, res, agentID)
+	})
+}
+
+// RemoveGroupMember removes an agent from a group and tears down its sessions. Removing a non-member is a no-op.
+func (s *Service) RemoveGroupMember(groupID, agentID int) (ReconcileResult, error) {
+	return s.updateGroupMembers(groupID, func(tx *sql.Tx) error {
+		res, err := tx.Exec("DELETE FROM agent_group_members WHERE group_id = $1 AND agent_id = $2", groupID, agentID)
+		if err != nil {
+			return err
+		}
+		return bumpMemberConfigVersion(tx, res, agentID)
+	})
+}
+
+// ReconcileGroup creates, updates and deletes the group's sessions to match its topology and members.
+// It is idempotent: reconciling a group that is already in line changes nothing.
+func (s *Service) ReconcileGroup(groupID int) (ReconcileResult, error) {
+	return s.updateGroupMembers(groupID, func(tx *sql.Tx) error { return nil })
+}
+
+// updateGroupMembers applies a membership change and reconciles the group in one transaction
+func (s *Service) updateGroupMembers(groupID int, change func(tx *sql.Tx) error) (ReconcileResult, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return ReconcileResult{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = change(tx)
+	if err != nil {
+		return ReconcileResult{}, fmt.Errorf("error updating group members: %w", err)
+	}
+	result, err := reconcileGroup(tx, groupID)
+	if err != nil {
+		return result, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return result, fmt.Errorf("error committing group reconciliation: %w", err)
+	}
+	return result, nil
+}
+
+// bumpMemberConfigVersion bumps the configuration version of an agent that joined or left
+// a group, since the group's configuration override stops or starts applying to it
+func bumpMemberConfigVersion(tx dbtx, res sql.Result, agentID int) error {
+	changed, err := res.RowsAffected()
+	if err != nil || changed == 0 {
+		return err
+	}
+	return bumpConfigVersions(tx, "SELECT $1", agentID)
+}
+
+// groupExists returns ErrGroupNotFound if no agent group has the given ID
+func (s *Service) groupExists(groupID int) error {
+	var id int
+	err := s.DB.QueryRow("SELECT id FROM agent_groups WHERE id = $1", groupID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrGroupNotFound
+		}
+		return fmt.Errorf("error checking agent group: %w", err)
+	}
+	return nil
+}
+
+// reconcileGroup brings the sessions provisioned by a group in line with its topology
+func reconcileGroup(tx dbtx, groupID int) (ReconcileResult, error) {
+	var result ReconcileResult
+
+	var group AgentGroup
+	err := tx.QueryRow(`
+	SELECT id, topology, hub_agent_id, protocol, interval_seconds, packet_size FROM agent_groups WHERE id = $1`,
+		groupID).Scan(&group.ID, &group.Topology, &group.HubAgentID, &group.Protocol, &group.IntervalSeconds, &group.PacketSize)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return result, ErrGroupNotFound
+		}
+		return result, fmt.Errorf("error fetching agent group: %w", err)
+	}
+	group.MemberIDs, err = groupMembers(tx, groupID)
+	if err != nil {
+		return result, err
+	}
+	group.Links, err = groupLinks(tx, groupID)
+	if err != nil {
+		return result, err
+	}
+	desired := desiredPairs(group)
+
+	// Compare with the sessions the group already owns
+	rows, err := tx.Query(`
+	SELECT id, source_agent_id, destination_agent_id, protocol, interval_seconds, packet_size
+	FROM monitoring_sessions WHERE group_id = $1`, groupID)
+	if err != nil {
+		return result, fmt.Errorf("error fetching group sessions: %w", err)
+	}
+	existing := make(map[sessionPair]bool)
+	var stale, outdated []int
+	for rows.Next() {
+		var id, intervalSeconds, packetSize int
+		var pair sessionPair
+		var protocol string
+		err = rows.Scan(&id, &pair.source, &pair.destination, &protocol, &intervalSeconds, &packetSize)
+		if err != nil {
+			rows.Close()
+			return result, fmt.Errorf("error scanning group session: %w", err)
+		}
+		switch {
+		case !desired[pair] || existing[pair]:
+			stale = append(stale, id)
+		case protocol != group.Protocol || intervalSeconds != group.IntervalSeconds || packetSize != group.PacketSize:
+			outdated = append(outdated, id)
+			existing[pair] = true
+		default:
+			existing[pair] = true
+		}
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return result, fmt.Errorf("error iterating group sessions: %w", err)
+	}
+
+	for _, id := range stale {
+		err = bumpConfigVersions(tx, sessionAgents, id)
+		if err != nil {
+			return result, err
+		}
+		_, err = tx.Exec("DELETE FROM monitoring_sessions WHERE id = $1", id)
+		if err != nil {
+			return result, fmt.Errorf("error deleting group session: %w", err)
+		}
+		result.Deleted++
+	}
+	for _, id := range outdated {
+		err = bumpConfigVersions(tx, sessionAgents, id)
+		if err != nil {
+			return result, err
+		}
+		_, err = tx.Exec(`
+		UPDATE monitoring_sessions SET protocol = $1, interval_seconds = $2, packet_size = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4`, group.Protocol, group.IntervalSeconds, group.PacketSize, id)
+		if err != nil {
+			return result, fmt.Errorf("error updating group session: %w", err)
+		}
+		result.Updated++
+	}
+
+	// Create missing sessions in a stable order
+	missing := make([]sessionPair, 0)
+	for pair := range desired {
+		if !existing[pair] {
+			missing = append(missing, pair)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].source != missing[j].source {
+			return missing[i].source < missing[j].source
+		}
+		return missing[i].destination < missing[j].destination
+	})
+	for _, pair := range missing {
+		_, err = tx.Exec(`
+		INSERT INTO monitoring_sessions (source_agent_id, destination_agent_id, protocol, interval_seconds, packet_size, group_id)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+			pair.source, pair.destination, group.Protocol, group.IntervalSeconds, group.PacketSize, groupID,
+		)
+		if err != nil {
+			return result, fmt.Errorf("error inserting group session: %w", err)
+		}
+		err = bumpConfigVersions(tx, "SELECT $1 UNION SELECT $2", pair.source, pair.destination)
+		if err != nil {
+			return result, err
+		}
+		result.Created++
+	}
+
+	return result, nil
+}
+
+// desiredPairs returns the directed sessions a group's topology calls for between its members
+func desiredPairs(group AgentGroup) map[sessionPair]bool {
+	members := make(map[int]bool, len(group.MemberIDs))
+	for _, id := range group.MemberIDs {
+		members[id] = true
+	}
+
+	pairs := make(map[sessionPair]bool)
+	switch group.Topology {
+	case TopologyFullMesh:
+		for _, source := range group.MemberIDs {
+			for _, destination := range group.MemberIDs {
+				if source != destination {
+					pairs[sessionPair{source, destination}] = true
+				}
+			}
+		}
+	case TopologyHubAndSpoke:
+		if group.HubAgentID == nil || !members[*group.HubAgentID] {
+			break
+		}
+		hub := *group.HubAgentID
+		for _, spoke := range group.MemberIDs {
+			if spoke != hub {
+				pairs[sessionPair{hub, spoke}] = true
+				pairs[sessionPair{spoke, hub}] = true
+			}
+		}
+	case TopologyCustom:
+		for _, link := range group.Links {
+			if members[link.SourceAgentID] && members[link.DestinationAgentID] && link.SourceAgentID != link.DestinationAgentID {
+				pairs[sessionPair{link.SourceAgentID, link.DestinationAgentID}] = true
+			}
+		}
+	}
+	return pairs
+}
+
+// saveGroupTopology stores the custom links of a group and makes sure its hub is a member
+func saveGroupTopology(tx dbtx, groupID int, req AgentGroupRequest) error {
+	_, err := tx.Exec("DELETE FROM agent_group_links WHERE group_id = $1", groupID)
+	if err != nil {
+		return fmt.Errorf("error clearing group links: %w", err)
+	}
+	if req.Topology == TopologyCustom {
+		for _, link := range req.Links {
+			_, err = tx.Exec(`
+			INSERT OR IGNORE INTO agent_group_links (group_id, source_agent_id, destination_agent_id) VALUES ($1, $2, $3)`,
+				groupID, link.SourceAgentID, link.DestinationAgentID,
+			)
+			if err != nil {
+				return fmt.Errorf("error inserting group link: %w", err)
+			}
+		}
+	}
+
+	if req.Topology == TopologyHubAndSpoke && req.HubAgentID != nil {
+		res, err := tx.Exec(
+			"INSERT OR IGNORE INTO agent_group_members (group_id, agent_id) VALUES ($1, $2)", groupID, *req.HubAgentID,
+		)
+		if err != nil {
+			return fmt.Errorf("error adding hub to group: %w", err)
+		}
+		err = bumpMemberConfigVersion(tx, res, *req.HubAgentID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkGroupRequest makes sure the group name is free and every referenced agent exists
+func (s *Service) checkGroupRequest(groupID int, req AgentGroupRequest) error {
+	var existingID int
+	err := s.DB.QueryRow("SELECT id FROM agent_groups WHERE name = $1", req.Name).Scan(&existingID)
+	if err == nil && existingID != groupID {
+		return fmt.Errorf("%w: name %q is already taken", ErrInvalidGroup, req.Name)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error checking group name: %w", err)
+	}
+
+	agentIDs := make([]int, 0, 1+2*len(req.Links))
+	if req.HubAgentID != nil {
+		agentIDs = append(agentIDs, *req.HubAgentID)
+	}
+	for _, link := range req.Links {
+		agentIDs = append(agentIDs, link.SourceAgentID, link.DestinationAgentID)
+	}
+	for _, agentID := range agentIDs {
+		err = s.agentExists(agentID)
+		if errors.Is(err, ErrAgentNotFound) {
+			return fmt.Errorf("%w: agent %d not found", ErrInvalidGroup, agentID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupMembers returns the IDs of a group's members that are not deleted in ascending order
+func groupMembers(tx dbtx, groupID int) ([]int, error) {
+	return queryIDs(tx, `
+	SELECT m.agent_id FROM agent_group_members m JOIN agents a ON a.id = m.agent_id
+	WHERE m.group_id = $1 AND a.deleted_at IS NULL ORDER BY m.agent_id`, groupID)
+}
+
+// agentGroupIDs returns the IDs of the groups an agent belongs to or is linked in
+func agentGroupIDs(tx dbtx, agentID int) ([]int, error) {
+	return queryIDs(tx, `
+	SELECT group_id FROM agent_group_members WHERE agent_id = $1
+	UNION SELECT id FROM agent_groups WHERE hub_agent_id = $1
+	ORDER BY 1`, agentID)
+}
+
+func groupLinks(tx dbtx, groupID int) ([]GroupLink, error) {
+	rows, err := tx.Query(`
+	SELECT source_agent_id, destination_agent_id FROM agent_group_links
+	WHERE group_id = $1 ORDER BY source_agent_id, destination_agent_id`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching group links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []GroupLink
+	for rows.Next() {
+		var link GroupLink
+		err = rows.Scan(&link.SourceAgentID, &link.DestinationAgentID)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning group link: %w", err)
+		}
+		links = append(links, link)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating group links: %w", err)
+	}
+	return links, nil
+}
+
+// queryIDs runs a query returning a single integer column
+func queryIDs(tx dbtx, query string, args ...any) ([]int, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return ids, nil
+}
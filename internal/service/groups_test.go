@@ -0,0 +1,151 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// countGroupSessions returns the number of sessions provisioned by a group
+func countGroupSessions(t *testing.T, groupID int) int {
+	t.Helper()
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM monitoring_sessions WHERE group_id = ?", groupID).Scan(&count)
+	assert.NoError(t, err)
+	return count
+}
+
+func TestAgentGroups(t *testing.T) {
+	svc := &Service{DB: db}
+	agents := []int{
+		insertTestAgent(t, "10.0.3.1"), insertTestAgent(t, "10.0.3.2"),
+		insertTestAgent(t, "10.0.3.3"), insertTestAgent(t, "10.0.3.4"),
+	}
+
+	request := AgentGroupRequest{Name: "mesh", Topology: TopologyFullMesh, Protocol: "udp", IntervalSeconds: 10, PacketSize: 64}
+	group, err := svc.CreateGroup(request)
+	assert.NoError(t, err)
+
+	t.Run("Provisions full mesh as members join", func(t *testing.T) {
+		for _, agentID := range agents[:3] {
+			_, err := svc.AddGroupMember(group.ID, agentID)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, 6, countGroupSessions(t, group.ID))
+
+		result, err := svc.AddGroupMember(group.ID, agents[3])
+		assert.NoError(t, err)
+		assert.Equal(t, ReconcileResult{Created: 6}, result)
+		assert.Equal(t, 12, countGroupSessions(t, group.ID))
+	})
+
+	t.Run("Reconciliation is idempotent", func(t *testing.T) {
+		result, err := svc.ReconcileGroup(group.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, ReconcileResult{}, result)
+
+		result, err = svc.AddGroupMember(group.ID, agents[3])
+		assert.NoError(t, err)
+		assert.Equal(t, ReconcileResult{}, result)
+	})
+
+	t.Run("Tears down sessions of leaving members", func(t *testing.T) {
+		result, err := svc.RemoveGroupMember(group.ID, agents[3])
+		assert.NoError(t, err)
+		assert.Equal(t, ReconcileResult{Deleted: 6}, result)
+		assert.Equal(t, 6, countGroupSessions(t, group.ID))
+	})
+
+	t.Run("Switches to hub and spoke", func(t *testing.T) {
+		update := request
+		update.Topology = TopologyHubAndSpoke
+		update.HubAgentID = &agents[0]
+		update.IntervalSeconds = 30
+		updated, err := svc.UpdateGroup(group.ID, update)
+		assert.NoError(t, err)
+		assert.Equal(t, agents[:3], updated.MemberIDs)
+
+		// Only hub <-> spoke sessions remain, with the new interval
+		assert.Equal(t, 4, countGroupSessions(t, group.ID))
+		var stale int
+		err = db.QueryRow("SELECT COUNT(*) FROM monitoring_sessions WHERE group_id = ? AND interval_seconds <> 30", group.ID).Scan(&stale)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, stale)
+	})
+
+	t.Run("Custom topology only provisions listed links", func(t *testing.T) {
+		update := request
+		update.Topology = TopologyCustom
+		update.Links = []GroupLink{
+			{SourceAgentID: agents[1], DestinationAgentID: agents[2]},
+			{SourceAgentID: agents[1], DestinationAgentID: agents[3]}, // Not a member
+		}
+		_, err := svc.UpdateGroup(group.ID, update)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, countGroupSessions(t, group.ID))
+	})
+
+	t.Run("Deleting an agent tears down its sessions", func(t *testing.T) {
+		update := request
+		_, err := svc.UpdateGroup(group.ID, update)
+		assert.NoError(t, err)
+		assert.Equal(t, 6, countGroupSessions(t, group.ID))
+
+		assert.NoError(t, svc.DeleteAgent(agents[2]))
+		assert.Equal(t, 2, countGroupSessions(t, group.ID))
+
+		updated, err := svc.GetGroup(group.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, agents[:2], updated.MemberIDs)
+	})
+
+	t.Run("Restoring an agent brings back its sessions", func(t *testing.T) {
+		_, err := svc.RestoreAgent(agents[2])
+		assert.NoError(t, err)
+		assert.Equal(t, 6, countGroupSessions(t, group.ID))
+
+		updated, err := svc.GetGroup(group.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, agents[:3], updated.MemberIDs)
+	})
+
+	t.Run("Purging a deleted agent removes its membership", func(t *testing.T) {
+		assert.NoError(t, svc.DeleteAgent(agents[2]))
+
+		purger := &Service{DB: db, PurgeAfter: time.Hour}
+		purged, err := purger.PurgeDeletedAgents(time.Now().Add(2 * time.Hour))
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, purged, 1)
+		assert.Equal(t, 2, countGroupSessions(t, group.ID))
+
+		var memberships int
+		err = db.QueryRow("SELECT COUNT(*) FROM agent_group_members WHERE agent_id = ?", agents[2]).Scan(&memberships)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, memberships)
+	})
+
+	t.Run("Rejects duplicate name", func(t *testing.T) {
+		_, err := svc.CreateGroup(request)
+		assert.ErrorIs(t, err, ErrInvalidGroup)
+	})
+
+	t.Run("Deletes group and its sessions", func(t *testing.T) {
+		rule, err := svc.CreateAlertRule(AlertRuleRequest{
+			Name: "mesh offline", Type: AlertTypeAgentOffline, Scope: AlertScopeGroup, ScopeID: group.ID, WindowSeconds: 60,
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, svc.EvaluateAlerts(time.Now().Add(time.Hour)))
+		alerts, err := svc.GetAlerts(AlertFilter{RuleID: rule.ID})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, alerts)
+
+		assert.NoError(t, svc.DeleteGroup(group.ID))
+		assert.Equal(t, 0, countGroupSessions(t, group.ID))
+		_, err = svc.GetAlertRule(rule.ID)
+		assert.ErrorIs(t, err, ErrAlertRuleNotFound)
+		alerts, err = svc.GetAlerts(AlertFilter{RuleID: rule.ID})
+		assert.NoError(t, err)
+		assert.Empty(t, alerts)
+		assert.ErrorIs(t, svc.DeleteGroup(group.ID), ErrGroupNotFound)
+	})
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shaughny/obkio-test/internal/labels"
+	"strings"
+)
+
+// AgentPatchRequest changes some details of an agent. Labels set to null are removed,
+// the others are set; labels left out are kept.
+type AgentPatchRequest struct {
+	Labels map[string]*string `json:"labels"`
+	SiteID *int               `json:"site_id" validate:"omitempty,gte=0"` // Site the agent is moved to; 0 removes it from its site
+}
+
+// ErrInvalidLabels is returned when an agent label has an invalid key or value.
+var ErrInvalidLabels = errors.New("invalid labels")
+
+// PatchAgent sets or removes labels of an agent and assigns it to a site.
+func (s *Service) PatchAgent(id int, req AgentPatchRequest) (DetailedAgentResponse, error) {
+	set := map[string]string{}
+	var removed []string
+	for key, value := range req.Labels {
+		if value == nil {
+			removed = append(removed, key)
+			continue
+		}
+		set[key] = *value
+	}
+	err := labels.Validate(set)
+	if err != nil {
+		return DetailedAgentResponse{}, fmt.Errorf("%w: %v", ErrInvalidLabels, err)
+	}
+	err = s.agentExists(id)
+	if err != nil {
+		return DetailedAgentResponse{}, err
+	}
+	if req.SiteID != nil && *req.SiteID != 0 {
+		err = s.siteExists(*req.SiteID)
+		if errors.Is(err, ErrSiteNotFound) {
+			return DetailedAgentResponse{}, fmt.Errorf("%w: site %d not found", ErrInvalidSite, *req.SiteID)
+		}
+		if err != nil {
+			return DetailedAgentResponse{}, err
+		}
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return DetailedAgentResponse{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, key := range removed {
+		_, err = tx.Exec("DELETE FROM agent_labels WHERE agent_id = $1 AND key = $2", id, key)
+		if err != nil {
+			return DetailedAgentResponse{}, fmt.Errorf("error removing label: %w", err)
+		}
+	}
+	err = setAgentLabels(tx, id, set)
+	if err != nil {
+		return DetailedAgentResponse{}, err
+	}
+	if req.SiteID != nil {
+		_, err = tx.Exec("UPDATE agents SET site_id = NULLIF($1, 0) WHERE id = $2", *req.SiteID, id)
+		if err != nil {
+			return DetailedAgentResponse{}, fmt.Errorf("error assigning site: %w", err)
+		}
+	}
+
+	return s.commitAgentChange(tx, id)
+}
+
+// setAgentLabels sets labels of an agent, keeping the others
+func setAgentLabels(tx dbtx, agentID int, set map[string]string) error {
+	for key, value := range set {
+		_, err := tx.Exec(`
+		INSERT INTO agent_labels (agent_id, key, value) VALUES ($1, $2, $3)
+		ON CONFLICT(agent_id, key) DO UPDATE SET value = EXCLUDED.value`,
+			agentID, key, value,
+		)
+		if err != nil {
+			return fmt.Errorf("error storing label: %w", err)
+		}
+	}
+	return nil
+}
+
+// queryAgentLabels returns the labels of an agent, nil when it has none
+func queryAgentLabels(tx dbtx, agentID int) (map[string]string, error) {
+	rows, err := tx.Query("SELECT key, value FROM agent_labels WHERE agent_id = $1", agentID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying labels: %w", err)
+	}
+	defer rows.Close()
+
+	var set map[string]string
+	for rows.Next() {
+		var key, value string
+		err = rows.Scan(&key, &value)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning label: %w", err)
+		}
+		if set == nil {
+			set = map[string]string{}
+		}
+		set[key] = value
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating labels: %w", err)
+	}
+	return set, nil
+}
+
+// labelCondition builds the condition an agent's labels meet a selector requirement with,
+// appending its arguments. Negative requirements also match agents without the label.
+func labelCondition(r labels.Requirement, args []any) (string, []any) {
+	args = append(args, r.Key)
+	condition := fmt.Sprintf("SELECT 1 FROM agent_labels l WHERE l.agent_id = agents.id AND l.key = $%d", len(args))
+	if len(r.Values) > 0 {
+		placeholders := make([]string, len(r.Values))
+		for i, value := range r.Values {
+			args = append(args, value)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		condition += " AND l.value IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	switch r.Operator {
+	case labels.NotEquals, labels.NotIn, labels.DoesNotExist:
+		return "NOT EXISTS (" + condition + ")", args
+	}
+	return "EXISTS (" + condition + ")", args
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestAgentLabels(t *testing.T) {
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "isp": "Videotron", "as": "AS5769 Videotron"}`))
+	}))
+	defer ipAPI.Close()
+	svc := &Service{DB: db, IPInfoURL: ipAPI.URL}
+
+	register := func(ip string, set map[string]string) int {
+		t.Helper()
+		assert.NoError(t, svc.AddAgent(ip, set))
+		var id int
+		assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = $1", ip).Scan(&id))
+		return id
+	}
+	mtl := register("10.0.16.1", map[string]string{"env": "prod", "site": "mtl", "test": "labels"})
+	defer svc.purgeAgent(mtl)
+	tor := register("10.0.16.2", map[string]string{"env": "prod", "site": "tor", "test": "labels"})
+	defer svc.purgeAgent(tor)
+	old := register("10.0.16.3", map[string]string{"env": "staging", "site": "mtl", "decommissioned": "", "test": "labels"})
+	defer svc.purgeAgent(old)
+
+	// Other tests' agents have no test label
+	selected := func(selector string) []int {
+		t.Helper()
+		agents, err := svc.GetAgents(AgentFilter{Selector: "test=labels," + selector})
+		assert.NoError(t, err)
+		found := ids(agents)
+		slices.Sort(found)
+		return found
+	}
+
+	t.Run("Selectors", func(t *testing.T) {
+		assert.Equal(t, []int{mtl, tor}, selected("env=prod"))
+		assert.Equal(t, []int{mtl, tor}, selected("site in (mtl,tor),!decommissioned"))
+		assert.Equal(t, []int{old}, selected("env!=prod"))
+		assert.Equal(t, []int{tor}, selected("site notin (mtl)"))
+		assert.Equal(t, []int{old}, selected("decommissioned"))
+		assert.Empty(t, selected("customer"))
+
+		_, err := svc.GetAgents(AgentFilter{Selector: "site in (mtl"})
+		assert.ErrorIs(t, err, ErrInvalidAgentFilter)
+	})
+
+	t.Run("Registering again keeps other labels", func(t *testing.T) {
+		register("10.0.16.1", map[string]string{"customer": "acme"})
+		agent, err := svc.GetAgent(mtl)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod", "site": "mtl", "test": "labels", "customer": "acme"}, agent.Labels)
+	})
+
+	t.Run("Patch sets and removes labels", func(t *testing.T) {
+		staging := "staging"
+		agent, err := svc.PatchAgent(tor, AgentPatchRequest{Labels: map[string]*string{"env": &staging, "site": nil}})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "staging", "test": "labels"}, agent.Labels)
+		assert.Equal(t, []int{tor}, selected("env=staging,!site"))
+	})
+
+	t.Run("Invalid labels", func(t *testing.T) {
+		assert.ErrorIs(t, svc.AddAgent("10.0.16.4", map[string]string{"env": "prod ready"}), ErrInvalidLabels)
+
+		bad := "x"
+		_, err := svc.PatchAgent(tor, AgentPatchRequest{Labels: map[string]*string{"-env": &bad}})
+		assert.ErrorIs(t, err, ErrInvalidLabels)
+		_, err = svc.PatchAgent(999999, AgentPatchRequest{})
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+	})
+}
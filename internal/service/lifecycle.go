@@ -0,0 +1,155 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Lifecycle states of an agent.
+const (
+	AgentStatePending        = "pending"        // Registered, waiting for its first report
+	AgentStateActive         = "active"         // Reporting and monitored
+	AgentStatePaused         = "paused"         // Monitoring suspended, for instance during a move, and not alerted on
+	AgentStateDecommissioned = "decommissioned" // Retired, left out of default listings and alerting
+	AgentStateArchived       = "archived"       // Decommissioned for good, only kept for its history
+)
+
+// agentStateTransitions are the states an agent can move to from each state.
+var agentStateTransitions = map[string][]string{
+	AgentStatePending:        {AgentStateActive, AgentStateDecommissioned},
+	AgentStateActive:         {AgentStatePaused, AgentStateDecommissioned},
+	AgentStatePaused:         {AgentStateActive, AgentStateDecommissioned},
+	AgentStateDecommissioned: {AgentStateActive, AgentStateArchived},
+	AgentStateArchived:       {},
+}
+
+// retiredAgentStates lists, as SQL, the states of agents left out of default listings
+// and alerting
+const retiredAgentStates = "('" + AgentStateDecommissioned + "', '" + AgentStateArchived + "')"
+
+// listedAgents is the condition agents in default listings meet: they are neither
+// deleted, decommissioned nor archived
+const listedAgents = "(deleted_at IS NULL AND state NOT IN " + retiredAgentStates + ")"
+
+// alertableAgents is the condition agents alert rules are evaluated against: listed
+// agents that are not paused
+const alertableAgents = "(" + listedAgents + " AND state <> '" + AgentStatePaused + "')"
+
+// AgentStateRequest moves an agent to another lifecycle state.
+type AgentStateRequest struct {
+	State  string `json:"state" validate:"required,oneof=pending active paused decommissioned archived"`
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// AgentStateTransition is a lifecycle state change recorded in an agent's history.
+// It is also the data of agent.state_changed events.
+type AgentStateTransition struct {
+	AgentID int       `json:"agent_id"`
+	From    string    `json:"from,omitempty"` // Empty when the agent was registered
+	To      string    `json:"to"`
+	Reason  string    `json:"reason"`
+	At      time.Time `json:"at"`
+}
+
+// ErrInvalidTransition is returned when an agent cannot move from its state to the one requested.
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// SetAgentState moves an agent to another lifecycle state, recording why.
+func (s *Service) SetAgentState(id int, req AgentStateRequest) (DetailedAgentResponse, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return DetailedAgentResponse{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var state string
+	err = tx.QueryRow("SELECT state FROM agents WHERE id = $1 AND deleted_at IS NULL", id).Scan(&state)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DetailedAgentResponse{}, ErrAgentNotFound
+		}
+		return DetailedAgentResponse{}, fmt.Errorf("error fetching agent state: %w", err)
+	}
+	if !slices.Contains(agentStateTransitions[state], req.State) {
+		return DetailedAgentResponse{}, fmt.Errorf("%w: agent %d cannot move from %s to %s", ErrInvalidTransition, id, state, req.State)
+	}
+
+	err = transitionAgent(tx, id, state, req.State, req.Reason)
+	if err != nil {
+		return DetailedAgentResponse{}, err
+	}
+	return s.commitAgentChange(tx, id)
+}
+
+// GetAgentStateHistory retrieves the lifecycle state changes of an agent in the order they happened.
+func (s *Service) GetAgentStateHistory(id int) ([]AgentStateTransition, error) {
+	err := s.agentExists(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.Query("SELECT agent_id, from_state, to_state, reason, at FROM agent_state_transitions WHERE agent_id = $1 ORDER BY id", id)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	history := []AgentStateTransition{}
+	for rows.Next() {
+		var transition AgentStateTransition
+		err = rows.Scan(&transition.AgentID, &transition.From, &transition.To, &transition.Reason, &transition.At)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		history = append(history, transition)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return history, nil
+}
+
+// activateAgent moves a pending agent to active once it reports for the first time
+func activateAgent(tx dbtx, id int) error {
+	var state string
+	err := tx.QueryRow("SELECT state FROM agents WHERE id = $1", id).Scan(&state)
+	if err != nil {
+		return fmt.Errorf("error fetching agent state: %w", err)
+	}
+	if state != AgentStatePending {
+		return nil
+	}
+	return transitionAgent(tx, id, state, AgentStateActive, "first report")
+}
+
+// transitionAgent stores the new state of an agent, records the transition and lets
+// webhook subscribers know
+func transitionAgent(tx dbtx, id int, from, to, reason string) error {
+	_, err := tx.Exec("UPDATE agents SET state = $1 WHERE id = $2", to, id)
+	if err != nil {
+		return fmt.Errorf("error updating agent state: %w", err)
+	}
+
+	transition, err := recordTransition(tx, id, from, to, reason)
+	if err != nil {
+		return err
+	}
+	return publishEvent(tx, EventAgentStateChanged, id, transition)
+}
+
+// recordTransition adds a state change to the history of an agent. Registrations are
+// recorded as a change from no state.
+func recordTransition(tx dbtx, id int, from, to, reason string) (AgentStateTransition, error) {
+	transition := AgentStateTransition{AgentID: id, From: from, To: to, Reason: reason, At: time.Now().UTC()}
+	_, err := tx.Exec("INSERT INTO agent_state_transitions (agent_id, from_state, to_state, reason, at) VALUES ($1, $2, $3, $4, $5)",
+		id, from, to, reason, transition.At)
+	if err != nil {
+		return transition, fmt.Errorf("error recording state transition: %w", err)
+	}
+	return transition, nil
+}
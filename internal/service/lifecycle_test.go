@@ -0,0 +1,153 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAgentLifecycle(t *testing.T) {
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "as": "AS64500 Test ISP", "isp": "Test ISP"}`))
+	}))
+	defer ipAPI.Close()
+	svc := &Service{DB: db, IPInfoURL: ipAPI.URL}
+
+	assert.NoError(t, svc.AddAgent("10.0.17.1", map[string]string{"lifecycle": "test"}))
+	var id int
+	assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = '10.0.17.1'").Scan(&id))
+	defer svc.purgeAgent(id)
+
+	setState := func(state string) error {
+		t.Helper()
+		_, err := svc.SetAgentState(id, AgentStateRequest{State: state, Reason: "moving to " + state})
+		return err
+	}
+	listed := func(filter AgentFilter) []int {
+		t.Helper()
+		filter.Selector = "lifecycle=test"
+		agents, err := svc.GetAgents(filter)
+		assert.NoError(t, err)
+		return ids(agents)
+	}
+
+	t.Run("Pending agents are not alerted offline", func(t *testing.T) {
+		rule, err := svc.CreateAlertRule(AlertRuleRequest{
+			Name: "lifecycle pending", Type: AlertTypeAgentOffline, Scope: AlertScopeAgent, ScopeID: id, WindowSeconds: 60,
+		})
+		assert.NoError(t, err)
+		defer svc.DeleteAlertRule(rule.ID)
+
+		assert.NoError(t, svc.EvaluateAlerts(time.Now().Add(time.Hour)))
+		alerts, err := svc.GetAlerts(AlertFilter{AgentID: id})
+		assert.NoError(t, err)
+		assert.Empty(t, alerts)
+	})
+
+	t.Run("Registered agents are pending until they report", func(t *testing.T) {
+		agent, err := svc.GetAgent(id)
+		assert.NoError(t, err)
+		assert.Equal(t, AgentStatePending, agent.State)
+
+		assert.NoError(t, svc.RecordHeartbeat(id))
+		agent, err = svc.GetAgent(id)
+		assert.NoError(t, err)
+		assert.Equal(t, AgentStateActive, agent.State)
+
+		// Registering again keeps the state
+		assert.NoError(t, svc.AddAgent("10.0.17.1", nil))
+		agent, err = svc.GetAgent(id)
+		assert.NoError(t, err)
+		assert.Equal(t, AgentStateActive, agent.State)
+	})
+
+	t.Run("Invalid transitions are refused", func(t *testing.T) {
+		assert.ErrorIs(t, setState(AgentStateArchived), ErrInvalidTransition)
+		assert.ErrorIs(t, setState(AgentStateActive), ErrInvalidTransition)
+		assert.ErrorIs(t, setState(AgentStatePending), ErrInvalidTransition)
+		_, err := svc.SetAgentState(999999, AgentStateRequest{State: AgentStatePaused, Reason: "test"})
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+	})
+
+	t.Run("Paused agents are listed but not alerted on", func(t *testing.T) {
+		rule, err := svc.CreateAlertRule(AlertRuleRequest{
+			Name: "lifecycle paused", Type: AlertTypeAgentOffline, Scope: AlertScopeAgent, ScopeID: id, WindowSeconds: 60,
+		})
+		assert.NoError(t, err)
+		defer svc.DeleteAlertRule(rule.ID)
+
+		assert.NoError(t, setState(AgentStatePaused))
+		assert.Equal(t, []int{id}, listed(AgentFilter{}))
+
+		assert.NoError(t, svc.EvaluateAlerts(time.Now().Add(time.Hour)))
+		alerts, err := svc.GetAlerts(AlertFilter{AgentID: id})
+		assert.NoError(t, err)
+		assert.Empty(t, alerts)
+	})
+
+	t.Run("Decommissioned agents are only listed when asked for", func(t *testing.T) {
+		assert.Equal(t, []int{id}, listed(AgentFilter{}))
+
+		assert.NoError(t, setState(AgentStateDecommissioned))
+		assert.Empty(t, listed(AgentFilter{}))
+		assert.Equal(t, []int{id}, listed(AgentFilter{State: AgentStateDecommissioned}))
+		_, err := svc.GetAgent(id)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Decommissioned agents are not alerted on", func(t *testing.T) {
+		rule, err := svc.CreateAlertRule(AlertRuleRequest{
+			Name: "lifecycle offline", Type: AlertTypeAgentOffline, Scope: AlertScopeAgent, ScopeID: id, WindowSeconds: 60,
+		})
+		assert.NoError(t, err)
+		defer svc.DeleteAlertRule(rule.ID)
+
+		assert.NoError(t, svc.EvaluateAlerts(time.Now().Add(time.Hour)))
+		alerts, err := svc.GetAlerts(AlertFilter{AgentID: id})
+		assert.NoError(t, err)
+		assert.Empty(t, alerts)
+	})
+
+	t.Run("Every transition is recorded", func(t *testing.T) {
+		assert.NoError(t, setState(AgentStateArchived))
+		assert.ErrorIs(t, setState(AgentStateActive), ErrInvalidTransition)
+
+		history, err := svc.GetAgentStateHistory(id)
+		assert.NoError(t, err)
+		var states []string
+		for _, transition := range history {
+			states = append(states, transition.From+">"+transition.To)
+		}
+		assert.Equal(t, []string{">pending", "pending>active", "active>paused", "paused>decommissioned", "decommissioned>archived"}, states)
+		assert.Equal(t, "first report", history[1].Reason)
+		assert.Equal(t, "moving to archived", history[4].Reason)
+
+		events, err := svc.GetEvents(EventFilter{Type: EventAgentStateChanged, AgentID: id})
+		assert.NoError(t, err)
+		assert.Len(t, events, 4)
+	})
+}
+
+func TestReplayedBatchActivatesAgent(t *testing.T) {
+	svc := &Service{DB: db}
+	id := insertTestAgent(t, "10.0.17.2")
+	defer svc.purgeAgent(id)
+
+	// The first batch was stored but its response was lost, so the agent sends it again
+	_, err := db.Exec("UPDATE agents SET state = 'pending' WHERE id = $1", id)
+	assert.NoError(t, err)
+	_, err = db.Exec("INSERT INTO metric_batches (agent_id, session_id, sequence, sample_count) VALUES ($1, 0, 1, 1)", id)
+	assert.NoError(t, err)
+
+	result, err := svc.IngestMetrics(id, MetricBatch{Sequence: 1, Samples: []MetricSample{
+		{Timestamp: time.Now(), Target: "1.1.1.1", LatencyMs: floatPtr(5)},
+	}})
+	assert.NoError(t, err)
+	assert.True(t, result.Duplicate)
+
+	agent, err := svc.GetAgent(id)
+	assert.NoError(t, err)
+	assert.Equal(t, AgentStateActive, agent.State)
+}
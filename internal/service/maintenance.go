@@ -0,0 +1,219 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Shaughny/obkio-test/internal/cron"
+	"time"
+)
+
+// MaintenanceWindow suppresses notifications about matching alerts for a while every
+// time its cron schedule fires, for instance during an ISP's weekly maintenance.
+type MaintenanceWindow struct {
+	ID              int    `json:"id"`
+	Name            string `json:"name"`
+	Schedule        string `json:"schedule"` // Cron expression of the start times, such as "0 2 * * sun"
+	DurationSeconds int    `json:"duration_seconds"`
+	Timezone        string `json:"timezone"` // Location the schedule is read in
+	AlertMatchers
+	CreatedBy string     `json:"created_by"`
+	Comment   string     `json:"comment,omitempty"`
+	Enabled   bool       `json:"enabled"`
+	Active    bool       `json:"active"`
+	NextStart *time.Time `json:"next_start,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// MaintenanceWindowRequest defines the structure for creating or updating a maintenance window.
+type MaintenanceWindowRequest struct {
+	Name            string `json:"name" validate:"required,max=100"`
+	Schedule        string `json:"schedule" validate:"required,max=100"`
+	DurationSeconds int    `json:"duration_seconds" validate:"required,gte=60,lte=604800"`
+	Timezone        string `json:"timezone" validate:"omitempty,max=64"` // Defaults to UTC
+	AlertMatchers
+	CreatedBy string `json:"created_by" validate:"required,max=100"`
+	Comment   string `json:"comment" validate:"max=1000"`
+	Enabled   *bool  `json:"enabled"` // Defaults to true
+}
+
+// ErrMaintenanceWindowNotFound is returned when a maintenance window is not found in the database.
+var ErrMaintenanceWindowNotFound = errors.New("maintenance window not found")
+
+// ErrInvalidMaintenanceWindow is returned when a maintenance window has an invalid schedule,
+// timezone or matchers.
+var ErrInvalidMaintenanceWindow = errors.New("invalid maintenance window")
+
+const maintenanceWindowColumns = `
+	SELECT id, name, schedule, duration_seconds, timezone, COALESCE(agent_id, 0), COALESCE(group_id, 0),
+	       COALESCE(asn, ''), COALESCE(selector, ''), created_by, comment, enabled, created_at, updated_at
+	FROM maintenance_windows`
+
+// CreateMaintenanceWindow creates a maintenance window.
+func (s *Service) CreateMaintenanceWindow(req MaintenanceWindowRequest, now time.Time) (MaintenanceWindow, error) {
+	req, err := s.checkMaintenanceWindow(req)
+	if err != nil {
+		return MaintenanceWindow{}, err
+	}
+
+	res, err := s.DB.Exec(`
+	INSERT INTO maintenance_windows
+		(name, schedule, duration_seconds, timezone, agent_id, group_id, asn, selector, created_by, comment, enabled)
+	VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, 0), NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11)`,
+		req.Name, req.Schedule, req.DurationSeconds, req.Timezone, req.AgentID, req.GroupID, req.ASN, req.Selector,
+		req.CreatedBy, req.Comment, req.Enabled == nil || *req.Enabled,
+	)
+	if err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("error inserting maintenance window: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("error reading maintenance window ID: %w", err)
+	}
+	return s.GetMaintenanceWindow(int(id), now)
+}
+
+// GetMaintenanceWindows retrieves all maintenance windows.
+func (s *Service) GetMaintenanceWindows(now time.Time) ([]MaintenanceWindow, error) {
+	return queryMaintenanceWindows(s.DB, maintenanceWindowColumns+" ORDER BY id", now)
+}
+
+// GetMaintenanceWindow retrieves a maintenance window by ID.
+func (s *Service) GetMaintenanceWindow(id int, now time.Time) (MaintenanceWindow, error) {
+	window, err := scanMaintenanceWindow(s.DB.QueryRow(maintenanceWindowColumns+" WHERE id = $1", id), now)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return window, ErrMaintenanceWindowNotFound
+		}
+		return window, fmt.Errorf("error fetching maintenance window: %w", err)
+	}
+	return window, nil
+}
+
+// UpdateMaintenanceWindow replaces the settings of a maintenance window.
+func (s *Service) UpdateMaintenanceWindow(id int, req MaintenanceWindowRequest, now time.Time) (MaintenanceWindow, error) {
+	req, err := s.checkMaintenanceWindow(req)
+	if err != nil {
+		return MaintenanceWindow{}, err
+	}
+
+	res, err := s.DB.Exec(`
+	UPDATE maintenance_windows
+	SET name = $1, schedule = $2, duration_seconds = $3, timezone = $4, agent_id = NULLIF($5, 0),
+	    group_id = NULLIF($6, 0), asn = NULLIF($7, ''), selector = NULLIF($8, ''), created_by = $9, comment = $10,
+	    enabled = $11, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $12`,
+		req.Name, req.Schedule, req.DurationSeconds, req.Timezone, req.AgentID, req.GroupID, req.ASN, req.Selector,
+		req.CreatedBy, req.Comment, req.Enabled == nil || *req.Enabled, id,
+	)
+	if err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("error updating maintenance window: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("error updating maintenance window: %w", err)
+	}
+	if updated == 0 {
+		return MaintenanceWindow{}, ErrMaintenanceWindowNotFound
+	}
+	return s.GetMaintenanceWindow(id, now)
+}
+
+// DeleteMaintenanceWindow removes a maintenance window.
+func (s *Service) DeleteMaintenanceWindow(id int) error {
+	res, err := s.DB.Exec("DELETE FROM maintenance_windows WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting maintenance window: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting maintenance window: %w", err)
+	}
+	if deleted == 0 {
+		return ErrMaintenanceWindowNotFound
+	}
+	return nil
+}
+
+// checkMaintenanceWindow verifies the schedule, timezone and matchers of a window
+func (s *Service) checkMaintenanceWindow(req MaintenanceWindowRequest) (MaintenanceWindowRequest, error) {
+	_, err := cron.Parse(req.Schedule)
+	if err != nil {
+		return req, fmt.Errorf("%w: %v", ErrInvalidMaintenanceWindow, err)
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	_, err = time.LoadLocation(req.Timezone)
+	if err != nil {
+		return req, fmt.Errorf("%w: unknown timezone %q", ErrInvalidMaintenanceWindow, req.Timezone)
+	}
+
+	req.AlertMatchers, err = s.checkMatchers(req.AlertMatchers, ErrInvalidMaintenanceWindow)
+	return req, err
+}
+
+// occurrence reports whether the window is open at now and when it next opens. A window
+// opened by the schedule at start stays open until start plus its duration.
+func (w MaintenanceWindow) occurrence(now time.Time) (open bool, next time.Time, err error) {
+	schedule, err := cron.Parse(w.Schedule)
+	if err != nil {
+		return false, next, err
+	}
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return false, next, err
+	}
+
+	// The first start after now minus the duration is still open if it has begun
+	duration := time.Duration(w.DurationSeconds) * time.Second
+	start := schedule.Next(now.In(loc).Add(-duration))
+	if start.IsZero() || start.After(now) {
+		return false, start, nil
+	}
+	return true, schedule.Next(now.In(loc)), nil
+}
+
+func queryMaintenanceWindows(tx dbtx, query string, now time.Time, args ...any) ([]MaintenanceWindow, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	windows := []MaintenanceWindow{}
+	for rows.Next() {
+		window, err := scanMaintenanceWindow(rows, now)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		windows = append(windows, window)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return windows, nil
+}
+
+// scanMaintenanceWindow reads a window and works out whether it is open at now
+func scanMaintenanceWindow(row rowScanner, now time.Time) (MaintenanceWindow, error) {
+	var w MaintenanceWindow
+	err := row.Scan(&w.ID, &w.Name, &w.Schedule, &w.DurationSeconds, &w.Timezone, &w.AgentID, &w.GroupID, &w.ASN,
+		&w.Selector, &w.CreatedBy, &w.Comment, &w.Enabled, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil || !w.Enabled {
+		return w, err
+	}
+
+	open, next, err := w.occurrence(now)
+	if err != nil {
+		return w, fmt.Errorf("error reading schedule of maintenance window %d: %w", w.ID, err)
+	}
+	w.Active = open
+	if !next.IsZero() {
+		w.NextStart = &next
+	}
+	return w, nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MetricSample is a single network measurement taken by an agent against a target.
+// Every measurement is optional so agents can report only what they measured.
+type MetricSample struct {
+	Timestamp      time.Time `json:"timestamp" validate:"required"`
+	Target         string    `json:"target" validate:"required,max=255"`
+	LatencyMs      *float64  `json:"latency_ms" validate:"omitempty,gte=0"`
+	JitterMs       *float64  `json:"jitter_ms" validate:"omitempty,gte=0"`
+	PacketLoss     *float64  `json:"packet_loss" validate:"omitempty,gte=0,lte=100"` // Percentage of lost packets
+	ThroughputKbps *float64  `json:"throughput_kbps" validate:"omitempty,gte=0"`
+}
+
+// MetricBatch defines the structure for incoming metric submissions.
+// Sequence is chosen by the agent and increases with every batch it sends,
+// which lets the server drop batches that are retried after a lost response.
+type MetricBatch struct {
+	Sequence int64          `json:"sequence" validate:"required,gte=1"`
+	Samples  []MetricSample `json:"samples" validate:"required,min=1,max=1000,dive"`
+}
+
+// IngestResult reports what happened to a submitted metric batch.
+type IngestResult struct {
+	Accepted  int  `json:"accepted"`
+	Duplicate bool `json:"duplicate"`
+}
+
+// IngestMetrics stores a batch of metric samples for an agent.
+// A batch whose sequence number was already ingested for the agent is acknowledged without being stored again.
+func (s *Service) IngestMetrics(agentID int, batch MetricBatch) (IngestResult, error) {
+	var result IngestResult
+
+	err := s.agentExists(agentID)
+	if err != nil {
+		return result, err
+	}
+
+	return s.storeMetricBatch(agentID, 0, batch)
+}
+
+// storeMetricBatch stores the samples of a batch measured by an agent, either on its own
+// (sessionID 0) or as part of a monitoring session, skipping batches already stored.
+func (s *Service) storeMetricBatch(agentID, sessionID int, batch MetricBatch) (IngestResult, error) {
+	var result IngestResult
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return result, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Any batch, even a replayed one, shows the agent is alive
+	_, err = tx.Exec("UPDATE agents SET last_seen_at = CURRENT_TIMESTAMP WHERE id = $1", agentID)
+	if err != nil {
+		return result, fmt.Errorf("error updating agent last seen time: %w", err)
+	}
+	err = activateAgent(tx, agentID)
+	if err != nil {
+		return result, err
+	}
+
+	// Record the sequence number first; the unique index turns a replayed batch into a no-op
+	res, err := tx.Exec(
+		"INSERT OR IGNORE INTO metric_batches (agent_id, session_id, sequence, sample_count) VALUES ($1, $2, $3, $4)",
+		agentID, sessionID, batch.Sequence, len(batch.Samples),
+	)
+	if err != nil {
+		return result, fmt.Errorf("error recording metric batch: %w", err)
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return result, fmt.Errorf("error recording metric batch: %w", err)
+	}
+	if inserted == 0 {
+		// Keep the last seen time of the replayed batch
+		err = tx.Commit()
+		if err != nil {
+			return result, fmt.Errorf("error committing metric batch: %w", err)
+		}
+		result.Duplicate = true
+		return result, nil
+	}
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO metrics (agent_id, session_id, target, ts, latency_ms, jitter_ms, packet_loss, throughput_kbps)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+	if err != nil {
+		return result, fmt.Errorf("error preparing metric insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, sample := range batch.Samples {
+		_, err = stmt.Exec(
+			agentID, sessionID, sample.Target, sample.Timestamp.Unix(),
+			sample.LatencyMs, sample.JitterMs, sample.PacketLoss, sample.ThroughputKbps,
+		)
+		if err != nil {
+			return result, fmt.Errorf("error inserting metric sample: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return result, fmt.Errorf("error committing metric batch: %w", err)
+	}
+
+	result.Accepted = len(batch.Samples)
+	return result, nil
+}
+
+// agentExists returns ErrAgentNotFound if no agent has the given ID.
+func (s *Service) agentExists(agentID int) error {
+	var id int
+	err := s.DB.QueryRow("SELECT id FROM agents WHERE id = $1 AND deleted_at IS NULL", agentID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAgentNotFound
+		}
+		return fmt.Errorf("error checking agent: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,504 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Shaughny/obkio-test/internal/sketch"
+)
+
+// Supported aggregations for metric queries.
+const (
+	AggAvg   = "avg"
+	AggMin   = "min"
+	AggMax   = "max"
+	AggP50   = "p50"
+	AggP95   = "p95"
+	AggP99   = "p99"
+	AggCount = "count"
+)
+
+// metricColumns lists the metrics that can be queried; each name is also its column in the metrics table.
+var metricColumns = []string{"latency_ms", "jitter_ms", "packet_loss", "throughput_kbps"}
+
+// rollupResolutions are the pre-computed aggregate resolutions, finest first.
+// Each resolution must divide the next one so buckets nest.
+var rollupResolutions = []time.Duration{time.Minute, time.Hour, 24 * time.Hour}
+
+const (
+	// rawQueryMaxRange is the widest range always answered from raw samples
+	rawQueryMaxRange = 6 * time.Hour
+	// maxQueryPoints caps the number of buckets returned per series
+	maxQueryPoints = 11000
+	// autoStepPoints is the number of buckets aimed for when no step is given
+	autoStepPoints = 300
+)
+
+// autoSteps are the step sizes picked from when a query leaves the step out.
+var autoSteps = []time.Duration{
+	10 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute, 15 * time.Minute,
+	time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+// ErrInvalidMetricQuery is returned when a metric query has invalid parameters.
+var ErrInvalidMetricQuery = errors.New("invalid metric query")
+
+// MetricQueryRequest holds the raw query string parameters of a metric query.
+type MetricQueryRequest struct {
+	From        string `query:"from"` // RFC 3339 or Unix seconds, defaults to one hour before To
+	To          string `query:"to"`   // RFC 3339 or Unix seconds, defaults to now
+	Step        string `query:"step"` // Go duration such as 30s or 5m, chosen automatically if empty
+	Metric      string `query:"metric" validate:"omitempty,oneof=latency_ms jitter_ms packet_loss throughput_kbps"`
+	Aggregation string `query:"agg" validate:"omitempty,oneof=avg min max p50 p95 p99 count"`
+	Target      string `query:"target"`
+}
+
+// MetricQuery describes a time range of one metric to aggregate into fixed-size steps.
+type MetricQuery struct {
+	From        time.Time
+	To          time.Time
+	Step        time.Duration
+	Metric      string
+	Aggregation string
+	Target      string // Optional, restricts the query to a single target
+}
+
+// MetricSeries holds the aggregated values of one target, aligned with MetricQueryResponse.Timestamps.
+// Buckets without data are null.
+type MetricSeries struct {
+	Target string     `json:"target"`
+	Values []*float64 `json:"values"`
+}
+
+// MetricQueryResponse is the columnar result of a metric query.
+type MetricQueryResponse struct {
+	AgentID     int            `json:"agent_id"`
+	SessionID   int            `json:"session_id,omitempty"`
+	Metric      string         `json:"metric"`
+	Aggregation string         `json:"aggregation"`
+	Step        int64          `json:"step"`       // Bucket size in seconds
+	Resolution  string         `json:"resolution"` // Coarsest stored resolution read: raw, 1m, 1h or 1d
+	Timestamps  []int64        `json:"timestamps"` // Bucket start times in Unix seconds
+	Series      []MetricSeries `json:"series"`
+}
+
+// ParseMetricQuery converts query string parameters into a MetricQuery, applying defaults relative to now.
+func ParseMetricQuery(req MetricQueryRequest, now time.Time) (MetricQuery, error) {
+	q := MetricQuery{
+		Metric:      req.Metric,
+		Aggregation: req.Aggregation,
+		Target:      req.Target,
+		To:          now,
+	}
+	if q.Metric == "" {
+		q.Metric = "latency_ms"
+	}
+	if q.Aggregation == "" {
+		q.Aggregation = AggAvg
+	}
+
+	var err error
+	if req.To != "" {
+		q.To, err = parseQueryTime(req.To)
+		if err != nil {
+			return q, fmt.Errorf("%w: to: %v", ErrInvalidMetricQuery, err)
+		}
+	}
+	q.From = q.To.Add(-time.Hour)
+	if req.From != "" {
+		q.From, err = parseQueryTime(req.From)
+		if err != nil {
+			return q, fmt.Errorf("%w: from: %v", ErrInvalidMetricQuery, err)
+		}
+	}
+	if req.Step != "" {
+		q.Step, err = time.ParseDuration(req.Step)
+		if err != nil {
+			return q, fmt.Errorf("%w: step: %v", ErrInvalidMetricQuery, err)
+		}
+	}
+
+	return q, nil
+}
+
+// parseQueryTime accepts either an RFC 3339 timestamp or Unix seconds
+func parseQueryTime(value string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// QueryMetrics aggregates an agent's samples of one metric into fixed-size buckets.
+// Short ranges are computed from raw samples. Longer ranges read the coarsest rollup
+// resolution that divides the step, falling back to finer data for the recent part
+// of the range that has not been rolled up yet. Ranges older than the raw samples kept
+// read the finest rollup kept instead.
+func (s *Service) QueryMetrics(agentID int, q MetricQuery) (MetricQueryResponse, error) {
+	response := MetricQueryResponse{AgentID: agentID, Metric: q.Metric, Aggregation: q.Aggregation}
+
+	err := validateMetricQuery(&q)
+	if err != nil {
+		return response, err
+	}
+
+	err = s.agentExists(agentID)
+	if err != nil {
+		return response, err
+	}
+
+	return s.queryMetricSeries(agentID, 0, q, response)
+}
+
+// queryMetricSeries fills response with the samples an agent measured on its own
+// (sessionID 0) or as part of a monitoring session. The query must be validated.
+func (s *Service) queryMetricSeries(agentID, sessionID int, q MetricQuery, response MetricQueryResponse) (MetricQueryResponse, error) {
+	resolution := pickResolution(&q, s.Retention, time.Now())
+	response.Resolution = resolutionName(resolution)
+
+	step := int64(q.Step / time.Second)
+	start := q.From.Unix() - mod(q.From.Unix(), step) // Align buckets to multiples of the step
+	end := q.To.Unix()
+	buckets := make(map[string][]*aggregator)
+	bucketCount := int((end - start + step - 1) / step)
+
+	bucketFor := func(target string, ts int64) *aggregator {
+		series, ok := buckets[target]
+		if !ok {
+			series = make([]*aggregator, bucketCount)
+			buckets[target] = series
+		}
+		idx := (ts - start) / step
+		if series[idx] == nil {
+			series[idx] = &aggregator{}
+		}
+		return series[idx]
+	}
+
+	// Walk from the chosen resolution down to raw data; each source covers
+	// the time between the previous source's end and its own watermark.
+	cursor := start
+	for i := len(rollupResolutions) - 1; i >= 0 && resolution > 0; i-- {
+		r := rollupResolutions[i]
+		if r > resolution {
+			continue
+		}
+		watermark, err := s.rollupWatermark(r)
+		if err != nil {
+			return response, err
+		}
+		segmentEnd := min(watermark, end)
+		if segmentEnd <= cursor {
+			continue
+		}
+		err = s.scanRollups(agentID, sessionID, q, r, cursor, segmentEnd, bucketFor)
+		if err != nil {
+			return response, err
+		}
+		cursor = segmentEnd
+	}
+	if cursor < end {
+		err := s.scanRawMetrics(agentID, sessionID, q, cursor, end, bucketFor)
+		if err != nil {
+			return response, err
+		}
+	}
+
+	// Build the columnar response
+	response.Step = step
+	response.Timestamps = make([]int64, bucketCount)
+	for i := range response.Timestamps {
+		response.Timestamps[i] = start + int64(i)*step
+	}
+	targets := make([]string, 0, len(buckets))
+	for target := range buckets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	response.Series = make([]MetricSeries, 0, len(targets))
+	for _, target := range targets {
+		series := MetricSeries{Target: target, Values: make([]*float64, bucketCount)}
+		for i, agg := range buckets[target] {
+			if agg == nil {
+				continue
+			}
+			value, ok := agg.result(q.Aggregation)
+			if ok {
+				series.Values[i] = &value
+			}
+		}
+		response.Series = append(response.Series, series)
+	}
+
+	return response, nil
+}
+
+// validateMetricQuery checks the query parameters and picks a step if none was given
+func validateMetricQuery(q *MetricQuery) error {
+	if !slices.Contains(metricColumns, q.Metric) {
+		return fmt.Errorf("%w: unknown metric %q", ErrInvalidMetricQuery, q.Metric)
+	}
+	switch q.Aggregation {
+	case AggAvg, AggMin, AggMax, AggP50, AggP95, AggP99, AggCount:
+	default:
+		return fmt.Errorf("%w: unknown aggregation %q", ErrInvalidMetricQuery, q.Aggregation)
+	}
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidMetricQuery)
+	}
+
+	if q.Step == 0 {
+		q.Step = autoSteps[len(autoSteps)-1]
+		for _, step := range autoSteps {
+			if q.To.Sub(q.From)/step <= autoStepPoints {
+				q.Step = step
+				break
+			}
+		}
+	}
+	if q.Step < time.Second || q.Step%time.Second != 0 {
+		return fmt.Errorf("%w: step must be a whole number of seconds", ErrInvalidMetricQuery)
+	}
+	if q.To.Sub(q.From)/q.Step > maxQueryPoints {
+		return fmt.Errorf("%w: range and step would return more than %d points", ErrInvalidMetricQuery, maxQueryPoints)
+	}
+	return nil
+}
+
+// pickResolution returns the rollup resolution to read for a query, or 0 for raw samples.
+// Ranges starting before the raw samples kept read the finest rollup still kept at their start,
+// with the step rounded up to a multiple of it for each rollup to fall in a single bucket.
+func pickResolution(q *MetricQuery, retention RetentionPolicy, now time.Time) time.Duration {
+	rawExpired := retention.Raw > 0 && q.From.Before(now.Add(-retention.Raw))
+	if q.To.Sub(q.From) <= rawQueryMaxRange && !rawExpired {
+		return 0
+	}
+
+	var resolution time.Duration
+	for i := len(rollupResolutions) - 1; i >= 0; i-- {
+		r := rollupResolutions[i]
+		if q.Step >= r && q.Step%r == 0 {
+			resolution = r
+			break
+		}
+	}
+	if !rawExpired {
+		return resolution
+	}
+
+	picked := rollupResolutions[len(rollupResolutions)-1]
+	for _, r := range rollupResolutions {
+		kept := retention.forResolution(r)
+		if r >= resolution && (kept <= 0 || !q.From.Before(now.Add(-kept))) {
+			picked = r
+			break
+		}
+	}
+	if q.Step%picked != 0 {
+		q.Step = (q.Step/picked + 1) * picked
+	}
+	return picked
+}
+
+// resolutionName returns the short name of a resolution, such as 1m or 1h
+func resolutionName(r time.Duration) string {
+	switch r {
+	case 0:
+		return "raw"
+	case time.Minute:
+		return "1m"
+	case time.Hour:
+		return "1h"
+	case 24 * time.Hour:
+		return "1d"
+	}
+	return r.String()
+}
+
+// rollupWatermark returns the time before which all data of a resolution has been rolled up
+func (s *Service) rollupWatermark(r time.Duration) (int64, error) {
+	var watermark int64
+	err := s.DB.QueryRow(
+		"SELECT watermark FROM rollup_watermarks WHERE resolution = $1", int64(r/time.Second),
+	).Scan(&watermark)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("error fetching rollup watermark: %w", err)
+	}
+	return watermark, nil
+}
+
+// scanRawMetrics feeds raw samples in [from, to) into their buckets
+func (s *Service) scanRawMetrics(agentID, sessionID int, q MetricQuery, from, to int64, bucketFor func(string, int64) *aggregator) error {
+	// The metric name was checked against metricColumns, so it is safe to use as a column
+	query := fmt.Sprintf(
+		"SELECT target, ts, %[1]s FROM metrics WHERE agent_id = $1 AND session_id = $2 AND ts >= $3 AND ts < $4 AND %[1]s IS NOT NULL",
+		q.Metric,
+	)
+	args := []any{agentID, sessionID, from, to}
+	if q.Target != "" {
+		query += " AND target = $5"
+		args = append(args, q.Target)
+	}
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("error querying metrics: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var target string
+		var ts int64
+		var value float64
+		err = rows.Scan(&target, &ts, &value)
+		if err != nil {
+			return fmt.Errorf("error scanning metric: %w", err)
+		}
+		bucketFor(target, ts).addValue(value)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("error iterating metrics: %w", err)
+	}
+	return nil
+}
+
+// scanRollups feeds rollups of one resolution with buckets starting in [from, to) into the query buckets
+func (s *Service) scanRollups(agentID, sessionID int, q MetricQuery, r time.Duration, from, to int64, bucketFor func(string, int64) *aggregator) error {
+	query := `
+	SELECT target, bucket_start, count, sum, min, max, sketch FROM metric_rollups
+	WHERE agent_id = $1 AND session_id = $2 AND resolution = $3 AND metric = $4 AND bucket_start >= $5 AND bucket_start < $6`
+	args := []any{agentID, sessionID, int64(r / time.Second), q.Metric, from, to}
+	if q.Target != "" {
+		query += " AND target = $7"
+		args = append(args, q.Target)
+	}
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("error querying rollups: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var target, encodedSketch string
+		var bucketStart int64
+		var rollup aggregator
+		err = rows.Scan(&target, &bucketStart, &rollup.count, &rollup.sum, &rollup.min, &rollup.max, &encodedSketch)
+		if err != nil {
+			return fmt.Errorf("error scanning rollup: %w", err)
+		}
+		rollup.sketch = sketch.New()
+		err = json.Unmarshal([]byte(encodedSketch), rollup.sketch)
+		if err != nil {
+			return fmt.Errorf("error decoding rollup sketch: %w", err)
+		}
+		bucketFor(target, bucketStart).merge(&rollup)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("error iterating rollups: %w", err)
+	}
+	return nil
+}
+
+// aggregator accumulates the values of one bucket. Raw values are kept as-is so
+// percentiles over raw data are exact; once a rollup is merged in, percentiles
+// come from the combined sketch instead.
+type aggregator struct {
+	count    int64
+	sum      float64
+	min, max float64
+	values   []float64
+	sketch   *sketch.Sketch
+}
+
+func (a *aggregator) addValue(v float64) {
+	a.observe(1, v, v, v)
+	a.values = append(a.values, v)
+}
+
+func (a *aggregator) merge(other *aggregator) {
+	if other.count == 0 {
+		return
+	}
+	a.observe(other.count, other.sum, other.min, other.max)
+	if a.sketch == nil {
+		a.sketch = sketch.New()
+	}
+	a.sketch.Merge(other.sketch)
+	for _, v := range other.values {
+		a.sketch.Add(v)
+	}
+}
+
+func (a *aggregator) observe(count int64, sum, lo, hi float64) {
+	if a.count == 0 || lo < a.min {
+		a.min = lo
+	}
+	if a.count == 0 || hi > a.max {
+		a.max = hi
+	}
+	a.count += count
+	a.sum += sum
+}
+
+// toSketch returns a sketch holding every value seen by the aggregator
+func (a *aggregator) toSketch() *sketch.Sketch {
+	sk := sketch.New()
+	sk.Merge(a.sketch)
+	for _, v := range a.values {
+		sk.Add(v)
+	}
+	return sk
+}
+
+// result computes the aggregation; the second return value is false for an empty bucket
+func (a *aggregator) result(aggregation string) (float64, bool) {
+	if a.count == 0 {
+		return 0, false
+	}
+	switch aggregation {
+	case AggAvg:
+		return a.sum / float64(a.count), true
+	case AggMin:
+		return a.min, true
+	case AggMax:
+		return a.max, true
+	case AggCount:
+		return float64(a.count), true
+	case AggP50:
+		return a.quantile(0.50)
+	case AggP95:
+		return a.quantile(0.95)
+	case AggP99:
+		return a.quantile(0.99)
+	}
+	return 0, false
+}
+
+func (a *aggregator) quantile(q float64) (float64, bool) {
+	if a.sketch != nil {
+		return a.toSketch().Quantile(q)
+	}
+
+	// Nearest-rank percentile over the raw values
+	sort.Float64s(a.values)
+	rank := int(math.Ceil(q*float64(len(a.values)))) - 1
+	return a.values[max(rank, 0)], true
+}
+
+// mod returns the non-negative remainder of a divided by b
+func mod(a, b int64) int64 {
+	return ((a % b) + b) % b
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// insertTestAgent inserts an agent directly and returns its ID
+func insertTestAgent(t *testing.T, ip string) int {
+	t.Helper()
+	res, err := db.Exec("INSERT INTO agents (ip_address, asn, isp) VALUES (?, 'AS64500', 'Test ISP')", ip)
+	assert.NoError(t, err)
+	id, err := res.LastInsertId()
+	assert.NoError(t, err)
+	return int(id)
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestIngestMetrics(t *testing.T) {
+	svc := &Service{DB: db}
+	agentID := insertTestAgent(t, "10.0.0.1")
+
+	batch := MetricBatch{
+		Sequence: 1,
+		Samples: []MetricSample{
+			{Timestamp: time.Unix(1700000000, 0), Target: "8.8.8.8", LatencyMs: floatPtr(10), PacketLoss: floatPtr(0)},
+			{Timestamp: time.Unix(1700000001, 0), Target: "8.8.8.8", LatencyMs: floatPtr(12), JitterMs: floatPtr(1.5)},
+		},
+	}
+
+	t.Run("Stores samples", func(t *testing.T) {
+		result, err := svc.IngestMetrics(agentID, batch)
+		assert.NoError(t, err)
+		assert.Equal(t, IngestResult{Accepted: 2}, result)
+
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM metrics WHERE agent_id = ?", agentID).Scan(&count)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("Deduplicates replayed batch", func(t *testing.T) {
+		result, err := svc.IngestMetrics(agentID, batch)
+		assert.NoError(t, err)
+		assert.True(t, result.Duplicate)
+
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM metrics WHERE agent_id = ?", agentID).Scan(&count)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("Replayed batch moves last seen time forward", func(t *testing.T) {
+		_, err := db.Exec("UPDATE agents SET last_seen_at = '2020-01-01 00:00:00' WHERE id = ?", agentID)
+		assert.NoError(t, err)
+
+		result, err := svc.IngestMetrics(agentID, batch)
+		assert.NoError(t, err)
+		assert.True(t, result.Duplicate)
+
+		var lastSeen time.Time
+		err = db.QueryRow("SELECT last_seen_at FROM agents WHERE id = ?", agentID).Scan(&lastSeen)
+		assert.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), lastSeen, time.Minute)
+	})
+
+	t.Run("Returns error if agent not found", func(t *testing.T) {
+		_, err := svc.IngestMetrics(999999, batch)
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+	})
+}
+
+func TestQueryMetrics(t *testing.T) {
+	svc := &Service{DB: db}
+	agentID := insertTestAgent(t, "10.0.0.2")
+	base := time.Unix(1700001000, 0).Truncate(time.Minute)
+
+	// Two minutes of samples against two targets
+	var samples []MetricSample
+	for i := 0; i < 120; i++ {
+		ts := base.Add(time.Duration(i) * time.Second)
+		samples = append(samples,
+			MetricSample{Timestamp: ts, Target: "1.1.1.1", LatencyMs: floatPtr(float64(i % 60))},
+			MetricSample{Timestamp: ts, Target: "8.8.8.8", LatencyMs: floatPtr(100)},
+		)
+	}
+	_, err := svc.IngestMetrics(agentID, MetricBatch{Sequence: 1, Samples: samples})
+	assert.NoError(t, err)
+
+	t.Run("Aggregates raw samples per step", func(t *testing.T) {
+		resp, err := svc.QueryMetrics(agentID, MetricQuery{
+			From: base, To: base.Add(3 * time.Minute), Step: time.Minute,
+			Metric: "latency_ms", Aggregation: AggMax,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "raw", resp.Resolution)
+		assert.Equal(t, []int64{base.Unix(), base.Unix() + 60, base.Unix() + 120}, resp.Timestamps)
+		assert.Len(t, resp.Series, 2)
+		assert.Equal(t, "1.1.1.1", resp.Series[0].Target)
+		assert.Equal(t, 59.0, *resp.Series[0].Values[0])
+		assert.Nil(t, resp.Series[0].Values[2]) // No data in the last minute
+	})
+
+	t.Run("Computes percentiles and filters by target", func(t *testing.T) {
+		resp, err := svc.QueryMetrics(agentID, MetricQuery{
+			From: base, To: base.Add(time.Minute), Step: time.Minute,
+			Metric: "latency_ms", Aggregation: AggP95, Target: "1.1.1.1",
+		})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Series, 1)
+		assert.Equal(t, 56.0, *resp.Series[0].Values[0])
+	})
+
+	t.Run("Reads rollups before the watermark", func(t *testing.T) {
+		day := base.Add(-48 * time.Hour).Truncate(time.Hour)
+		_, err := db.Exec(`INSERT INTO metric_rollups (agent_id, target, metric, resolution, bucket_start, count, sum, min, max, sketch)
+			VALUES (?, '1.1.1.1', 'latency_ms', 3600, ?, 4, 40, 5, 15, '{"buckets":{"230":4}}')`, agentID, day.Unix())
+		assert.NoError(t, err)
+		_, err = db.Exec("INSERT OR REPLACE INTO rollup_watermarks (resolution, watermark) VALUES (3600, ?)", day.Add(time.Hour).Unix())
+		assert.NoError(t, err)
+		defer db.Exec("DELETE FROM rollup_watermarks")
+
+		resp, err := svc.QueryMetrics(agentID, MetricQuery{
+			From: day, To: day.Add(24 * time.Hour), Step: time.Hour,
+			Metric: "latency_ms", Aggregation: AggAvg, Target: "1.1.1.1",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "1h", resp.Resolution)
+		assert.Len(t, resp.Timestamps, 24)
+		assert.Equal(t, 10.0, *resp.Series[0].Values[0])
+	})
+
+	t.Run("Rejects invalid query", func(t *testing.T) {
+		_, err := svc.QueryMetrics(agentID, MetricQuery{
+			From: base, To: base.Add(-time.Minute), Step: time.Minute, Metric: "latency_ms", Aggregation: AggAvg,
+		})
+		assert.ErrorIs(t, err, ErrInvalidMetricQuery)
+	})
+}
+
+func TestParseMetricQuery(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	t.Run("Applies defaults", func(t *testing.T) {
+		q, err := ParseMetricQuery(MetricQueryRequest{}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, now.Add(-time.Hour), q.From)
+		assert.Equal(t, now, q.To)
+		assert.Equal(t, "latency_ms", q.Metric)
+		assert.Equal(t, AggAvg, q.Aggregation)
+	})
+
+	t.Run("Parses RFC 3339 and Unix times", func(t *testing.T) {
+		q, err := ParseMetricQuery(MetricQueryRequest{From: "2023-11-14T22:00:00Z", To: "1700000000", Step: "5m"}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1699999200), q.From.Unix())
+		assert.Equal(t, now, q.To)
+		assert.Equal(t, 5*time.Minute, q.Step)
+	})
+
+	t.Run("Rejects bad step", func(t *testing.T) {
+		_, err := ParseMetricQuery(MetricQueryRequest{Step: "often"}, now)
+		assert.ErrorIs(t, err, ErrInvalidMetricQuery)
+	})
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"slices"
+	"time"
+)
+
+// overrideFields are the agent details admins can override, named after their columns.
+var overrideFields = []string{
+	"asn", "isp", "country", "country_code", "region", "region_name", "city", "latitude", "longitude", "timezone",
+}
+
+// AgentOverride is an agent detail set by hand and locked, so looking the agent up
+// again does not overwrite it. The value the provider reports keeps being recorded.
+type AgentOverride struct {
+	Field         string    `json:"field"`
+	Value         any       `json:"value"`
+	ProviderValue any       `json:"provider_value"` // Latest value reported by ip-api, null if none
+	SetBy         string    `json:"set_by"`
+	SetAt         time.Time `json:"set_at"`
+}
+
+// AgentOverrideRequest sets and locks agent details. Fields that are not given are left
+// as they are, overridden or not.
+type AgentOverrideRequest struct {
+	ASN         *string  `json:"asn" validate:"omitempty,max=12"` // Such as AS15169
+	ISP         *string  `json:"isp" validate:"omitempty,min=1,max=200"`
+	Country     *string  `json:"country" validate:"omitempty,max=100"`
+	CountryCode *string  `json:"country_code" validate:"omitempty,len=2,alpha"`
+	Region      *string  `json:"region" validate:"omitempty,max=10"`
+	RegionName  *string  `json:"region_name" validate:"omitempty,max=100"`
+	City        *string  `json:"city" validate:"omitempty,max=100"`
+	Latitude    *float64 `json:"latitude" validate:"omitempty,gte=-90,lte=90"`
+	Longitude   *float64 `json:"longitude" validate:"omitempty,gte=-180,lte=180"`
+	Timezone    *string  `json:"timezone" validate:"omitempty,max=64"`
+	SetBy       string   `json:"set_by" validate:"required,max=100"`
+	Confidence  *float64 `json:"confidence" validate:"omitempty,gte=0,lte=1"` // Recorded in the provenance of the values
+}
+
+// ErrOverrideNotFound is returned when an agent detail is not overridden.
+var ErrOverrideNotFound = errors.New("override not found")
+
+// ErrInvalidOverride is returned when an override sets no field, an unknown field or an invalid value.
+var ErrInvalidOverride = errors.New("invalid override")
+
+// SetAgentOverrides overrides and locks the given details of an agent.
+func (s *Service) SetAgentOverrides(id int, req AgentOverrideRequest) (DetailedAgentResponse, error) {
+	values, err := req.values()
+	if err != nil {
+		return DetailedAgentResponse{}, err
+	}
+	err = s.agentExists(id)
+	if err != nil {
+		return DetailedAgentResponse{}, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return DetailedAgentResponse{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	source := enrich.Source{FetchedAt: time.Now().UTC()}
+	for _, field := range overrideFields {
+		value, ok := values[field]
+		if !ok {
+			continue
+		}
+		// The agent holds the provider value until the field is first overridden
+		_, err = tx.Exec(`
+		INSERT INTO agent_overrides (agent_id, field, value, provider_value, set_by)
+		VALUES ($1, $2, $3, (SELECT `+field+` FROM agents WHERE id = $1), $4)
+		ON CONFLICT(agent_id, field) DO UPDATE
+		SET value = EXCLUDED.value, set_by = EXCLUDED.set_by, set_at = CURRENT_TIMESTAMP`,
+			id, field, value, req.SetBy,
+		)
+		if err != nil {
+			return DetailedAgentResponse{}, fmt.Errorf("error storing override: %w", err)
+		}
+		err = setAgentField(tx, id, field, value)
+		if err != nil {
+			return DetailedAgentResponse{}, err
+		}
+		err = recordProvenance(tx, id, field, ProviderManual, value, source, req.Confidence)
+		if err != nil {
+			return DetailedAgentResponse{}, err
+		}
+	}
+
+	return s.commitAgentChange(tx, id)
+}
+
+// ClearAgentOverride unlocks an agent detail and restores the value the provider reported.
+func (s *Service) ClearAgentOverride(id int, field string) (DetailedAgentResponse, error) {
+	if !slices.Contains(overrideFields, field) {
+		return DetailedAgentResponse{}, fmt.Errorf("%w: unknown field %q", ErrInvalidOverride, field)
+	}
+	err := s.agentExists(id)
+	if err != nil {
+		return DetailedAgentResponse{}, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return DetailedAgentResponse{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var provider any
+	err = tx.QueryRow("SELECT provider_value FROM agent_overrides WHERE agent_id = $1 AND field = $2", id, field).Scan(&provider)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DetailedAgentResponse{}, ErrOverrideNotFound
+		}
+		return DetailedAgentResponse{}, fmt.Errorf("error fetching override: %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM agent_overrides WHERE agent_id = $1 AND field = $2", id, field)
+	if err != nil {
+		return DetailedAgentResponse{}, fmt.Errorf("error deleting override: %w", err)
+	}
+	err = setAgentField(tx, id, field, provider)
+	if err != nil {
+		return DetailedAgentResponse{}, err
+	}
+
+	return s.commitAgentChange(tx, id)
+}
+
+// commitAgentChange lets webhook subscribers know an agent's details changed, commits
+// and returns the agent
+func (s *Service) commitAgentChange(tx *sql.Tx, id int) (DetailedAgentResponse, error) {
+	details, err := scanDetailedAgent(tx.QueryRow(detailedAgentColumns+" WHERE id = $1", id))
+	if err != nil {
+		return DetailedAgentResponse{}, fmt.Errorf("error fetching agent: %w", err)
+	}
+	err = publishEvent(tx, EventAgentUpdated, id, details)
+	if err != nil {
+		return DetailedAgentResponse{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return DetailedAgentResponse{}, fmt.Errorf("error committing agent: %w", err)
+	}
+	return s.GetAgent(id)
+}
+
+// setAgentField stores a detail of an agent, keeping the ASN catalog reference in step with the ASN
+func setAgentField(tx dbtx, id int, field string, value any) error {
+	_, err := tx.Exec("UPDATE agents SET "+field+" = $1 WHERE id = $2", value, id)
+	if err != nil {
+		return fmt.Errorf("error updating agent %s: %w", field, err)
+	}
+	if field != "asn" {
+		return nil
+	}
+
+	asn, _ := value.(string)
+	asnID, err := upsertASN(tx, asn, "", nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE agents SET asn_id = $1 WHERE id = $2", asnID, id)
+	if err != nil {
+		return fmt.Errorf("error updating agent ASN: %w", err)
+	}
+	return nil
+}
+
+// applyOverrides replaces the details ip-api reported for an agent with the overridden
+// ones, recording the reported values as the provider values of the overrides
+func applyOverrides(tx dbtx, agentID int, agent *DetailedAgentRequest) error {
+	overrides, err := queryAgentOverrides(tx, agentID)
+	if err != nil {
+		return err
+	}
+
+	for _, override := range overrides {
+		_, err = tx.Exec("UPDATE agent_overrides SET provider_value = $1 WHERE agent_id = $2 AND field = $3",
+			agent.field(override.Field), agentID, override.Field)
+		if err != nil {
+			return fmt.Errorf("error recording provider value: %w", err)
+		}
+		agent.setField(override.Field, override.Value)
+	}
+	return nil
+}
+
+func queryAgentOverrides(tx dbtx, agentID int) ([]AgentOverride, error) {
+	rows, err := tx.Query(`
+	SELECT field, value, provider_value, set_by, set_at FROM agent_overrides WHERE agent_id = $1 ORDER BY field`,
+		agentID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying overrides: %w", err)
+	}
+	defer rows.Close()
+
+	var overrides []AgentOverride
+	for rows.Next() {
+		var override AgentOverride
+		err = rows.Scan(&override.Field, &override.Value, &override.ProviderValue, &override.SetBy, &override.SetAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning override: %w", err)
+		}
+		overrides = append(overrides, override)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating overrides: %w", err)
+	}
+	return overrides, nil
+}
+
+// values returns the fields the request sets by column, checking the ASN and timezone
+func (r AgentOverrideRequest) values() (map[string]any, error) {
+	values := map[string]any{}
+	texts := map[string]*string{
+		"asn": r.ASN, "isp": r.ISP, "country": r.Country, "country_code": r.CountryCode, "region": r.Region,
+		"region_name": r.RegionName, "city": r.City, "timezone": r.Timezone,
+	}
+	for field, value := range texts {
+		if value != nil {
+			values[field] = *value
+		}
+	}
+	if r.Latitude != nil {
+		values["latitude"] = *r.Latitude
+	}
+	if r.Longitude != nil {
+		values["longitude"] = *r.Longitude
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%w: no field to override", ErrInvalidOverride)
+	}
+
+	if r.ASN != nil {
+		asn, err := ParseASN(*r.ASN)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid ASN %q", ErrInvalidOverride, *r.ASN)
+		}
+		values["asn"] = fmt.Sprintf("AS%d", asn)
+	}
+	if r.Timezone != nil {
+		_, err := time.LoadLocation(*r.Timezone)
+		if err != nil || *r.Timezone == "" {
+			return nil, fmt.Errorf("%w: unknown timezone %q", ErrInvalidOverride, *r.Timezone)
+		}
+	}
+	return values, nil
+}
+
+// field returns a detail reported by ip-api by column
+func (a *DetailedAgentRequest) field(name string) any {
+	switch name {
+	case "asn":
+		return a.ASN
+	case "isp":
+		return a.ISP
+	case "country":
+		return a.Country
+	case "country_code":
+		return a.CountryCode
+	case "region":
+		return a.Region
+	case "region_name":
+		return a.RegionName
+	case "city":
+		return a.City
+	case "latitude":
+		return a.Lat
+	case "longitude":
+		return a.Lon
+	case "timezone":
+		return a.Timezone
+	}
+	return nil
+}
+
+// setField replaces a detail reported by ip-api by column
+func (a *DetailedAgentRequest) setField(name string, value any) {
+	text, _ := value.(string)
+	var coordinate *float64
+	switch v := value.(type) {
+	case float64:
+		coordinate = &v
+	case int64:
+		f := float64(v)
+		coordinate = &f
+	}
+
+	switch name {
+	case "asn":
+		a.ASN, a.ASName = text, ""
+	case "isp":
+		a.ISP = text
+	case "country":
+		a.Country = text
+	case "country_code":
+		a.CountryCode = text
+	case "region":
+		a.Region = text
+	case "region_name":
+		a.RegionName = text
+	case "city":
+		a.City = text
+	case "latitude":
+		a.Lat = coordinate
+	case "longitude":
+		a.Lon = coordinate
+	case "timezone":
+		a.Timezone = text
+	}
+}
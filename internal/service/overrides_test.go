@@ -0,0 +1,110 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAgentOverrides(t *testing.T) {
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "country": "Canada", "countryCode": "CA", "city": "Toronto",
+			"lat": 43.6532, "lon": -79.3832, "isp": "Rogers", "as": "AS812 Rogers"}`))
+	}))
+	defer ipAPI.Close()
+
+	svc := &Service{DB: db, IPInfoURL: ipAPI.URL}
+	assert.NoError(t, svc.AddAgent("10.0.14.1", nil))
+	var id int
+	assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = '10.0.14.1'").Scan(&id))
+	defer svc.purgeAgent(id)
+
+	overrideOf := func(agent DetailedAgentResponse, field string) AgentOverride {
+		t.Helper()
+		for _, override := range agent.Overrides {
+			if override.Field == field {
+				return override
+			}
+		}
+		t.Errorf("%s is not overridden", field)
+		return AgentOverride{}
+	}
+
+	isp, asn, city, latitude := "Bell Leased Line", "as577", "Montreal", 45.5019
+	agent, err := svc.SetAgentOverrides(id, AgentOverrideRequest{
+		ISP: &isp, ASN: &asn, City: &city, Latitude: &latitude, SetBy: "noc@example.com",
+	})
+	assert.NoError(t, err)
+
+	t.Run("Overrides replace the provider values", func(t *testing.T) {
+		assert.Equal(t, "Bell Leased Line", agent.ISP)
+		assert.Equal(t, "AS577", agent.ASN)
+		assert.Equal(t, "Montreal", agent.City)
+		assert.Equal(t, "Canada", agent.Country)
+		if assert.NotNil(t, agent.Latitude) {
+			assert.Equal(t, 45.5019, *agent.Latitude)
+		}
+
+		if !assert.Len(t, agent.Overrides, 4) {
+			return
+		}
+		override := overrideOf(agent, "isp")
+		assert.Equal(t, "Bell Leased Line", override.Value)
+		assert.Equal(t, "Rogers", override.ProviderValue)
+		assert.Equal(t, "noc@example.com", override.SetBy)
+		assert.False(t, override.SetAt.IsZero())
+		assert.Equal(t, 43.6532, overrideOf(agent, "latitude").ProviderValue)
+
+		listed, err := svc.GetASNAgents(577)
+		assert.NoError(t, err)
+		assert.Contains(t, ids(listed), id)
+	})
+
+	t.Run("Registering again keeps locked fields", func(t *testing.T) {
+		assert.NoError(t, svc.AddAgent("10.0.14.1", nil))
+		agent, err := svc.GetAgent(id)
+		assert.NoError(t, err)
+		assert.Equal(t, "Bell Leased Line", agent.ISP)
+		assert.Equal(t, "AS577", agent.ASN)
+		assert.Equal(t, "Montreal", agent.City)
+		assert.Equal(t, "Rogers", overrideOf(agent, "isp").ProviderValue)
+
+		// The overridden ISP did not change, so no ISP change was published
+		events, err := svc.GetEvents(EventFilter{Type: EventAgentISPChanged, AgentID: id})
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+	})
+
+	t.Run("Clearing an override restores the provider value", func(t *testing.T) {
+		agent, err := svc.ClearAgentOverride(id, "isp")
+		assert.NoError(t, err)
+		assert.Equal(t, "Rogers", agent.ISP)
+		assert.Len(t, agent.Overrides, 3)
+
+		agent, err = svc.ClearAgentOverride(id, "latitude")
+		assert.NoError(t, err)
+		if assert.NotNil(t, agent.Latitude) {
+			assert.Equal(t, 43.6532, *agent.Latitude)
+		}
+
+		_, err = svc.ClearAgentOverride(id, "isp")
+		assert.ErrorIs(t, err, ErrOverrideNotFound)
+	})
+
+	t.Run("Invalid overrides", func(t *testing.T) {
+		_, err := svc.SetAgentOverrides(id, AgentOverrideRequest{SetBy: "noc"})
+		assert.ErrorIs(t, err, ErrInvalidOverride)
+
+		badASN, badZone := "Bell", "Mars/Olympus"
+		_, err = svc.SetAgentOverrides(id, AgentOverrideRequest{ASN: &badASN, SetBy: "noc"})
+		assert.ErrorIs(t, err, ErrInvalidOverride)
+		_, err = svc.SetAgentOverrides(id, AgentOverrideRequest{Timezone: &badZone, SetBy: "noc"})
+		assert.ErrorIs(t, err, ErrInvalidOverride)
+
+		_, err = svc.ClearAgentOverride(id, "ip_address")
+		assert.ErrorIs(t, err, ErrInvalidOverride)
+		_, err = svc.SetAgentOverrides(999999, AgentOverrideRequest{ISP: &isp, SetBy: "noc"})
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+	})
+}
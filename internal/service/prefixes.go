@@ -0,0 +1,134 @@
+package service
+
+import (
+	"fmt"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"net/netip"
+	"slices"
+)
+
+// PrefixGroup gathers the agents announced by the same BGP prefix.
+type PrefixGroup struct {
+	Prefix     string `json:"prefix"`     // Such as 8.8.8.0/24
+	OriginASN  string `json:"origin_asn"` // Such as AS15169
+	AgentCount int    `json:"agent_count"`
+	AgentIDs   []int  `json:"agent_ids"`
+}
+
+// GetPrefixGroups groups the listed agents by announced prefix, the most populated prefixes
+// first. Agents whose address is not in the prefix table are left out.
+func (s *Service) GetPrefixGroups() ([]PrefixGroup, error) {
+	rows, err := s.DB.Query(`
+	SELECT id, announced_prefix, COALESCE(origin_asn, '') FROM agents
+	WHERE announced_prefix IS NOT NULL AND ` + listedAgents + `
+	ORDER BY announced_prefix, id`)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	groups := []PrefixGroup{}
+	for rows.Next() {
+		var id int
+		var prefix, origin string
+		err = rows.Scan(&id, &prefix, &origin)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		if len(groups) == 0 || groups[len(groups)-1].Prefix != prefix {
+			groups = append(groups, PrefixGroup{Prefix: prefix, OriginASN: origin})
+		}
+		group := &groups[len(groups)-1]
+		group.AgentCount++
+		group.AgentIDs = append(group.AgentIDs, id)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	// Sorting is stable, so prefixes with as many agents stay in prefix order
+	slices.SortStableFunc(groups, func(a, b PrefixGroup) int { return b.AgentCount - a.AgentCount })
+	return groups, nil
+}
+
+// RefreshAgentPrefixes resolves the announced prefix of every agent again, for instance
+// after a newer prefix table was loaded. It does nothing without a prefix table.
+func (s *Service) RefreshAgentPrefixes() error {
+	if s.Prefixes == nil {
+		return nil
+	}
+
+	rows, err := s.DB.Query("SELECT id, ip_address FROM agents")
+	if err != nil {
+		return fmt.Errorf("error executing query: %w", err)
+	}
+	var agents []Agent
+	for rows.Next() {
+		var agent Agent
+		err = rows.Scan(&agent.ID, &agent.IPAddress)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		agents = append(agents, agent)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, agent := range agents {
+		_, _, err = s.resolvePrefix(tx, agent.ID, agent.IPAddress)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing agent prefixes: %w", err)
+	}
+	return nil
+}
+
+// resolvePrefix stores and returns the most specific prefix announcing the address of an
+// agent and its origin AS, both NULL when the address is not announced. Without a prefix
+// table the stored values are left alone and nothing is returned.
+func (s *Service) resolvePrefix(tx dbtx, agentID int, ip string) (announced, origin string, err error) {
+	if s.Prefixes == nil {
+		return "", "", nil
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err == nil {
+		if route, ok := s.Prefixes.Lookup(addr); ok {
+			announced, origin = route.Prefix.String(), route.OriginASN()
+		}
+	}
+
+	_, err = tx.Exec("UPDATE agents SET announced_prefix = NULLIF($1, ''), origin_asn = NULLIF($2, '') WHERE id = $3",
+		announced, origin, agentID)
+	if err != nil {
+		return "", "", fmt.Errorf("error storing announced prefix: %w", err)
+	}
+
+	source := enrich.Source{FetchedAt: s.Prefixes.LoadedAt(), ResponseHash: s.Prefixes.Hash()}
+	for field, value := range map[string]string{"announced_prefix": announced, "origin_asn": origin} {
+		var stored any
+		if value != "" {
+			stored = value
+		}
+		err = recordProvenance(tx, agentID, field, ProviderPrefixTable, stored, source, nil)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return announced, origin, nil
+}
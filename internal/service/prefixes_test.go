@@ -0,0 +1,92 @@
+package service
+
+import (
+	"github.com/Shaughny/obkio-test/internal/prefix"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAnnouncedPrefixes(t *testing.T) {
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success", "isp": "Example", "as": "AS64500 Example"}`))
+	}))
+	defer ipAPI.Close()
+
+	table, err := prefix.Parse(strings.NewReader(`
+198.51.0.0	16	64510
+198.51.100.0	24	64511
+203.0.113.0/24 64512_64513
+`))
+	assert.NoError(t, err)
+	svc := &Service{DB: db, IPInfoURL: ipAPI.URL, Prefixes: table}
+
+	agents := map[string]int{}
+	for _, ip := range []string{"198.51.100.7", "198.51.100.8", "198.51.7.1", "203.0.113.9", "192.0.2.1"} {
+		assert.NoError(t, svc.AddAgent(ip, nil))
+		var id int
+		assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = $1", ip).Scan(&id))
+		agents[ip] = id
+		defer svc.purgeAgent(id)
+	}
+
+	t.Run("Longest prefix match is stored", func(t *testing.T) {
+		for ip, want := range map[string][2]string{
+			"198.51.100.7": {"198.51.100.0/24", "AS64511"},
+			"198.51.7.1":   {"198.51.0.0/16", "AS64510"},
+			"203.0.113.9":  {"203.0.113.0/24", "AS64512"},
+			"192.0.2.1":    {"", ""},
+		} {
+			agent, err := svc.GetAgent(agents[ip])
+			assert.NoError(t, err)
+			assert.Equal(t, want[0], agent.AnnouncedPrefix, ip)
+			assert.Equal(t, want[1], agent.OriginASN, ip)
+		}
+	})
+
+	t.Run("Group by prefix", func(t *testing.T) {
+		groups, err := svc.GetPrefixGroups()
+		assert.NoError(t, err)
+		byPrefix := map[string]PrefixGroup{}
+		for _, group := range groups {
+			byPrefix[group.Prefix] = group
+		}
+		assert.Equal(t, PrefixGroup{
+			Prefix: "198.51.100.0/24", OriginASN: "AS64511", AgentCount: 2,
+			AgentIDs: []int{agents["198.51.100.7"], agents["198.51.100.8"]},
+		}, byPrefix["198.51.100.0/24"])
+		assert.Equal(t, 1, byPrefix["198.51.0.0/16"].AgentCount)
+		assert.NotContains(t, byPrefix, "")
+
+		filtered, err := svc.GetAgents(AgentFilter{Prefix: "198.51.100.0/24"})
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []int{agents["198.51.100.7"], agents["198.51.100.8"]}, ids(filtered))
+
+		// Prefixes are matched in their canonical form
+		filtered, err = svc.GetAgents(AgentFilter{Prefix: "198.51.100.1/24"})
+		assert.NoError(t, err)
+		assert.Len(t, filtered, 2)
+	})
+
+	t.Run("Refresh with a newer table", func(t *testing.T) {
+		svc.Prefixes, err = prefix.Parse(strings.NewReader("192.0.2.0/24 64520\n198.51.0.0/16 64510\n"))
+		assert.NoError(t, err)
+		assert.NoError(t, svc.RefreshAgentPrefixes())
+
+		agent, err := svc.GetAgent(agents["192.0.2.1"])
+		assert.NoError(t, err)
+		assert.Equal(t, "192.0.2.0/24", agent.AnnouncedPrefix)
+		assert.Equal(t, "AS64520", agent.OriginASN)
+
+		// The more specific prefix was withdrawn
+		agent, err = svc.GetAgent(agents["198.51.100.7"])
+		assert.NoError(t, err)
+		assert.Equal(t, "198.51.0.0/16", agent.AnnouncedPrefix)
+
+		agent, err = svc.GetAgent(agents["203.0.113.9"])
+		assert.NoError(t, err)
+		assert.Empty(t, agent.AnnouncedPrefix)
+	})
+}
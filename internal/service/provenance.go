@@ -0,0 +1,166 @@
+package service
+
+import (
+	"fmt"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"slices"
+	"time"
+)
+
+// Providers agent details are enriched from.
+const (
+	ProviderIPAPI       = "ip-api"
+	ProviderReverseDNS  = "reverse-dns"
+	ProviderRDAP        = "rdap"
+	ProviderPrefixTable = "prefix-table"
+	ProviderManual      = "manual" // Overrides set by hand
+)
+
+// FieldProvenance tells where an agent detail comes from: which provider reported the
+// value, when and in which response.
+type FieldProvenance struct {
+	Field        string    `json:"field"`
+	Provider     string    `json:"provider"`
+	Value        any       `json:"value"`
+	ResponseHash string    `json:"response_hash,omitempty"` // Hex encoded SHA-256 of the raw response
+	Confidence   *float64  `json:"confidence,omitempty"`    // Between 0 and 1, when the provider gives one
+	FetchedAt    time.Time `json:"fetched_at"`              // Latest time the provider reported the value
+	RecordedAt   time.Time `json:"recorded_at"`             // When the provider first reported the value
+}
+
+// ProvenanceFilter narrows down the provenance history listed.
+type ProvenanceFilter struct {
+	Field string `query:"field" validate:"omitempty,max=50"`
+	Limit int    `query:"limit" validate:"omitempty,gte=1,lte=1000"` // Defaults to 100
+}
+
+// GetAgentProvenance retrieves where each enriched detail of an agent currently comes
+// from. Overridden details come from the override.
+func (s *Service) GetAgentProvenance(id int) ([]FieldProvenance, error) {
+	err := s.agentExists(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return queryProvenance(s.DB, `
+	SELECT field, provider, value, response_hash, confidence, fetched_at, recorded_at
+	FROM agent_provenance p
+	WHERE agent_id = $1 AND id = (
+		SELECT MAX(id) FROM agent_provenance l
+		WHERE l.agent_id = p.agent_id AND l.field = p.field
+		  AND (l.provider = $2) = EXISTS (SELECT 1 FROM agent_overrides o WHERE o.agent_id = p.agent_id AND o.field = p.field)
+	)
+	ORDER BY field`, id, ProviderManual)
+}
+
+// GetProvenanceHistory retrieves every value the providers reported for the details of
+// an agent, latest first.
+func (s *Service) GetProvenanceHistory(id int, filter ProvenanceFilter) ([]FieldProvenance, error) {
+	err := s.agentExists(id)
+	if err != nil {
+		return nil, err
+	}
+	if filter.Limit == 0 {
+		filter.Limit = 100
+	}
+
+	return queryProvenance(s.DB, `
+	SELECT field, provider, value, response_hash, confidence, fetched_at, recorded_at
+	FROM agent_provenance
+	WHERE agent_id = $1 AND ($2 = '' OR field = $2)
+	ORDER BY id DESC
+	LIMIT $3`, id, filter.Field, filter.Limit)
+}
+
+// recordProvenance records the value a provider reported for an agent detail. The
+// latest value the provider reported is only refreshed when it did not change, so the
+// history holds one row per change.
+func recordProvenance(tx dbtx, agentID int, field, provider string, value any, source enrich.Source, confidence *float64) error {
+	res, err := tx.Exec(`
+	UPDATE agent_provenance SET fetched_at = $1, response_hash = $2, confidence = $3
+	WHERE id = (SELECT MAX(id) FROM agent_provenance WHERE agent_id = $4 AND field = $5 AND provider = $6)
+	  AND value IS $7`,
+		source.FetchedAt.UTC(), source.ResponseHash, confidence, agentID, field, provider, value,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating %s provenance: %w", field, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error updating %s provenance: %w", field, err)
+	}
+	if updated > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO agent_provenance (agent_id, field, provider, value, response_hash, confidence, fetched_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		agentID, field, provider, value, source.ResponseHash, confidence, source.FetchedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting %s provenance: %w", field, err)
+	}
+	return nil
+}
+
+// recordAgentProvenance records the details ip-api reported for an agent, before
+// overrides are applied, and the results of the reverse DNS and RDAP lookups
+func recordAgentProvenance(tx dbtx, agentID int, reported DetailedAgentRequest, enriched enrichment) error {
+	values := map[string]any{"hosting": reported.Hosting, "proxy": reported.Proxy, "mobile": reported.Mobile}
+	for _, field := range overrideFields {
+		values[field] = reported.field(field)
+	}
+	for field, value := range values {
+		err := recordProvenance(tx, agentID, field, ProviderIPAPI, value, reported.Source, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if enriched.ptr != nil {
+		err := recordProvenance(tx, agentID, "ptr", ProviderReverseDNS, *enriched.ptr, enriched.ptrSource, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if enriched.rdap != nil {
+		for field, value := range map[string]string{
+			"rdap_name": enriched.rdap.Name, "rdap_handle": enriched.rdap.Handle, "rdap_abuse_email": enriched.rdap.AbuseEmail,
+		} {
+			err := recordProvenance(tx, agentID, field, ProviderRDAP, value, enriched.rdapSource, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func queryProvenance(tx dbtx, query string, args ...any) ([]FieldProvenance, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying provenance: %w", err)
+	}
+	defer rows.Close()
+
+	provenance := []FieldProvenance{}
+	for rows.Next() {
+		var p FieldProvenance
+		err = rows.Scan(&p.Field, &p.Provider, &p.Value, &p.ResponseHash, &p.Confidence, &p.FetchedAt, &p.RecordedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning provenance: %w", err)
+		}
+		// SQLite stores the network flags as integers
+		if flag, ok := p.Value.(int64); ok && slices.Contains([]string{"hosting", "proxy", "mobile"}, p.Field) {
+			p.Value = flag != 0
+		}
+		provenance = append(provenance, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating provenance: %w", err)
+	}
+	return provenance, nil
+}
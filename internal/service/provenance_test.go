@@ -0,0 +1,135 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"github.com/Shaughny/obkio-test/internal/enrich/enrichtest"
+	"github.com/Shaughny/obkio-test/internal/prefix"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAgentProvenance(t *testing.T) {
+	var isp atomic.Value
+	isp.Store("Rogers")
+	var lastBody atomic.Value
+	ipAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `{"status": "success", "country": "Canada", "countryCode": "CA", "lat": 43.6532, "lon": -79.3832,
+			"isp": "` + isp.Load().(string) + `", "as": "AS812 Rogers", "hosting": true}`
+		lastBody.Store(body)
+		w.Write([]byte(body))
+	}))
+	defer ipAPI.Close()
+	dns := enrichtest.NewDNSServer()
+	defer dns.Close()
+	rdap := enrichtest.NewRDAPServer()
+	defer rdap.Close()
+	dns.SetPTR("10.0.15.1", "agent-1.example.net")
+	rdap.SetNetwork("10.0.15.1", enrich.Network{Name: "ROGERS", Handle: "NET-10-0-15-0-1"})
+	table, err := prefix.Parse(strings.NewReader("10.0.15.0/24 812\n"))
+	assert.NoError(t, err)
+
+	svc := &Service{
+		DB:         db,
+		IPInfoURL:  ipAPI.URL,
+		ReverseDNS: &enrich.ReverseDNS{Resolver: dns.Resolver(), Timeout: 200 * time.Millisecond, CacheTTL: -1},
+		RDAP:       &enrich.RDAP{BaseURL: rdap.URL, Timeout: time.Second, CacheTTL: -1},
+		Prefixes:   table,
+	}
+	assert.NoError(t, svc.AddAgent("10.0.15.1", nil))
+	var id int
+	assert.NoError(t, db.QueryRow("SELECT id FROM agents WHERE ip_address = '10.0.15.1'").Scan(&id))
+	defer svc.purgeAgent(id)
+
+	provenanceOf := func(field string) FieldProvenance {
+		t.Helper()
+		provenance, err := svc.GetAgentProvenance(id)
+		assert.NoError(t, err)
+		for _, p := range provenance {
+			if p.Field == field {
+				return p
+			}
+		}
+		t.Errorf("no provenance for %s", field)
+		return FieldProvenance{}
+	}
+
+	t.Run("Each provider is recorded", func(t *testing.T) {
+		sum := sha256.Sum256([]byte(lastBody.Load().(string)))
+		isp := provenanceOf("isp")
+		assert.Equal(t, ProviderIPAPI, isp.Provider)
+		assert.Equal(t, "Rogers", isp.Value)
+		assert.Equal(t, hex.EncodeToString(sum[:]), isp.ResponseHash)
+		assert.Nil(t, isp.Confidence)
+		assert.WithinDuration(t, time.Now(), isp.FetchedAt, time.Minute)
+
+		assert.Equal(t, 43.6532, provenanceOf("latitude").Value)
+		assert.Equal(t, true, provenanceOf("hosting").Value)
+		assert.Equal(t, ProviderReverseDNS, provenanceOf("ptr").Provider)
+		assert.Equal(t, "NET-10-0-15-0-1", provenanceOf("rdap_handle").Value)
+		assert.NotEmpty(t, provenanceOf("rdap_handle").ResponseHash)
+
+		announced := provenanceOf("announced_prefix")
+		assert.Equal(t, ProviderPrefixTable, announced.Provider)
+		assert.Equal(t, "10.0.15.0/24", announced.Value)
+		assert.Equal(t, table.Hash(), announced.ResponseHash)
+		assert.Equal(t, table.LoadedAt(), announced.FetchedAt.UTC())
+	})
+
+	t.Run("Unchanged values are refreshed", func(t *testing.T) {
+		assert.NoError(t, svc.AddAgent("10.0.15.1", nil))
+		history, err := svc.GetProvenanceHistory(id, ProvenanceFilter{Field: "isp"})
+		assert.NoError(t, err)
+		assert.Len(t, history, 1)
+	})
+
+	t.Run("Changed values are kept as history", func(t *testing.T) {
+		isp.Store("Rogers Business")
+		assert.NoError(t, svc.AddAgent("10.0.15.1", nil))
+		assert.Equal(t, "Rogers Business", provenanceOf("isp").Value)
+
+		history, err := svc.GetProvenanceHistory(id, ProvenanceFilter{Field: "isp"})
+		assert.NoError(t, err)
+		if assert.Len(t, history, 2) {
+			assert.Equal(t, "Rogers Business", history[0].Value)
+			assert.Equal(t, "Rogers", history[1].Value)
+			assert.NotEqual(t, history[0].ResponseHash, history[1].ResponseHash)
+		}
+
+		limited, err := svc.GetProvenanceHistory(id, ProvenanceFilter{Limit: 1})
+		assert.NoError(t, err)
+		assert.Len(t, limited, 1)
+	})
+
+	t.Run("Overridden details come from the override", func(t *testing.T) {
+		city, confidence := "Montreal", 0.9
+		_, err := svc.SetAgentOverrides(id, AgentOverrideRequest{City: &city, Confidence: &confidence, SetBy: "noc@example.com"})
+		assert.NoError(t, err)
+
+		// Registering again keeps recording what ip-api reports
+		assert.NoError(t, svc.AddAgent("10.0.15.1", nil))
+		manual := provenanceOf("city")
+		assert.Equal(t, ProviderManual, manual.Provider)
+		assert.Equal(t, "Montreal", manual.Value)
+		if assert.NotNil(t, manual.Confidence) {
+			assert.Equal(t, 0.9, *manual.Confidence)
+		}
+
+		_, err = svc.ClearAgentOverride(id, "city")
+		assert.NoError(t, err)
+		assert.Equal(t, ProviderIPAPI, provenanceOf("city").Provider)
+	})
+
+	t.Run("Unknown agent", func(t *testing.T) {
+		_, err := svc.GetAgentProvenance(999999)
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+		_, err = svc.GetProvenanceHistory(999999, ProvenanceFilter{})
+		assert.ErrorIs(t, err, ErrAgentNotFound)
+	})
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Region groups sites, for instance by country or metropolitan area. Regions can be
+// nested in a parent region.
+type Region struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  *int      `json:"parent_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RegionRequest defines the structure for creating or updating a region.
+type RegionRequest struct {
+	Name     string `json:"name" validate:"required,max=100"`
+	ParentID *int   `json:"parent_id" validate:"omitempty,gte=1"`
+}
+
+// ErrRegionNotFound is returned when a region is not found in the database.
+var ErrRegionNotFound = errors.New("region not found")
+
+// ErrInvalidRegion is returned when a region has an unknown parent, would be its own
+// ancestor, or is deleted while it still holds regions or sites.
+var ErrInvalidRegion = errors.New("invalid region")
+
+const regionColumns = "SELECT id, name, parent_id, created_at, updated_at FROM regions"
+
+// CreateRegion creates a region.
+func (s *Service) CreateRegion(req RegionRequest) (Region, error) {
+	err := s.checkRegionParent(0, req.ParentID)
+	if err != nil {
+		return Region{}, err
+	}
+
+	res, err := s.DB.Exec("INSERT INTO regions (name, parent_id) VALUES ($1, $2)", req.Name, req.ParentID)
+	if err != nil {
+		return Region{}, fmt.Errorf("error inserting region: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Region{}, fmt.Errorf("error reading region ID: %w", err)
+	}
+	return s.GetRegion(int(id))
+}
+
+// GetRegions retrieves all regions.
+func (s *Service) GetRegions() ([]Region, error) {
+	rows, err := s.DB.Query(regionColumns + " ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	regions := []Region{}
+	for rows.Next() {
+		region, err := scanRegion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		regions = append(regions, region)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return regions, nil
+}
+
+// GetRegion retrieves a region by ID.
+func (s *Service) GetRegion(id int) (Region, error) {
+	region, err := scanRegion(s.DB.QueryRow(regionColumns+" WHERE id = $1", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return region, ErrRegionNotFound
+		}
+		return region, fmt.Errorf("error fetching region: %w", err)
+	}
+	return region, nil
+}
+
+// UpdateRegion renames a region or moves it to another parent.
+func (s *Service) UpdateRegion(id int, req RegionRequest) (Region, error) {
+	_, err := s.GetRegion(id)
+	if err != nil {
+		return Region{}, err
+	}
+	err = s.checkRegionParent(id, req.ParentID)
+	if err != nil {
+		return Region{}, err
+	}
+
+	_, err = s.DB.Exec("UPDATE regions SET name = $1, parent_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3",
+		req.Name, req.ParentID, id)
+	if err != nil {
+		return Region{}, fmt.Errorf("error updating region: %w", err)
+	}
+	return s.GetRegion(id)
+}
+
+// DeleteRegion removes a region that holds no regions or sites.
+func (s *Service) DeleteRegion(id int) error {
+	_, err := s.GetRegion(id)
+	if err != nil {
+		return err
+	}
+
+	var children, sites int
+	err = s.DB.QueryRow(`
+	SELECT (SELECT COUNT(*) FROM regions WHERE parent_id = $1), (SELECT COUNT(*) FROM sites WHERE region_id = $1)`,
+		id,
+	).Scan(&children, &sites)
+	if err != nil {
+		return fmt.Errorf("error checking region contents: %w", err)
+	}
+	if children > 0 || sites > 0 {
+		return fmt.Errorf("%w: region %d still holds %d regions and %d sites", ErrInvalidRegion, id, children, sites)
+	}
+
+	_, err = s.DB.Exec("DELETE FROM regions WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting region: %w", err)
+	}
+	return nil
+}
+
+// checkRegionParent verifies that the parent of a region exists and is not the region
+// itself or one of its descendants. New regions have ID 0.
+func (s *Service) checkRegionParent(id int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+
+	// Walk up from the parent; reaching the region means it would be its own ancestor
+	for ancestor := parentID; ancestor != nil; {
+		if *ancestor == id {
+			return fmt.Errorf("%w: region %d cannot be nested in itself", ErrInvalidRegion, id)
+		}
+		region, err := s.GetRegion(*ancestor)
+		if errors.Is(err, ErrRegionNotFound) {
+			return fmt.Errorf("%w: parent region %d not found", ErrInvalidRegion, *ancestor)
+		}
+		if err != nil {
+			return err
+		}
+		ancestor = region.ParentID
+	}
+	return nil
+}
+
+func scanRegion(row rowScanner) (Region, error) {
+	var region Region
+	err := row.Scan(&region.ID, &region.Name, &region.ParentID, &region.CreatedAt, &region.UpdatedAt)
+	return region, err
+}
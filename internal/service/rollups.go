@@ -0,0 +1,374 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Shaughny/obkio-test/internal/sketch"
+)
+
+// compactionDelay is how long raw samples are left alone before being rolled up,
+// giving late batches a chance to arrive. Samples older than a resolution's
+// watermark when they arrive are kept raw but are not part of its rollups.
+const compactionDelay = 2 * time.Minute
+
+// compactionChunkBuckets is the number of buckets rolled up per transaction.
+const compactionChunkBuckets = 60
+
+// RetentionPolicy defines how long data is kept at each resolution.
+// A zero duration keeps data forever.
+type RetentionPolicy struct {
+	Raw    time.Duration
+	Minute time.Duration
+	Hour   time.Duration
+	Day    time.Duration
+}
+
+// forResolution returns the retention of a rollup resolution
+func (p RetentionPolicy) forResolution(r time.Duration) time.Duration {
+	switch r {
+	case time.Minute:
+		return p.Minute
+	case time.Hour:
+		return p.Hour
+	case 24 * time.Hour:
+		return p.Day
+	}
+	return 0
+}
+
+// RetentionResult reports how many rows a retention run removed.
+type RetentionResult struct {
+	RawSamples         int64
+	Rollups            int64
+	EmailNotifications int64
+}
+
+// rollupKey identifies one rollup bucket
+type rollupKey struct {
+	agentID     int
+	sessionID   int
+	target      string
+	metric      string
+	bucketStart int64
+}
+
+// CompactMetrics rolls raw samples up into 1-minute aggregates, and each resolution
+// into the next coarser one, for every complete bucket up to now. Each resolution
+// keeps a watermark so only new buckets are processed on every run.
+func (s *Service) CompactMetrics(now time.Time) error {
+	available := now.Add(-compactionDelay).Unix()
+
+	for i, r := range rollupResolutions {
+		var source time.Duration // Raw samples for the finest resolution
+		if i > 0 {
+			source = rollupResolutions[i-1]
+			watermark, err := s.rollupWatermark(source)
+			if err != nil {
+				return err
+			}
+			available = watermark
+		}
+
+		err := s.compactResolution(r, source, available)
+		if err != nil {
+			return fmt.Errorf("error compacting %s rollups: %w", resolutionName(r), err)
+		}
+	}
+
+	return nil
+}
+
+// compactResolution rolls the source data up to resolution r for buckets ending before available
+func (s *Service) compactResolution(r, source time.Duration, available int64) error {
+	step := int64(r / time.Second)
+	end := available - mod(available, step)
+
+	start, err := s.rollupWatermark(r)
+	if err != nil {
+		return err
+	}
+
+	for start < end {
+		// Skip over gaps without data instead of writing empty chunks
+		next, err := s.nextSourceTime(source, start)
+		if err != nil {
+			return err
+		}
+		if next < 0 || next >= end {
+			return s.setRollupWatermark(r, end)
+		}
+		start = next - mod(next, step)
+
+		chunkEnd := min(start+step*compactionChunkBuckets, end)
+		err = s.compactChunk(r, source, start, chunkEnd)
+		if err != nil {
+			return err
+		}
+		start = chunkEnd
+	}
+	return nil
+}
+
+// compactChunk aggregates [from, to) and stores the rollups together with the new watermark
+func (s *Service) compactChunk(r, source time.Duration, from, to int64) error {
+	var buckets map[rollupKey]*aggregator
+	var err error
+	if source == 0 {
+		buckets, err = s.aggregateRawSamples(r, from, to)
+	} else {
+		buckets, err = s.aggregateRollups(r, source, from, to)
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+	INSERT OR REPLACE INTO metric_rollups (agent_id, session_id, target, metric, resolution, bucket_start, count, sum, min, max, sketch)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`)
+	if err != nil {
+		return fmt.Errorf("error preparing rollup insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for key, agg := range buckets {
+		encodedSketch, err := json.Marshal(agg.toSketch())
+		if err != nil {
+			return fmt.Errorf("error encoding rollup sketch: %w", err)
+		}
+		_, err = stmt.Exec(
+			key.agentID, key.sessionID, key.target, key.metric, int64(r/time.Second), key.bucketStart,
+			agg.count, agg.sum, agg.min, agg.max, string(encodedSketch),
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting rollup: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(
+		"INSERT OR REPLACE INTO rollup_watermarks (resolution, watermark) VALUES ($1, $2)",
+		int64(r/time.Second), to,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating rollup watermark: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing rollups: %w", err)
+	}
+	return nil
+}
+
+// aggregateRawSamples groups raw samples in [from, to) into buckets of resolution r
+func (s *Service) aggregateRawSamples(r time.Duration, from, to int64) (map[rollupKey]*aggregator, error) {
+	rows, err := s.DB.Query(`
+	SELECT agent_id, session_id, target, ts, latency_ms, jitter_ms, packet_loss, throughput_kbps
+	FROM metrics WHERE ts >= $1 AND ts < $2`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error querying metrics: %w", err)
+	}
+	defer rows.Close()
+
+	step := int64(r / time.Second)
+	buckets := make(map[rollupKey]*aggregator)
+	for rows.Next() {
+		var agentID, sessionID int
+		var target string
+		var ts int64
+		values := make([]*float64, len(metricColumns))
+		err = rows.Scan(&agentID, &sessionID, &target, &ts, &values[0], &values[1], &values[2], &values[3])
+		if err != nil {
+			return nil, fmt.Errorf("error scanning metric: %w", err)
+		}
+
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+			key := rollupKey{
+				agentID: agentID, sessionID: sessionID, target: target,
+				metric: metricColumns[i], bucketStart: ts - mod(ts, step),
+			}
+			if buckets[key] == nil {
+				buckets[key] = &aggregator{}
+			}
+			buckets[key].addValue(*value)
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating metrics: %w", err)
+	}
+	return buckets, nil
+}
+
+// aggregateRollups merges rollups of the source resolution in [from, to) into buckets of resolution r
+func (s *Service) aggregateRollups(r, source time.Duration, from, to int64) (map[rollupKey]*aggregator, error) {
+	rows, err := s.DB.Query(`
+	SELECT agent_id, session_id, target, metric, bucket_start, count, sum, min, max, sketch
+	FROM metric_rollups WHERE resolution = $1 AND bucket_start >= $2 AND bucket_start < $3`,
+		int64(source/time.Second), from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error querying rollups: %w", err)
+	}
+	defer rows.Close()
+
+	step := int64(r / time.Second)
+	buckets := make(map[rollupKey]*aggregator)
+	for rows.Next() {
+		var key rollupKey
+		var encodedSketch string
+		var rollup aggregator
+		err = rows.Scan(&key.agentID, &key.sessionID, &key.target, &key.metric, &key.bucketStart,
+			&rollup.count, &rollup.sum, &rollup.min, &rollup.max, &encodedSketch)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning rollup: %w", err)
+		}
+		rollup.sketch = sketch.New()
+		err = json.Unmarshal([]byte(encodedSketch), rollup.sketch)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding rollup sketch: %w", err)
+		}
+
+		key.bucketStart -= mod(key.bucketStart, step)
+		if buckets[key] == nil {
+			buckets[key] = &aggregator{}
+		}
+		buckets[key].merge(&rollup)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rollups: %w", err)
+	}
+	return buckets, nil
+}
+
+// nextSourceTime returns the time of the first raw sample, or rollup of the given
+// resolution, at or after from. It returns -1 if there is none.
+func (s *Service) nextSourceTime(source time.Duration, from int64) (int64, error) {
+	var next *int64
+	var err error
+	if source == 0 {
+		err = s.DB.QueryRow("SELECT MIN(ts) FROM metrics WHERE ts >= $1", from).Scan(&next)
+	} else {
+		err = s.DB.QueryRow(
+			"SELECT MIN(bucket_start) FROM metric_rollups WHERE resolution = $1 AND bucket_start >= $2",
+			int64(source/time.Second), from,
+		).Scan(&next)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error finding next data to roll up: %w", err)
+	}
+	if next == nil {
+		return -1, nil
+	}
+	return *next, nil
+}
+
+func (s *Service) setRollupWatermark(r time.Duration, watermark int64) error {
+	_, err := s.DB.Exec(
+		"INSERT OR REPLACE INTO rollup_watermarks (resolution, watermark) VALUES ($1, $2)",
+		int64(r/time.Second), watermark,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating rollup watermark: %w", err)
+	}
+	return nil
+}
+
+// ApplyRetention deletes data older than the retention of its resolution and returns
+// the freed pages to the file system. Data that has not been rolled up into the next
+// resolution yet is always kept, so a short retention never loses history.
+func (s *Service) ApplyRetention(now time.Time) (RetentionResult, error) {
+	var result RetentionResult
+
+	// Raw samples
+	if s.Retention.Raw > 0 {
+		watermark, err := s.rollupWatermark(rollupResolutions[0])
+		if err != nil {
+			return result, err
+		}
+		cutoff := min(now.Add(-s.Retention.Raw).Unix(), watermark)
+		res, err := s.DB.Exec("DELETE FROM metrics WHERE ts < $1", cutoff)
+		if err != nil {
+			return result, fmt.Errorf("error deleting raw metrics: %w", err)
+		}
+		result.RawSamples, _ = res.RowsAffected()
+
+		// Replays older than the raw retention can no longer be told apart, so their sequence numbers go too
+		_, err = s.DB.Exec("DELETE FROM metric_batches WHERE received_at < $1", now.Add(-s.Retention.Raw).UTC().Format(time.DateTime))
+		if err != nil {
+			return result, fmt.Errorf("error deleting metric batches: %w", err)
+		}
+	}
+
+	// Rollups
+	for i, r := range rollupResolutions {
+		retention := s.Retention.forResolution(r)
+		if retention <= 0 {
+			continue
+		}
+		cutoff := now.Add(-retention).Unix()
+		if i+1 < len(rollupResolutions) {
+			watermark, err := s.rollupWatermark(rollupResolutions[i+1])
+			if err != nil {
+				return result, err
+			}
+			cutoff = min(cutoff, watermark)
+		}
+		res, err := s.DB.Exec(
+			"DELETE FROM metric_rollups WHERE resolution = $1 AND bucket_start < $2", int64(r/time.Second), cutoff,
+		)
+		if err != nil {
+			return result, fmt.Errorf("error deleting %s rollups: %w", resolutionName(r), err)
+		}
+		deleted, _ := res.RowsAffected()
+		result.Rollups += deleted
+	}
+
+	// Email notifications queued without a mailer would never be sent
+	if s.Mailer == nil {
+		res, err := s.DB.Exec("DELETE FROM email_notifications WHERE status = $1", EmailPending)
+		if err != nil {
+			return result, fmt.Errorf("error deleting unsendable email notifications: %w", err)
+		}
+		result.EmailNotifications, _ = res.RowsAffected()
+	}
+
+	// Hand free pages back to the file system (a no-op unless auto_vacuum is INCREMENTAL)
+	if result.RawSamples+result.Rollups > 0 {
+		err := s.incrementalVacuum()
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// incrementalVacuum releases all free pages. The pragma yields a row per page
+// freed, so it has to be stepped to completion rather than executed once.
+func (s *Service) incrementalVacuum() error {
+	rows, err := s.DB.Query("PRAGMA incremental_vacuum")
+	if err != nil {
+		return fmt.Errorf("error vacuuming database: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("error vacuuming database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCompactMetrics(t *testing.T) {
+	defer db.Exec("DELETE FROM metric_rollups")
+	defer db.Exec("DELETE FROM rollup_watermarks")
+
+	svc := &Service{DB: db}
+	agentID := insertTestAgent(t, "10.0.1.1")
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// One sample per second for three minutes, latency equal to the second within the minute
+	var samples []MetricSample
+	for i := 0; i < 180; i++ {
+		samples = append(samples, MetricSample{
+			Timestamp: base.Add(time.Duration(i) * time.Second), Target: "1.1.1.1", LatencyMs: floatPtr(float64(i % 60)),
+		})
+	}
+	_, err := svc.IngestMetrics(agentID, MetricBatch{Sequence: 1, Samples: samples})
+	assert.NoError(t, err)
+
+	t.Run("Rolls complete buckets up", func(t *testing.T) {
+		err := svc.CompactMetrics(base.Add(2 * time.Hour))
+		assert.NoError(t, err)
+
+		var count, minuteRollups int
+		var sum float64
+		err = db.QueryRow("SELECT COUNT(*) FROM metric_rollups WHERE agent_id = ? AND resolution = 60 AND metric = 'latency_ms'",
+			agentID).Scan(&minuteRollups)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, minuteRollups)
+
+		err = db.QueryRow("SELECT count, sum FROM metric_rollups WHERE agent_id = ? AND resolution = 3600 AND bucket_start = ?",
+			agentID, base.Unix()).Scan(&count, &sum)
+		assert.NoError(t, err)
+		assert.Equal(t, 180, count)
+		assert.Equal(t, 3*1770.0, sum)
+
+		watermark, err := svc.rollupWatermark(time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, base.Add(time.Hour).Unix(), watermark)
+	})
+
+	t.Run("Rollups keep percentiles", func(t *testing.T) {
+		resp, err := svc.QueryMetrics(agentID, MetricQuery{
+			From: base.Add(-12 * time.Hour), To: base.Add(time.Hour), Step: time.Hour,
+			Metric: "latency_ms", Aggregation: AggP50,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "1h", resp.Resolution)
+		assert.InDelta(t, 29.5, *resp.Series[0].Values[12], 1)
+	})
+
+	t.Run("Is idempotent", func(t *testing.T) {
+		err := svc.CompactMetrics(base.Add(2 * time.Hour))
+		assert.NoError(t, err)
+
+		var count int
+		err = db.QueryRow("SELECT count FROM metric_rollups WHERE agent_id = ? AND resolution = 3600", agentID).Scan(&count)
+		assert.NoError(t, err)
+		assert.Equal(t, 180, count)
+	})
+}
+
+func TestApplyRetention(t *testing.T) {
+	defer db.Exec("DELETE FROM metric_rollups")
+	defer db.Exec("DELETE FROM rollup_watermarks")
+
+	svc := &Service{DB: db, Retention: RetentionPolicy{Raw: time.Hour, Minute: 24 * time.Hour}}
+	agentID := insertTestAgent(t, "10.0.1.2")
+	now := time.Now().Truncate(time.Minute)
+	old := now.Add(-3 * time.Hour)
+
+	_, err := svc.IngestMetrics(agentID, MetricBatch{Sequence: 1, Samples: []MetricSample{
+		{Timestamp: old, Target: "1.1.1.1", LatencyMs: floatPtr(5)},
+		{Timestamp: now.Add(-time.Minute), Target: "1.1.1.1", LatencyMs: floatPtr(5)},
+	}})
+	assert.NoError(t, err)
+
+	countRaw := func() int {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM metrics WHERE agent_id = ?", agentID).Scan(&count)
+		assert.NoError(t, err)
+		return count
+	}
+
+	t.Run("Keeps samples that are not rolled up", func(t *testing.T) {
+		result, err := svc.ApplyRetention(now)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), result.RawSamples)
+		assert.Equal(t, 2, countRaw())
+	})
+
+	t.Run("Deletes expired samples once rolled up", func(t *testing.T) {
+		assert.NoError(t, svc.CompactMetrics(now))
+
+		_, err := svc.ApplyRetention(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, countRaw())
+
+		var rollups int
+		err = db.QueryRow("SELECT COUNT(*) FROM metric_rollups WHERE agent_id = ? AND resolution = 60", agentID).Scan(&rollups)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, rollups) // The recent sample is not rolled up yet
+	})
+}
+
+func TestQueryExpiredRawMetrics(t *testing.T) {
+	defer db.Exec("DELETE FROM metric_rollups")
+	defer db.Exec("DELETE FROM rollup_watermarks")
+
+	retention := RetentionPolicy{Raw: 48 * time.Hour, Minute: 14 * 24 * time.Hour, Hour: 90 * 24 * time.Hour}
+	svc := &Service{DB: db, Retention: retention}
+	agentID := insertTestAgent(t, "10.0.1.3")
+	now := time.Now().Truncate(time.Hour)
+	old := now.Add(-72 * time.Hour)
+
+	_, err := svc.IngestMetrics(agentID, MetricBatch{Sequence: 1, Samples: []MetricSample{
+		{Timestamp: old, Target: "1.1.1.1", LatencyMs: floatPtr(5)},
+		{Timestamp: old.Add(time.Minute), Target: "1.1.1.1", LatencyMs: floatPtr(7)},
+	}})
+	assert.NoError(t, err)
+	assert.NoError(t, svc.CompactMetrics(now))
+	_, err = svc.ApplyRetention(now)
+	assert.NoError(t, err)
+
+	t.Run("Short ranges read rollups once raw samples expired", func(t *testing.T) {
+		resp, err := svc.QueryMetrics(agentID, MetricQuery{
+			From: old.Add(-10 * time.Minute), To: old.Add(50 * time.Minute), Metric: "latency_ms", Aggregation: AggAvg,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "1m", resp.Resolution)
+		if assert.Len(t, resp.Series, 1) {
+			var values []float64
+			for _, value := range resp.Series[0].Values {
+				if value != nil {
+					values = append(values, *value)
+				}
+			}
+			assert.Equal(t, []float64{5, 7}, values)
+		}
+	})
+
+	t.Run("Picks the finest rollup kept", func(t *testing.T) {
+		q := MetricQuery{From: now.Add(-30 * 24 * time.Hour), To: now.Add(-30*24*time.Hour + time.Hour), Step: 10 * time.Second}
+		assert.Equal(t, time.Hour, pickResolution(&q, retention, now))
+		assert.Equal(t, time.Hour, q.Step)
+
+		q = MetricQuery{From: now.Add(-30 * 24 * time.Hour), To: now.Add(-30*24*time.Hour + 6*time.Hour), Step: 90 * time.Minute}
+		assert.Equal(t, time.Hour, pickResolution(&q, retention, now))
+		assert.Equal(t, 2*time.Hour, q.Step)
+
+		q = MetricQuery{From: now.Add(-time.Hour), To: now, Step: 10 * time.Second}
+		assert.Equal(t, time.Duration(0), pickResolution(&q, retention, now))
+		assert.Equal(t, 10*time.Second, q.Step)
+	})
+
+	t.Run("Widens the step to the rollup read", func(t *testing.T) {
+		// The 1m rollups are gone too, so a 5m step reads 1h rollups in 1h buckets
+		expired := &Service{DB: db, Retention: RetentionPolicy{Raw: 24 * time.Hour, Minute: 48 * time.Hour, Hour: 90 * 24 * time.Hour}}
+		resp, err := expired.QueryMetrics(agentID, MetricQuery{
+			From: old.Add(10 * time.Minute), To: old.Add(50 * time.Minute), Step: 5 * time.Minute,
+			Metric: "latency_ms", Aggregation: AggAvg,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "1h", resp.Resolution)
+		assert.Equal(t, int64(3600), resp.Step)
+		assert.Equal(t, []int64{old.Unix()}, resp.Timestamps)
+		if assert.Len(t, resp.Series, 1) {
+			assert.Equal(t, []*float64{floatPtr(6)}, resp.Series[0].Values)
+		}
+	})
+}
@@ -1,21 +1,314 @@
 package service
 
-import "database/sql"
+import (
+	"database/sql"
+	"github.com/Shaughny/obkio-test/internal/enrich"
+	"github.com/Shaughny/obkio-test/internal/notify"
+	"github.com/Shaughny/obkio-test/internal/prefix"
+	"net/http"
+	"time"
+)
 
 // ServiceI defines the interface for the service layer
 type ServiceI interface {
-	// AddAgent adds a new agent or updates existing agent details.
-	AddAgent(ipAddress string) error
+	// AddAgent adds a new agent or updates existing agent details, setting the given labels.
+	AddAgent(ipAddress string, labels map[string]string) error
 
-	// GetAgents retrieves a list of all registered agents.
-	GetAgents() ([]Agent, error)
+	// GetAgents retrieves a list of the registered agents matching a filter.
+	GetAgents(filter AgentFilter) ([]Agent, error)
 
-	// GetAgent retrieves the detailed information of a specific agent by ID.
+	// GetNearestAgents lists the agents closest to an agent, nearest first.
+	GetNearestAgents(id int, q NearestQuery) ([]Agent, error)
+
+	// GetAgentsGeoJSON exports the located agents matching a filter as a GeoJSON FeatureCollection.
+	GetAgentsGeoJSON(filter AgentFilter) (FeatureCollection, error)
+
+	// GetAgent retrieves the detailed information of a specific agent by ID, unless it was deleted.
 	GetAgent(id int) (DetailedAgentResponse, error)
+
+	// PatchAgent sets or removes labels of an agent and assigns it to a site.
+	PatchAgent(id int, req AgentPatchRequest) (DetailedAgentResponse, error)
+
+	// SetAgentState moves an agent to another lifecycle state, recording why.
+	SetAgentState(id int, req AgentStateRequest) (DetailedAgentResponse, error)
+
+	// GetAgentStateHistory retrieves the lifecycle state changes of an agent.
+	GetAgentStateHistory(id int) ([]AgentStateTransition, error)
+
+	// GetAgentProvenance retrieves where each enriched detail of an agent comes from.
+	GetAgentProvenance(id int) ([]FieldProvenance, error)
+
+	// GetProvenanceHistory retrieves every value the providers reported for the details of an agent.
+	GetProvenanceHistory(id int, filter ProvenanceFilter) ([]FieldProvenance, error)
+
+	// GetPrefixGroups groups the agents by the BGP prefix announcing their address.
+	GetPrefixGroups() ([]PrefixGroup, error)
+
+	// GetASNs retrieves the catalog of autonomous systems.
+	GetASNs() ([]ASN, error)
+
+	// GetASNAgents retrieves the agents announced by an AS.
+	GetASNAgents(asn uint32) ([]Agent, error)
+
+	// GetAgentStats counts the agents by ISP, AS, country or status.
+	GetAgentStats(q AgentStatsQuery, now time.Time) (AgentStats, error)
+
+	// SetAgentOverrides sets and locks details of an agent against lookups.
+	SetAgentOverrides(id int, req AgentOverrideRequest) (DetailedAgentResponse, error)
+
+	// ClearAgentOverride unlocks a detail of an agent and restores the provider value.
+	ClearAgentOverride(id int, field string) (DetailedAgentResponse, error)
+
+	// CreateRegion creates a region.
+	CreateRegion(req RegionRequest) (Region, error)
+
+	// GetRegions retrieves all regions.
+	GetRegions() ([]Region, error)
+
+	// GetRegion retrieves a region by ID.
+	GetRegion(id int) (Region, error)
+
+	// UpdateRegion renames a region or moves it to another parent.
+	UpdateRegion(id int, req RegionRequest) (Region, error)
+
+	// DeleteRegion removes a region that holds no regions or sites.
+	DeleteRegion(id int) error
+
+	// CreateSite creates a site.
+	CreateSite(req SiteRequest, now time.Time) (Site, error)
+
+	// GetSites retrieves the sites matching a filter with the status of their agents.
+	GetSites(filter SiteFilter, now time.Time) ([]Site, error)
+
+	// GetSite retrieves a site by ID with the status of its agents.
+	GetSite(id int, now time.Time) (Site, error)
+
+	// UpdateSite replaces the details of a site.
+	UpdateSite(id int, req SiteRequest, now time.Time) (Site, error)
+
+	// DeleteSite removes a site, keeping its agents.
+	DeleteSite(id int) error
+
+	// GetSiteAgents retrieves the agents assigned to a site.
+	GetSiteAgents(id int) ([]Agent, error)
+
+	// GetEvents retrieves the recorded agent and alert events matching a filter.
+	GetEvents(filter EventFilter) ([]Event, error)
+
+	// RecordHeartbeat marks an agent as alive.
+	RecordHeartbeat(agentID int) error
+
+	// DeleteAgent hides an agent until it is restored or purged with its metrics, memberships and sessions.
+	DeleteAgent(id int) error
+
+	// RestoreAgent brings back a deleted agent that was not purged yet.
+	RestoreAgent(id int) (DetailedAgentResponse, error)
+
+	// IngestMetrics stores a batch of network metric samples reported by an agent.
+	IngestMetrics(agentID int, batch MetricBatch) (IngestResult, error)
+
+	// QueryMetrics aggregates an agent's metric samples over a time range.
+	QueryMetrics(agentID int, q MetricQuery) (MetricQueryResponse, error)
+
+	// CreateSession creates a monitoring session between two agents.
+	CreateSession(req MonitoringSessionRequest) (MonitoringSession, error)
+
+	// GetSessions retrieves all monitoring sessions.
+	GetSessions() ([]MonitoringSession, error)
+
+	// GetSession retrieves a monitoring session by ID.
+	GetSession(id int) (MonitoringSession, error)
+
+	// UpdateSession replaces the settings of a monitoring session.
+	UpdateSession(id int, req MonitoringSessionRequest) (MonitoringSession, error)
+
+	// DeleteSession removes a monitoring session.
+	DeleteSession(id int) error
+
+	// GetAgentSessions retrieves the enabled sessions an agent takes part in.
+	GetAgentSessions(agentID int) ([]AssignedSession, error)
+
+	// IngestSessionMetrics stores a batch of results for a monitoring session.
+	IngestSessionMetrics(sessionID int, batch MetricBatch) (IngestResult, error)
+
+	// QuerySessionMetrics aggregates the results of a monitoring session over a time range.
+	QuerySessionMetrics(sessionID int, q MetricQuery) (MetricQueryResponse, error)
+
+	// CreateGroup creates an agent group and provisions its sessions.
+	CreateGroup(req AgentGroupRequest) (AgentGroup, error)
+
+	// GetGroups retrieves all agent groups.
+	GetGroups() ([]AgentGroup, error)
+
+	// GetGroup retrieves an agent group by ID.
+	GetGroup(id int) (AgentGroup, error)
+
+	// UpdateGroup replaces the settings of an agent group and reconciles its sessions.
+	UpdateGroup(id int, req AgentGroupRequest) (AgentGroup, error)
+
+	// DeleteGroup removes an agent group and the sessions it provisioned.
+	DeleteGroup(id int) error
+
+	// AddGroupMember adds an agent to a group and provisions its sessions.
+	AddGroupMember(groupID, agentID int) (ReconcileResult, error)
+
+	// RemoveGroupMember removes an agent from a group and tears down its sessions.
+	RemoveGroupMember(groupID, agentID int) (ReconcileResult, error)
+
+	// ReconcileGroup brings a group's sessions in line with its topology and members.
+	ReconcileGroup(groupID int) (ReconcileResult, error)
+
+	// GetAgentConfig composes the versioned configuration document of an agent.
+	GetAgentConfig(agentID int) (AgentConfig, error)
+
+	// GetConfigOverride retrieves the configuration override of a scope.
+	GetConfigOverride(scope string, scopeID int) (map[string]any, error)
+
+	// SetConfigOverride stores the configuration override of a scope.
+	SetConfigOverride(scope string, scopeID int, override map[string]any) error
+
+	// DeleteConfigOverride removes the configuration override of a scope.
+	DeleteConfigOverride(scope string, scopeID int) error
+
+	// CreateAlertRule creates an alert rule.
+	CreateAlertRule(req AlertRuleRequest) (AlertRule, error)
+
+	// GetAlertRules retrieves all alert rules.
+	GetAlertRules() ([]AlertRule, error)
+
+	// GetAlertRule retrieves an alert rule by ID.
+	GetAlertRule(id int) (AlertRule, error)
+
+	// UpdateAlertRule replaces the settings of an alert rule.
+	UpdateAlertRule(id int, req AlertRuleRequest) (AlertRule, error)
+
+	// DeleteAlertRule removes an alert rule together with its alerts.
+	DeleteAlertRule(id int) error
+
+	// GetAlerts retrieves the alerts matching a filter.
+	GetAlerts(filter AlertFilter) ([]Alert, error)
+
+	// GetAlert retrieves an alert by ID.
+	GetAlert(id int) (Alert, error)
+
+	// GetAlertHistory retrieves the state changes of an alert.
+	GetAlertHistory(id int) ([]AlertTransition, error)
+
+	// CreateWebhook creates a webhook subscription.
+	CreateWebhook(req WebhookSubscriptionRequest) (WebhookSubscription, error)
+
+	// GetWebhooks retrieves all webhook subscriptions.
+	GetWebhooks() ([]WebhookSubscription, error)
+
+	// GetWebhook retrieves a webhook subscription by ID.
+	GetWebhook(id int) (WebhookSubscription, error)
+
+	// UpdateWebhook replaces the settings of a webhook subscription.
+	UpdateWebhook(id int, req WebhookSubscriptionRequest) (WebhookSubscription, error)
+
+	// DeleteWebhook removes a webhook subscription and its deliveries.
+	DeleteWebhook(id int) error
+
+	// SendTestWebhook delivers a test event to a webhook subscription right away.
+	SendTestWebhook(id int, now time.Time) (WebhookDelivery, error)
+
+	// GetDeliveries retrieves the webhook deliveries matching a filter.
+	GetDeliveries(filter DeliveryFilter) ([]WebhookDelivery, error)
+
+	// RetryDelivery puts a dead webhook delivery back in the queue.
+	RetryDelivery(id int, now time.Time) (WebhookDelivery, error)
+
+	// CreateEmailRoute creates an email notification route.
+	CreateEmailRoute(req EmailRouteRequest) (EmailRoute, error)
+
+	// GetEmailRoutes retrieves all email notification routes.
+	GetEmailRoutes() ([]EmailRoute, error)
+
+	// GetEmailRoute retrieves an email notification route by ID.
+	GetEmailRoute(id int) (EmailRoute, error)
+
+	// UpdateEmailRoute replaces the settings of an email notification route.
+	UpdateEmailRoute(id int, req EmailRouteRequest) (EmailRoute, error)
+
+	// DeleteEmailRoute removes an email notification route and its notifications.
+	DeleteEmailRoute(id int) error
+
+	// GetEmailNotifications retrieves the email notifications matching a filter.
+	GetEmailNotifications(filter EmailNotificationFilter) ([]EmailNotification, error)
+
+	// CreateSilence creates a silence.
+	CreateSilence(req SilenceRequest, now time.Time) (Silence, error)
+
+	// GetSilences retrieves the silences matching a filter.
+	GetSilences(filter SilenceFilter, now time.Time) ([]Silence, error)
+
+	// GetSilence retrieves a silence by ID.
+	GetSilence(id int, now time.Time) (Silence, error)
+
+	// UpdateSilence replaces the matchers, time range and comment of a silence.
+	UpdateSilence(id int, req SilenceRequest, now time.Time) (Silence, error)
+
+	// ExpireSilence ends a silence now.
+	ExpireSilence(id int, now time.Time) (Silence, error)
+
+	// CreateMaintenanceWindow creates a recurring maintenance window.
+	CreateMaintenanceWindow(req MaintenanceWindowRequest, now time.Time) (MaintenanceWindow, error)
+
+	// GetMaintenanceWindows retrieves all maintenance windows.
+	GetMaintenanceWindows(now time.Time) ([]MaintenanceWindow, error)
+
+	// GetMaintenanceWindow retrieves a maintenance window by ID.
+	GetMaintenanceWindow(id int, now time.Time) (MaintenanceWindow, error)
+
+	// UpdateMaintenanceWindow replaces the settings of a maintenance window.
+	UpdateMaintenanceWindow(id int, req MaintenanceWindowRequest, now time.Time) (MaintenanceWindow, error)
+
+	// DeleteMaintenanceWindow removes a maintenance window.
+	DeleteMaintenanceWindow(id int) error
 }
 
 // Service is the concrete implementation of the ServiceI interface.
 // It interacts with the SQLite database.
 type Service struct {
 	DB *sql.DB
+
+	// Retention controls how long metrics are kept at each resolution
+	Retention RetentionPolicy
+
+	// ServerURLs are the default server addresses handed out in agent configuration
+	ServerURLs []string
+
+	// HTTPClient sends webhook deliveries; a client with a 10 second timeout is used when nil
+	HTTPClient *http.Client
+
+	// IPInfoURL is the ip-api compatible endpoint agents are looked up with; ip-api.com is used when empty
+	IPInfoURL string
+
+	// ReverseDNS and RDAP look up the PTR record and network registration of agent addresses
+	// when they register; each lookup is skipped when nil
+	ReverseDNS *enrich.ReverseDNS
+	RDAP       *enrich.RDAP
+
+	// Prefixes resolves agent addresses to the BGP prefix announcing them and its origin AS;
+	// announced prefixes are not resolved when nil
+	Prefixes *prefix.Table
+
+	// OfflineAfter is how long an agent may go without reporting before fleet statistics
+	// count it as offline; 5 minutes when zero
+	OfflineAfter time.Duration
+
+	// PurgeAfter is how long deleted agents can be restored before they are purged with
+	// their metrics; they are kept when zero
+	PurgeAfter time.Duration
+
+	// StatsCacheTTL is how long fleet statistics are cached; 30 seconds when zero, not cached when negative
+	StatsCacheTTL time.Duration
+
+	// Mailer sends email notifications; none are sent when nil
+	Mailer notify.Sender
+
+	// EmailTemplates render email notifications; built-in templates are used when nil
+	EmailTemplates *notify.Templates
+
+	stats statsCache
 }
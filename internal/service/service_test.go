@@ -2,6 +2,7 @@ package service
 
 import (
 	"database/sql"
+	"github.com/Shaughny/obkio-test/config"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 	"log"
@@ -31,7 +32,7 @@ func TestAddAgent(t *testing.T) {
 	svc := &Service{DB: db}
 
 	t.Run("Successfully adds agent", func(t *testing.T) {
-		err := svc.AddAgent("8.8.8.8")
+		err := svc.AddAgent("8.8.8.8", nil)
 
 		assert.NoError(t, err)
 
@@ -43,7 +44,7 @@ func TestAddAgent(t *testing.T) {
 	})
 
 	t.Run("updates existing agent", func(t *testing.T) {
-		err := svc.AddAgent("8.8.8.8") // Same IP
+		err := svc.AddAgent("8.8.8.8", nil) // Same IP
 		assert.NoError(t, err)
 	})
 
@@ -54,7 +55,7 @@ func TestGetAgents(t *testing.T) {
 	t.Run("Retrieves agents", func(t *testing.T) {
 		db.Exec("INSERT INTO agents (ip_address, asn, isp) VALUES ('1.1.1.1', '15169', 'Cloudflare')")
 
-		agents, err := svc.GetAgents()
+		agents, err := svc.GetAgents(AgentFilter{})
 		assert.NoError(t, err)
 		assert.GreaterOrEqual(t, len(agents), 1) // At least one agent should exist
 	})
@@ -63,7 +64,7 @@ func TestGetAgents(t *testing.T) {
 		_, err := db.Exec("DELETE FROM agents") // Clear table
 		assert.NoError(t, err)
 
-		agents, err := svc.GetAgents()
+		agents, err := svc.GetAgents(AgentFilter{})
 		assert.NoError(t, err)
 		assert.Len(t, agents, 0)
 	})
@@ -89,16 +90,7 @@ func TestGetAgent(t *testing.T) {
 }
 
 func setupTestDB(db *sql.DB) {
-	schema := `
-	CREATE TABLE agents (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		ip_address TEXT UNIQUE NOT NULL,
-		asn TEXT,
-		isp TEXT,
-		last_updated DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-	_, err := db.Exec(schema)
+	err := config.Migrate(db)
 	if err != nil {
 		log.Fatalf("Failed to create test schema: %v", err)
 	}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Roles an agent can play in a monitoring session.
+const (
+	RoleSource      = "source"
+	RoleDestination = "destination"
+)
+
+// MonitoringSession is a recurring network test from a source agent to a destination agent.
+type MonitoringSession struct {
+	ID                 int       `json:"id"`
+	SourceAgentID      int       `json:"source_agent_id"`
+	SourceIP           string    `json:"source_ip"`
+	DestinationAgentID int       `json:"destination_agent_id"`
+	DestinationIP      string    `json:"destination_ip"`
+	Protocol           string    `json:"protocol"`
+	IntervalSeconds    int       `json:"interval_seconds"`
+	PacketSize         int       `json:"packet_size"` // Bytes per probe packet
+	Enabled            bool      `json:"enabled"`
+	GroupID            *int      `json:"group_id,omitempty"` // Set when the session is provisioned by an agent group
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// MonitoringSessionRequest defines the structure for creating or updating a monitoring session.
+type MonitoringSessionRequest struct {
+	SourceAgentID      int    `json:"source_agent_id" validate:"required,gte=1"`
+	DestinationAgentID int    `json:"destination_agent_id" validate:"required,gte=1,nefield=SourceAgentID"`
+	Protocol           string `json:"protocol" validate:"required,oneof=udp tcp icmp"`
+	IntervalSeconds    int    `json:"interval_seconds" validate:"required,gte=1,lte=3600"`
+	PacketSize         int    `json:"packet_size" validate:"required,gte=20,lte=9000"`
+	Enabled            *bool  `json:"enabled"` // Defaults to true
+}
+
+// AssignedSession is a monitoring session as seen by one of its agents.
+type AssignedSession struct {
+	MonitoringSession
+	Role string `json:"role"` // source or destination
+}
+
+// ErrSessionNotFound is returned when a monitoring session is not found in the database.
+var ErrSessionNotFound = errors.New("monitoring session not found")
+
+// ErrInvalidSession is returned when a monitoring session refers to agents that cannot take part in it.
+var ErrInvalidSession = errors.New("invalid monitoring session")
+
+// sessionColumns selects a monitoring session together with the IP addresses of both agents.
+// Sessions of deleted agents are left out until the agents are restored or purged.
+const sessionColumns = `
+	SELECT ms.id, ms.source_agent_id, src.ip_address, ms.destination_agent_id, dst.ip_address,
+	       ms.protocol, ms.interval_seconds, ms.packet_size, ms.enabled, ms.group_id, ms.created_at, ms.updated_at
+	FROM monitoring_sessions ms
+	JOIN agents src ON src.id = ms.source_agent_id AND src.deleted_at IS NULL
+	JOIN agents dst ON dst.id = ms.destination_agent_id AND dst.deleted_at IS NULL`
+
+// sessionAgents selects the agents of the session with ID $1, whose configuration changes with it
+const sessionAgents = `
+	SELECT source_agent_id FROM monitoring_sessions WHERE id = $1
+	UNION SELECT destination_agent_id FROM monitoring_sessions WHERE id = $1`
+
+// CreateSession creates a monitoring session between two existing agents.
+func (s *Service) CreateSession(req MonitoringSessionRequest) (MonitoringSession, error) {
+	err := s.checkSessionAgents(req)
+	if err != nil {
+		return MonitoringSession{}, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return MonitoringSession{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	enabled := req.Enabled == nil || *req.Enabled
+	res, err := tx.Exec(`
+	INSERT INTO monitoring_sessions (source_agent_id, destination_agent_id, protocol, interval_seconds, packet_size, enabled)
+	VALUES ($1, $2, $3, $4, $5, $6)`,
+		req.SourceAgentID, req.DestinationAgentID, req.Protocol, req.IntervalSeconds, req.PacketSize, enabled,
+	)
+	if err != nil {
+		return MonitoringSession{}, fmt.Errorf("error inserting monitoring session: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return MonitoringSession{}, fmt.Errorf("error reading monitoring session ID: %w", err)
+	}
+	err = bumpConfigVersions(tx, sessionAgents, id)
+	if err != nil {
+		return MonitoringSession{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return MonitoringSession{}, fmt.Errorf("error committing monitoring session: %w", err)
+	}
+	return s.GetSession(int(id))
+}
+
+// GetSessions retrieves all monitoring sessions.
+func (s *Service) GetSessions() ([]MonitoringSession, error) {
+	return s.querySessions(sessionColumns + " ORDER BY ms.id")
+}
+
+// GetSession retrieves a monitoring session by ID.
+func (s *Service) GetSession(id int) (MonitoringSession, error) {
+	session, err := scanSession(s.DB.QueryRow(sessionColumns+" WHERE ms.id = $1", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return session, ErrSessionNotFound
+		}
+		return session, fmt.Errorf("error fetching monitoring session: %w", err)
+	}
+	return session, nil
+}
+
+// UpdateSession replaces the test settings of a monitoring session.
+// The agents of a session cannot be changed since its results belong to that pair.
+func (s *Service) UpdateSession(id int, req MonitoringSessionRequest) (MonitoringSession, error) {
+	session, err := s.GetSession(id)
+	if err != nil {
+		return session, err
+	}
+	if session.SourceAgentID != req.SourceAgentID || session.DestinationAgentID != req.DestinationAgentID {
+		return session, fmt.Errorf("%w: the agents of a session cannot be changed", ErrInvalidSession)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return session, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	enabled := req.Enabled == nil || *req.Enabled
+	_, err = tx.Exec(`
+	UPDATE monitoring_sessions
+	SET protocol = $1, interval_seconds = $2, packet_size = $3, enabled = $4, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $5`,
+		req.Protocol, req.IntervalSeconds, req.PacketSize, enabled, id,
+	)
+	if err != nil {
+		return session, fmt.Errorf("error updating monitoring session: %w", err)
+	}
+	err = bumpConfigVersions(tx, sessionAgents, id)
+	if err != nil {
+		return session, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return session, fmt.Errorf("error committing monitoring session: %w", err)
+	}
+	return s.GetSession(id)
+}
+
+// DeleteSession removes a monitoring session. Its stored results are kept.
+func (s *Service) DeleteSession(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = bumpConfigVersions(tx, sessionAgents, id)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM monitoring_sessions WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting monitoring session: %w", err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting monitoring session: %w", err)
+	}
+	if deleted == 0 {
+		return ErrSessionNotFound
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing monitoring session deletion: %w", err)
+	}
+	return nil
+}
+
+// GetAgentSessions retrieves the enabled sessions an agent takes part in, either as
+// the source running the test or as the destination answering it.
+func (s *Service) GetAgentSessions(agentID int) ([]AssignedSession, error) {
+	err := s.agentExists(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.querySessions(sessionColumns+`
+	WHERE ms.enabled = 1 AND (ms.source_agent_id = $1 OR ms.destination_agent_id = $1)
+	ORDER BY ms.id`, agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	assigned := make([]AssignedSession, 0, len(sessions))
+	for _, session := range sessions {
+		role := RoleSource
+		if session.DestinationAgentID == agentID {
+			role = RoleDestination
+		}
+		assigned = append(assigned, AssignedSession{MonitoringSession: session, Role: role})
+	}
+	return assigned, nil
+}
+
+// IngestSessionMetrics stores a batch of results measured by the source agent of a session.
+func (s *Service) IngestSessionMetrics(sessionID int, batch MetricBatch) (IngestResult, error) {
+	session, err := s.GetSession(sessionID)
+	if err != nil {
+		return IngestResult{}, err
+	}
+	return s.storeMetricBatch(session.SourceAgentID, session.ID, batch)
+}
+
+// QuerySessionMetrics aggregates the results of a monitoring session over a time range.
+func (s *Service) QuerySessionMetrics(sessionID int, q MetricQuery) (MetricQueryResponse, error) {
+	response := MetricQueryResponse{SessionID: sessionID, Metric: q.Metric, Aggregation: q.Aggregation}
+
+	err := validateMetricQuery(&q)
+	if err != nil {
+		return response, err
+	}
+
+	session, err := s.GetSession(sessionID)
+	if err != nil {
+		return response, err
+	}
+
+	response.AgentID = session.SourceAgentID
+	return s.queryMetricSeries(session.SourceAgentID, session.ID, q, response)
+}
+
+// checkSessionAgents makes sure both agents of a session request exist
+func (s *Service) checkSessionAgents(req MonitoringSessionRequest) error {
+	if req.SourceAgentID == req.DestinationAgentID {
+		return fmt.Errorf("%w: source and destination must be different agents", ErrInvalidSession)
+	}
+	for _, agentID := range []int{req.SourceAgentID, req.DestinationAgentID} {
+		err := s.agentExists(agentID)
+		if err != nil {
+			if errors.Is(err, ErrAgentNotFound) {
+				return fmt.Errorf("%w: agent %d not found", ErrInvalidSession, agentID)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) querySessions(query string, args ...any) ([]MonitoringSession, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []MonitoringSession{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return sessions, nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (MonitoringSession, error) {
+	var session MonitoringSession
+	err := row.Scan(
+		&session.ID, &session.SourceAgentID, &session.SourceIP, &session.DestinationAgentID, &session.DestinationIP,
+		&session.Protocol, &session.IntervalSeconds, &session.PacketSize, &session.Enabled, &session.GroupID,
+		&session.CreatedAt, &session.UpdatedAt,
+	)
+	return session, err
+}
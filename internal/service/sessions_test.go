@@ -0,0 +1,100 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMonitoringSessions(t *testing.T) {
+	svc := &Service{DB: db}
+	source := insertTestAgent(t, "10.0.2.1")
+	destination := insertTestAgent(t, "10.0.2.2")
+
+	request := MonitoringSessionRequest{
+		SourceAgentID: source, DestinationAgentID: destination, Protocol: "udp", IntervalSeconds: 10, PacketSize: 64,
+	}
+	var session MonitoringSession
+
+	t.Run("Creates session", func(t *testing.T) {
+		var err error
+		session, err = svc.CreateSession(request)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.2.1", session.SourceIP)
+		assert.Equal(t, "10.0.2.2", session.DestinationIP)
+		assert.True(t, session.Enabled)
+	})
+
+	t.Run("Rejects unknown agent", func(t *testing.T) {
+		invalid := request
+		invalid.DestinationAgentID = 999999
+		_, err := svc.CreateSession(invalid)
+		assert.ErrorIs(t, err, ErrInvalidSession)
+	})
+
+	t.Run("Assigns session to both agents", func(t *testing.T) {
+		assigned, err := svc.GetAgentSessions(source)
+		assert.NoError(t, err)
+		assert.Len(t, assigned, 1)
+		assert.Equal(t, RoleSource, assigned[0].Role)
+
+		assigned, err = svc.GetAgentSessions(destination)
+		assert.NoError(t, err)
+		assert.Len(t, assigned, 1)
+		assert.Equal(t, RoleDestination, assigned[0].Role)
+	})
+
+	t.Run("Updates settings but not agents", func(t *testing.T) {
+		update := request
+		update.IntervalSeconds = 30
+		updated, err := svc.UpdateSession(session.ID, update)
+		assert.NoError(t, err)
+		assert.Equal(t, 30, updated.IntervalSeconds)
+
+		update.SourceAgentID = destination
+		update.DestinationAgentID = source
+		_, err = svc.UpdateSession(session.ID, update)
+		assert.ErrorIs(t, err, ErrInvalidSession)
+	})
+
+	t.Run("Disabled sessions are not assigned", func(t *testing.T) {
+		disabled := false
+		update := request
+		update.Enabled = &disabled
+		_, err := svc.UpdateSession(session.ID, update)
+		assert.NoError(t, err)
+
+		assigned, err := svc.GetAgentSessions(source)
+		assert.NoError(t, err)
+		assert.Empty(t, assigned)
+	})
+
+	t.Run("Keeps session results apart from agent metrics", func(t *testing.T) {
+		ts := time.Unix(1700100000, 0)
+		batch := MetricBatch{Sequence: 1, Samples: []MetricSample{{Timestamp: ts, Target: "10.0.2.2", LatencyMs: floatPtr(20)}}}
+
+		result, err := svc.IngestSessionMetrics(session.ID, batch)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, result.Accepted)
+
+		// The same sequence number is independent from the agent's own batches
+		result, err = svc.IngestMetrics(source, batch)
+		assert.NoError(t, err)
+		assert.False(t, result.Duplicate)
+
+		query := MetricQuery{From: ts, To: ts.Add(time.Minute), Step: time.Minute, Metric: "latency_ms", Aggregation: AggCount}
+		resp, err := svc.QuerySessionMetrics(session.ID, query)
+		assert.NoError(t, err)
+		assert.Equal(t, session.ID, resp.SessionID)
+		assert.Equal(t, 1.0, *resp.Series[0].Values[0])
+
+		resp, err = svc.QueryMetrics(source, query)
+		assert.NoError(t, err)
+		assert.Equal(t, 1.0, *resp.Series[0].Values[0])
+	})
+
+	t.Run("Deletes session", func(t *testing.T) {
+		assert.NoError(t, svc.DeleteSession(session.ID))
+		assert.ErrorIs(t, svc.DeleteSession(session.ID), ErrSessionNotFound)
+	})
+}
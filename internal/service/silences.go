@@ -0,0 +1,350 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Shaughny/obkio-test/internal/labels"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States of a silence, derived from its time range.
+const (
+	SilencePending = "pending" // Starts in the future
+	SilenceActive  = "active"
+	SilenceExpired = "expired"
+)
+
+// AlertMatchers select the alerts a silence or maintenance window suppresses. Every
+// matcher that is set must match the alert's agent.
+type AlertMatchers struct {
+	AgentID int    `json:"agent_id,omitempty" validate:"omitempty,gte=1"`
+	GroupID int    `json:"group_id,omitempty" validate:"omitempty,gte=1"` // Agents in the group
+	ASN     string `json:"asn,omitempty" validate:"omitempty,max=12"`     // Agents announced by the AS, such as AS15169
+
+	// Selector matches agents by label, such as env=prod,site in (mtl,tor)
+	Selector string `json:"selector,omitempty" validate:"omitempty,max=1000"`
+}
+
+// Silence suppresses notifications about matching alerts for a period of time.
+// Alerts keep being evaluated and recorded while silenced.
+type Silence struct {
+	ID int `json:"id"`
+	AlertMatchers
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	State     string    `json:"state"`
+	CreatedBy string    `json:"created_by"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SilenceRequest defines the structure for creating or updating a silence.
+type SilenceRequest struct {
+	AlertMatchers
+	StartsAt  *time.Time `json:"starts_at"` // Defaults to now
+	EndsAt    time.Time  `json:"ends_at" validate:"required"`
+	CreatedBy string     `json:"created_by" validate:"required,max=100"`
+	Comment   string     `json:"comment" validate:"required,max=1000"`
+}
+
+// SilenceFilter narrows down the silences listed.
+type SilenceFilter struct {
+	State string `query:"state" validate:"omitempty,oneof=pending active expired"`
+}
+
+// ErrSilenceNotFound is returned when a silence is not found in the database.
+var ErrSilenceNotFound = errors.New("silence not found")
+
+// ErrInvalidSilence is returned when a silence has no matcher, an unknown target or an empty time range.
+var ErrInvalidSilence = errors.New("invalid silence")
+
+const silenceColumns = `
+	SELECT id, COALESCE(agent_id, 0), COALESCE(group_id, 0), COALESCE(asn, ''), COALESCE(selector, ''),
+	       starts_at, ends_at, created_by, comment, created_at, updated_at
+	FROM silences`
+
+// CreateSilence creates a silence.
+func (s *Service) CreateSilence(req SilenceRequest, now time.Time) (Silence, error) {
+	req, err := s.checkSilence(req, now)
+	if err != nil {
+		return Silence{}, err
+	}
+
+	res, err := s.DB.Exec(`
+	INSERT INTO silences (agent_id, group_id, asn, selector, starts_at, ends_at, created_by, comment)
+	VALUES (NULLIF($1, 0), NULLIF($2, 0), NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8)`,
+		req.AgentID, req.GroupID, req.ASN, req.Selector, req.StartsAt.UTC(), req.EndsAt.UTC(), req.CreatedBy, req.Comment,
+	)
+	if err != nil {
+		return Silence{}, fmt.Errorf("error inserting silence: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Silence{}, fmt.Errorf("error reading silence ID: %w", err)
+	}
+	return s.GetSilence(int(id), now)
+}
+
+// GetSilences retrieves the silences matching the filter, latest ending first.
+func (s *Service) GetSilences(filter SilenceFilter, now time.Time) ([]Silence, error) {
+	query := silenceColumns
+	var args []any
+	switch filter.State {
+	case SilencePending:
+		query += " WHERE starts_at > $1"
+		args = append(args, now.UTC())
+	case SilenceActive:
+		query += " WHERE starts_at <= $1 AND ends_at > $1"
+		args = append(args, now.UTC())
+	case SilenceExpired:
+		query += " WHERE ends_at <= $1"
+		args = append(args, now.UTC())
+	}
+
+	rows, err := s.DB.Query(query+" ORDER BY ends_at DESC, id DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	silences := []Silence{}
+	for rows.Next() {
+		silence, err := scanSilence(rows, now)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		silences = append(silences, silence)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return silences, nil
+}
+
+// GetSilence retrieves a silence by ID.
+func (s *Service) GetSilence(id int, now time.Time) (Silence, error) {
+	silence, err := scanSilence(s.DB.QueryRow(silenceColumns+" WHERE id = $1", id), now)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return silence, ErrSilenceNotFound
+		}
+		return silence, fmt.Errorf("error fetching silence: %w", err)
+	}
+	return silence, nil
+}
+
+// UpdateSilence replaces the matchers, time range, attribution and comment of a silence.
+func (s *Service) UpdateSilence(id int, req SilenceRequest, now time.Time) (Silence, error) {
+	req, err := s.checkSilence(req, now)
+	if err != nil {
+		return Silence{}, err
+	}
+
+	res, err := s.DB.Exec(`
+	UPDATE silences
+	SET agent_id = NULLIF($1, 0), group_id = NULLIF($2, 0), asn = NULLIF($3, ''), selector = NULLIF($4, ''),
+	    starts_at = $5, ends_at = $6, created_by = $7, comment = $8, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $9`,
+		req.AgentID, req.GroupID, req.ASN, req.Selector, req.StartsAt.UTC(), req.EndsAt.UTC(), req.CreatedBy, req.Comment, id,
+	)
+	if err != nil {
+		return Silence{}, fmt.Errorf("error updating silence: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return Silence{}, fmt.Errorf("error updating silence: %w", err)
+	}
+	if updated == 0 {
+		return Silence{}, ErrSilenceNotFound
+	}
+	return s.GetSilence(id, now)
+}
+
+// ExpireSilence ends a silence now. Expired silences are kept as a record of what was silenced.
+func (s *Service) ExpireSilence(id int, now time.Time) (Silence, error) {
+	silence, err := s.GetSilence(id, now)
+	if err != nil {
+		return silence, err
+	}
+	if silence.State == SilenceExpired {
+		return silence, nil
+	}
+
+	_, err = s.DB.Exec(`
+	UPDATE silences SET starts_at = MIN(starts_at, $1), ends_at = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
+		now.UTC(), id,
+	)
+	if err != nil {
+		return silence, fmt.Errorf("error expiring silence: %w", err)
+	}
+	return s.GetSilence(id, now)
+}
+
+// checkSilence fills in the start time and verifies the matchers and time range of a silence
+func (s *Service) checkSilence(req SilenceRequest, now time.Time) (SilenceRequest, error) {
+	if req.StartsAt == nil {
+		req.StartsAt = &now
+	}
+	if !req.EndsAt.After(*req.StartsAt) {
+		return req, fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidSilence)
+	}
+
+	var err error
+	req.AlertMatchers, err = s.checkMatchers(req.AlertMatchers, ErrInvalidSilence)
+	return req, err
+}
+
+// checkMatchers verifies that at least one matcher is set, that the agent and group
+// exist and that the selector parses, and normalizes the ASN to the AS15169 form and the
+// selector to its canonical form. Problems are reported wrapping invalid.
+func (s *Service) checkMatchers(m AlertMatchers, invalid error) (AlertMatchers, error) {
+	if m.Selector != "" {
+		selector, err := labels.Parse(m.Selector)
+		if err != nil {
+			return m, fmt.Errorf("%w: %v", invalid, err)
+		}
+		m.Selector = selector.String()
+	}
+	if m.AgentID == 0 && m.GroupID == 0 && m.ASN == "" && m.Selector == "" {
+		return m, fmt.Errorf("%w: at least one of agent_id, group_id, asn or selector is required", invalid)
+	}
+	if m.AgentID != 0 {
+		err := s.agentExists(m.AgentID)
+		if errors.Is(err, ErrAgentNotFound) {
+			return m, fmt.Errorf("%w: agent %d not found", invalid, m.AgentID)
+		}
+		if err != nil {
+			return m, err
+		}
+	}
+	if m.GroupID != 0 {
+		err := s.groupExists(m.GroupID)
+		if errors.Is(err, ErrGroupNotFound) {
+			return m, fmt.Errorf("%w: group %d not found", invalid, m.GroupID)
+		}
+		if err != nil {
+			return m, err
+		}
+	}
+	if m.ASN != "" {
+		number, err := ParseASN(m.ASN)
+		if err != nil {
+			return m, fmt.Errorf("%w: invalid ASN %q", invalid, m.ASN)
+		}
+		m.ASN = "AS" + strconv.FormatUint(uint64(number), 10)
+	}
+	return m, nil
+}
+
+// alertTarget is what matchers are checked against: an alert's agent, its ASN, groups and labels
+type alertTarget struct {
+	agentID int
+	asn     string
+	groups  []int
+	labels  map[string]string
+}
+
+// matches reports whether every matcher that is set matches the target. Selectors were
+// validated when stored, so one that no longer parses matches nothing.
+func (m AlertMatchers) matches(target alertTarget) bool {
+	if m.Selector != "" {
+		selector, err := labels.Parse(m.Selector)
+		if err != nil || !selector.Matches(target.labels) {
+			return false
+		}
+	}
+	return (m.AgentID == 0 || m.AgentID == target.agentID) &&
+		(m.GroupID == 0 || slices.Contains(target.groups, m.GroupID)) &&
+		(m.ASN == "" || m.ASN == target.asn)
+}
+
+// alertSuppression returns the silence or maintenance window suppressing notifications
+// about an alert at the given time, or nil when the alert is not suppressed
+func alertSuppression(tx dbtx, alert Alert, now time.Time) (*string, error) {
+	target, err := loadAlertTarget(tx, alert.AgentID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(silenceColumns+" WHERE starts_at <= $1 AND ends_at > $1 ORDER BY id", now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying active silences: %w", err)
+	}
+	var silences []Silence
+	for rows.Next() {
+		silence, err := scanSilence(rows, now)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning silence: %w", err)
+		}
+		silences = append(silences, silence)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating silences: %w", err)
+	}
+	for _, silence := range silences {
+		if silence.matches(target) {
+			suppressedBy := fmt.Sprintf("silence %d", silence.ID)
+			return &suppressedBy, nil
+		}
+	}
+
+	windows, err := queryMaintenanceWindows(tx, maintenanceWindowColumns+" WHERE enabled = 1 ORDER BY id", now)
+	if err != nil {
+		return nil, err
+	}
+	for _, window := range windows {
+		if window.Active && window.matches(target) {
+			suppressedBy := fmt.Sprintf("maintenance window %d", window.ID)
+			return &suppressedBy, nil
+		}
+	}
+	return nil, nil
+}
+
+func loadAlertTarget(tx dbtx, agentID int) (alertTarget, error) {
+	target := alertTarget{agentID: agentID}
+	var asn string
+	err := tx.QueryRow("SELECT asn FROM agents WHERE id = $1", agentID).Scan(&asn)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return target, fmt.Errorf("error fetching agent: %w", err)
+	}
+	// ip-api reports the AS as "AS15169 Google LLC"
+	if fields := strings.Fields(asn); len(fields) > 0 {
+		target.asn = strings.ToUpper(fields[0])
+	}
+
+	target.groups, err = queryIDs(tx, "SELECT group_id FROM agent_group_members WHERE agent_id = $1", agentID)
+	if err != nil {
+		return target, err
+	}
+	target.labels, err = queryAgentLabels(tx, agentID)
+	if err != nil {
+		return target, err
+	}
+	return target, nil
+}
+
+func scanSilence(row rowScanner, now time.Time) (Silence, error) {
+	var silence Silence
+	err := row.Scan(&silence.ID, &silence.AgentID, &silence.GroupID, &silence.ASN, &silence.Selector,
+		&silence.StartsAt, &silence.EndsAt, &silence.CreatedBy, &silence.Comment, &silence.CreatedAt, &silence.UpdatedAt)
+	switch {
+	case now.Before(silence.StartsAt):
+		silence.State = SilencePending
+	case now.Before(silence.EndsAt):
+		silence.State = SilenceActive
+	default:
+		silence.State = SilenceExpired
+	}
+	return silence, err
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAlertSuppression(t *testing.T) {
+	svc := &Service{DB: db}
+	now := time.Date(2027, 9, 6, 3, 0, 0, 0, time.UTC)
+
+	first := insertTestAgent(t, "10.0.8.1")
+	second := insertTestAgent(t, "10.0.8.2")
+	defer svc.purgeAgent(first)
+	defer svc.purgeAgent(second)
+	_, err := db.Exec("UPDATE agents SET asn = 'AS64501 Other ISP' WHERE id = $1", second)
+	assert.NoError(t, err)
+
+	sequences := map[int]int64{}
+	ingest := func(agentID int, at time.Time, latency float64) {
+		t.Helper()
+		sequences[agentID]++
+		_, err := svc.IngestMetrics(agentID, MetricBatch{
+			Sequence: sequences[agentID],
+			Samples:  []MetricSample{{Timestamp: at, Target: "8.8.8.8", LatencyMs: floatPtr(latency)}},
+		})
+		assert.NoError(t, err)
+	}
+
+	group, err := svc.CreateGroup(AgentGroupRequest{
+		Name: "suppression", Topology: TopologyFullMesh, Protocol: "udp", IntervalSeconds: 10, PacketSize: 64,
+	})
+	assert.NoError(t, err)
+	defer svc.DeleteGroup(group.ID)
+	for _, agentID := range []int{first, second} {
+		_, err = svc.AddGroupMember(group.ID, agentID)
+		assert.NoError(t, err)
+	}
+
+	rule, err := svc.CreateAlertRule(AlertRuleRequest{
+		Name: "suppressed latency", Metric: "latency_ms", Scope: AlertScopeGroup, ScopeID: group.ID,
+		Comparator: "gt", Threshold: floatPtr(10), WindowSeconds: 60,
+	})
+	assert.NoError(t, err)
+	defer svc.DeleteAlertRule(rule.ID)
+
+	webhook, err := svc.CreateWebhook(WebhookSubscriptionRequest{
+		URL: "http://127.0.0.1:1/hook", EventTypes: []string{EventAlertFiring, EventAlertResolved},
+	})
+	assert.NoError(t, err)
+	defer svc.DeleteWebhook(webhook.ID)
+	// Other tests' alerts are delivered to the subscription too, so only count this test's agents
+	deliveries := func() int {
+		t.Helper()
+		var count int
+		err := db.QueryRow(`
+		SELECT COUNT(*) FROM webhook_deliveries d JOIN events e ON e.id = d.event_id
+		WHERE d.subscription_id = $1 AND e.agent_id IN ($2, $3)`, webhook.ID, first, second).Scan(&count)
+		assert.NoError(t, err)
+		return count
+	}
+	alertOf := func(agentID int) Alert {
+		t.Helper()
+		alerts, err := svc.GetAlerts(AlertFilter{RuleID: rule.ID, AgentID: agentID})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, alerts)
+		return alerts[0]
+	}
+
+	silence, err := svc.CreateSilence(SilenceRequest{
+		AlertMatchers: AlertMatchers{ASN: "as64501"},
+		EndsAt:        now.Add(time.Hour),
+		CreatedBy:     "noc@example.com",
+		Comment:       "Other ISP upgrading its core routers",
+	}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "AS64501", silence.ASN)
+	assert.Equal(t, SilenceActive, silence.State)
+	assert.Equal(t, now.UTC(), silence.StartsAt.UTC())
+
+	window, err := svc.CreateMaintenanceWindow(MaintenanceWindowRequest{
+		Name: "nightly", Schedule: "0 2 * * *", DurationSeconds: 7200,
+		AlertMatchers: AlertMatchers{AgentID: first}, CreatedBy: "noc@example.com",
+	}, now)
+	assert.NoError(t, err)
+	defer svc.DeleteMaintenanceWindow(window.ID)
+	assert.Equal(t, "UTC", window.Timezone)
+	assert.True(t, window.Active)
+	assert.Equal(t, time.Date(2027, 9, 7, 2, 0, 0, 0, time.UTC), *window.NextStart)
+
+	t.Run("Silenced alerts are recorded but not notified", func(t *testing.T) {
+		// Disable the window for now so only the silence applies
+		disabled := false
+		_, err := svc.UpdateMaintenanceWindow(window.ID, MaintenanceWindowRequest{
+			Name: "nightly", Schedule: "0 2 * * *", DurationSeconds: 7200,
+			AlertMatchers: AlertMatchers{AgentID: first}, CreatedBy: "noc@example.com", Enabled: &disabled,
+		}, now)
+		assert.NoError(t, err)
+
+		ingest(first, now.Add(-10*time.Second), 50)
+		ingest(second, now.Add(-10*time.Second), 50)
+		assert.NoError(t, svc.EvaluateAlerts(now))
+
+		assert.Equal(t, AlertFiring, alertOf(second).State)
+		assert.Equal(t, "silence "+strconv.Itoa(silence.ID), *alertOf(second).SuppressedBy)
+		assert.Nil(t, alertOf(first).SuppressedBy)
+		assert.Equal(t, 1, deliveries())
+
+		suppressed := true
+		alerts, err := svc.GetAlerts(AlertFilter{RuleID: rule.ID, Suppressed: &suppressed})
+		assert.NoError(t, err)
+		assert.Len(t, alerts, 1)
+	})
+
+	t.Run("Maintenance windows suppress while open", func(t *testing.T) {
+		_, err := svc.UpdateMaintenanceWindow(window.ID, MaintenanceWindowRequest{
+			Name: "nightly", Schedule: "0 2 * * *", DurationSeconds: 7200,
+			AlertMatchers: AlertMatchers{AgentID: first}, CreatedBy: "noc@example.com",
+		}, now)
+		assert.NoError(t, err)
+
+		ingest(first, now.Add(50*time.Second), 1)
+		ingest(second, now.Add(50*time.Second), 1)
+		assert.NoError(t, svc.EvaluateAlerts(now.Add(time.Minute)))
+
+		assert.Equal(t, AlertResolved, alertOf(first).State)
+		assert.Equal(t, "maintenance window "+strconv.Itoa(window.ID), *alertOf(first).SuppressedBy)
+		assert.Equal(t, AlertResolved, alertOf(second).State)
+		assert.Equal(t, 1, deliveries())
+
+		// The window closes at 04:00
+		closed, err := svc.GetMaintenanceWindow(window.ID, now.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.False(t, closed.Active)
+	})
+
+	t.Run("Expired silences stop suppressing", func(t *testing.T) {
+		expired, err := svc.ExpireSilence(silence.ID, now.Add(2*time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, SilenceExpired, expired.State)
+
+		active, err := svc.GetSilences(SilenceFilter{State: SilenceActive}, now.Add(2*time.Minute))
+		assert.NoError(t, err)
+		for _, s := range active {
+			assert.NotEqual(t, silence.ID, s.ID)
+		}
+
+		ingest(second, now.Add(170*time.Second), 50)
+		assert.NoError(t, svc.EvaluateAlerts(now.Add(3*time.Minute)))
+		assert.Equal(t, AlertFiring, alertOf(second).State)
+		assert.Nil(t, alertOf(second).SuppressedBy)
+		assert.Equal(t, 2, deliveries())
+	})
+
+	t.Run("Pending silences", func(t *testing.T) {
+		starts := now.Add(time.Hour)
+		pending, err := svc.CreateSilence(SilenceRequest{
+			AlertMatchers: AlertMatchers{GroupID: group.ID}, StartsAt: &starts, EndsAt: starts.Add(time.Hour),
+			CreatedBy: "noc@example.com", Comment: "Planned",
+		}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, SilencePending, pending.State)
+	})
+
+	t.Run("Label selectors match the labels of the agent", func(t *testing.T) {
+		at := now.Add(3 * time.Hour)
+		_, err := db.Exec("INSERT INTO agent_labels (agent_id, key, value) VALUES ($1, 'env', 'lab')", second)
+		assert.NoError(t, err)
+
+		labelled, err := svc.CreateSilence(SilenceRequest{
+			AlertMatchers: AlertMatchers{Selector: "env in (lab, staging)"}, StartsAt: &at, EndsAt: at.Add(time.Hour),
+			CreatedBy: "noc@example.com", Comment: "Lab rewiring",
+		}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, "env in (lab,staging)", labelled.Selector)
+
+		suppressedBy, err := alertSuppression(db, Alert{AgentID: second}, at)
+		assert.NoError(t, err)
+		if assert.NotNil(t, suppressedBy) {
+			assert.Equal(t, "silence "+strconv.Itoa(labelled.ID), *suppressedBy)
+		}
+		suppressedBy, err = alertSuppression(db, Alert{AgentID: first}, at)
+		assert.NoError(t, err)
+		assert.Nil(t, suppressedBy)
+		_, err = svc.ExpireSilence(labelled.ID, at)
+		assert.NoError(t, err)
+
+		// Windows combine the selector with the other matchers
+		labelledWindow, err := svc.CreateMaintenanceWindow(MaintenanceWindowRequest{
+			Name: "lab", Schedule: "0 6 * * *", DurationSeconds: 3600,
+			AlertMatchers: AlertMatchers{GroupID: group.ID, Selector: "env=lab"}, CreatedBy: "noc@example.com",
+		}, now)
+		assert.NoError(t, err)
+		defer svc.DeleteMaintenanceWindow(labelledWindow.ID)
+		suppressedBy, err = alertSuppression(db, Alert{AgentID: second}, at.Add(time.Minute))
+		assert.NoError(t, err)
+		if assert.NotNil(t, suppressedBy) {
+			assert.Equal(t, "maintenance window "+strconv.Itoa(labelledWindow.ID), *suppressedBy)
+		}
+		suppressedBy, err = alertSuppression(db, Alert{AgentID: first}, at.Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Nil(t, suppressedBy)
+	})
+
+	t.Run("Invalid silences and windows", func(t *testing.T) {
+		for _, req := range []SilenceRequest{
+			{EndsAt: now.Add(time.Hour), CreatedBy: "noc", Comment: "No matcher"},
+			{AlertMatchers: AlertMatchers{AgentID: 999999}, EndsAt: now.Add(time.Hour), CreatedBy: "noc", Comment: "Unknown agent"},
+			{AlertMatchers: AlertMatchers{ASN: "Google"}, EndsAt: now.Add(time.Hour), CreatedBy: "noc", Comment: "Bad ASN"},
+			{AlertMatchers: AlertMatchers{Selector: "env in (lab"}, EndsAt: now.Add(time.Hour), CreatedBy: "noc", Comment: "Bad selector"},
+			{AlertMatchers: AlertMatchers{AgentID: first}, EndsAt: now.Add(-time.Hour), CreatedBy: "noc", Comment: "Already over"},
+		} {
+			_, err := svc.CreateSilence(req, now)
+			assert.ErrorIs(t, err, ErrInvalidSilence, req.Comment)
+		}
+
+		for _, req := range []MaintenanceWindowRequest{
+			{Name: "bad schedule", Schedule: "0 25 * * *", DurationSeconds: 600, AlertMatchers: AlertMatchers{AgentID: first}, CreatedBy: "noc"},
+			{Name: "bad zone", Schedule: "0 2 * * *", Timezone: "Mars/Olympus", DurationSeconds: 600, AlertMatchers: AlertMatchers{AgentID: first}, CreatedBy: "noc"},
+			{Name: "no matcher", Schedule: "0 2 * * *", DurationSeconds: 600, CreatedBy: "noc"},
+			{Name: "bad selector", Schedule: "0 2 * * *", DurationSeconds: 600, AlertMatchers: AlertMatchers{Selector: "Bad Key=x"}, CreatedBy: "noc"},
+		} {
+			_, err := svc.CreateMaintenanceWindow(req, now)
+			assert.ErrorIs(t, err, ErrInvalidMaintenanceWindow, req.Name)
+		}
+
+		_, err := svc.ExpireSilence(999999, now)
+		assert.ErrorIs(t, err, ErrSilenceNotFound)
+		assert.ErrorIs(t, svc.DeleteMaintenanceWindow(999999), ErrMaintenanceWindowNotFound)
+	})
+}
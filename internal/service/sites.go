@@ -0,0 +1,274 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Statuses of a site, derived from the statuses of its agents.
+const (
+	SiteEmpty    = "empty"    // No agent is assigned to the site
+	SiteUp       = "up"       // Every agent is online
+	SiteDegraded = "degraded" // Some agents are online
+	SiteDown     = "down"     // No agent is online
+)
+
+// Site is a physical location agents live in, such as a branch office or a datacenter.
+type Site struct {
+	ID        int            `json:"id"`
+	Name      string         `json:"name"`
+	Address   string         `json:"address,omitempty"`
+	Latitude  *float64       `json:"latitude,omitempty"`
+	Longitude *float64       `json:"longitude,omitempty"`
+	RegionID  *int           `json:"region_id,omitempty"`
+	Status    string         `json:"status"`
+	Agents    SiteAgentCount `json:"agents"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// SiteAgentCount counts the agents of a site by status.
+type SiteAgentCount struct {
+	Total     int `json:"total"`
+	Online    int `json:"online"`
+	Offline   int `json:"offline"`
+	NeverSeen int `json:"never_seen"`
+}
+
+// SiteRequest defines the structure for creating or updating a site.
+type SiteRequest struct {
+	Name      string   `json:"name" validate:"required,max=100"`
+	Address   string   `json:"address" validate:"max=500"`
+	Latitude  *float64 `json:"latitude" validate:"required_with=Longitude,omitempty,gte=-90,lte=90"`
+	Longitude *float64 `json:"longitude" validate:"required_with=Latitude,omitempty,gte=-180,lte=180"`
+	RegionID  *int     `json:"region_id" validate:"omitempty,gte=1"`
+}
+
+// SiteFilter narrows down the sites listed.
+type SiteFilter struct {
+	RegionID int `query:"region_id" validate:"omitempty,gte=1"` // Sites in the region or its sub-regions
+}
+
+// ErrSiteNotFound is returned when a site is not found in the database.
+var ErrSiteNotFound = errors.New("site not found")
+
+// ErrInvalidSite is returned when a site is in an unknown region.
+var ErrInvalidSite = errors.New("invalid site")
+
+// siteColumns selects sites with the number of their agents by status, leaving out
+// deleted, decommissioned and archived agents. The offline threshold is bound as $1, in Unix seconds.
+const siteColumns = `
+	SELECT s.id, s.name, s.address, s.latitude, s.longitude, s.region_id, s.created_at, s.updated_at,
+	       COUNT(a.id),
+	       COUNT(CASE WHEN unixepoch(a.last_seen_at) >= $1 THEN 1 END),
+	       COUNT(CASE WHEN unixepoch(a.last_seen_at) < $1 THEN 1 END),
+	       COUNT(CASE WHEN a.id IS NOT NULL AND a.last_seen_at IS NULL THEN 1 END)
+	FROM sites s LEFT JOIN agents a ON a.site_id = s.id AND ` + listedAgents
+
+// CreateSite creates a site.
+func (s *Service) CreateSite(req SiteRequest, now time.Time) (Site, error) {
+	err := s.checkSite(req)
+	if err != nil {
+		return Site{}, err
+	}
+
+	res, err := s.DB.Exec("INSERT INTO sites (name, address, latitude, longitude, region_id) VALUES ($1, $2, $3, $4, $5)",
+		req.Name, req.Address, req.Latitude, req.Longitude, req.RegionID)
+	if err != nil {
+		return Site{}, fmt.Errorf("error inserting site: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Site{}, fmt.Errorf("error reading site ID: %w", err)
+	}
+	return s.GetSite(int(id), now)
+}
+
+// GetSites retrieves the sites matching the filter with the status of their agents at now.
+func (s *Service) GetSites(filter SiteFilter, now time.Time) ([]Site, error) {
+	query := siteColumns
+	args := []any{s.onlineSince(now)}
+	if filter.RegionID != 0 {
+		query += `
+		WHERE s.region_id IN (
+			WITH RECURSIVE subregions(id) AS (
+				SELECT $2
+				UNION SELECT r.id FROM regions r JOIN subregions ON r.parent_id = subregions.id
+			)
+			SELECT id FROM subregions
+		)`
+		args = append(args, filter.RegionID)
+	}
+
+	rows, err := s.DB.Query(query+" GROUP BY s.id ORDER BY s.id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	sites := []Site{}
+	for rows.Next() {
+		site, err := scanSite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		sites = append(sites, site)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return sites, nil
+}
+
+// GetSite retrieves a site by ID with the status of its agents at now.
+func (s *Service) GetSite(id int, now time.Time) (Site, error) {
+	site, err := scanSite(s.DB.QueryRow(siteColumns+" WHERE s.id = $2 GROUP BY s.id", s.onlineSince(now), id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return site, ErrSiteNotFound
+		}
+		return site, fmt.Errorf("error fetching site: %w", err)
+	}
+	return site, nil
+}
+
+// UpdateSite replaces the details of a site.
+func (s *Service) UpdateSite(id int, req SiteRequest, now time.Time) (Site, error) {
+	err := s.checkSite(req)
+	if err != nil {
+		return Site{}, err
+	}
+
+	res, err := s.DB.Exec(`
+	UPDATE sites SET name = $1, address = $2, latitude = $3, longitude = $4, region_id = $5, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $6`,
+		req.Name, req.Address, req.Latitude, req.Longitude, req.RegionID, id,
+	)
+	if err != nil {
+		return Site{}, fmt.Errorf("error updating site: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return Site{}, fmt.Errorf("error updating site: %w", err)
+	}
+	if updated == 0 {
+		return Site{}, ErrSiteNotFound
+	}
+	return s.GetSite(id, now)
+}
+
+// DeleteSite removes a site. Its agents are kept, without a site.
+func (s *Service) DeleteSite(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM sites WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting site: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting site: %w", err)
+	}
+	if deleted == 0 {
+		return ErrSiteNotFound
+	}
+	_, err = tx.Exec("UPDATE agents SET site_id = NULL WHERE site_id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error unassigning site agents: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing site deletion: %w", err)
+	}
+	return nil
+}
+
+// GetSiteAgents retrieves the agents assigned to a site, except deleted, decommissioned and archived ones.
+func (s *Service) GetSiteAgents(id int) ([]Agent, error) {
+	err := s.siteExists(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.Query("SELECT id, ip_address FROM agents WHERE site_id = $1 AND "+listedAgents+" ORDER BY id", id)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	agents := []Agent{}
+	for rows.Next() {
+		var agent Agent
+		err = rows.Scan(&agent.ID, &agent.IPAddress)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		agents = append(agents, agent)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return agents, nil
+}
+
+// checkSite verifies that the region of a site exists
+func (s *Service) checkSite(req SiteRequest) error {
+	if req.RegionID == nil {
+		return nil
+	}
+	_, err := s.GetRegion(*req.RegionID)
+	if errors.Is(err, ErrRegionNotFound) {
+		return fmt.Errorf("%w: region %d not found", ErrInvalidSite, *req.RegionID)
+	}
+	return err
+}
+
+func (s *Service) siteExists(id int) error {
+	var found int
+	err := s.DB.QueryRow("SELECT id FROM sites WHERE id = $1", id).Scan(&found)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSiteNotFound
+		}
+		return fmt.Errorf("error checking site: %w", err)
+	}
+	return nil
+}
+
+// onlineSince returns the Unix time agents must have reported since to be online at now
+func (s *Service) onlineSince(now time.Time) int64 {
+	offlineAfter := s.OfflineAfter
+	if offlineAfter == 0 {
+		offlineAfter = defaultOfflineAfter
+	}
+	return now.Add(-offlineAfter).Unix()
+}
+
+// scanSite reads a site and derives its status from the statuses of its agents
+func scanSite(row rowScanner) (Site, error) {
+	var site Site
+	err := row.Scan(&site.ID, &site.Name, &site.Address, &site.Latitude, &site.Longitude, &site.RegionID,
+		&site.CreatedAt, &site.UpdatedAt, &site.Agents.Total, &site.Agents.Online, &site.Agents.Offline, &site.Agents.NeverSeen)
+	switch {
+	case site.Agents.Total == 0:
+		site.Status = SiteEmpty
+	case site.Agents.Online == site.Agents.Total:
+		site.Status = SiteUp
+	case site.Agents.Online == 0:
+		site.Status = SiteDown
+	default:
+		site.Status = SiteDegraded
+	}
+	return site, err
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSites(t *testing.T) {
+	svc := &Service{DB: db}
+	now := time.Date(2027, 10, 4, 12, 0, 0, 0, time.UTC)
+
+	canada, err := svc.CreateRegion(RegionRequest{Name: "Canada"})
+	assert.NoError(t, err)
+	defer svc.DeleteRegion(canada.ID)
+	quebec, err := svc.CreateRegion(RegionRequest{Name: "Quebec", ParentID: &canada.ID})
+	assert.NoError(t, err)
+	defer svc.DeleteRegion(quebec.ID)
+	assert.Equal(t, canada.ID, *quebec.ParentID)
+
+	lat, lon := 45.5019, -73.5674
+	office, err := svc.CreateSite(SiteRequest{
+		Name: "Montreal office", Address: "1 Place Ville Marie", Latitude: &lat, Longitude: &lon, RegionID: &quebec.ID,
+	}, now)
+	assert.NoError(t, err)
+	defer svc.DeleteSite(office.ID)
+	assert.Equal(t, SiteEmpty, office.Status)
+
+	datacenter, err := svc.CreateSite(SiteRequest{Name: "Toronto datacenter", RegionID: &canada.ID}, now)
+	assert.NoError(t, err)
+	defer svc.DeleteSite(datacenter.ID)
+
+	online := insertTestAgent(t, "10.0.17.1")
+	defer svc.purgeAgent(online)
+	offline := insertTestAgent(t, "10.0.17.2")
+	defer svc.purgeAgent(offline)
+	_, err = db.Exec("UPDATE agents SET last_seen_at = $1 WHERE id = $2", now.Add(-time.Minute), online)
+	assert.NoError(t, err)
+	_, err = db.Exec("UPDATE agents SET last_seen_at = $1 WHERE id = $2", now.Add(-time.Hour), offline)
+	assert.NoError(t, err)
+
+	assign := func(agentID, siteID int) {
+		t.Helper()
+		agent, err := svc.PatchAgent(agentID, AgentPatchRequest{SiteID: &siteID})
+		assert.NoError(t, err)
+		if siteID == 0 {
+			assert.Nil(t, agent.SiteID)
+		} else if assert.NotNil(t, agent.SiteID) {
+			assert.Equal(t, siteID, *agent.SiteID)
+		}
+	}
+
+	t.Run("Status derived from agents", func(t *testing.T) {
+		assign(online, office.ID)
+		site, err := svc.GetSite(office.ID, now)
+		assert.NoError(t, err)
+		assert.Equal(t, SiteUp, site.Status)
+
+		assign(offline, office.ID)
+		site, err = svc.GetSite(office.ID, now)
+		assert.NoError(t, err)
+		assert.Equal(t, SiteDegraded, site.Status)
+		assert.Equal(t, SiteAgentCount{Total: 2, Online: 1, Offline: 1}, site.Agents)
+
+		site, err = svc.GetSite(office.ID, now.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, SiteDown, site.Status)
+
+		agents, err := svc.GetSiteAgents(office.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{online, offline}, ids(agents))
+	})
+
+	t.Run("Sites of a region include its sub-regions", func(t *testing.T) {
+		sites, err := svc.GetSites(SiteFilter{RegionID: canada.ID}, now)
+		assert.NoError(t, err)
+		if assert.Len(t, sites, 2) {
+			assert.Equal(t, office.ID, sites[0].ID)
+			assert.Equal(t, datacenter.ID, sites[1].ID)
+		}
+
+		sites, err = svc.GetSites(SiteFilter{RegionID: quebec.ID}, now)
+		assert.NoError(t, err)
+		assert.Len(t, sites, 1)
+	})
+
+	t.Run("Moving agents", func(t *testing.T) {
+		assign(offline, datacenter.ID)
+		assign(online, 0)
+		site, err := svc.GetSite(office.ID, now)
+		assert.NoError(t, err)
+		assert.Equal(t, SiteEmpty, site.Status)
+
+		updated, err := svc.UpdateSite(datacenter.ID, SiteRequest{Name: "Toronto DC", RegionID: &canada.ID}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, "Toronto DC", updated.Name)
+		assert.Equal(t, SiteDown, updated.Status)
+	})
+
+	t.Run("Deleting a site keeps its agents", func(t *testing.T) {
+		temporary, err := svc.CreateSite(SiteRequest{Name: "Pop-up"}, now)
+		assert.NoError(t, err)
+		assign(online, temporary.ID)
+		assert.NoError(t, svc.DeleteSite(temporary.ID))
+
+		agent, err := svc.GetAgent(online)
+		assert.NoError(t, err)
+		assert.Nil(t, agent.SiteID)
+		assert.ErrorIs(t, svc.DeleteSite(temporary.ID), ErrSiteNotFound)
+	})
+
+	t.Run("Invalid regions and sites", func(t *testing.T) {
+		_, err := svc.UpdateRegion(canada.ID, RegionRequest{Name: "Canada", ParentID: &quebec.ID})
+		assert.ErrorIs(t, err, ErrInvalidRegion)
+		_, err = svc.UpdateRegion(canada.ID, RegionRequest{Name: "Canada", ParentID: &canada.ID})
+		assert.ErrorIs(t, err, ErrInvalidRegion)
+		unknown := 999999
+		_, err = svc.CreateRegion(RegionRequest{Name: "Nowhere", ParentID: &unknown})
+		assert.ErrorIs(t, err, ErrInvalidRegion)
+		assert.ErrorIs(t, svc.DeleteRegion(quebec.ID), ErrInvalidRegion)
+
+		_, err = svc.CreateSite(SiteRequest{Name: "Nowhere", RegionID: &unknown}, now)
+		assert.ErrorIs(t, err, ErrInvalidSite)
+		_, err = svc.PatchAgent(online, AgentPatchRequest{SiteID: &unknown})
+		assert.ErrorIs(t, err, ErrInvalidSite)
+		_, err = svc.GetSiteAgents(unknown)
+		assert.ErrorIs(t, err, ErrSiteNotFound)
+		_, err = svc.GetRegion(unknown)
+		assert.ErrorIs(t, err, ErrRegionNotFound)
+	})
+}
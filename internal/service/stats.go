@@ -0,0 +1,131 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Statuses of agents in fleet statistics, derived from when they last reported.
+const (
+	AgentOnline    = "online"     // Reported within the offline threshold
+	AgentOffline   = "offline"    // Reported before the offline threshold
+	AgentNeverSeen = "never_seen" // Registered but never reported
+)
+
+// defaultOfflineAfter is how long an agent may go without reporting before fleet
+// statistics count it as offline, unless OfflineAfter is set.
+const defaultOfflineAfter = 5 * time.Minute
+
+// defaultStatsCacheTTL is how long fleet statistics are cached unless StatsCacheTTL is set.
+const defaultStatsCacheTTL = 30 * time.Second
+
+// AgentStatsQuery selects how fleet statistics are grouped.
+type AgentStatsQuery struct {
+	GroupBy string `query:"group_by" validate:"required,oneof=isp asn country status"`
+}
+
+// AgentStats is the distribution of the agents across ISPs, ASes, countries or statuses.
+type AgentStats struct {
+	GroupBy     string            `json:"group_by"`
+	Total       int               `json:"total"`
+	Groups      []AgentStatsGroup `json:"groups"` // Largest first
+	GeneratedAt time.Time         `json:"generated_at"`
+}
+
+// AgentStatsGroup counts the agents sharing an ISP, AS, country or status. Agents without
+// a value, such as unlocated agents when grouping by country, share an empty key.
+type AgentStatsGroup struct {
+	Key           string     `json:"key"`             // ISP, AS such as AS15169, country code or status
+	Label         string     `json:"label,omitempty"` // Organization of the AS or name of the country
+	Count         int        `json:"count"`
+	Percentage    float64    `json:"percentage"` // Share of all agents, rounded to 2 decimals
+	OldestUpdated *time.Time `json:"oldest_updated,omitempty"`
+	NewestUpdated *time.Time `json:"newest_updated,omitempty"`
+}
+
+// statsCache holds the latest fleet statistics of each grouping
+type statsCache struct {
+	mu      sync.Mutex
+	entries map[string]AgentStats
+}
+
+// agentStatsGroupings are the key and label expressions of each grouping. Agents are
+// joined to the ASN catalog as n.
+var agentStatsGroupings = map[string][2]string{
+	"isp":     {"a.isp", "''"},
+	"asn":     {"COALESCE('AS' || a.asn_id, a.asn)", "COALESCE(n.name, '')"},
+	"country": {"COALESCE(a.country_code, '')", "COALESCE(a.country, '')"},
+	"status": {`CASE WHEN a.last_seen_at IS NULL THEN '` + AgentNeverSeen + `'
+	                 WHEN unixepoch(a.last_seen_at) >= $1 THEN '` + AgentOnline + `'
+	                 ELSE '` + AgentOffline + `' END`, "''"},
+}
+
+// GetAgentStats counts the agents by ISP, AS, country or status, leaving out deleted,
+// decommissioned and archived agents. Statistics are computed
+// by the database and cached for StatsCacheTTL, so they may lag behind registrations.
+func (s *Service) GetAgentStats(q AgentStatsQuery, now time.Time) (AgentStats, error) {
+	grouping, ok := agentStatsGroupings[q.GroupBy]
+	if !ok {
+		return AgentStats{}, fmt.Errorf("%w: unknown grouping %q", ErrInvalidAgentFilter, q.GroupBy)
+	}
+
+	ttl := s.StatsCacheTTL
+	if ttl == 0 {
+		ttl = defaultStatsCacheTTL
+	}
+	s.stats.mu.Lock()
+	cached, ok := s.stats.entries[q.GroupBy]
+	s.stats.mu.Unlock()
+	if ok && now.Before(cached.GeneratedAt.Add(ttl)) && !now.Before(cached.GeneratedAt) {
+		return cached, nil
+	}
+
+	rows, err := s.DB.Query(`
+	SELECT key, MAX(label), COUNT(*), ROUND(COUNT(*) * 100.0 / SUM(COUNT(*)) OVER (), 2),
+	       MIN(unixepoch(last_updated)), MAX(unixepoch(last_updated))
+	FROM (
+		SELECT `+grouping[0]+` AS key, `+grouping[1]+` AS label, a.last_updated
+		FROM agents a LEFT JOIN asns n ON n.asn = a.asn_id
+		WHERE `+listedAgents+`
+	)
+	GROUP BY key
+	ORDER BY COUNT(*) DESC, key`,
+		s.onlineSince(now),
+	)
+	if err != nil {
+		return AgentStats{}, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	stats := AgentStats{GroupBy: q.GroupBy, Groups: []AgentStatsGroup{}, GeneratedAt: now}
+	for rows.Next() {
+		var group AgentStatsGroup
+		var oldest, newest *int64
+		err = rows.Scan(&group.Key, &group.Label, &group.Count, &group.Percentage, &oldest, &newest)
+		if err != nil {
+			return AgentStats{}, fmt.Errorf("error scanning row: %w", err)
+		}
+		if oldest != nil && newest != nil {
+			oldestUpdated, newestUpdated := time.Unix(*oldest, 0).UTC(), time.Unix(*newest, 0).UTC()
+			group.OldestUpdated, group.NewestUpdated = &oldestUpdated, &newestUpdated
+		}
+		stats.Total += group.Count
+		stats.Groups = append(stats.Groups, group)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return AgentStats{}, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	if ttl > 0 {
+		s.stats.mu.Lock()
+		if s.stats.entries == nil {
+			s.stats.entries = make(map[string]AgentStats)
+		}
+		s.stats.entries[q.GroupBy] = stats
+		s.stats.mu.Unlock()
+	}
+	return stats, nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAgentStats(t *testing.T) {
+	svc := &Service{DB: db, StatsCacheTTL: -1}
+	now := time.Now()
+
+	agents := map[string]int{}
+	for _, ip := range []string{"10.0.13.1", "10.0.13.2", "10.0.13.3", "10.0.13.4"} {
+		agents[ip] = insertTestAgent(t, ip)
+		defer svc.purgeAgent(agents[ip])
+	}
+	for ip, isp := range map[string]string{"10.0.13.1": "Stats ISP", "10.0.13.2": "Stats ISP", "10.0.13.3": "Stats ISP", "10.0.13.4": "Other Stats ISP"} {
+		_, err := db.Exec("UPDATE agents SET isp = $1, country_code = 'ZZ', country = 'Statsland' WHERE id = $2", isp, agents[ip])
+		assert.NoError(t, err)
+	}
+	_, err := db.Exec("UPDATE agents SET last_updated = '2027-01-01 00:00:00' WHERE id = $1", agents["10.0.13.1"])
+	assert.NoError(t, err)
+	_, err = db.Exec("UPDATE agents SET last_updated = '2027-03-01 12:00:00' WHERE id = $1", agents["10.0.13.2"])
+	assert.NoError(t, err)
+	_, err = db.Exec("UPDATE agents SET last_updated = '2027-02-01 00:00:00' WHERE id = $1", agents["10.0.13.3"])
+	assert.NoError(t, err)
+	assert.NoError(t, svc.RecordHeartbeat(agents["10.0.13.1"]))
+	_, err = db.Exec("UPDATE agents SET last_seen_at = '2020-01-01 00:00:00' WHERE id = $1", agents["10.0.13.2"])
+	assert.NoError(t, err)
+
+	groupOf := func(stats AgentStats, key string) AgentStatsGroup {
+		t.Helper()
+		for _, group := range stats.Groups {
+			if group.Key == key {
+				return group
+			}
+		}
+		t.Errorf("no %s group %q", stats.GroupBy, key)
+		return AgentStatsGroup{}
+	}
+
+	t.Run("Group by ISP", func(t *testing.T) {
+		stats, err := svc.GetAgentStats(AgentStatsQuery{GroupBy: "isp"}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, "isp", stats.GroupBy)
+
+		group := groupOf(stats, "Stats ISP")
+		assert.Equal(t, 3, group.Count)
+		if assert.NotNil(t, group.OldestUpdated) && assert.NotNil(t, group.NewestUpdated) {
+			assert.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), *group.OldestUpdated)
+			assert.Equal(t, time.Date(2027, 3, 1, 12, 0, 0, 0, time.UTC), *group.NewestUpdated)
+		}
+
+		// Shares are of the whole fleet, which other tests may have agents in
+		var count int
+		var percentage float64
+		for _, group := range stats.Groups {
+			count += group.Count
+			percentage += group.Percentage
+		}
+		assert.Equal(t, stats.Total, count)
+		assert.InDelta(t, 100, percentage, 0.05)
+		assert.InDelta(t, 300/float64(stats.Total), group.Percentage, 0.005)
+	})
+
+	t.Run("Group by country and ASN", func(t *testing.T) {
+		stats, err := svc.GetAgentStats(AgentStatsQuery{GroupBy: "country"}, now)
+		assert.NoError(t, err)
+		group := groupOf(stats, "ZZ")
+		assert.Equal(t, 4, group.Count)
+		assert.Equal(t, "Statsland", group.Label)
+
+		stats, err = svc.GetAgentStats(AgentStatsQuery{GroupBy: "asn"}, now)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, groupOf(stats, "AS64500").Count, 4)
+	})
+
+	t.Run("Group by status", func(t *testing.T) {
+		before, err := svc.GetAgentStats(AgentStatsQuery{GroupBy: "status"}, now)
+		assert.NoError(t, err)
+		assert.NoError(t, svc.RecordHeartbeat(agents["10.0.13.3"]))
+		after, err := svc.GetAgentStats(AgentStatsQuery{GroupBy: "status"}, now)
+		assert.NoError(t, err)
+
+		assert.Equal(t, groupOf(before, AgentOnline).Count+1, groupOf(after, AgentOnline).Count)
+		assert.Equal(t, groupOf(before, AgentNeverSeen).Count-1, groupOf(after, AgentNeverSeen).Count)
+		assert.GreaterOrEqual(t, groupOf(after, AgentOffline).Count, 1)
+	})
+
+	t.Run("Statistics are cached", func(t *testing.T) {
+		cached := &Service{DB: db, StatsCacheTTL: time.Minute}
+		first, err := cached.GetAgentStats(AgentStatsQuery{GroupBy: "isp"}, now)
+		assert.NoError(t, err)
+
+		_, err = db.Exec("UPDATE agents SET isp = 'Other Stats ISP' WHERE id = $1", agents["10.0.13.3"])
+		assert.NoError(t, err)
+		second, err := cached.GetAgentStats(AgentStatsQuery{GroupBy: "isp"}, now.Add(30*time.Second))
+		assert.NoError(t, err)
+		assert.Equal(t, first, second)
+
+		refreshed, err := cached.GetAgentStats(AgentStatsQuery{GroupBy: "isp"}, now.Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, groupOf(refreshed, "Stats ISP").Count)
+	})
+
+	_, err = svc.GetAgentStats(AgentStatsQuery{GroupBy: "city"}, now)
+	assert.ErrorIs(t, err, ErrInvalidAgentFilter)
+}
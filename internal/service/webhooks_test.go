@@ -0,0 +1,177 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the requests it gets and answers with status
+type webhookReceiver struct {
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	w.WriteHeader(r.status)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusOK}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	svc := &Service{DB: db, HTTPClient: server.Client()}
+	secret := "0123456789abcdef0123456789abcdef"
+	subscription, err := svc.CreateWebhook(WebhookSubscriptionRequest{
+		URL: server.URL, EventTypes: []string{EventAgentDeleted}, Secret: secret,
+	})
+	assert.NoError(t, err)
+	defer svc.DeleteWebhook(subscription.ID)
+
+	// A subscriber to other events must not receive anything
+	other, err := svc.CreateWebhook(WebhookSubscriptionRequest{URL: server.URL, EventTypes: []string{EventAlertFiring}})
+	assert.NoError(t, err)
+	assert.Len(t, other.Secret, 64)
+	defer svc.DeleteWebhook(other.ID)
+
+	t.Run("Delivers signed events", func(t *testing.T) {
+		agentID := insertTestAgent(t, "10.0.6.1")
+		assert.NoError(t, svc.DeleteAgent(agentID))
+		assert.NoError(t, svc.DeliverWebhooks(time.Now()))
+
+		assert.Len(t, receiver.requests, 1)
+		req, body := receiver.requests[0], receiver.bodies[0]
+		assert.Equal(t, EventAgentDeleted, req.Header.Get(WebhookEventHeader))
+		timestamp, err := strconv.ParseInt(req.Header.Get(WebhookTimestampHeader), 10, 64)
+		assert.NoError(t, err)
+		assert.Equal(t, "sha256="+SignWebhook(secret, timestamp, body), req.Header.Get(WebhookSignatureHeader))
+
+		var event Event
+		assert.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, EventAgentDeleted, event.Type)
+		assert.Equal(t, agentID, *event.AgentID)
+
+		deliveries, err := svc.GetDeliveries(DeliveryFilter{SubscriptionID: subscription.ID})
+		assert.NoError(t, err)
+		assert.Equal(t, DeliveryDelivered, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+
+		deliveries, err = svc.GetDeliveries(DeliveryFilter{SubscriptionID: other.ID})
+		assert.NoError(t, err)
+		assert.Empty(t, deliveries)
+	})
+
+	t.Run("Retries with backoff and dead-letters", func(t *testing.T) {
+		receiver.status = http.StatusInternalServerError
+		receiver.requests = nil
+		now := time.Now()
+
+		agentID := insertTestAgent(t, "10.0.6.2")
+		assert.NoError(t, svc.DeleteAgent(agentID))
+		assert.NoError(t, svc.DeliverWebhooks(now))
+
+		deliveries, err := svc.GetDeliveries(DeliveryFilter{Status: DeliveryPending, SubscriptionID: subscription.ID})
+		assert.NoError(t, err)
+		assert.Len(t, deliveries, 1)
+		delivery := deliveries[0]
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusInternalServerError, *delivery.LastStatusCode)
+		assert.Equal(t, now.Add(webhookBackoffBase).Unix(), delivery.NextAttemptAt.Unix())
+
+		// Not due yet
+		assert.NoError(t, svc.DeliverWebhooks(now.Add(10*time.Second)))
+		assert.Len(t, receiver.requests, 1)
+
+		for i := 0; i < maxDeliveryAttempts; i++ {
+			now = now.Add(webhookBackoffMax)
+			assert.NoError(t, svc.DeliverWebhooks(now))
+		}
+		assert.Len(t, receiver.requests, maxDeliveryAttempts)
+
+		delivery, err = svc.GetDelivery(delivery.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, DeliveryDead, delivery.Status)
+
+		dead, err := svc.GetDeliveries(DeliveryFilter{Status: DeliveryDead})
+		assert.NoError(t, err)
+		assert.Equal(t, delivery.ID, dead[0].ID)
+
+		// A retried delivery goes out on the next run
+		receiver.status = http.StatusNoContent
+		delivery, err = svc.RetryDelivery(delivery.ID, now)
+		assert.NoError(t, err)
+		assert.Equal(t, DeliveryPending, delivery.Status)
+		assert.NoError(t, svc.DeliverWebhooks(now))
+		delivery, err = svc.GetDelivery(delivery.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, DeliveryDelivered, delivery.Status)
+
+		_, err = svc.RetryDelivery(delivery.ID, now)
+		assert.ErrorIs(t, err, ErrInvalidDelivery)
+	})
+
+	t.Run("Sends test deliveries right away", func(t *testing.T) {
+		receiver.status = http.StatusOK
+		delivery, err := svc.SendTestWebhook(other.ID, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, EventWebhookTest, delivery.EventType)
+		assert.Equal(t, DeliveryDelivered, delivery.Status)
+
+		_, err = svc.SendTestWebhook(999999, time.Now())
+		assert.ErrorIs(t, err, ErrWebhookNotFound)
+	})
+
+	t.Run("Sends test deliveries to disabled subscriptions", func(t *testing.T) {
+		disabled := false
+		paused, err := svc.CreateWebhook(WebhookSubscriptionRequest{URL: server.URL, EventTypes: []string{EventAlertFiring}, Enabled: &disabled})
+		assert.NoError(t, err)
+		defer svc.DeleteWebhook(paused.ID)
+
+		receiver.requests = nil
+		delivery, err := svc.SendTestWebhook(paused.ID, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, DeliveryDelivered, delivery.Status)
+		assert.Len(t, receiver.requests, 1)
+	})
+
+	t.Run("Deliveries in flight are only sent again once their claim expired", func(t *testing.T) {
+		receiver.requests = nil
+		now := time.Now()
+
+		agentID := insertTestAgent(t, "10.0.6.3")
+		assert.NoError(t, svc.DeleteAgent(agentID))
+		deliveries, err := svc.GetDeliveries(DeliveryFilter{Status: DeliveryPending, SubscriptionID: subscription.ID})
+		assert.NoError(t, err)
+		assert.Len(t, deliveries, 1)
+
+		// Another run claimed it and is still sending it
+		_, err = db.Exec("UPDATE webhook_deliveries SET status = $1, next_attempt_at = $2 WHERE id = $3",
+			DeliveryInFlight, now.Add(deliveryLease).Unix(), deliveries[0].ID)
+		assert.NoError(t, err)
+		assert.NoError(t, svc.DeliverWebhooks(now))
+		assert.Empty(t, receiver.requests)
+
+		assert.NoError(t, svc.DeliverWebhooks(now.Add(deliveryLease)))
+		assert.Len(t, receiver.requests, 1)
+		delivery, err := svc.GetDelivery(deliveries[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, DeliveryDelivered, delivery.Status)
+	})
+}
+
+func TestDeliveryBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, deliveryBackoff(1))
+	assert.Equal(t, 60*time.Second, deliveryBackoff(2))
+	assert.Equal(t, 8*time.Minute, deliveryBackoff(5))
+	assert.Equal(t, time.Hour, deliveryBackoff(20))
+}
@@ -0,0 +1,99 @@
+package sketch
+
+import (
+	"math"
+	"sort"
+)
+
+// relativeAccuracy bounds the error of any quantile estimate relative to the true value.
+const relativeAccuracy = 0.01
+
+// minIndexable is the smallest value tracked in a logarithmic bucket; anything smaller counts as zero.
+const minIndexable = 1e-9
+
+var (
+	gamma    = (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	logGamma = math.Log(gamma)
+)
+
+// Sketch is a mergeable quantile sketch for non-negative values.
+// Values are counted in logarithmically sized buckets (as in DDSketch), so two sketches
+// can be merged by adding their bucket counts and quantiles stay within relativeAccuracy.
+type Sketch struct {
+	Zero    uint64         `json:"zero,omitempty"`
+	Buckets map[int]uint64 `json:"buckets,omitempty"`
+}
+
+// New returns an empty sketch
+func New() *Sketch {
+	return &Sketch{Buckets: map[int]uint64{}}
+}
+
+// Add records a single value. Negative values are clamped to zero.
+func (s *Sketch) Add(v float64) {
+	if v < minIndexable {
+		s.Zero++
+		return
+	}
+	if s.Buckets == nil {
+		s.Buckets = map[int]uint64{}
+	}
+	s.Buckets[int(math.Ceil(math.Log(v)/logGamma))]++
+}
+
+// Merge adds all values recorded in other to s
+func (s *Sketch) Merge(other *Sketch) {
+	if other == nil {
+		return
+	}
+	if s.Buckets == nil {
+		s.Buckets = map[int]uint64{}
+	}
+	s.Zero += other.Zero
+	for idx, count := range other.Buckets {
+		s.Buckets[idx] += count
+	}
+}
+
+// Count returns the number of values recorded
+func (s *Sketch) Count() uint64 {
+	count := s.Zero
+	for _, c := range s.Buckets {
+		count += c
+	}
+	return count
+}
+
+// Quantile returns an estimate of the q-quantile (0 <= q <= 1).
+// The second return value is false if the sketch is empty.
+func (s *Sketch) Quantile(q float64) (float64, bool) {
+	count := s.Count()
+	if count == 0 {
+		return 0, false
+	}
+
+	rank := uint64(q * float64(count-1))
+	if rank < s.Zero {
+		return 0, true
+	}
+
+	indexes := make([]int, 0, len(s.Buckets))
+	for idx := range s.Buckets {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+
+	seen := s.Zero
+	for _, idx := range indexes {
+		seen += s.Buckets[idx]
+		if seen > rank {
+			return bucketValue(idx), true
+		}
+	}
+	return bucketValue(indexes[len(indexes)-1]), true
+}
+
+// bucketValue returns the value representing a bucket, chosen so the relative error is the same at both bucket bounds
+func bucketValue(idx int) float64 {
+	return 2 * math.Pow(gamma, float64(idx)) / (gamma + 1)
+}
@@ -0,0 +1,59 @@
+package sketch
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestQuantile(t *testing.T) {
+	s := New()
+	for i := 1; i <= 1000; i++ {
+		s.Add(float64(i))
+	}
+
+	for _, tc := range []struct {
+		q        float64
+		expected float64
+	}{{0, 1}, {0.5, 500}, {0.95, 950}, {0.99, 990}, {1, 1000}} {
+		v, ok := s.Quantile(tc.q)
+		assert.True(t, ok)
+		assert.InEpsilon(t, tc.expected, v, 0.02, "quantile %v", tc.q)
+	}
+
+	t.Run("Empty sketch", func(t *testing.T) {
+		_, ok := New().Quantile(0.5)
+		assert.False(t, ok)
+	})
+
+	t.Run("Zero values", func(t *testing.T) {
+		z := New()
+		z.Add(0)
+		z.Add(0)
+		z.Add(5)
+		v, ok := z.Quantile(0.5)
+		assert.True(t, ok)
+		assert.Equal(t, 0.0, v)
+	})
+}
+
+func TestMerge(t *testing.T) {
+	a, b := New(), New()
+	for i := 1; i <= 500; i++ {
+		a.Add(float64(i))
+		b.Add(float64(i + 500))
+	}
+
+	// Merging must survive a JSON round trip since sketches are stored as text
+	data, err := json.Marshal(b)
+	assert.NoError(t, err)
+	var decoded Sketch
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+
+	a.Merge(&decoded)
+	assert.Equal(t, uint64(1000), a.Count())
+
+	v, ok := a.Quantile(0.5)
+	assert.True(t, ok)
+	assert.InEpsilon(t, 500, v, 0.02)
+}
@@ -24,6 +24,10 @@ func ValidationErrorResponse(err error) ErrorResponse {
 			errorsMap[e.Field()] = e.Field() + " is required"
 		case "ip":
 			errorsMap[e.Field()] = "ip_address must be a valid IPv4 or IPv6 address"
+		case "gte", "min":
+			errorsMap[e.Field()] = e.Field() + " must be at least " + e.Param()
+		case "lte", "max":
+			errorsMap[e.Field()] = e.Field() + " must be at most " + e.Param()
 		default:
 			errorsMap[e.Field()] = e.Field() + " is invalid"
 		}
@@ -58,6 +62,14 @@ func GenericErrorResponse() ErrorResponse {
 	}
 }
 
+// ConflictResponse returns a conflict error response.
+func ConflictResponse(err error) ErrorResponse {
+	return ErrorResponse{
+		Error:   "Conflict",
+		Details: map[string]string{"error": err.Error()},
+	}
+}
+
 // NotFoundResponse returns a not found error response.
 func NotFoundResponse(err error) ErrorResponse {
 	return ErrorResponse{